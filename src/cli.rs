@@ -1,17 +1,35 @@
 //! CLI interface and argument parsing.
 
 use crate::error::ParseError;
-use clap::{Parser, ValueEnum};
+use crate::logging::LogLevel;
+use crate::models::{PRComment, Severity};
+use chrono::{DateTime, NaiveDate, Utc};
+use clap::parser::ValueSource;
+use clap::{ArgMatches, Parser, ValueEnum};
+use globset::Glob;
+use regex::Regex;
 
 /// Git repository URL used for self-update via `cargo install --git`.
 pub const REPO_URL: &str = "https://github.com/rjmurphy777/Pull-request-fetcher";
 
+/// Snippet lines on the side of the change not given by `--context-before`
+/// or `--context-after`.
+pub const DEFAULT_CONTEXT_LINES: usize = 3;
+
+/// Exit status with `--fail-if-comments` when comments remain after filtering.
+pub const COMMENTS_FOUND_EXIT_CODE: u8 = 2;
+
 /// CLI tool to fetch and format GitHub PR comments for LLM consumption.
-#[derive(Parser, Debug)]
+#[derive(Parser, Debug, Default)]
 #[command(name = "pr-comments")]
 #[command(version = "0.1.0")]
 #[command(about = "Fetch and format GitHub PR comments for LLM consumption")]
 #[command(author = "rjmurphy777")]
+#[command(after_help = "Exit status:\n  \
+    0  success (also when no comments remain, unless --empty-exit-code is set)\n  \
+    1  error (gh failure, invalid PR, unreadable file)\n  \
+    2  comments remain after filtering, with --fail-if-comments\n     \
+    (also invalid command-line usage)")]
 pub struct Args {
     /// PR URL or owner/repo#number format
     #[arg(value_name = "PR")]
@@ -29,14 +47,195 @@ pub struct Args {
     #[arg(short = 'n', long = "pr-number")]
     pub pr_number: Option<i32>,
 
-    /// Filter by author username
-    #[arg(short = 'a', long)]
-    pub author: Option<String>,
+    /// Read defaults from this YAML config file instead of ./.prcomments.yaml
+    #[arg(long, value_name = "PATH")]
+    pub config: Option<String>,
+
+    /// Use the PR for the current git branch (via `gh pr view`)
+    #[arg(long, conflicts_with_all = ["pr", "owner", "repo", "pr_number"])]
+    pub current: bool,
+
+    /// GitHub host for shorthand or --owner/--repo (e.g. a GitHub Enterprise
+    /// server); full PR URLs carry their own
+    #[arg(long, value_name = "HOST")]
+    pub host: Option<String>,
+
+    /// Read review comments from a saved JSON array (as returned by the pulls
+    /// comments API) instead of fetching them; the PR argument labels the output
+    #[arg(long = "from-file", value_name = "PATH", conflicts_with_all = ["current", "checks"])]
+    pub from_file: Option<String>,
+
+    /// PR title to show with --from-file
+    #[arg(long = "pr-title", value_name = "TITLE", requires = "from_file")]
+    pub pr_title: Option<String>,
+
+    /// PR URL to show with --from-file
+    #[arg(long = "pr-url", value_name = "URL", requires = "from_file")]
+    pub pr_url: Option<String>,
+
+    /// Filter by author username (repeatable or comma-separated; matches any)
+    #[arg(short = 'a', long, value_delimiter = ',')]
+    pub author: Vec<String>,
+
+    /// Filter by author username matching the regex PATTERN (overrides --author)
+    #[arg(long = "author-regex", value_name = "PATTERN")]
+    pub author_regex: Option<Regex>,
+
+    /// Show only comments on files whose path matches GLOB (e.g. 'src/**/*.py')
+    #[arg(long = "file", value_name = "GLOB")]
+    pub file: Option<Glob>,
+
+    /// Show only comments on line N (or whose range includes it), in any file
+    #[arg(long = "line", value_name = "N")]
+    pub line: Option<i32>,
+
+    /// Show only comments on line N or later
+    #[arg(long = "min-line", value_name = "N")]
+    pub min_line: Option<i32>,
+
+    /// Show only comments on line N or earlier
+    #[arg(long = "max-line", value_name = "N")]
+    pub max_line: Option<i32>,
+
+    /// Show only comments whose code snippet or diff hunk matches the regex PATTERN
+    #[arg(long = "snippet-grep", value_name = "PATTERN")]
+    pub snippet_grep: Option<Regex>,
+
+    /// Show only comments whose body contains a link (http/https URL)
+    #[arg(long = "with-links")]
+    pub with_links: bool,
+
+    /// Drop comments on test files (*_test.go, test_*.py, **/tests/**, *.spec.ts, ...)
+    #[arg(long = "exclude-tests")]
+    pub exclude_tests: bool,
+
+    /// Additional glob identifying test files for --exclude-tests (repeatable)
+    #[arg(long = "test-glob", value_name = "GLOB", requires = "exclude_tests")]
+    pub test_glob: Vec<Glob>,
+
+    /// Show only comments asking for tests ("add a test", "missing coverage", ...)
+    #[arg(long = "test-requests-only")]
+    pub test_requests_only: bool,
+
+    /// Prefix comments asking for tests with "🧪 test requested"
+    #[arg(long = "flag-test-requests")]
+    pub flag_test_requests: bool,
+
+    /// Show only comments from reviews that requested changes (otherwise
+    /// they're marked "⛔ blocking")
+    #[arg(long = "blocking-only")]
+    pub blocking_only: bool,
+
+    /// Show only comments with a ```suggestion block (a proposed code change)
+    #[arg(long = "suggestions-only")]
+    pub suggestions_only: bool,
+
+    /// Show only comments in hotspots: clusters of at least N nearby comments in a file
+    #[arg(long = "hotspots-only", value_name = "N")]
+    pub hotspots_only: Option<usize>,
+
+    /// Lines between neighboring comments that still count as one hotspot
+    #[arg(long = "hotspot-radius", value_name = "K", default_value_t = 5)]
+    pub hotspot_radius: i32,
+
+    /// Show only comments on files no longer in the PR's diff (e.g. after a rebase)
+    #[arg(long = "orphaned-only")]
+    pub orphaned_only: bool,
+
+    /// Drop comments on files no longer in the PR's diff (stale after a rebase)
+    #[arg(long = "only-changed-files", conflicts_with = "orphaned_only")]
+    pub only_changed_files: bool,
+
+    /// Show only comments not seen in previous --only-new runs for this PR
+    #[arg(long = "only-new")]
+    pub only_new: bool,
+
+    /// Show N lines either side of each comment's line from the full file at
+    /// the PR head instead of the diff hunk (falls back to the hunk)
+    #[arg(long = "full-context", value_name = "N")]
+    pub full_context: Option<usize>,
+
+    /// Remap comment lines from the reviewed commit through a unified diff PATCH
+    /// (e.g. `git diff <reviewed-sha>`), flagging comments on deleted lines
+    #[arg(long = "remap-with", value_name = "PATCH")]
+    pub remap_with: Option<String>,
 
     /// Show only newest comment per file
     #[arg(short = 'm', long = "most-recent")]
     pub most_recent: bool,
 
+    /// Mask secrets (AWS keys, GitHub and bearer tokens, emails, password=...)
+    /// in comment bodies and code snippets before output
+    #[arg(long)]
+    pub redact: bool,
+
+    /// Collapse identical comments (same author, file and body on overlapping
+    /// lines) that GitHub can repeat after a force-push
+    #[arg(long)]
+    pub dedupe: bool,
+
+    /// Keep at most N comments after sorting: the first N as rendered, or the
+    /// most recent for flat output (0 = no limit)
+    #[arg(long, value_name = "N", default_value_t = 0)]
+    pub limit: usize,
+
+    /// Fail if PR info (title, URL) cannot be fetched instead of continuing without it
+    #[arg(long = "require-pr-info")]
+    pub require_pr_info: bool,
+
+    /// Show the PR's base and head branch names in the claude header
+    #[arg(long = "include-branches")]
+    pub include_branches: bool,
+
+    /// Only show comments updated on or after this time (RFC3339 or YYYY-MM-DD, UTC)
+    #[arg(long, value_name = "DATE", value_parser = parse_since)]
+    pub since: Option<DateTime<Utc>>,
+
+    /// Only show comments added or edited after your most recent submitted review
+    #[arg(long = "since-review")]
+    pub since_review: bool,
+
+    /// Show each file's diff size, e.g. "(+120 -30)", next to its header
+    #[arg(long = "show-diff-stats")]
+    pub show_diff_stats: bool,
+
+    /// Sort comments by the given key (within each file for grouped formats);
+    /// without it, grouped formats sort by line and flat by date
+    #[arg(long = "sort", value_enum)]
+    pub sort: Option<SortKey>,
+
+    /// Agent reading order: files alphabetically, comments bottom-to-top by line
+    #[arg(long = "agent-order", conflicts_with_all = ["diff_order", "sort"])]
+    pub agent_order: bool,
+
+    /// Order files as they appear in the PR diff instead of alphabetically
+    #[arg(long = "diff-order")]
+    pub diff_order: bool,
+
+    /// For bot-started threads, keep the bot comment and human replies only
+    #[arg(long = "human-responses-to-bots")]
+    pub human_responses_to_bots: bool,
+
+    /// Show only comments in resolved review threads (queries `gh api graphql`)
+    #[arg(long = "resolved", conflicts_with = "unresolved")]
+    pub resolved: bool,
+
+    /// Show only comments in unresolved review threads (queries `gh api graphql`)
+    #[arg(long = "unresolved")]
+    pub unresolved: bool,
+
+    /// Include bot accounts in --format mentions (excluded by default)
+    #[arg(long = "mention-bots")]
+    pub mention_bots: bool,
+
+    /// Drop comments from bot accounts (logins ending in "[bot]")
+    #[arg(long = "no-bots")]
+    pub no_bots: bool,
+
+    /// Drop comments below this inferred severity: none, nit, suggestion, warning, critical
+    #[arg(long = "min-severity", value_name = "LEVEL")]
+    pub min_severity: Option<Severity>,
+
     /// Output format
     #[arg(short = 'f', long, default_value = "claude", value_enum)]
     pub format: OutputFormat,
@@ -45,18 +244,199 @@ pub struct Args {
     #[arg(long = "no-snippet")]
     pub no_snippet: bool,
 
+    /// Truncate comment bodies longer than N characters at a sentence boundary
+    #[arg(long = "max-body-chars", value_name = "N")]
+    pub max_body_chars: Option<usize>,
+
+    /// Reduce runs of blank lines in comment bodies to a single blank line
+    #[arg(long = "collapse-blank-lines")]
+    pub collapse_blank_lines: bool,
+
     /// Max lines in snippets
     #[arg(long = "snippet-lines", default_value = "15")]
     pub snippet_lines: usize,
 
+    /// Snippet lines above the last changed line (replaces --snippet-lines)
+    #[arg(long = "context-before", value_name = "N")]
+    pub context_before: Option<usize>,
+
+    /// Snippet lines below the last changed line (replaces --snippet-lines)
+    #[arg(long = "context-after", value_name = "N")]
+    pub context_after: Option<usize>,
+
+    /// Hard-wrap snippet lines longer than N characters
+    #[arg(long = "wrap-snippet", value_name = "N")]
+    pub wrap_snippet: Option<usize>,
+
+    /// Show each comment's body before its code snippet instead of after
+    #[arg(long = "snippet-after")]
+    pub snippet_after: bool,
+
+    /// Mark every snippet line as added (+), removed (-) or context, in a diff block
+    #[arg(long = "annotate-diff")]
+    pub annotate_diff: bool,
+
+    /// Display bot authors without the "[bot]" suffix (filters and JSON use the real login)
+    #[arg(long = "normalize-bot-names")]
+    pub normalize_bot_names: bool,
+
+    /// Append "(N words)" to each comment header (claude and grouped formats)
+    #[arg(long = "show-word-count")]
+    pub show_word_count: bool,
+
+    /// Note line numbers referenced in comment bodies ("line 88", "L88")
+    #[arg(long = "line-refs")]
+    pub line_refs: bool,
+
+    /// Include the unprocessed diff hunk as `raw_diff_hunk` in JSON output
+    #[arg(long = "include-raw-diff")]
+    pub include_raw_diff: bool,
+
+    /// Show author avatars in HTML output
+    #[arg(long = "html-avatars")]
+    pub html_avatars: bool,
+
+    /// Inline the titles of referenced issues, e.g. "#123" becomes "(#123: Title)"
+    #[arg(long = "resolve-issues")]
+    pub resolve_issues: bool,
+
+    /// Append a list of all URLs referenced in comment bodies
+    #[arg(long = "extract-links")]
+    pub extract_links: bool,
+
+    /// Prepend a YAML front-matter block (owner, repo, pr, total, generated_at)
+    #[arg(long = "front-matter")]
+    pub front_matter: bool,
+
+    /// Append unchecked task list items from all comments as an "Outstanding tasks" section
+    #[arg(long = "extract-tasks")]
+    pub extract_tasks: bool,
+
+    /// Print only a summary (totals, time span, busiest file, tallies by author and file) instead of comments
+    #[arg(long, conflicts_with = "with_stats")]
+    pub stats: bool,
+
+    /// Prepend a stats summary (tallies by author and file, time span) to the output
+    #[arg(long = "with-stats")]
+    pub with_stats: bool,
+
+    /// Group comments by a computed key instead of by file: dir:N, ext, author, severity
+    #[arg(long = "group-by-expr", value_name = "EXPR")]
+    pub group_by_expr: Option<GroupExpr>,
+
+    /// Top-level layout: per file, per author, or severity sections across the whole PR
+    #[arg(
+        long = "group-by",
+        value_name = "KEY",
+        default_value = "file",
+        value_enum,
+        conflicts_with = "group_by_expr"
+    )]
+    pub group_by: GroupBy,
+
+    /// Show each commented file in full, fetched at the PR head, with comments
+    /// inlined at their lines
+    #[arg(long = "fetch-source")]
+    pub fetch_source: bool,
+
+    /// Include general PR conversation comments (not attached to code), filed under "(general)"
+    #[arg(long = "include-general")]
+    pub include_general: bool,
+
+    /// Print the fraction of changed lines that received comments, per file and overall
+    #[arg(long)]
+    pub coverage: bool,
+
+    /// Print a tab-separated tally of comments by key instead of comments
+    #[arg(long = "count-by", value_enum)]
+    pub count_by: Option<CountKey>,
+
+    /// HTTPS proxy for gh to use (sets HTTPS_PROXY)
+    #[arg(long, value_name = "URL")]
+    pub proxy: Option<String>,
+
+    /// CA bundle for gh to trust (sets GIT_SSL_CAINFO and SSL_CERT_FILE)
+    #[arg(long = "ca-bundle", value_name = "PATH")]
+    pub ca_bundle: Option<String>,
+
+    /// Retry gh calls failing with a rate limit or 5xx error up to N times, with backoff
+    #[arg(long, value_name = "N", default_value_t = 2)]
+    pub retries: u32,
+
+    /// Give up on a gh call after SECS seconds (0 waits forever)
+    #[arg(long, value_name = "SECS", default_value_t = 30)]
+    pub timeout: u64,
+
+    /// Stderr log verbosity
+    #[arg(long = "log-level", value_enum, default_value = "info")]
+    pub log_level: LogLevel,
+
+    /// Insert the contents of FILE before the output, verbatim
+    #[arg(long = "prepend-file", value_name = "FILE")]
+    pub prepend_file: Option<String>,
+
+    /// Insert the contents of FILE after the output, verbatim
+    #[arg(long = "append-file", value_name = "FILE")]
+    pub append_file: Option<String>,
+
+    /// Write each comment to its own file in DIR, named by comment ID
+    #[arg(long = "split-by-comment", value_name = "DIR")]
+    pub split_by_comment: Option<String>,
+
     /// Write output to file
     #[arg(short = 'O', long)]
     pub output: Option<String>,
 
+    /// Copy output to the system clipboard instead of printing it
+    #[arg(long, conflicts_with = "output")]
+    pub clipboard: bool,
+
+    /// Also format the comments in this format, written to --also-output
+    #[arg(long = "also-format", value_enum, requires = "also_output")]
+    pub also_format: Option<OutputFormat>,
+
+    /// File for the --also-format output
+    #[arg(long = "also-output", value_name = "PATH", requires = "also_format")]
+    pub also_output: Option<String>,
+
+    /// Truncate the final output to at most N bytes (JSON drops trailing comments)
+    #[arg(long = "max-output-bytes", value_name = "N")]
+    pub max_output_bytes: Option<usize>,
+
+    /// Keep ANSI escape sequences (terminal colors) in bodies and snippets
+    #[arg(long = "keep-ansi")]
+    pub keep_ansi: bool,
+
+    /// Text to print instead of "No comments found." when no comments remain
+    #[arg(long = "empty-message", value_name = "TEXT")]
+    pub empty_message: Option<String>,
+
+    /// Exit with this status when no comments remain after filtering
+    #[arg(long = "empty-exit-code", value_name = "CODE", default_value_t = 0)]
+    pub empty_exit_code: u8,
+
+    /// Report the output's size on stderr as "~N tokens (~M chars)", estimating
+    /// one token per four characters
+    #[arg(long = "show-size")]
+    pub show_size: bool,
+
+    /// Exit with status 2 when any comments remain after filtering, e.g. to
+    /// fail a pre-merge check (output is still printed)
+    #[arg(long = "fail-if-comments")]
+    pub fail_if_comments: bool,
+
     /// Show CI check statuses instead of review comments
     #[arg(long)]
     pub checks: bool,
 
+    /// Run the parse, filter and format pipeline on built-in samples (no network)
+    #[arg(long)]
+    pub selftest: bool,
+
+    /// Print the JSON Schema of --format json output and exit
+    #[arg(long = "emit-schema")]
+    pub emit_schema: bool,
+
     /// Update pr-comments to the latest version from GitHub
     #[arg(long)]
     pub update: bool,
@@ -68,12 +448,60 @@ impl Args {
     pub fn is_update_request(&self) -> bool {
         self.update || self.pr.as_deref() == Some("update")
     }
+
+    /// Returns the (before, after) snippet window from `--context-before` and
+    /// `--context-after`, defaulting a missing side to
+    /// [`DEFAULT_CONTEXT_LINES`], or None when neither is given.
+    pub fn context_window(&self) -> Option<(usize, usize)> {
+        if self.context_before.is_none() && self.context_after.is_none() {
+            return None;
+        }
+        Some((
+            self.context_before.unwrap_or(DEFAULT_CONTEXT_LINES),
+            self.context_after.unwrap_or(DEFAULT_CONTEXT_LINES),
+        ))
+    }
+
+    /// Returns the exit status for a run that left `comment_count` comments:
+    /// `--empty-exit-code` when none remain, [`COMMENTS_FOUND_EXIT_CODE`]
+    /// with `--fail-if-comments` when some do, else 0.
+    pub fn exit_code_for_comments(&self, comment_count: usize) -> u8 {
+        match comment_count {
+            0 => self.empty_exit_code,
+            _ if self.fail_if_comments => COMMENTS_FOUND_EXIT_CODE,
+            _ => 0,
+        }
+    }
+
+    /// Returns the flags given that need the GitHub API, which a
+    /// `--from-file` replay can't serve.
+    pub fn api_only_flags(&self) -> Vec<&'static str> {
+        [
+            ("--include-general", self.include_general),
+            ("--diff-order", self.diff_order),
+            ("--show-diff-stats", self.show_diff_stats),
+            ("--orphaned-only", self.orphaned_only),
+            ("--only-changed-files", self.only_changed_files),
+            ("--coverage", self.coverage),
+            ("--since-review", self.since_review),
+            ("--resolved", self.resolved),
+            ("--unresolved", self.unresolved),
+            ("--resolve-issues", self.resolve_issues),
+            ("--full-context", self.full_context.is_some()),
+            ("--fetch-source", self.fetch_source),
+            ("--require-pr-info", self.require_pr_info),
+        ]
+        .into_iter()
+        .filter_map(|(flag, set)| set.then_some(flag))
+        .collect()
+    }
 }
 
 /// Available output formats.
-#[derive(Debug, Clone, Copy, ValueEnum, PartialEq)]
+#[derive(Debug, Clone, Copy, ValueEnum, PartialEq, Default)]
 pub enum OutputFormat {
     /// Claude/LLM-optimized format (default)
+    #[default]
     Claude,
     /// Grouped by file
     Grouped,
@@ -83,30 +511,198 @@ pub enum OutputFormat {
     Minimal,
     /// JSON output
     Json,
+    /// Standalone HTML page
+    Html,
+    /// Plain prose without markdown, for screen readers and voice output
+    Plain,
+    /// Comment counts by age (<1d, 1-3d, 3-7d, >7d), for review SLA tracking
+    AgeBuckets,
+    /// Newline-delimited event stream (pr_start, comment..., pr_end) for event stores
+    NdjsonEvents,
+    /// Deduplicated "@author" handles on one line, for notification scripts
+    Mentions,
+    /// Markdown table (File, Line, Author, Comment) for PR descriptions
+    Table,
+    /// CSV (file, line, author, body, url) for spreadsheet import
+    Csv,
+    /// Newline-delimited JSON, one compact comment object per line, for jq and log pipelines
+    Ndjson,
+    /// Plain text grouped by file, code indented instead of fenced, for markdown-hostile targets
+    Text,
+    /// Slack mrkdwn message, kept within Slack's 3000-character block limit
+    Slack,
+}
+
+impl OutputFormat {
+    /// Returns the format's name as registered in the formatter registry.
+    pub fn name(&self) -> &'static str {
+        match self {
+            OutputFormat::Claude => "claude",
+            OutputFormat::Grouped => "grouped",
+            OutputFormat::Flat => "flat",
+            OutputFormat::Minimal => "minimal",
+            OutputFormat::Json => "json",
+            OutputFormat::Html => "html",
+            OutputFormat::Plain => "plain",
+            OutputFormat::AgeBuckets => "age-buckets",
+            OutputFormat::NdjsonEvents => "ndjson-events",
+            OutputFormat::Mentions => "mentions",
+            OutputFormat::Table => "table",
+            OutputFormat::Csv => "csv",
+            OutputFormat::Ndjson => "ndjson",
+            OutputFormat::Text => "text",
+            OutputFormat::Slack => "slack",
+        }
+    }
+}
+
+/// Orderings available with `--sort`.
+#[derive(Debug, Clone, Copy, ValueEnum, PartialEq)]
+pub enum SortKey {
+    /// Line number, earliest first
+    Line,
+    /// Creation time, most recent first
+    Date,
+    /// Author login, case-insensitively, then oldest first
+    Author,
+    /// File path, then line number
+    File,
+    /// Net reaction score (+1, heart, hooray minus -1, confused), highest first
+    Reactions,
+}
+
+/// Layouts available with `--group-by`.
+#[derive(Debug, Clone, Copy, Default, ValueEnum, PartialEq)]
+pub enum GroupBy {
+    /// Comments grouped by file, as the output format lays them out
+    #[default]
+    File,
+    /// "Issues", "Suggestions", "Nits" and "Info" sections across the whole PR
+    /// (claude and grouped); other formats group by inferred severity
+    Severity,
+    /// One section per reviewer, alphabetically
+    Author,
+}
+
+/// Keys available for tallying comments with `--count-by`.
+#[derive(Debug, Clone, Copy, ValueEnum, PartialEq)]
+pub enum CountKey {
+    /// Comment author login
+    Author,
+    /// File path the comment is attached to
+    File,
+    /// Inferred comment severity
+    Severity,
+    /// Day of the week the comment was created (UTC)
+    Weekday,
+}
+
+impl CountKey {
+    /// Returns the tally key for a comment.
+    pub fn key_for(&self, comment: &PRComment) -> String {
+        match self {
+            CountKey::Author => comment.author.clone(),
+            CountKey::File if comment.file_path.is_empty() => "(review)".to_string(),
+            CountKey::File => comment.file_path.clone(),
+            CountKey::Severity => comment.infer_severity().to_string(),
+            CountKey::Weekday => comment.created_at.format("%A").to_string(),
+        }
+    }
+}
+
+/// Computed keys for grouping comments with `--group-by-expr`.
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub enum GroupExpr {
+    /// `dir:N`: the first N directories of the file path
+    Dir(usize),
+    /// `ext`: the file extension
+    Ext,
+    /// `author`: the comment author login
+    Author,
+    /// `severity`: the inferred comment severity
+    Severity,
+}
+
+impl GroupExpr {
+    /// Returns the group key for a comment.
+    ///
+    /// Comments not on a file (review bodies, conversation comments) group
+    /// under "(review)" for the path-based expressions; root files group
+    /// under "." for `dir:N`.
+    pub fn key_for(&self, comment: &PRComment) -> String {
+        match self {
+            GroupExpr::Dir(_) | GroupExpr::Ext if comment.is_general() => "(review)".to_string(),
+            GroupExpr::Dir(depth) => {
+                let dirs: Vec<&str> = comment.file_path.split('/').collect();
+                let dirs = &dirs[..dirs.len() - 1];
+                if dirs.is_empty() {
+                    ".".to_string()
+                } else {
+                    dirs[..dirs.len().min(*depth)].join("/")
+                }
+            }
+            GroupExpr::Ext => std::path::Path::new(&comment.file_path)
+                .extension()
+                .map(|e| e.to_string_lossy().into_owned())
+                .unwrap_or_else(|| "(none)".to_string()),
+            GroupExpr::Author => comment.author.clone(),
+            GroupExpr::Severity => comment.infer_severity().to_string(),
+        }
+    }
+}
+
+impl std::str::FromStr for GroupExpr {
+    type Err = String;
+
+    fn from_str(s: &str) -> Result<Self, Self::Err> {
+        match s {
+            "ext" => Ok(GroupExpr::Ext),
+            "author" => Ok(GroupExpr::Author),
+            "severity" => Ok(GroupExpr::Severity),
+            _ => match s.strip_prefix("dir:").map(str::parse::<usize>) {
+                Some(Ok(depth)) if depth > 0 => Ok(GroupExpr::Dir(depth)),
+                Some(_) => Err(format!(
+                    "invalid depth in '{s}': expected dir:N with N >= 1"
+                )),
+                None => Err(format!(
+                    "unknown group expression '{s}': expected dir:N, ext, author or severity"
+                )),
+            },
+        }
+    }
 }
 
 /// Parses a GitHub PR URL or shorthand format into (owner, repo, pr_number).
 ///
 /// Supports:
-/// - Full URL: https://github.com/owner/repo/pull/123
+/// - Full URL: https://github.com/owner/repo/pull/123, or on any GitHub
+///   Enterprise host (see [`parse_pr_url_with_host`])
 /// - Shorthand: owner/repo#123
 pub fn parse_pr_url(url: &str) -> Result<(String, String, i32), ParseError> {
+    parse_pr_url_with_host(url).map(|(_, owner, repo, pr_number)| (owner, repo, pr_number))
+}
+
+/// Parses a PR URL or shorthand like [`parse_pr_url`], also returning the
+/// host of a full URL (e.g. "github.mycorp.com"). Shorthand has no host.
+pub fn parse_pr_url_with_host(
+    url: &str,
+) -> Result<(Option<String>, String, String, i32), ParseError> {
     let url = url.trim().trim_end_matches('/');
 
-    // Try full URL format: https://github.com/owner/repo/pull/123
-    if url.starts_with("https://github.com/") || url.starts_with("http://github.com/") {
-        let path = url
-            .trim_start_matches("https://github.com/")
-            .trim_start_matches("http://github.com/");
-
-        let parts: Vec<&str> = path.split('/').collect();
-        if parts.len() >= 4 && parts[2] == "pull" {
-            let owner = parts[0].to_string();
-            let repo = parts[1].to_string();
-            let pr_number = parts[3]
+    // Try full URL format: https://HOST/owner/repo/pull/123
+    if let Some(rest) = url
+        .strip_prefix("https://")
+        .or_else(|| url.strip_prefix("http://"))
+    {
+        let parts: Vec<&str> = rest.split('/').collect();
+        if parts.len() >= 5 && !parts[0].is_empty() && parts[3] == "pull" {
+            let host = parts[0].to_string();
+            let owner = parts[1].to_string();
+            let repo = parts[2].to_string();
+            let pr_number = parts[4]
                 .parse::<i32>()
-                .map_err(|_| ParseError::InvalidPrNumber(parts[3].to_string()))?;
-            return Ok((owner, repo, pr_number));
+                .map_err(|_| ParseError::InvalidPrNumber(parts[4].to_string()))?;
+            return Ok((Some(host), owner, repo, pr_number));
         }
     }
 
@@ -123,7 +719,7 @@ pub fn parse_pr_url(url: &str) -> Result<(String, String, i32), ParseError> {
                 .map_err(|_| ParseError::InvalidPrNumber(pr_part.to_string()))?;
 
             if !owner.is_empty() && !repo.is_empty() {
-                return Ok((owner, repo, pr_number));
+                return Ok((None, owner, repo, pr_number));
             }
         }
     }
@@ -131,6 +727,19 @@ pub fn parse_pr_url(url: &str) -> Result<(String, String, i32), ParseError> {
     Err(ParseError::InvalidUrl(url.to_string()))
 }
 
+/// Parses a `--since` value: an RFC3339 timestamp, or a date meaning
+/// midnight UTC.
+pub fn parse_since(value: &str) -> Result<DateTime<Utc>, String> {
+    if let Ok(time) = crate::parser::parse_datetime(value) {
+        return Ok(time);
+    }
+    NaiveDate::parse_from_str(value, "%Y-%m-%d")
+        .map(|date| date.and_time(chrono::NaiveTime::MIN).and_utc())
+        .map_err(|_| {
+            format!("invalid date '{value}': expected RFC3339 (2024-01-15T10:30:00Z) or YYYY-MM-DD")
+        })
+}
+
 /// Resolves CLI arguments into (owner, repo, pr_number).
 ///
 /// Priority:
@@ -152,10 +761,155 @@ pub fn resolve_pr_args(args: &Args) -> Result<(String, String, i32), ParseError>
     ))
 }
 
+/// Returns true if the argument `id` was given on the command line, as
+/// opposed to taking a default or not being set at all.
+pub fn given_on_command_line(matches: &ArgMatches, id: &str) -> bool {
+    matches.value_source(id) == Some(ValueSource::CommandLine)
+}
+
+/// Environment variables that supply defaults for common flags.
+pub const OWNER_ENV: &str = "PR_COMMENTS_OWNER";
+pub const REPO_ENV: &str = "PR_COMMENTS_REPO";
+pub const FORMAT_ENV: &str = "PR_COMMENTS_FORMAT";
+pub const AUTHOR_ENV: &str = "PR_COMMENTS_AUTHOR";
+
+/// Fills `--owner`, `--repo`, `--format` and `--author` from the
+/// `PR_COMMENTS_*` environment variables when not given on the command line.
+///
+/// Explicit flags win over the environment, which wins over built-in
+/// defaults. `env` looks a variable up (e.g. `|k| std::env::var(k).ok()`);
+/// empty values count as unset.
+pub fn resolve_config(
+    mut args: Args,
+    matches: &ArgMatches,
+    env: impl Fn(&str) -> Option<String>,
+) -> Result<Args, ParseError> {
+    let from_env = |id: &str, var: &str| {
+        let explicit = given_on_command_line(matches, id);
+        env(var).filter(|v| !explicit && !v.is_empty())
+    };
+
+    // --current finds the repository itself
+    if !args.current {
+        if let Some(owner) = from_env("owner", OWNER_ENV) {
+            args.owner = Some(owner);
+        }
+        if let Some(repo) = from_env("repo", REPO_ENV) {
+            args.repo = Some(repo);
+        }
+    }
+    if let Some(format) = from_env("format", FORMAT_ENV) {
+        args.format = OutputFormat::from_str(&format, true)
+            .map_err(|e| ParseError::InvalidEnvVar(FORMAT_ENV.to_string(), e))?;
+    }
+    if let Some(authors) = from_env("author", AUTHOR_ENV) {
+        args.author = authors.split(',').map(|a| a.trim().to_string()).collect();
+    }
+    Ok(args)
+}
+
+/// Resolves the GitHub host to query: `--host` if given, else the host of
+/// a full PR URL. None means gh's default host; with `--current` the host
+/// of the branch's PR URL replaces it once that's known.
+pub fn resolve_pr_host(args: &Args) -> Option<String> {
+    args.host.clone().or_else(|| {
+        let pr = args.pr.as_deref()?;
+        parse_pr_url_with_host(pr).ok()?.0
+    })
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
 
+    /// Parses `argv` and resolves env defaults from `vars` instead of the
+    /// process environment.
+    fn parse_with_env(argv: &[&str], vars: &[(&str, &str)]) -> Result<Args, ParseError> {
+        use clap::{CommandFactory, FromArgMatches};
+        let matches = Args::command().get_matches_from(argv);
+        let args = Args::from_arg_matches(&matches).unwrap();
+        resolve_config(args, &matches, |key| {
+            vars.iter()
+                .find(|(k, _)| *k == key)
+                .map(|(_, v)| v.to_string())
+        })
+    }
+
+    #[test]
+    fn test_resolve_config_uses_env_when_flags_absent() {
+        let vars = [
+            (OWNER_ENV, "ROKT"),
+            (REPO_ENV, "canal"),
+            (FORMAT_ENV, "JSON"),
+            (AUTHOR_ENV, "alice, bob"),
+        ];
+        let args = parse_with_env(&["pr-comments", "--pr-number", "7"], &vars).unwrap();
+        assert_eq!(args.owner.as_deref(), Some("ROKT"));
+        assert_eq!(args.repo.as_deref(), Some("canal"));
+        assert_eq!(args.format, OutputFormat::Json);
+        assert_eq!(args.author, vec!["alice".to_string(), "bob".to_string()]);
+        assert_eq!(
+            resolve_pr_args(&args).unwrap(),
+            ("ROKT".into(), "canal".into(), 7)
+        );
+    }
+
+    #[test]
+    fn test_resolve_config_flags_win_over_env() {
+        let vars = [
+            (OWNER_ENV, "ROKT"),
+            (REPO_ENV, "canal"),
+            (FORMAT_ENV, "json"),
+            (AUTHOR_ENV, "alice"),
+        ];
+        let args = parse_with_env(
+            &[
+                "pr-comments",
+                "-o",
+                "me",
+                "-r",
+                "mine",
+                "--pr-number",
+                "1",
+                "--format",
+                "claude",
+                "-a",
+                "carol",
+            ],
+            &vars,
+        )
+        .unwrap();
+        assert_eq!(args.owner.as_deref(), Some("me"));
+        assert_eq!(args.repo.as_deref(), Some("mine"));
+        assert_eq!(args.format, OutputFormat::Claude);
+        assert_eq!(args.author, vec!["carol".to_string()]);
+    }
+
+    #[test]
+    fn test_resolve_config_defaults_without_env() {
+        let args = parse_with_env(&["pr-comments", "ROKT/canal#1"], &[(FORMAT_ENV, "")]).unwrap();
+        assert!(args.owner.is_none());
+        assert!(args.repo.is_none());
+        assert_eq!(args.format, OutputFormat::Claude);
+        assert!(args.author.is_empty());
+    }
+
+    #[test]
+    fn test_resolve_config_current_ignores_repo_env() {
+        let vars = [(OWNER_ENV, "ROKT"), (REPO_ENV, "canal")];
+        let args = parse_with_env(&["pr-comments", "--current"], &vars).unwrap();
+        assert!(args.owner.is_none());
+        assert!(args.repo.is_none());
+    }
+
+    #[test]
+    fn test_resolve_config_invalid_format_env() {
+        let err =
+            parse_with_env(&["pr-comments", "ROKT/canal#1"], &[(FORMAT_ENV, "yaml")]).unwrap_err();
+        assert!(err.to_string().starts_with("Invalid PR_COMMENTS_FORMAT: "));
+        assert!(err.to_string().contains("yaml"));
+    }
+
     #[test]
     fn test_parse_pr_url_full_url() {
         let (owner, repo, pr) = parse_pr_url("https://github.com/ROKT/canal/pull/14777").unwrap();
@@ -180,6 +934,35 @@ mod tests {
         assert_eq!(pr, 14777);
     }
 
+    #[test]
+    fn test_parse_pr_url_enterprise_host() {
+        let (host, owner, repo, pr) =
+            parse_pr_url_with_host("https://github.mycorp.com/team/service/pull/42/files").unwrap();
+        assert_eq!(host.as_deref(), Some("github.mycorp.com"));
+        assert_eq!((owner.as_str(), repo.as_str(), pr), ("team", "service", 42));
+
+        let (host, ..) = parse_pr_url_with_host("https://github.com/ROKT/canal/pull/1").unwrap();
+        assert_eq!(host.as_deref(), Some("github.com"));
+        let (host, ..) = parse_pr_url_with_host("ROKT/canal#1").unwrap();
+        assert_eq!(host, None);
+
+        assert!(parse_pr_url("https://github.mycorp.com/team/service/issues/42").is_err());
+        assert!(parse_pr_url("https://github.mycorp.com/team/service/pull/x").is_err());
+    }
+
+    #[test]
+    fn test_resolve_pr_host() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert_eq!(resolve_pr_host(&args), None);
+        let args = Args::parse_from(["pr-comments", "https://ghe.corp/ROKT/canal/pull/123"]);
+        assert_eq!(resolve_pr_host(&args).as_deref(), Some("ghe.corp"));
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--host", "ghe.corp"]);
+        assert_eq!(resolve_pr_host(&args).as_deref(), Some("ghe.corp"));
+        let args = Args::parse_from(["pr-comments", "not a pr"]);
+        assert_eq!(resolve_pr_host(&args), None);
+        assert_eq!(resolve_pr_host(&Args::default()), None);
+    }
+
     #[test]
     fn test_parse_pr_url_invalid() {
         let result = parse_pr_url("invalid-url");
@@ -210,6 +993,33 @@ mod tests {
         assert_eq!(args.format, OutputFormat::Grouped);
     }
 
+    #[test]
+    fn test_output_format_name() {
+        assert_eq!(OutputFormat::Claude.name(), "claude");
+        assert_eq!(OutputFormat::Grouped.name(), "grouped");
+        assert_eq!(OutputFormat::Flat.name(), "flat");
+        assert_eq!(OutputFormat::Minimal.name(), "minimal");
+        assert_eq!(OutputFormat::Json.name(), "json");
+        assert_eq!(OutputFormat::Html.name(), "html");
+        assert_eq!(OutputFormat::Plain.name(), "plain");
+        assert_eq!(OutputFormat::AgeBuckets.name(), "age-buckets");
+        assert_eq!(OutputFormat::NdjsonEvents.name(), "ndjson-events");
+        assert_eq!(OutputFormat::Ndjson.name(), "ndjson");
+        assert_eq!(OutputFormat::Text.name(), "text");
+        assert_eq!(OutputFormat::Slack.name(), "slack");
+        assert_eq!(OutputFormat::Mentions.name(), "mentions");
+        assert_eq!(OutputFormat::Table.name(), "table");
+        assert_eq!(OutputFormat::Csv.name(), "csv");
+    }
+
+    #[test]
+    fn test_output_format_names_are_registered() {
+        let registered = crate::formatter::registered_formats();
+        for format in OutputFormat::value_variants() {
+            assert!(registered.contains(&format.name().to_string()));
+        }
+    }
+
     #[test]
     fn test_resolve_pr_args_explicit() {
         let args = Args {
@@ -217,14 +1027,8 @@ mod tests {
             owner: Some("owner".to_string()),
             repo: Some("repo".to_string()),
             pr_number: Some(123),
-            author: None,
-            most_recent: false,
-            format: OutputFormat::Claude,
-            no_snippet: false,
             snippet_lines: 15,
-            output: None,
-            checks: false,
-            update: false,
+            ..Args::default()
         };
         let (owner, repo, pr) = resolve_pr_args(&args).unwrap();
         assert_eq!(owner, "owner");
@@ -239,14 +1043,8 @@ mod tests {
             owner: None,
             repo: None,
             pr_number: None,
-            author: None,
-            most_recent: false,
-            format: OutputFormat::Claude,
-            no_snippet: false,
             snippet_lines: 15,
-            output: None,
-            checks: false,
-            update: false,
+            ..Args::default()
         };
         let (owner, repo, pr) = resolve_pr_args(&args).unwrap();
         assert_eq!(owner, "ROKT");
@@ -261,14 +1059,8 @@ mod tests {
             owner: None,
             repo: None,
             pr_number: None,
-            author: None,
-            most_recent: false,
-            format: OutputFormat::Claude,
-            no_snippet: false,
             snippet_lines: 15,
-            output: None,
-            checks: false,
-            update: false,
+            ..Args::default()
         };
         let result = resolve_pr_args(&args);
         assert!(result.is_err());
@@ -277,7 +1069,67 @@ mod tests {
     #[test]
     fn test_args_author_filter() {
         let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--author", "testuser"]);
-        assert_eq!(args.author, Some("testuser".to_string()));
+        assert_eq!(args.author, vec!["testuser".to_string()]);
+    }
+
+    #[test]
+    fn test_args_author_regex() {
+        let args = Args::parse_from([
+            "pr-comments",
+            "ROKT/canal#123",
+            "--author-regex",
+            r".*\[bot\]$",
+        ]);
+        assert_eq!(args.author_regex.unwrap().as_str(), r".*\[bot\]$");
+        let result = Args::try_parse_from([
+            "pr-comments",
+            "ROKT/canal#123",
+            "--author-regex",
+            "[unclosed",
+        ]);
+        assert!(result.is_err());
+    }
+
+    #[test]
+    fn test_args_from_file() {
+        let args = Args::parse_from([
+            "pr-comments",
+            "ROKT/canal#123",
+            "--from-file",
+            "comments.json",
+            "--pr-title",
+            "Add feature",
+        ]);
+        assert_eq!(args.from_file.as_deref(), Some("comments.json"));
+        assert_eq!(args.pr_title.as_deref(), Some("Add feature"));
+        assert!(args.pr_url.is_none());
+
+        let result = Args::try_parse_from(["pr-comments", "ROKT/canal#123", "--pr-title", "x"]);
+        assert!(result.is_err());
+        let result = Args::try_parse_from([
+            "pr-comments",
+            "ROKT/canal#123",
+            "--from-file",
+            "comments.json",
+            "--checks",
+        ]);
+        assert!(result.is_err());
+    }
+
+    #[test]
+    fn test_args_multiple_authors() {
+        let args = Args::parse_from([
+            "pr-comments",
+            "ROKT/canal#123",
+            "--author",
+            "reviewer1,reviewer2",
+            "-a",
+            "reviewer3",
+        ]);
+        assert_eq!(args.author, vec!["reviewer1", "reviewer2", "reviewer3"]);
+
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert!(args.author.is_empty());
     }
 
     #[test]
@@ -286,6 +1138,847 @@ mod tests {
         assert!(args.most_recent);
     }
 
+    #[test]
+    fn test_args_line() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--line", "1"]);
+        assert_eq!(args.line, Some(1));
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert!(args.line.is_none());
+    }
+
+    #[test]
+    fn test_args_current() {
+        let args = Args::parse_from(["pr-comments", "--current"]);
+        assert!(args.current);
+        assert!(args.pr.is_none());
+        let result = Args::try_parse_from(["pr-comments", "ROKT/canal#123", "--current"]);
+        assert!(result.is_err());
+    }
+
+    #[test]
+    fn test_args_line_range() {
+        let args = Args::parse_from([
+            "pr-comments",
+            "ROKT/canal#123",
+            "--min-line",
+            "100",
+            "--max-line",
+            "200",
+        ]);
+        assert_eq!((args.min_line, args.max_line), (Some(100), Some(200)));
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--min-line", "100"]);
+        assert_eq!((args.min_line, args.max_line), (Some(100), None));
+    }
+
+    #[test]
+    fn test_args_split_by_comment() {
+        let args = Args::parse_from([
+            "pr-comments",
+            "ROKT/canal#123",
+            "--split-by-comment",
+            "out/comments",
+        ]);
+        assert_eq!(args.split_by_comment.as_deref(), Some("out/comments"));
+    }
+
+    #[test]
+    fn test_args_normalize_bot_names() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--normalize-bot-names"]);
+        assert!(args.normalize_bot_names);
+    }
+
+    #[test]
+    fn test_args_line_refs() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--line-refs"]);
+        assert!(args.line_refs);
+    }
+
+    #[test]
+    fn test_args_stats() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert!(!args.stats);
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--stats"]);
+        assert!(args.stats);
+        assert!(
+            Args::try_parse_from(["pr-comments", "ROKT/canal#123", "--stats", "--with-stats"])
+                .is_err()
+        );
+    }
+
+    #[test]
+    fn test_args_with_stats() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--with-stats"]);
+        assert!(args.with_stats);
+    }
+
+    #[test]
+    fn test_args_include_raw_diff() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--include-raw-diff"]);
+        assert!(args.include_raw_diff);
+    }
+
+    #[test]
+    fn test_args_only_changed_files() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--only-changed-files"]);
+        assert!(args.only_changed_files);
+        assert!(Args::try_parse_from([
+            "pr-comments",
+            "ROKT/canal#123",
+            "--only-changed-files",
+            "--orphaned-only"
+        ])
+        .is_err());
+    }
+
+    #[test]
+    fn test_args_orphaned_only() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--orphaned-only"]);
+        assert!(args.orphaned_only);
+    }
+
+    #[test]
+    fn test_args_file_glob() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert!(args.file.is_none());
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--file", "src/**/*.py"]);
+        assert_eq!(args.file.unwrap().glob(), "src/**/*.py");
+
+        // Invalid globs are rejected at parse time
+        assert!(
+            Args::try_parse_from(["pr-comments", "ROKT/canal#123", "--file", "src/[.py"]).is_err()
+        );
+    }
+
+    #[test]
+    fn test_args_exclude_tests() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert!(!args.exclude_tests);
+        assert!(args.test_glob.is_empty());
+        let args = Args::parse_from([
+            "pr-comments",
+            "ROKT/canal#123",
+            "--exclude-tests",
+            "--test-glob",
+            "fixtures/**",
+            "--test-glob",
+            "*_spec.rb",
+        ]);
+        assert!(args.exclude_tests);
+        assert_eq!(args.test_glob[1].glob(), "*_spec.rb");
+
+        // --test-glob needs --exclude-tests, and must be a valid glob
+        assert!(
+            Args::try_parse_from(["pr-comments", "ROKT/canal#123", "--test-glob", "*.rb"]).is_err()
+        );
+        assert!(Args::try_parse_from([
+            "pr-comments",
+            "ROKT/canal#123",
+            "--exclude-tests",
+            "--test-glob",
+            "a[",
+        ])
+        .is_err());
+    }
+
+    #[test]
+    fn test_args_hotspots() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert_eq!(args.hotspots_only, None);
+        assert_eq!(args.hotspot_radius, 5);
+        let args = Args::parse_from([
+            "pr-comments",
+            "ROKT/canal#123",
+            "--hotspots-only",
+            "3",
+            "--hotspot-radius",
+            "10",
+        ]);
+        assert_eq!(args.hotspots_only, Some(3));
+        assert_eq!(args.hotspot_radius, 10);
+    }
+
+    #[test]
+    fn test_args_only_new() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--only-new"]);
+        assert!(args.only_new);
+    }
+
+    #[test]
+    fn test_args_proxy_and_ca_bundle() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert_eq!(args.proxy, None);
+        assert_eq!(args.ca_bundle, None);
+        let args = Args::parse_from([
+            "pr-comments",
+            "ROKT/canal#123",
+            "--proxy",
+            "http://proxy.corp:8080",
+            "--ca-bundle",
+            "/etc/ssl/corp-ca.pem",
+        ]);
+        assert_eq!(args.proxy.as_deref(), Some("http://proxy.corp:8080"));
+        assert_eq!(args.ca_bundle.as_deref(), Some("/etc/ssl/corp-ca.pem"));
+    }
+
+    #[test]
+    fn test_args_retries() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert_eq!(args.retries, 2);
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--retries", "0"]);
+        assert_eq!(args.retries, 0);
+    }
+
+    #[test]
+    fn test_args_timeout() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert_eq!(args.timeout, 30);
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--timeout", "0"]);
+        assert_eq!(args.timeout, 0);
+    }
+
+    #[test]
+    fn test_args_collapse_blank_lines() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert!(!args.collapse_blank_lines);
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--collapse-blank-lines"]);
+        assert!(args.collapse_blank_lines);
+    }
+
+    #[test]
+    fn test_args_agent_order() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--agent-order"]);
+        assert!(args.agent_order);
+        let result = Args::try_parse_from([
+            "pr-comments",
+            "ROKT/canal#123",
+            "--agent-order",
+            "--diff-order",
+        ]);
+        assert!(result.is_err());
+    }
+
+    #[test]
+    fn test_args_selftest() {
+        let args = Args::parse_from(["pr-comments", "--selftest"]);
+        assert!(args.selftest);
+        assert!(args.pr.is_none());
+    }
+
+    #[test]
+    fn test_args_emit_schema() {
+        let args = Args::parse_from(["pr-comments", "--emit-schema"]);
+        assert!(args.emit_schema);
+        assert!(args.pr.is_none());
+    }
+
+    #[test]
+    fn test_args_extract_tasks() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert!(!args.extract_tasks);
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--extract-tasks"]);
+        assert!(args.extract_tasks);
+    }
+
+    #[test]
+    fn test_args_front_matter() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert!(!args.front_matter);
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--front-matter"]);
+        assert!(args.front_matter);
+    }
+
+    #[test]
+    fn test_args_snippet_grep() {
+        let args = Args::parse_from([
+            "pr-comments",
+            "ROKT/canal#123",
+            "--snippet-grep",
+            r"bulk_\w+",
+        ]);
+        assert_eq!(args.snippet_grep.unwrap().as_str(), r"bulk_\w+");
+        let result = Args::try_parse_from([
+            "pr-comments",
+            "ROKT/canal#123",
+            "--snippet-grep",
+            "(unclosed",
+        ]);
+        assert!(result.is_err());
+    }
+
+    #[test]
+    fn test_args_include_branches() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert!(!args.include_branches);
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--include-branches"]);
+        assert!(args.include_branches);
+    }
+
+    #[test]
+    fn test_args_since() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--since", "2024-01-15"]);
+        assert_eq!(
+            args.since.unwrap().to_rfc3339(),
+            "2024-01-15T00:00:00+00:00"
+        );
+        let args = Args::parse_from([
+            "pr-comments",
+            "ROKT/canal#123",
+            "--since",
+            "2024-01-15T10:30:00+02:00",
+        ]);
+        assert_eq!(
+            args.since.unwrap().to_rfc3339(),
+            "2024-01-15T08:30:00+00:00"
+        );
+
+        let err = Args::try_parse_from(["pr-comments", "ROKT/canal#123", "--since", "last week"])
+            .unwrap_err();
+        assert!(err.to_string().contains("expected RFC3339"));
+    }
+
+    #[test]
+    fn test_args_coverage() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert!(!args.coverage);
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--coverage"]);
+        assert!(args.coverage);
+    }
+
+    #[test]
+    fn test_args_prepend_and_append_file() {
+        let args = Args::parse_from([
+            "pr-comments",
+            "ROKT/canal#123",
+            "--prepend-file",
+            "intro.md",
+            "--append-file",
+            "outro.md",
+        ]);
+        assert_eq!(args.prepend_file.as_deref(), Some("intro.md"));
+        assert_eq!(args.append_file.as_deref(), Some("outro.md"));
+    }
+
+    #[test]
+    fn test_args_remap_with() {
+        let args = Args::parse_from([
+            "pr-comments",
+            "ROKT/canal#123",
+            "--remap-with",
+            "head.patch",
+        ]);
+        assert_eq!(args.remap_with.as_deref(), Some("head.patch"));
+    }
+
+    #[test]
+    fn test_args_show_word_count() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert!(!args.show_word_count);
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--show-word-count"]);
+        assert!(args.show_word_count);
+    }
+
+    #[test]
+    fn test_args_clipboard() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--clipboard"]);
+        assert!(args.clipboard);
+        let result =
+            Args::try_parse_from(["pr-comments", "ROKT/canal#123", "--clipboard", "-O", "x.md"]);
+        assert!(result.is_err());
+    }
+
+    #[test]
+    fn test_args_also_format() {
+        let args = Args::parse_from([
+            "pr-comments",
+            "ROKT/canal#123",
+            "--also-format",
+            "json",
+            "--also-output",
+            "comments.json",
+        ]);
+        assert_eq!(args.also_format, Some(OutputFormat::Json));
+        assert_eq!(args.also_output.as_deref(), Some("comments.json"));
+
+        // Each needs the other
+        for flag in [["--also-format", "json"], ["--also-output", "c.json"]] {
+            let mut argv = vec!["pr-comments", "ROKT/canal#123"];
+            argv.extend(flag);
+            assert!(Args::try_parse_from(argv).is_err());
+        }
+    }
+
+    #[test]
+    fn test_args_max_output_bytes() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert_eq!(args.max_output_bytes, None);
+        let args = Args::parse_from([
+            "pr-comments",
+            "ROKT/canal#123",
+            "--max-output-bytes",
+            "4096",
+        ]);
+        assert_eq!(args.max_output_bytes, Some(4096));
+    }
+
+    #[test]
+    fn test_args_keep_ansi() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert!(!args.keep_ansi);
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--keep-ansi"]);
+        assert!(args.keep_ansi);
+    }
+
+    #[test]
+    fn test_args_show_size() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--show-size"]);
+        assert!(args.show_size);
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert!(!args.show_size);
+    }
+
+    #[test]
+    fn test_context_window() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert_eq!(args.context_window(), None);
+
+        let args = Args::parse_from([
+            "pr-comments",
+            "ROKT/canal#123",
+            "--context-before",
+            "5",
+            "--context-after",
+            "2",
+        ]);
+        assert_eq!(args.context_window(), Some((5, 2)));
+
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--context-after", "8"]);
+        assert_eq!(args.context_window(), Some((DEFAULT_CONTEXT_LINES, 8)));
+    }
+
+    #[test]
+    fn test_api_only_flags() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--from-file", "c.json"]);
+        assert!(args.api_only_flags().is_empty());
+
+        let args = Args::parse_from([
+            "pr-comments",
+            "ROKT/canal#123",
+            "--include-general",
+            "--resolved",
+            "--full-context",
+            "5",
+        ]);
+        assert_eq!(
+            args.api_only_flags(),
+            vec!["--include-general", "--resolved", "--full-context"]
+        );
+    }
+
+    #[test]
+    fn test_exit_code_for_comments() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert_eq!(args.exit_code_for_comments(0), 0);
+        assert_eq!(args.exit_code_for_comments(3), 0);
+
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--fail-if-comments"]);
+        assert!(args.fail_if_comments);
+        assert_eq!(args.exit_code_for_comments(0), 0);
+        assert_eq!(args.exit_code_for_comments(1), COMMENTS_FOUND_EXIT_CODE);
+
+        let args = Args::parse_from([
+            "pr-comments",
+            "ROKT/canal#123",
+            "--fail-if-comments",
+            "--empty-exit-code",
+            "3",
+        ]);
+        assert_eq!(args.exit_code_for_comments(0), 3);
+        assert_eq!(args.exit_code_for_comments(5), 2);
+    }
+
+    #[test]
+    fn test_help_documents_exit_status() {
+        use clap::CommandFactory;
+        let help = Args::command().render_long_help().to_string();
+        assert!(help.contains("Exit status:"));
+        assert!(help.contains("2  comments remain after filtering, with --fail-if-comments"));
+    }
+
+    #[test]
+    fn test_args_empty_result() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert_eq!(args.empty_message, None);
+        assert_eq!(args.empty_exit_code, 0);
+        let args = Args::parse_from([
+            "pr-comments",
+            "ROKT/canal#123",
+            "--empty-message",
+            "NO_COMMENTS",
+            "--empty-exit-code",
+            "3",
+        ]);
+        assert_eq!(args.empty_message.as_deref(), Some("NO_COMMENTS"));
+        assert_eq!(args.empty_exit_code, 3);
+        assert!(Args::try_parse_from(["pr-comments", "--empty-exit-code", "256"]).is_err());
+    }
+
+    #[test]
+    fn test_args_resolve_issues() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert!(!args.resolve_issues);
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--resolve-issues"]);
+        assert!(args.resolve_issues);
+    }
+
+    #[test]
+    fn test_args_include_general() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert!(!args.include_general);
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--include-general"]);
+        assert!(args.include_general);
+    }
+
+    #[test]
+    fn test_args_fetch_source() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert!(!args.fetch_source);
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--fetch-source"]);
+        assert!(args.fetch_source);
+    }
+
+    #[test]
+    fn test_args_test_requests() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert!(!args.test_requests_only);
+        assert!(!args.flag_test_requests);
+        let args = Args::parse_from([
+            "pr-comments",
+            "ROKT/canal#123",
+            "--test-requests-only",
+            "--flag-test-requests",
+        ]);
+        assert!(args.test_requests_only);
+        assert!(args.flag_test_requests);
+    }
+
+    #[test]
+    fn test_args_blocking_only() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert!(!args.blocking_only);
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--blocking-only"]);
+        assert!(args.blocking_only);
+    }
+
+    #[test]
+    fn test_args_suggestions_only() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert!(!args.suggestions_only);
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--suggestions-only"]);
+        assert!(args.suggestions_only);
+    }
+
+    #[test]
+    fn test_args_links() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert!(!args.with_links);
+        assert!(!args.extract_links);
+        let args = Args::parse_from([
+            "pr-comments",
+            "ROKT/canal#123",
+            "--with-links",
+            "--extract-links",
+        ]);
+        assert!(args.with_links);
+        assert!(args.extract_links);
+    }
+
+    #[test]
+    fn test_args_log_level() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert_eq!(args.log_level, LogLevel::Info);
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--log-level", "debug"]);
+        assert_eq!(args.log_level, LogLevel::Debug);
+    }
+
+    #[test]
+    fn test_args_require_pr_info() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert!(!args.require_pr_info);
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--require-pr-info"]);
+        assert!(args.require_pr_info);
+    }
+
+    #[test]
+    fn test_args_since_review() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--since-review"]);
+        assert!(args.since_review);
+    }
+
+    #[test]
+    fn test_args_sort() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--sort", "reactions"]);
+        assert_eq!(args.sort, Some(SortKey::Reactions));
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--sort", "author"]);
+        assert_eq!(args.sort, Some(SortKey::Author));
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert!(args.sort.is_none());
+        assert!(Args::try_parse_from(["pr-comments", "ROKT/canal#123", "--sort", "size"]).is_err());
+    }
+
+    #[test]
+    fn test_args_show_diff_stats() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--show-diff-stats"]);
+        assert!(args.show_diff_stats);
+    }
+
+    #[test]
+    fn test_args_diff_order() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--diff-order"]);
+        assert!(args.diff_order);
+    }
+
+    #[test]
+    fn test_args_human_responses_to_bots() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--human-responses-to-bots"]);
+        assert!(args.human_responses_to_bots);
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert!(!args.human_responses_to_bots);
+    }
+
+    #[test]
+    fn test_args_resolved() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert!(!args.resolved && !args.unresolved);
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--resolved"]);
+        assert!(args.resolved);
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--unresolved"]);
+        assert!(args.unresolved);
+        let result = Args::try_parse_from([
+            "pr-comments",
+            "ROKT/canal#123",
+            "--resolved",
+            "--unresolved",
+        ]);
+        assert!(result.is_err());
+    }
+
+    #[test]
+    fn test_args_mentions() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--format", "mentions"]);
+        assert_eq!(args.format, OutputFormat::Mentions);
+        assert!(!args.mention_bots);
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--mention-bots"]);
+        assert!(args.mention_bots);
+    }
+
+    #[test]
+    fn test_args_min_severity() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--min-severity", "warning"]);
+        assert_eq!(args.min_severity, Some(Severity::Warning));
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert_eq!(args.min_severity, None);
+        assert!(
+            Args::try_parse_from(["pr-comments", "ROKT/canal#123", "--min-severity", "major"])
+                .is_err()
+        );
+    }
+
+    #[test]
+    fn test_args_no_bots() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--no-bots"]);
+        assert!(args.no_bots);
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert!(!args.no_bots);
+    }
+
+    #[test]
+    fn test_args_full_context() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--full-context", "20"]);
+        assert_eq!(args.full_context, Some(20));
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert!(args.full_context.is_none());
+    }
+
+    #[test]
+    fn test_args_redact() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--redact"]);
+        assert!(args.redact);
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert!(!args.redact);
+    }
+
+    #[test]
+    fn test_args_dedupe() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--dedupe"]);
+        assert!(args.dedupe);
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert!(!args.dedupe);
+    }
+
+    #[test]
+    fn test_args_limit() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--limit", "50"]);
+        assert_eq!(args.limit, 50);
+
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert_eq!(args.limit, 0);
+    }
+
+    #[test]
+    fn test_args_max_body_chars() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--max-body-chars", "280"]);
+        assert_eq!(args.max_body_chars, Some(280));
+    }
+
+    #[test]
+    fn test_args_snippet_after() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--snippet-after"]);
+        assert!(args.snippet_after);
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert!(!args.snippet_after);
+    }
+
+    #[test]
+    fn test_args_annotate_diff() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--annotate-diff"]);
+        assert!(args.annotate_diff);
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert!(!args.annotate_diff);
+    }
+
+    #[test]
+    fn test_args_wrap_snippet() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--wrap-snippet", "120"]);
+        assert_eq!(args.wrap_snippet, Some(120));
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert!(args.wrap_snippet.is_none());
+    }
+
+    #[test]
+    fn test_args_html_format_with_avatars() {
+        let args = Args::parse_from([
+            "pr-comments",
+            "ROKT/canal#123",
+            "--format",
+            "html",
+            "--html-avatars",
+        ]);
+        assert_eq!(args.format, OutputFormat::Html);
+        assert!(args.html_avatars);
+    }
+
+    #[test]
+    fn test_args_count_by() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--count-by", "author"]);
+        assert_eq!(args.count_by, Some(CountKey::Author));
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert!(args.count_by.is_none());
+    }
+
+    #[test]
+    fn test_count_key_for() {
+        use chrono::{TimeZone, Utc};
+        let comment = PRComment::new(
+            1,
+            None,
+            "src/main.rs".to_string(),
+            Some(10),
+            None,
+            "reviewer1".to_string(),
+            "nit: spacing".to_string(),
+            // 2024-01-15 was a Monday
+            Utc.with_ymd_and_hms(2024, 1, 15, 10, 0, 0).unwrap(),
+            Utc.with_ymd_and_hms(2024, 1, 15, 10, 0, 0).unwrap(),
+            String::new(),
+            String::new(),
+        );
+        assert_eq!(CountKey::Author.key_for(&comment), "reviewer1");
+        assert_eq!(CountKey::File.key_for(&comment), "src/main.rs");
+        assert_eq!(CountKey::Severity.key_for(&comment), "nit");
+        assert_eq!(CountKey::Weekday.key_for(&comment), "Monday");
+
+        let mut review = comment.clone();
+        review.file_path = String::new();
+        assert_eq!(CountKey::File.key_for(&review), "(review)");
+    }
+
+    #[test]
+    fn test_group_expr_from_str() {
+        assert_eq!("dir:2".parse::<GroupExpr>(), Ok(GroupExpr::Dir(2)));
+        assert_eq!("ext".parse::<GroupExpr>(), Ok(GroupExpr::Ext));
+        assert_eq!("author".parse::<GroupExpr>(), Ok(GroupExpr::Author));
+        assert_eq!("severity".parse::<GroupExpr>(), Ok(GroupExpr::Severity));
+        assert!("dir:0"
+            .parse::<GroupExpr>()
+            .unwrap_err()
+            .contains("invalid depth"));
+        assert!("dir:x".parse::<GroupExpr>().is_err());
+        assert!("size"
+            .parse::<GroupExpr>()
+            .unwrap_err()
+            .contains("unknown group expression"));
+    }
+
+    #[test]
+    fn test_group_expr_key_for() {
+        use chrono::{TimeZone, Utc};
+        let mut comment = PRComment::new(
+            1,
+            None,
+            "src/formatter/html.rs".to_string(),
+            Some(10),
+            None,
+            "reviewer1".to_string(),
+            "nit: spacing".to_string(),
+            Utc.with_ymd_and_hms(2024, 1, 15, 10, 0, 0).unwrap(),
+            Utc.with_ymd_and_hms(2024, 1, 15, 10, 0, 0).unwrap(),
+            String::new(),
+            String::new(),
+        );
+        assert_eq!(GroupExpr::Dir(1).key_for(&comment), "src");
+        assert_eq!(GroupExpr::Dir(2).key_for(&comment), "src/formatter");
+        assert_eq!(GroupExpr::Dir(5).key_for(&comment), "src/formatter");
+        assert_eq!(GroupExpr::Ext.key_for(&comment), "rs");
+        assert_eq!(GroupExpr::Author.key_for(&comment), "reviewer1");
+        assert_eq!(GroupExpr::Severity.key_for(&comment), "nit");
+
+        comment.file_path = "Makefile".to_string();
+        assert_eq!(GroupExpr::Dir(1).key_for(&comment), ".");
+        assert_eq!(GroupExpr::Ext.key_for(&comment), "(none)");
+
+        comment.file_path = String::new();
+        assert_eq!(GroupExpr::Dir(1).key_for(&comment), "(review)");
+        assert_eq!(GroupExpr::Ext.key_for(&comment), "(review)");
+    }
+
+    #[test]
+    fn test_args_group_by() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123"]);
+        assert_eq!(args.group_by, GroupBy::File);
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--group-by", "severity"]);
+        assert_eq!(args.group_by, GroupBy::Severity);
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--group-by", "author"]);
+        assert_eq!(args.group_by, GroupBy::Author);
+        let result = Args::try_parse_from([
+            "pr-comments",
+            "ROKT/canal#123",
+            "--group-by",
+            "severity",
+            "--group-by-expr",
+            "ext",
+        ]);
+        assert!(result.is_err());
+    }
+
+    #[test]
+    fn test_args_group_by_expr() {
+        let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--group-by-expr", "dir:1"]);
+        assert_eq!(args.group_by_expr, Some(GroupExpr::Dir(1)));
+        let result =
+            Args::try_parse_from(["pr-comments", "ROKT/canal#123", "--group-by-expr", "x"]);
+        assert!(result.is_err());
+    }
+
     #[test]
     fn test_args_no_snippet() {
         let args = Args::parse_from(["pr-comments", "ROKT/canal#123", "--no-snippet"]);
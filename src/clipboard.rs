@@ -0,0 +1,182 @@
+//! Copying output to the system clipboard (--clipboard).
+//!
+//! Shells out to the platform's clipboard tool: `pbcopy` on macOS,
+//! `wl-copy` or `xclip` on Linux, and `clip` on Windows.
+
+use std::io::{self, Write};
+use std::process::{Command, Stdio};
+
+/// Trait for piping text into a clipboard tool, allowing for mocking in tests.
+pub trait ClipboardRunner {
+    fn pipe(&self, program: &str, args: &[&str], input: &str) -> io::Result<()>;
+}
+
+/// Default implementation that spawns the actual clipboard tool.
+pub struct SystemClipboardRunner;
+
+impl ClipboardRunner for SystemClipboardRunner {
+    fn pipe(&self, program: &str, args: &[&str], input: &str) -> io::Result<()> {
+        let mut child = Command::new(program)
+            .args(args)
+            .stdin(Stdio::piped())
+            .stdout(Stdio::null())
+            .stderr(Stdio::null())
+            .spawn()?;
+        if let Some(mut stdin) = child.stdin.take() {
+            stdin.write_all(input.as_bytes())?;
+        }
+        let status = child.wait()?;
+        if status.success() {
+            Ok(())
+        } else {
+            Err(io::Error::other(format!("{program} exited with {status}")))
+        }
+    }
+}
+
+/// A clipboard tool: program name and arguments.
+type ClipboardTool = (&'static str, &'static [&'static str]);
+
+/// Returns the clipboard tools to try on `os` (as in
+/// `std::env::consts::OS`), in order of preference.
+pub fn clipboard_tools(os: &str) -> &'static [ClipboardTool] {
+    match os {
+        "macos" => &[("pbcopy", &[])],
+        "windows" => &[("clip", &[])],
+        "linux" | "freebsd" | "openbsd" | "netbsd" => {
+            &[("wl-copy", &[]), ("xclip", &["-selection", "clipboard"])]
+        }
+        _ => &[],
+    }
+}
+
+/// Copies `text` to the system clipboard, returning the tool that was used.
+pub fn copy_to_clipboard(text: &str) -> io::Result<&'static str> {
+    copy_to_clipboard_with_runner(text, std::env::consts::OS, &SystemClipboardRunner)
+}
+
+/// Copies `text` with a custom runner and OS (for testing).
+///
+/// Tools are tried in order; one that is missing or fails (e.g. `wl-copy`
+/// outside a Wayland session) falls through to the next.
+pub fn copy_to_clipboard_with_runner(
+    text: &str,
+    os: &str,
+    runner: &dyn ClipboardRunner,
+) -> io::Result<&'static str> {
+    let tools = clipboard_tools(os);
+    let mut errors = Vec::new();
+    for (program, args) in tools {
+        match runner.pipe(program, args, text) {
+            Ok(()) => return Ok(program),
+            Err(e) => errors.push(format!("{program}: {e}")),
+        }
+    }
+    if errors.is_empty() {
+        errors.push(format!("no clipboard tool known for {os}"));
+    }
+    Err(io::Error::new(
+        io::ErrorKind::NotFound,
+        format!("no clipboard tool available ({})", errors.join("; ")),
+    ))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::cell::RefCell;
+
+    /// Records calls and succeeds only for the listed programs.
+    struct MockRunner {
+        available: Vec<&'static str>,
+        calls: RefCell<Vec<(String, Vec<String>, String)>>,
+    }
+
+    impl MockRunner {
+        fn new(available: &[&'static str]) -> Self {
+            Self {
+                available: available.to_vec(),
+                calls: RefCell::new(Vec::new()),
+            }
+        }
+
+        fn programs(&self) -> Vec<String> {
+            self.calls.borrow().iter().map(|c| c.0.clone()).collect()
+        }
+    }
+
+    impl ClipboardRunner for MockRunner {
+        fn pipe(&self, program: &str, args: &[&str], input: &str) -> io::Result<()> {
+            self.calls.borrow_mut().push((
+                program.to_string(),
+                args.iter().map(|a| a.to_string()).collect(),
+                input.to_string(),
+            ));
+            if self.available.contains(&program) {
+                Ok(())
+            } else {
+                Err(io::Error::from(io::ErrorKind::NotFound))
+            }
+        }
+    }
+
+    #[test]
+    fn test_tool_selection_per_os() {
+        for (os, tool) in [
+            ("macos", "pbcopy"),
+            ("windows", "clip"),
+            ("linux", "wl-copy"),
+        ] {
+            let runner = MockRunner::new(&["pbcopy", "clip", "wl-copy", "xclip"]);
+            assert_eq!(
+                copy_to_clipboard_with_runner("hi", os, &runner).unwrap(),
+                tool
+            );
+            assert_eq!(runner.programs(), vec![tool]);
+            assert_eq!(runner.calls.borrow()[0].2, "hi");
+        }
+    }
+
+    #[test]
+    fn test_linux_falls_back_to_xclip() {
+        let runner = MockRunner::new(&["xclip"]);
+        assert_eq!(
+            copy_to_clipboard_with_runner("hi", "linux", &runner).unwrap(),
+            "xclip"
+        );
+        assert_eq!(runner.programs(), vec!["wl-copy", "xclip"]);
+        assert_eq!(runner.calls.borrow()[1].1, vec!["-selection", "clipboard"]);
+    }
+
+    #[test]
+    fn test_no_tool_available() {
+        let runner = MockRunner::new(&[]);
+        let err = copy_to_clipboard_with_runner("hi", "linux", &runner).unwrap_err();
+        assert_eq!(err.kind(), io::ErrorKind::NotFound);
+        assert!(err.to_string().contains("wl-copy"));
+        assert!(err.to_string().contains("xclip"));
+
+        let err = copy_to_clipboard_with_runner("hi", "plan9", &runner).unwrap_err();
+        assert!(err
+            .to_string()
+            .contains("no clipboard tool known for plan9"));
+        assert_eq!(runner.programs().len(), 2);
+    }
+
+    #[test]
+    fn test_system_runner() {
+        // cat stands in for a clipboard tool that reads stdin
+        assert!(SystemClipboardRunner.pipe("cat", &[], "hi").is_ok());
+        assert!(SystemClipboardRunner.pipe("false", &[], "").is_err());
+        let err = SystemClipboardRunner
+            .pipe("nonexistent-clipboard-tool-xyz", &[], "")
+            .unwrap_err();
+        assert_eq!(err.kind(), io::ErrorKind::NotFound);
+    }
+
+    #[test]
+    fn test_copy_to_clipboard_public_api() {
+        // Depends on the environment; either copies or reports no tool
+        let _ = copy_to_clipboard("");
+    }
+}
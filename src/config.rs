@@ -0,0 +1,403 @@
+//! Repo-local defaults from a `.prcomments.yaml` config file.
+//!
+//! The file holds flat `key: value` pairs in a small YAML subset: `#`
+//! comments, quoted strings, and lists written `[a, b]` or as `- item`
+//! lines. Values only fill in flags not given on the command line.
+
+use crate::cli::{given_on_command_line, Args, OutputFormat};
+use crate::error::ConfigError;
+use clap::{ArgMatches, ValueEnum};
+use std::fs;
+use std::io;
+use std::path::Path;
+
+/// Config file looked for in the working directory when `--config` is not given.
+pub const CONFIG_FILE_NAME: &str = ".prcomments.yaml";
+
+/// Defaults read from a config file; None keeps the flag's built-in default.
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct Config {
+    pub owner: Option<String>,
+    pub repo: Option<String>,
+    pub format: Option<OutputFormat>,
+    pub author: Option<Vec<String>>,
+    pub snippet_lines: Option<usize>,
+    pub no_snippet: Option<bool>,
+    pub no_bots: Option<bool>,
+    pub include_general: Option<bool>,
+}
+
+/// A config value: a single scalar or a list.
+#[derive(Debug, PartialEq)]
+enum Value {
+    Scalar(String),
+    List(Vec<String>),
+}
+
+impl Config {
+    /// Fills in the flags not given on the command line, returning the
+    /// updated arguments.
+    pub fn apply(&self, mut args: Args, matches: &ArgMatches) -> Args {
+        let unset = |id: &str| !given_on_command_line(matches, id);
+
+        // --current finds the repository itself
+        if !args.current {
+            if let (Some(owner), true) = (&self.owner, unset("owner")) {
+                args.owner = Some(owner.clone());
+            }
+            if let (Some(repo), true) = (&self.repo, unset("repo")) {
+                args.repo = Some(repo.clone());
+            }
+        }
+        if let (Some(format), true) = (self.format, unset("format")) {
+            args.format = format;
+        }
+        if let (Some(author), true) = (&self.author, unset("author")) {
+            args.author = author.clone();
+        }
+        if let (Some(lines), true) = (self.snippet_lines, unset("snippet_lines")) {
+            args.snippet_lines = lines;
+        }
+        if let (Some(no_snippet), true) = (self.no_snippet, unset("no_snippet")) {
+            args.no_snippet = no_snippet;
+        }
+        if let (Some(no_bots), true) = (self.no_bots, unset("no_bots")) {
+            args.no_bots = no_bots;
+        }
+        if let (Some(include), true) = (self.include_general, unset("include_general")) {
+            args.include_general = include;
+        }
+        args
+    }
+
+    /// Sets the field for `key` (dashes and underscores both accepted).
+    fn set(&mut self, key: &str, value: Value) -> Result<(), String> {
+        match key.replace('-', "_").as_str() {
+            "owner" => self.owner = Some(scalar(key, value)?),
+            "repo" => self.repo = Some(scalar(key, value)?),
+            "format" => {
+                let format = scalar(key, value)?;
+                self.format = Some(OutputFormat::from_str(&format, true)?);
+            }
+            "author" => {
+                self.author = Some(match value {
+                    Value::List(authors) => authors,
+                    Value::Scalar(authors) => split_list(&authors),
+                })
+            }
+            "snippet_lines" => {
+                let lines = scalar(key, value)?;
+                self.snippet_lines = Some(
+                    lines
+                        .parse()
+                        .map_err(|_| format!("{key} must be a number, got {lines:?}"))?,
+                );
+            }
+            "no_snippet" => self.no_snippet = Some(boolean(key, value)?),
+            "no_bots" => self.no_bots = Some(boolean(key, value)?),
+            "include_general" => self.include_general = Some(boolean(key, value)?),
+            _ => return Err(format!("unknown key {key:?}")),
+        }
+        Ok(())
+    }
+}
+
+/// Loads and parses a config file.
+pub fn load_config(path: &Path) -> Result<Config, ConfigError> {
+    let path_name = path.display().to_string();
+    let contents = fs::read_to_string(path).map_err(|source| ConfigError::Read {
+        path: path_name.clone(),
+        source,
+    })?;
+    parse_config(&contents).map_err(|(line, message)| ConfigError::Invalid {
+        path: path_name,
+        line,
+        message,
+    })
+}
+
+/// Loads the `--config` file if given, else [`CONFIG_FILE_NAME`] in `dir`.
+///
+/// A missing file is only an error when it was named explicitly.
+pub fn discover_config(explicit: Option<&Path>, dir: &Path) -> Result<Config, ConfigError> {
+    if let Some(path) = explicit {
+        return load_config(path);
+    }
+    match load_config(&dir.join(CONFIG_FILE_NAME)) {
+        Err(ConfigError::Read { source, .. }) if source.kind() == io::ErrorKind::NotFound => {
+            Ok(Config::default())
+        }
+        result => result,
+    }
+}
+
+/// Parses config text, reporting errors with their 1-based line number.
+fn parse_config(contents: &str) -> Result<Config, (usize, String)> {
+    let mut config = Config::default();
+    let mut lines = contents
+        .lines()
+        .enumerate()
+        .map(|(i, line)| (i + 1, strip_comment(line)))
+        .filter(|(_, line)| !line.trim().is_empty())
+        .peekable();
+
+    while let Some((number, line)) = lines.next() {
+        let (key, value) = line
+            .split_once(':')
+            .filter(|_| !line.starts_with([' ', '\t', '-']))
+            .ok_or_else(|| {
+                (
+                    number,
+                    format!("expected \"key: value\", got {:?}", line.trim()),
+                )
+            })?;
+
+        let value = value.trim();
+        let value = if value.is_empty() {
+            // Block list: the "- item" lines that follow
+            let mut items = Vec::new();
+            while let Some(item) = lines
+                .peek()
+                .and_then(|(_, next)| next.trim_start().strip_prefix('-'))
+            {
+                items.push(unquote(item.trim()).to_string());
+                lines.next();
+            }
+            Value::List(items)
+        } else if let Some(inner) = value.strip_prefix('[').and_then(|v| v.strip_suffix(']')) {
+            Value::List(split_list(inner))
+        } else {
+            Value::Scalar(unquote(value).to_string())
+        };
+
+        config.set(key.trim(), value).map_err(|e| (number, e))?;
+    }
+    Ok(config)
+}
+
+/// Removes a `#` comment that starts the line or follows whitespace,
+/// outside quotes.
+fn strip_comment(line: &str) -> &str {
+    let mut quote = None;
+    let mut prev = ' ';
+    for (i, c) in line.char_indices() {
+        match (quote, c) {
+            (None, '"' | '\'') => quote = Some(c),
+            (Some(q), _) if c == q => quote = None,
+            (None, '#') if prev.is_whitespace() => return &line[..i],
+            _ => {}
+        }
+        prev = c;
+    }
+    line
+}
+
+/// Strips one pair of matching surrounding quotes.
+fn unquote(value: &str) -> &str {
+    ['"', '\'']
+        .iter()
+        .find_map(|q| value.strip_prefix(*q)?.strip_suffix(*q))
+        .unwrap_or(value)
+}
+
+/// Splits a comma-separated list, unquoting items and dropping empty ones.
+fn split_list(value: &str) -> Vec<String> {
+    value
+        .split(',')
+        .map(|item| unquote(item.trim()).to_string())
+        .filter(|item| !item.is_empty())
+        .collect()
+}
+
+/// Returns a scalar value, rejecting lists.
+fn scalar(key: &str, value: Value) -> Result<String, String> {
+    match value {
+        Value::Scalar(value) => Ok(value),
+        Value::List(_) => Err(format!("{key} must be a single value, not a list")),
+    }
+}
+
+/// Parses a YAML-style boolean (true/false, yes/no, on/off).
+fn boolean(key: &str, value: Value) -> Result<bool, String> {
+    let value = scalar(key, value)?;
+    match value.to_ascii_lowercase().as_str() {
+        "true" | "yes" | "on" => Ok(true),
+        "false" | "no" | "off" => Ok(false),
+        _ => Err(format!("{key} must be true or false, got {value:?}")),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use clap::{CommandFactory, FromArgMatches};
+    use tempfile::TempDir;
+
+    /// Parses `argv` and applies `config` under it.
+    fn apply(config: &Config, argv: &[&str]) -> Args {
+        let matches = Args::command().get_matches_from(argv);
+        let args = Args::from_arg_matches(&matches).unwrap();
+        config.apply(args, &matches)
+    }
+
+    #[test]
+    fn test_parse_config_all_keys() {
+        let config = parse_config(
+            "# Repo defaults\n\
+             owner: ROKT\n\
+             repo: \"canal\"   # quoted\n\
+             format: json\n\
+             author: [alice, 'bob']\n\
+             snippet-lines: 25\n\
+             no_snippet: no\n\
+             no-bots: true\n\
+             include_general: on\n",
+        )
+        .unwrap();
+        assert_eq!(
+            config,
+            Config {
+                owner: Some("ROKT".to_string()),
+                repo: Some("canal".to_string()),
+                format: Some(OutputFormat::Json),
+                author: Some(vec!["alice".to_string(), "bob".to_string()]),
+                snippet_lines: Some(25),
+                no_snippet: Some(false),
+                no_bots: Some(true),
+                include_general: Some(true),
+            }
+        );
+    }
+
+    #[test]
+    fn test_parse_config_lists() {
+        let config = parse_config("author:\n  - alice\n  - \"bob\"\nowner: x\n").unwrap();
+        assert_eq!(config.author, Some(vec!["alice".into(), "bob".into()]));
+        assert_eq!(config.owner.as_deref(), Some("x"));
+
+        let config = parse_config("author: alice, bob").unwrap();
+        assert_eq!(config.author, Some(vec!["alice".into(), "bob".into()]));
+
+        // A "#" inside quotes or a word is not a comment
+        let config = parse_config("owner: \"a #1\"\nrepo: c#sharp").unwrap();
+        assert_eq!(config.owner.as_deref(), Some("a #1"));
+        assert_eq!(config.repo.as_deref(), Some("c#sharp"));
+
+        assert_eq!(parse_config("").unwrap(), Config::default());
+    }
+
+    #[test]
+    fn test_parse_config_errors() {
+        let error = |text| parse_config(text).unwrap_err();
+        assert_eq!(
+            error("owner: x\nfromat: json"),
+            (2, "unknown key \"fromat\"".to_string())
+        );
+        assert_eq!(error("just text").0, 1);
+        assert_eq!(error("  - stray").0, 1);
+        assert!(error("format: yaml").1.contains("yaml"));
+        assert_eq!(
+            error("snippet_lines: many").1,
+            "snippet_lines must be a number, got \"many\""
+        );
+        assert_eq!(
+            error("no_bots: maybe").1,
+            "no_bots must be true or false, got \"maybe\""
+        );
+        assert_eq!(
+            error("owner: [a, b]").1,
+            "owner must be a single value, not a list"
+        );
+    }
+
+    #[test]
+    fn test_load_config_merges_under_cli_flags() {
+        let dir = TempDir::new().unwrap();
+        let path = dir.path().join(CONFIG_FILE_NAME);
+        fs::write(
+            &path,
+            "format: minimal\nsnippet_lines: 5\nno_bots: true\nauthor: alice\n",
+        )
+        .unwrap();
+        let config = load_config(&path).unwrap();
+
+        // Config fills in flags left out
+        let args = apply(&config, &["pr-comments", "ROKT/canal#1"]);
+        assert_eq!(args.format, OutputFormat::Minimal);
+        assert_eq!(args.snippet_lines, 5);
+        assert!(args.no_bots);
+        assert_eq!(args.author, vec!["alice".to_string()]);
+        assert!(!args.no_snippet);
+
+        // Explicit flags win
+        let args = apply(
+            &config,
+            &[
+                "pr-comments",
+                "ROKT/canal#1",
+                "--format",
+                "claude",
+                "--snippet-lines",
+                "15",
+                "-a",
+                "carol",
+            ],
+        );
+        assert_eq!(args.format, OutputFormat::Claude);
+        assert_eq!(args.snippet_lines, 15);
+        assert_eq!(args.author, vec!["carol".to_string()]);
+        assert!(args.no_bots);
+    }
+
+    #[test]
+    fn test_apply_owner_repo_and_current() {
+        let config = Config {
+            owner: Some("ROKT".to_string()),
+            repo: Some("canal".to_string()),
+            no_snippet: Some(true),
+            include_general: Some(true),
+            ..Config::default()
+        };
+        let args = apply(&config, &["pr-comments", "--pr-number", "3"]);
+        assert_eq!(args.owner.as_deref(), Some("ROKT"));
+        assert_eq!(args.repo.as_deref(), Some("canal"));
+        assert!(args.no_snippet);
+        assert!(args.include_general);
+
+        let args = apply(&config, &["pr-comments", "--current"]);
+        assert!(args.owner.is_none());
+        assert!(args.repo.is_none());
+    }
+
+    #[test]
+    fn test_discover_config() {
+        let dir = TempDir::new().unwrap();
+        // No file in the directory is fine
+        assert_eq!(
+            discover_config(None, dir.path()).unwrap(),
+            Config::default()
+        );
+
+        fs::write(dir.path().join(CONFIG_FILE_NAME), "format: json\n").unwrap();
+        let config = discover_config(None, dir.path()).unwrap();
+        assert_eq!(config.format, Some(OutputFormat::Json));
+
+        // A named file must exist
+        let missing = dir.path().join("missing.yaml");
+        let err = discover_config(Some(&missing), dir.path()).unwrap_err();
+        assert!(err.to_string().starts_with("Cannot read config file"));
+
+        let bad = dir.path().join("bad.yaml");
+        fs::write(&bad, "format: json\nbogus: 1\n").unwrap();
+        let err = discover_config(Some(&bad), dir.path()).unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            format!("{}:2: unknown key \"bogus\"", bad.display())
+        );
+
+        // Unreadable in other ways (a directory) is still an error
+        fs::create_dir(dir.path().join("nested")).unwrap();
+        fs::create_dir(dir.path().join("nested").join(CONFIG_FILE_NAME)).unwrap();
+        assert!(discover_config(None, &dir.path().join("nested")).is_err());
+    }
+}
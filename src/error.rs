@@ -1,5 +1,6 @@
 //! Error types for the pr-comments CLI tool.
 
+use std::time::Duration;
 use thiserror::Error;
 
 /// Errors that can occur when interacting with the GitHub API.
@@ -16,6 +17,26 @@ pub enum GitHubAPIError {
 
     #[error("gh CLI not found. Please install it from https://cli.github.com/")]
     GhNotFound,
+
+    #[error("gh command timed out after {0:?} (raise it with --timeout)")]
+    Timeout(Duration),
+}
+
+/// Errors that can occur when loading a config file.
+#[derive(Error, Debug)]
+pub enum ConfigError {
+    #[error("Cannot read config file {path}: {source}")]
+    Read {
+        path: String,
+        source: std::io::Error,
+    },
+
+    #[error("{path}:{line}: {message}")]
+    Invalid {
+        path: String,
+        line: usize,
+        message: String,
+    },
 }
 
 /// Errors that can occur when parsing PR URLs.
@@ -26,4 +47,7 @@ pub enum ParseError {
 
     #[error("Invalid PR number: {0}")]
     InvalidPrNumber(String),
+
+    #[error("Invalid {0}: {1}")]
+    InvalidEnvVar(String, String),
 }
@@ -1,8 +1,20 @@
 //! GitHub API interaction via the gh CLI tool.
 
+use crate::cli::parse_pr_url_with_host;
 use crate::error::GitHubAPIError;
+use crate::models::{IssueRef, PRInfo};
+use crate::parser::{latest_review_submitted_by, parse_pr_info, parse_resolved_state};
+use crate::{log_debug, log_warn};
+use base64::Engine;
+use chrono::{DateTime, Utc};
+use regex::Regex;
 use serde_json::Value;
-use std::process::Command;
+use std::collections::HashMap;
+use std::io::Read;
+use std::process::{Command, Output, Stdio};
+use std::sync::{Mutex, OnceLock};
+use std::thread::{self, JoinHandle};
+use std::time::{Duration, Instant};
 
 /// Trait for running commands, allowing for mocking in tests.
 pub trait CommandRunner {
@@ -12,6 +24,8 @@ pub trait CommandRunner {
         query: &str,
         variables: &[(&str, &str)],
     ) -> Result<String, GitHubAPIError>;
+    /// Runs `gh pr view --json {fields}` for the current branch's PR.
+    fn run_pr_view(&self, fields: &str) -> Result<String, GitHubAPIError>;
 }
 
 /// Default implementation that runs the actual `gh` CLI.
@@ -20,10 +34,7 @@ pub struct GhCliRunner;
 impl CommandRunner for GhCliRunner {
     fn run(&self, endpoint: &str) -> Result<String, GitHubAPIError> {
         let gh_cli = std::env::var("GH_CLI").unwrap_or_else(|_| "gh".to_string());
-        let output = Command::new(&gh_cli)
-            .args(["api", endpoint])
-            .output()
-            .map_err(map_io_error)?;
+        let output = gh_output(gh_api_command(&gh_cli).arg(endpoint))?;
 
         if !output.status.success() {
             let stderr = String::from_utf8_lossy(&output.stderr);
@@ -42,7 +53,7 @@ impl CommandRunner for GhCliRunner {
         variables: &[(&str, &str)],
     ) -> Result<String, GitHubAPIError> {
         let query_arg = format!("query={query}");
-        let mut args = vec!["api", "graphql", "-f", &query_arg];
+        let mut args = vec!["graphql", "-f", &query_arg];
         let formatted_vars: Vec<String> =
             variables.iter().map(|(k, v)| format!("{k}={v}")).collect();
         for var in &formatted_vars {
@@ -50,10 +61,7 @@ impl CommandRunner for GhCliRunner {
             args.push(var);
         }
 
-        let output = Command::new("gh")
-            .args(&args)
-            .output()
-            .map_err(map_io_error)?;
+        let output = gh_output(gh_api_command("gh").args(&args))?;
 
         if !output.status.success() {
             let stderr = String::from_utf8_lossy(&output.stderr);
@@ -65,6 +73,185 @@ impl CommandRunner for GhCliRunner {
 
         parse_utf8_output(output.stdout)
     }
+
+    fn run_pr_view(&self, fields: &str) -> Result<String, GitHubAPIError> {
+        let gh_cli = std::env::var("GH_CLI").unwrap_or_else(|_| "gh".to_string());
+        let output = gh_output(gh_command(&gh_cli).args(["pr", "view", "--json", fields]))?;
+
+        if !output.status.success() {
+            let stderr = String::from_utf8_lossy(&output.stderr);
+            return Err(GitHubAPIError::ApiError(format!(
+                "Failed to find a pull request for the current branch: {}",
+                stderr.trim()
+            )));
+        }
+
+        parse_utf8_output(output.stdout)
+    }
+}
+
+/// Environment that keeps gh from paging output or prompting for input.
+///
+/// Either would hang the process, since we capture gh's output.
+const GH_NON_INTERACTIVE_ENV: [(&str, &str); 2] =
+    [("GH_PAGER", "cat"), ("GH_PROMPT_DISABLED", "1")];
+
+/// Host, proxy, CA and timeout settings for gh, for GitHub Enterprise,
+/// corporate and flaky networks.
+///
+/// gh already inherits HTTPS_PROXY and friends from our environment; these
+/// override them for a single run.
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct NetworkOptions {
+    pub proxy: Option<String>,
+    pub ca_bundle: Option<String>,
+    /// Times to retry a gh call that hit a rate limit or server error.
+    pub retries: u32,
+    /// GitHub host for `gh api` calls (`--hostname`). None uses gh's default.
+    pub hostname: Option<String>,
+    /// Kill a gh command that runs longer than this. None waits forever.
+    pub timeout: Option<Duration>,
+}
+
+impl NetworkOptions {
+    /// Returns the environment variables to set on the gh process.
+    pub fn env_vars(&self) -> Vec<(&'static str, String)> {
+        let mut vars = Vec::new();
+        if let Some(proxy) = &self.proxy {
+            vars.push(("HTTPS_PROXY", proxy.clone()));
+        }
+        if let Some(ca_bundle) = &self.ca_bundle {
+            vars.push(("GIT_SSL_CAINFO", ca_bundle.clone()));
+            vars.push(("SSL_CERT_FILE", ca_bundle.clone()));
+        }
+        vars
+    }
+
+    /// Returns the extra arguments for a `gh api` call.
+    pub fn api_args(&self) -> Vec<&str> {
+        match &self.hostname {
+            Some(hostname) => vec!["--hostname", hostname],
+            None => Vec::new(),
+        }
+    }
+}
+
+/// Process-wide network settings applied to every gh command.
+static NETWORK_OPTIONS: Mutex<NetworkOptions> = Mutex::new(NetworkOptions {
+    proxy: None,
+    ca_bundle: None,
+    retries: 0,
+    hostname: None,
+    timeout: None,
+});
+
+/// Sets the proxy and CA settings used for all subsequent gh commands.
+pub fn set_network_options(options: NetworkOptions) {
+    *NETWORK_OPTIONS.lock().unwrap_or_else(|e| e.into_inner()) = options;
+}
+
+/// Points subsequent `gh api` calls at `hostname`, keeping the other
+/// network settings.
+pub fn set_network_hostname(hostname: Option<String>) {
+    NETWORK_OPTIONS
+        .lock()
+        .unwrap_or_else(|e| e.into_inner())
+        .hostname = hostname;
+}
+
+/// Builds a gh command that never pages output or waits for input.
+fn gh_command(program: &str) -> Command {
+    let network = NETWORK_OPTIONS
+        .lock()
+        .unwrap_or_else(|e| e.into_inner())
+        .clone();
+    gh_command_with_network(program, &network)
+}
+
+/// Builds a `gh api` command against the configured host; the caller adds
+/// the endpoint and any flags.
+fn gh_api_command(program: &str) -> Command {
+    let network = NETWORK_OPTIONS
+        .lock()
+        .unwrap_or_else(|e| e.into_inner())
+        .clone();
+    let mut command = gh_command_with_network(program, &network);
+    command.arg("api").args(network.api_args());
+    command
+}
+
+/// Builds a gh command like [`gh_command`] with explicit network settings.
+fn gh_command_with_network(program: &str, network: &NetworkOptions) -> Command {
+    let mut command = Command::new(program);
+    command
+        .envs(GH_NON_INTERACTIVE_ENV)
+        .envs(network.env_vars())
+        .stdin(Stdio::null());
+    command
+}
+
+/// Runs a gh command under the process-wide timeout.
+fn gh_output(command: &mut Command) -> Result<Output, GitHubAPIError> {
+    let timeout = NETWORK_OPTIONS
+        .lock()
+        .unwrap_or_else(|e| e.into_inner())
+        .timeout;
+    output_with_timeout(command, timeout)
+}
+
+/// How often a running command is checked against its deadline.
+const TIMEOUT_POLL_INTERVAL: Duration = Duration::from_millis(20);
+
+/// Runs `command` to completion and captures its output, killing it once
+/// `timeout` has elapsed.
+fn output_with_timeout(
+    command: &mut Command,
+    timeout: Option<Duration>,
+) -> Result<Output, GitHubAPIError> {
+    let Some(timeout) = timeout else {
+        return command.output().map_err(map_io_error);
+    };
+
+    let mut child = command
+        .stdout(Stdio::piped())
+        .stderr(Stdio::piped())
+        .spawn()
+        .map_err(map_io_error)?;
+    // Drain the pipes while waiting, so a large response can't fill a pipe
+    // and stall the child
+    let stdout = child.stdout.take().map(read_pipe);
+    let stderr = child.stderr.take().map(read_pipe);
+
+    let deadline = Instant::now() + timeout;
+    let status = loop {
+        if let Some(status) = child.try_wait().map_err(map_io_error)? {
+            break status;
+        }
+        if Instant::now() >= deadline {
+            let _ = child.kill();
+            let _ = child.wait();
+            return Err(GitHubAPIError::Timeout(timeout));
+        }
+        thread::sleep(TIMEOUT_POLL_INTERVAL);
+    };
+
+    let collect = |reader: Option<JoinHandle<Vec<u8>>>| {
+        reader.and_then(|r| r.join().ok()).unwrap_or_default()
+    };
+    Ok(Output {
+        status,
+        stdout: collect(stdout),
+        stderr: collect(stderr),
+    })
+}
+
+/// Reads a child's pipe to the end on a background thread.
+fn read_pipe(mut pipe: impl Read + Send + 'static) -> JoinHandle<Vec<u8>> {
+    thread::spawn(move || {
+        let mut bytes = Vec::new();
+        let _ = pipe.read_to_end(&mut bytes);
+        bytes
+    })
 }
 
 /// Parses command output as UTF-8 string.
@@ -84,6 +271,106 @@ fn map_io_error(e: std::io::Error) -> GitHubAPIError {
     }
 }
 
+/// Delay before the first retry of a transient failure; doubled for each
+/// retry after that.
+const RETRY_BASE_DELAY: Duration = Duration::from_secs(1);
+
+/// Returns true for failures likely to pass on retry: rate limits and 5xx
+/// server errors. Missing resources and auth failures are permanent.
+fn is_transient(error: &GitHubAPIError) -> bool {
+    static SERVER_ERROR: OnceLock<Regex> = OnceLock::new();
+    let GitHubAPIError::ApiError(message) = error else {
+        return false;
+    };
+    let server_error = SERVER_ERROR.get_or_init(|| Regex::new(r"(?i)\bHTTP 5\d\d\b").unwrap());
+    message.to_lowercase().contains("rate limit") || server_error.is_match(message)
+}
+
+/// Makes a gh call, retrying transient failures up to `retries` times with
+/// exponential backoff from `base_delay`.
+fn with_retries(
+    retries: u32,
+    base_delay: Duration,
+    call: impl Fn() -> Result<String, GitHubAPIError>,
+) -> Result<String, GitHubAPIError> {
+    let mut delay = base_delay;
+    let mut attempt = 0;
+    loop {
+        match call() {
+            Err(e) if attempt < retries && is_transient(&e) => {
+                attempt += 1;
+                log_warn!("{e}; retrying in {delay:?} ({attempt}/{retries})");
+                thread::sleep(delay);
+                delay *= 2;
+            }
+            result => return result,
+        }
+    }
+}
+
+/// Returns the configured number of retries for transient failures.
+fn configured_retries() -> u32 {
+    NETWORK_OPTIONS
+        .lock()
+        .unwrap_or_else(|e| e.into_inner())
+        .retries
+}
+
+/// Runs a REST call through `runner`, retrying transient failures and
+/// logging its duration at debug level.
+fn timed_run(runner: &dyn CommandRunner, endpoint: &str) -> Result<String, GitHubAPIError> {
+    let started = Instant::now();
+    let result = with_retries(configured_retries(), RETRY_BASE_DELAY, || {
+        runner.run(endpoint)
+    });
+    log_debug!(
+        "gh api {endpoint} took {}ms ({})",
+        started.elapsed().as_millis(),
+        if result.is_ok() { "ok" } else { "failed" }
+    );
+    result
+}
+
+/// Runs a GraphQL call through `runner`, retrying transient failures and
+/// logging its duration at debug level.
+fn timed_run_graphql(
+    runner: &dyn CommandRunner,
+    query: &str,
+    variables: &[(&str, &str)],
+) -> Result<String, GitHubAPIError> {
+    let started = Instant::now();
+    let result = with_retries(configured_retries(), RETRY_BASE_DELAY, || {
+        runner.run_graphql(query, variables)
+    });
+    log_debug!(
+        "gh api graphql took {}ms ({})",
+        started.elapsed().as_millis(),
+        if result.is_ok() { "ok" } else { "failed" }
+    );
+    result
+}
+
+/// Runs two independent fetches in parallel and returns both results.
+///
+/// Both fetches always run to completion. If both fail, `first`'s error is
+/// reported, so errors are the same as running them in order.
+pub fn fetch_concurrently<A, B, E>(
+    first: impl FnOnce() -> Result<A, E> + Send,
+    second: impl FnOnce() -> Result<B, E> + Send,
+) -> Result<(A, B), E>
+where
+    A: Send,
+    B: Send,
+    E: Send,
+{
+    thread::scope(|scope| {
+        let second = scope.spawn(second);
+        let first = first();
+        let second = second.join().expect("fetch thread panicked");
+        Ok((first?, second?))
+    })
+}
+
 /// Default runner instance for production use.
 static DEFAULT_RUNNER: GhCliRunner = GhCliRunner;
 
@@ -109,19 +396,20 @@ pub fn fetch_pr_comments_with_runner(
     fetch_api_endpoint_with_runner(&endpoint, runner)
 }
 
-/// Fetches PR issue comments (general comments not on code) from GitHub.
+/// Fetches PR issue comments (general conversation comments not on code)
+/// from GitHub.
 ///
 /// Uses: `gh api repos/{owner}/{repo}/issues/{pr_number}/comments`
-pub fn fetch_pr_review_comments(
+pub fn fetch_issue_comments(
     owner: &str,
     repo: &str,
     pr_number: i32,
 ) -> Result<Vec<Value>, GitHubAPIError> {
-    fetch_pr_review_comments_with_runner(owner, repo, pr_number, &DEFAULT_RUNNER)
+    fetch_issue_comments_with_runner(owner, repo, pr_number, &DEFAULT_RUNNER)
 }
 
 /// Fetches PR issue comments with a custom runner (for testing).
-pub fn fetch_pr_review_comments_with_runner(
+pub fn fetch_issue_comments_with_runner(
     owner: &str,
     repo: &str,
     pr_number: i32,
@@ -131,6 +419,27 @@ pub fn fetch_pr_review_comments_with_runner(
     fetch_api_endpoint_with_runner(&endpoint, runner)
 }
 
+/// Fetches PR issue comments from GitHub.
+#[deprecated(note = "these are issue comments; use `fetch_issue_comments`")]
+pub fn fetch_pr_review_comments(
+    owner: &str,
+    repo: &str,
+    pr_number: i32,
+) -> Result<Vec<Value>, GitHubAPIError> {
+    fetch_issue_comments(owner, repo, pr_number)
+}
+
+/// Fetches PR issue comments with a custom runner (for testing).
+#[deprecated(note = "these are issue comments; use `fetch_issue_comments_with_runner`")]
+pub fn fetch_pr_review_comments_with_runner(
+    owner: &str,
+    repo: &str,
+    pr_number: i32,
+    runner: &dyn CommandRunner,
+) -> Result<Vec<Value>, GitHubAPIError> {
+    fetch_issue_comments_with_runner(owner, repo, pr_number, runner)
+}
+
 /// Fetches PR reviews (review-level comments) from GitHub.
 ///
 /// Uses: `gh api repos/{owner}/{repo}/pulls/{pr_number}/reviews`
@@ -153,6 +462,71 @@ pub fn fetch_pr_reviews_with_runner(
     fetch_api_endpoint_with_runner(&endpoint, runner)
 }
 
+/// Fetches the files changed in a PR, in diff order.
+///
+/// Uses: `gh api repos/{owner}/{repo}/pulls/{pr_number}/files`
+pub fn fetch_pr_files(
+    owner: &str,
+    repo: &str,
+    pr_number: i32,
+) -> Result<Vec<Value>, GitHubAPIError> {
+    fetch_pr_files_with_runner(owner, repo, pr_number, &DEFAULT_RUNNER)
+}
+
+/// Fetches the files changed in a PR with a custom runner (for testing).
+pub fn fetch_pr_files_with_runner(
+    owner: &str,
+    repo: &str,
+    pr_number: i32,
+    runner: &dyn CommandRunner,
+) -> Result<Vec<Value>, GitHubAPIError> {
+    let endpoint = format!("repos/{owner}/{repo}/pulls/{pr_number}/files");
+    fetch_api_endpoint_with_runner(&endpoint, runner)
+}
+
+/// Fetches the login of the authenticated gh user.
+///
+/// Uses: `gh api user`
+pub fn fetch_current_user() -> Result<String, GitHubAPIError> {
+    fetch_current_user_with_runner(&DEFAULT_RUNNER)
+}
+
+/// Fetches the authenticated user's login with a custom runner (for testing).
+pub fn fetch_current_user_with_runner(
+    runner: &dyn CommandRunner,
+) -> Result<String, GitHubAPIError> {
+    let output = timed_run(runner, "user")?;
+    let user: Value = serde_json::from_str(&output)
+        .map_err(|e| GitHubAPIError::ParseError(format!("Failed to parse user: {e}")))?;
+    user.get("login")
+        .and_then(|v| v.as_str())
+        .map(String::from)
+        .ok_or_else(|| GitHubAPIError::ParseError("User response has no login".to_string()))
+}
+
+/// Fetches when the authenticated user last submitted a review on a PR.
+///
+/// Returns None if the user has not submitted a review yet.
+pub fn fetch_my_last_review_time(
+    owner: &str,
+    repo: &str,
+    pr_number: i32,
+) -> Result<Option<DateTime<Utc>>, GitHubAPIError> {
+    fetch_my_last_review_time_with_runner(owner, repo, pr_number, &DEFAULT_RUNNER)
+}
+
+/// Fetches the user's last review submission time with a custom runner (for testing).
+pub fn fetch_my_last_review_time_with_runner(
+    owner: &str,
+    repo: &str,
+    pr_number: i32,
+    runner: &dyn CommandRunner,
+) -> Result<Option<DateTime<Utc>>, GitHubAPIError> {
+    let login = fetch_current_user_with_runner(runner)?;
+    let reviews = fetch_pr_reviews_with_runner(owner, repo, pr_number, runner)?;
+    Ok(latest_review_submitted_by(&reviews, &login))
+}
+
 /// Fetches PR info (metadata) from GitHub.
 ///
 /// Uses: `gh api repos/{owner}/{repo}/pulls/{pr_number}`
@@ -168,11 +542,226 @@ pub fn fetch_pr_info_with_runner(
     runner: &dyn CommandRunner,
 ) -> Result<Value, GitHubAPIError> {
     let endpoint = format!("repos/{owner}/{repo}/pulls/{pr_number}");
-    let output = runner.run(&endpoint)?;
+    let output = timed_run(runner, &endpoint)?;
     serde_json::from_str(&output)
         .map_err(|e| GitHubAPIError::ParseError(format!("Failed to parse PR info: {e}")))
 }
 
+/// Fetches PR info, falling back to empty info if the fetch fails.
+///
+/// PR info only decorates the output, so a failure here should not discard
+/// comments that were fetched successfully. A warning is printed to stderr.
+pub fn fetch_pr_info_best_effort(owner: &str, repo: &str, pr_number: i32) -> PRInfo {
+    fetch_pr_info_best_effort_with_runner(owner, repo, pr_number, &DEFAULT_RUNNER)
+}
+
+/// Fetches PR info best-effort with a custom runner (for testing).
+pub fn fetch_pr_info_best_effort_with_runner(
+    owner: &str,
+    repo: &str,
+    pr_number: i32,
+    runner: &dyn CommandRunner,
+) -> PRInfo {
+    match fetch_pr_info_with_runner(owner, repo, pr_number, runner) {
+        Ok(info) => parse_pr_info(&info),
+        Err(e) => {
+            log_warn!("could not fetch PR info, continuing without it: {e}");
+            PRInfo::default()
+        }
+    }
+}
+
+/// Fetches a file's contents at a git ref (branch, tag or commit SHA), or
+/// at the default branch when `git_ref` is None.
+///
+/// Uses: `gh api repos/{owner}/{repo}/contents/{path}?ref={git_ref}`
+pub fn fetch_file_source(
+    owner: &str,
+    repo: &str,
+    path: &str,
+    git_ref: Option<&str>,
+) -> Result<String, GitHubAPIError> {
+    fetch_file_source_with_runner(owner, repo, path, git_ref, &DEFAULT_RUNNER)
+}
+
+/// Fetches a file's contents with a custom runner (for testing).
+pub fn fetch_file_source_with_runner(
+    owner: &str,
+    repo: &str,
+    path: &str,
+    git_ref: Option<&str>,
+    runner: &dyn CommandRunner,
+) -> Result<String, GitHubAPIError> {
+    let mut endpoint = format!("repos/{owner}/{repo}/contents/{path}");
+    if let Some(git_ref) = git_ref {
+        endpoint.push_str(&format!("?ref={git_ref}"));
+    }
+    let output = timed_run(runner, &endpoint)?;
+    let file: Value = serde_json::from_str(&output)
+        .map_err(|e| GitHubAPIError::ParseError(format!("Failed to parse file contents: {e}")))?;
+
+    // The API wraps base64 content at 60 columns
+    let encoded: String = file
+        .get("content")
+        .and_then(|v| v.as_str())
+        .unwrap_or_default()
+        .split_whitespace()
+        .collect();
+    let bytes = base64::engine::general_purpose::STANDARD
+        .decode(encoded)
+        .map_err(|e| GitHubAPIError::ParseError(format!("Failed to decode {path}: {e}")))?;
+    String::from_utf8(bytes)
+        .map_err(|_| GitHubAPIError::ParseError(format!("{path} is not a UTF-8 text file")))
+}
+
+/// Returns the lines within `radius` of 1-based `line` in `source`, clamped
+/// to the file, or None when `line` is outside it.
+pub fn extract_file_context(source: &str, line: i32, radius: usize) -> Option<String> {
+    let lines: Vec<&str> = source.lines().collect();
+    let index = usize::try_from(line).ok()?.checked_sub(1)?;
+    if index >= lines.len() {
+        return None;
+    }
+    let start = index.saturating_sub(radius);
+    let end = (index + radius).min(lines.len() - 1);
+    Some(lines[start..=end].join("\n"))
+}
+
+/// File contents fetched so far, so each file is fetched at most once per
+/// run when several comments need context from it.
+///
+/// Failed fetches are cached too (as None), with a warning on the first
+/// failure; those comments keep their diff hunk.
+#[derive(Debug, Default)]
+pub struct FileSourceCache {
+    sources: HashMap<String, Option<String>>,
+}
+
+impl FileSourceCache {
+    /// Returns the lines within `radius` of `line` in `path` at `git_ref`,
+    /// fetching the file on first use.
+    pub fn context(
+        &mut self,
+        owner: &str,
+        repo: &str,
+        path: &str,
+        git_ref: Option<&str>,
+        line: i32,
+        radius: usize,
+    ) -> Option<String> {
+        self.context_with_runner(owner, repo, path, git_ref, line, radius, &DEFAULT_RUNNER)
+    }
+
+    /// Returns file context with a custom runner (for testing).
+    #[allow(clippy::too_many_arguments)]
+    pub fn context_with_runner(
+        &mut self,
+        owner: &str,
+        repo: &str,
+        path: &str,
+        git_ref: Option<&str>,
+        line: i32,
+        radius: usize,
+        runner: &dyn CommandRunner,
+    ) -> Option<String> {
+        let source = self.sources.entry(path.to_string()).or_insert_with(|| {
+            fetch_file_source_with_runner(owner, repo, path, git_ref, runner)
+                .inspect_err(|e| log_warn!("could not fetch {path}, using diff hunks: {e}"))
+                .ok()
+        });
+        extract_file_context(source.as_deref()?, line, radius)
+    }
+}
+
+/// Fetches the title of an issue (or PR) from GitHub.
+///
+/// Uses: `gh api repos/{owner}/{repo}/issues/{number}`
+pub fn fetch_issue_title(owner: &str, repo: &str, number: i64) -> Result<String, GitHubAPIError> {
+    fetch_issue_title_with_runner(owner, repo, number, &DEFAULT_RUNNER)
+}
+
+/// Fetches an issue title with a custom runner (for testing).
+pub fn fetch_issue_title_with_runner(
+    owner: &str,
+    repo: &str,
+    number: i64,
+    runner: &dyn CommandRunner,
+) -> Result<String, GitHubAPIError> {
+    let endpoint = format!("repos/{owner}/{repo}/issues/{number}");
+    let output = timed_run(runner, &endpoint)?;
+    let issue: Value = serde_json::from_str(&output)
+        .map_err(|e| GitHubAPIError::ParseError(format!("Failed to parse issue: {e}")))?;
+    issue
+        .get("title")
+        .and_then(|v| v.as_str())
+        .map(String::from)
+        .ok_or_else(|| GitHubAPIError::ParseError("Issue response has no title".to_string()))
+}
+
+/// Issue titles fetched so far, so each referenced issue is fetched at most
+/// once per run.
+///
+/// Failed lookups are cached too (as None), with a warning on the first
+/// failure; a missing title only leaves the reference unannotated.
+#[derive(Debug, Default)]
+pub struct IssueTitleCache {
+    titles: HashMap<IssueRef, Option<String>>,
+}
+
+impl IssueTitleCache {
+    /// Returns the issue's title, fetching it on first use.
+    pub fn title(&mut self, issue: &IssueRef) -> Option<String> {
+        self.title_with_runner(issue, &DEFAULT_RUNNER)
+    }
+
+    /// Returns the issue's title with a custom runner (for testing).
+    pub fn title_with_runner(
+        &mut self,
+        issue: &IssueRef,
+        runner: &dyn CommandRunner,
+    ) -> Option<String> {
+        self.titles
+            .entry(issue.clone())
+            .or_insert_with(|| {
+                fetch_issue_title_with_runner(&issue.owner, &issue.repo, issue.number, runner)
+                    .inspect_err(|e| {
+                        log_warn!(
+                            "could not fetch {}/{}#{}: {e}",
+                            issue.owner,
+                            issue.repo,
+                            issue.number
+                        )
+                    })
+                    .ok()
+            })
+            .clone()
+    }
+}
+
+/// Resolves the PR for the current git branch as (host, owner, repo,
+/// pr_number).
+///
+/// gh finds the repository from the checkout's git remote and the PR from
+/// the current branch. The host is the one in the PR's URL, which differs
+/// from github.com for GitHub Enterprise checkouts.
+pub fn resolve_current_pr() -> Result<(Option<String>, String, String, i32), GitHubAPIError> {
+    resolve_current_pr_with_runner(&DEFAULT_RUNNER)
+}
+
+/// Resolves the current branch's PR with a custom runner (for testing).
+pub fn resolve_current_pr_with_runner(
+    runner: &dyn CommandRunner,
+) -> Result<(Option<String>, String, String, i32), GitHubAPIError> {
+    let output = runner.run_pr_view("number,url")?;
+    let value: Value = serde_json::from_str(&output)
+        .map_err(|e| GitHubAPIError::ParseError(format!("Failed to parse gh pr view: {e}")))?;
+    let url = value
+        .get("url")
+        .and_then(|v| v.as_str())
+        .ok_or_else(|| GitHubAPIError::ParseError("Missing url in gh pr view".to_string()))?;
+    parse_pr_url_with_host(url).map_err(|e| GitHubAPIError::ParseError(e.to_string()))
+}
+
 /// GraphQL query to fetch CI check statuses for a PR.
 const CHECKS_GRAPHQL_QUERY: &str = r#"
 query($owner: String!, $repo: String!, $pr: Int!) {
@@ -234,19 +823,120 @@ pub fn fetch_pr_checks_with_runner(
 ) -> Result<Value, GitHubAPIError> {
     let pr_str = pr_number.to_string();
     let variables = [("owner", owner), ("repo", repo), ("pr", pr_str.as_str())];
-    let output = runner.run_graphql(CHECKS_GRAPHQL_QUERY, &variables)?;
+    let output = timed_run_graphql(runner, CHECKS_GRAPHQL_QUERY, &variables)?;
     serde_json::from_str(&output)
         .map_err(|e| GitHubAPIError::ParseError(format!("Failed to parse GraphQL response: {e}")))
 }
 
-/// Fetches an API endpoint that returns an array with a custom runner.
+/// GraphQL query for one page of review thread resolution. The REST
+/// comments endpoint does not expose whether a thread is resolved.
+const RESOLVED_GRAPHQL_QUERY: &str = r#"
+query($owner: String!, $repo: String!, $pr: Int!, $after: String) {
+  repository(owner: $owner, name: $repo) {
+    pullRequest(number: $pr) {
+      reviewThreads(first: 100, after: $after) {
+        pageInfo { hasNextPage endCursor }
+        nodes {
+          isResolved
+          comments(first: 100) {
+            pageInfo { hasNextPage }
+            nodes { databaseId }
+          }
+        }
+      }
+    }
+  }
+}
+"#;
+
+/// Fetches whether each review comment's thread is resolved, keyed by
+/// comment ID, using `gh api graphql`.
+pub fn fetch_resolved_state(
+    owner: &str,
+    repo: &str,
+    pr_number: i32,
+) -> Result<HashMap<i64, bool>, GitHubAPIError> {
+    fetch_resolved_state_with_runner(owner, repo, pr_number, &DEFAULT_RUNNER)
+}
+
+/// Fetches review thread resolution with a custom runner (for testing).
+pub fn fetch_resolved_state_with_runner(
+    owner: &str,
+    repo: &str,
+    pr_number: i32,
+    runner: &dyn CommandRunner,
+) -> Result<HashMap<i64, bool>, GitHubAPIError> {
+    let pr_str = pr_number.to_string();
+    let mut state = HashMap::new();
+    let mut cursor: Option<String> = None;
+    let mut long_threads = 0;
+    loop {
+        let mut variables = vec![("owner", owner), ("repo", repo), ("pr", pr_str.as_str())];
+        if let Some(after) = &cursor {
+            variables.push(("after", after.as_str()));
+        }
+        let output = timed_run_graphql(runner, RESOLVED_GRAPHQL_QUERY, &variables)?;
+        let response: Value = serde_json::from_str(&output).map_err(|e| {
+            GitHubAPIError::ParseError(format!("Failed to parse GraphQL response: {e}"))
+        })?;
+        state.extend(parse_resolved_state(&response)?);
+
+        let threads = response.pointer("/data/repository/pullRequest/reviewThreads");
+        long_threads += threads
+            .and_then(|t| t.get("nodes"))
+            .and_then(|n| n.as_array())
+            .into_iter()
+            .flatten()
+            .filter(|t| t.pointer("/comments/pageInfo/hasNextPage") == Some(&Value::Bool(true)))
+            .count();
+        let next = threads
+            .filter(|t| t.pointer("/pageInfo/hasNextPage") == Some(&Value::Bool(true)))
+            .and_then(|t| t.pointer("/pageInfo/endCursor"))
+            .and_then(|c| c.as_str());
+        match next {
+            Some(after) => cursor = Some(after.to_string()),
+            None => break,
+        }
+    }
+    if long_threads > 0 {
+        log_warn!(
+            "{long_threads} review thread(s) have over 100 comments; their later replies can't be matched and are left out"
+        );
+    }
+    Ok(state)
+}
+
+/// Items requested per page from list endpoints (GitHub's maximum).
+const PER_PAGE: usize = 100;
+
+/// Fetches every page of an API endpoint that returns an array with a
+/// custom runner.
+///
+/// GitHub paginates list endpoints (30 items by default), so pages are
+/// requested with `per_page`/`page` until one comes back short.
 fn fetch_api_endpoint_with_runner(
     endpoint: &str,
     runner: &dyn CommandRunner,
 ) -> Result<Vec<Value>, GitHubAPIError> {
-    let output = runner.run(endpoint)?;
-    serde_json::from_str(&output)
-        .map_err(|e| GitHubAPIError::ParseError(format!("Failed to parse JSON array: {e}")))
+    let mut items: Vec<Value> = Vec::new();
+    let mut pages = 0;
+    loop {
+        pages += 1;
+        let page_endpoint = format!("{endpoint}?per_page={PER_PAGE}&page={pages}");
+        let output = timed_run(runner, &page_endpoint)?;
+        let page: Vec<Value> = serde_json::from_str(&output)
+            .map_err(|e| GitHubAPIError::ParseError(format!("Failed to parse JSON array: {e}")))?;
+        let last = page.len() < PER_PAGE;
+        items.extend(page);
+        if last {
+            break;
+        }
+    }
+    log_debug!(
+        "{endpoint}: fetched {pages} page(s), {} item(s)",
+        items.len()
+    );
+    Ok(items)
 }
 
 #[cfg(test)]
@@ -257,6 +947,8 @@ mod tests {
     struct MockRunner {
         response: Result<String, GitHubAPIError>,
         graphql_response: Option<Result<String, GitHubAPIError>>,
+        routes: Vec<(String, Result<String, GitHubAPIError>)>,
+        graphql_routes: Vec<(String, Result<String, GitHubAPIError>)>,
     }
 
     impl MockRunner {
@@ -264,6 +956,8 @@ mod tests {
             Self {
                 response: Ok(json.to_string()),
                 graphql_response: None,
+                routes: Vec::new(),
+                graphql_routes: Vec::new(),
             }
         }
 
@@ -271,6 +965,8 @@ mod tests {
             Self {
                 response: Err(err),
                 graphql_response: None,
+                routes: Vec::new(),
+                graphql_routes: Vec::new(),
             }
         }
 
@@ -278,22 +974,49 @@ mod tests {
             self.graphql_response = Some(response);
             self
         }
+
+        /// Returns `response` for calls to exactly `endpoint`.
+        fn with_route(mut self, endpoint: &str, response: Result<String, GitHubAPIError>) -> Self {
+            self.routes.push((endpoint.to_string(), response));
+            self
+        }
+
+        /// Returns `response` for GraphQL calls whose `after` cursor is `after`.
+        fn with_graphql_page(mut self, after: &str, response: &str) -> Self {
+            self.graphql_routes
+                .push((after.to_string(), Ok(response.to_string())));
+            self
+        }
     }
 
     impl CommandRunner for MockRunner {
-        fn run(&self, _endpoint: &str) -> Result<String, GitHubAPIError> {
-            self.response.clone()
+        fn run(&self, endpoint: &str) -> Result<String, GitHubAPIError> {
+            self.routes
+                .iter()
+                .find(|(route, _)| route == endpoint)
+                .map(|(_, response)| response.clone())
+                .unwrap_or_else(|| self.response.clone())
         }
 
         fn run_graphql(
             &self,
             _query: &str,
-            _variables: &[(&str, &str)],
+            variables: &[(&str, &str)],
         ) -> Result<String, GitHubAPIError> {
+            let after = variables.iter().find(|(k, _)| *k == "after");
+            if let Some((_, cursor)) = after {
+                if let Some((_, response)) = self.graphql_routes.iter().find(|(c, _)| c == cursor) {
+                    return response.clone();
+                }
+            }
             self.graphql_response
                 .clone()
                 .unwrap_or_else(|| self.response.clone())
         }
+
+        fn run_pr_view(&self, fields: &str) -> Result<String, GitHubAPIError> {
+            self.run(&format!("pr view --json {fields}"))
+        }
     }
 
     #[test]
@@ -323,6 +1046,28 @@ mod tests {
     }
 
     #[test]
+    fn test_fetch_issue_comments_success() {
+        let runner = MockRunner::success(r#"[{"id": 2, "body": "general"}]"#);
+        let result = fetch_issue_comments_with_runner("owner", "repo", 1, &runner);
+        assert!(result.is_ok());
+        let comments = result.unwrap();
+        assert_eq!(comments.len(), 1);
+        assert_eq!(comments[0]["id"], 2);
+    }
+
+    #[test]
+    fn test_fetch_issue_comments_command_failed() {
+        let runner = MockRunner::error(GitHubAPIError::CommandFailed("timeout".to_string()));
+        let result = fetch_issue_comments_with_runner("owner", "repo", 1, &runner);
+        assert!(result.is_err());
+        assert!(matches!(
+            result.unwrap_err(),
+            GitHubAPIError::CommandFailed(_)
+        ));
+    }
+
+    #[test]
+    #[allow(deprecated)]
     fn test_fetch_pr_review_comments_success() {
         let runner = MockRunner::success(r#"[{"id": 2, "body": "review"}]"#);
         let result = fetch_pr_review_comments_with_runner("owner", "repo", 1, &runner);
@@ -333,6 +1078,7 @@ mod tests {
     }
 
     #[test]
+    #[allow(deprecated)]
     fn test_fetch_pr_review_comments_command_failed() {
         let runner = MockRunner::error(GitHubAPIError::CommandFailed("timeout".to_string()));
         let result = fetch_pr_review_comments_with_runner("owner", "repo", 1, &runner);
@@ -369,6 +1115,98 @@ mod tests {
         assert!(result.is_err());
     }
 
+    #[test]
+    fn test_fetch_pr_files_success() {
+        let runner = MockRunner::success(
+            r#"[{"filename": "src/b.rs", "status": "modified"}, {"filename": "src/a.rs", "status": "added"}]"#,
+        );
+        let result = fetch_pr_files_with_runner("owner", "repo", 1, &runner);
+        let files = result.unwrap();
+        assert_eq!(files.len(), 2);
+        assert_eq!(files[0]["filename"], "src/b.rs");
+    }
+
+    #[test]
+    fn test_fetch_pr_files_api_error() {
+        let runner = MockRunner::error(GitHubAPIError::ApiError("Not found".to_string()));
+        let result = fetch_pr_files_with_runner("owner", "repo", 1, &runner);
+        assert!(matches!(result.unwrap_err(), GitHubAPIError::ApiError(_)));
+    }
+
+    #[test]
+    fn test_fetch_pr_files_public_api() {
+        let result = fetch_pr_files("nonexistent-owner-xyz", "nonexistent-repo-xyz", 99999);
+        assert!(result.is_err());
+    }
+
+    #[test]
+    fn test_fetch_current_user_success() {
+        let runner = MockRunner::success(r#"{"login": "me", "id": 42}"#);
+        assert_eq!(fetch_current_user_with_runner(&runner).unwrap(), "me");
+    }
+
+    #[test]
+    fn test_fetch_current_user_missing_login() {
+        let runner = MockRunner::success(r#"{"id": 42}"#);
+        let result = fetch_current_user_with_runner(&runner);
+        assert!(matches!(result.unwrap_err(), GitHubAPIError::ParseError(_)));
+    }
+
+    #[test]
+    fn test_fetch_current_user_invalid_json() {
+        let runner = MockRunner::success("not json");
+        let result = fetch_current_user_with_runner(&runner);
+        assert!(matches!(result.unwrap_err(), GitHubAPIError::ParseError(_)));
+    }
+
+    #[test]
+    fn test_fetch_current_user_public_api() {
+        // Exercises the default runner; succeeds or fails depending on gh auth
+        let _ = fetch_current_user();
+    }
+
+    #[test]
+    fn test_fetch_my_last_review_time() {
+        let reviews = r#"[
+            {"id": 1, "user": {"login": "me"}, "state": "COMMENTED", "submitted_at": "2024-01-15T10:00:00Z"},
+            {"id": 2, "user": {"login": "other"}, "state": "APPROVED", "submitted_at": "2024-01-17T10:00:00Z"},
+            {"id": 3, "user": {"login": "me"}, "state": "CHANGES_REQUESTED", "submitted_at": "2024-01-16T10:00:00Z"}
+        ]"#;
+        let runner =
+            MockRunner::success(reviews).with_route("user", Ok(r#"{"login": "me"}"#.to_string()));
+
+        let since = fetch_my_last_review_time_with_runner("owner", "repo", 1, &runner).unwrap();
+        assert_eq!(since.unwrap().to_rfc3339(), "2024-01-16T10:00:00+00:00");
+    }
+
+    #[test]
+    fn test_fetch_my_last_review_time_no_reviews_by_user() {
+        let runner = MockRunner::success(
+            r#"[{"id": 1, "user": {"login": "other"}, "submitted_at": "2024-01-15T10:00:00Z"}]"#,
+        )
+        .with_route("user", Ok(r#"{"login": "me"}"#.to_string()));
+
+        let since = fetch_my_last_review_time_with_runner("owner", "repo", 1, &runner).unwrap();
+        assert!(since.is_none());
+    }
+
+    #[test]
+    fn test_fetch_my_last_review_time_user_error() {
+        let runner = MockRunner::success("[]").with_route(
+            "user",
+            Err(GitHubAPIError::ApiError("Unauthorized".to_string())),
+        );
+        let result = fetch_my_last_review_time_with_runner("owner", "repo", 1, &runner);
+        assert!(matches!(result.unwrap_err(), GitHubAPIError::ApiError(_)));
+    }
+
+    #[test]
+    fn test_fetch_my_last_review_time_public_api() {
+        let result =
+            fetch_my_last_review_time("nonexistent-owner-xyz", "nonexistent-repo-xyz", 99999);
+        assert!(result.is_err());
+    }
+
     #[test]
     fn test_fetch_pr_info_success() {
         let runner = MockRunner::success(
@@ -398,6 +1236,352 @@ mod tests {
         assert!(matches!(result.unwrap_err(), GitHubAPIError::GhNotFound));
     }
 
+    #[test]
+    fn test_fetch_pr_info_best_effort_success() {
+        let runner = MockRunner::success(
+            r#"{"title": "Test PR", "html_url": "https://github.com/owner/repo/pull/1"}"#,
+        );
+        let info = fetch_pr_info_best_effort_with_runner("owner", "repo", 1, &runner);
+        assert_eq!(info.title.as_deref(), Some("Test PR"));
+    }
+
+    #[test]
+    fn test_pr_info_failure_still_formats_comments() {
+        let comments_json = r#"[{
+            "id": 1,
+            "path": "src/main.rs",
+            "line": 10,
+            "user": {"login": "reviewer"},
+            "body": "Please rename this",
+            "created_at": "2024-01-15T10:30:00Z",
+            "updated_at": "2024-01-15T10:30:00Z"
+        }]"#;
+        let runner = MockRunner::success(comments_json).with_route(
+            "repos/owner/repo/pulls/1",
+            Err(GitHubAPIError::ApiError("Server error".to_string())),
+        );
+
+        let raw_comments = fetch_pr_comments_with_runner("owner", "repo", 1, &runner).unwrap();
+        let info = fetch_pr_info_best_effort_with_runner("owner", "repo", 1, &runner);
+        assert_eq!(info, PRInfo::default());
+
+        let options = crate::formatter::FormatOptions {
+            pr_title: info.title,
+            pr_url: info.url,
+            pr_node_id: info.node_id,
+            ..Default::default()
+        };
+        let comments = crate::parser::parse_comments(&raw_comments);
+        let output = crate::formatter::format_for_claude_with_options(&comments, &options);
+        assert!(output.contains("Please rename this"));
+        assert!(!output.contains("**PR Title:**"));
+    }
+
+    #[test]
+    fn test_fetch_pr_info_best_effort_public_api() {
+        let info =
+            fetch_pr_info_best_effort("nonexistent-owner-xyz", "nonexistent-repo-xyz", 99999);
+        assert_eq!(info, PRInfo::default());
+    }
+
+    #[test]
+    fn test_fetch_logs_timing_and_counts_at_debug() {
+        use crate::logging::{capture, LogLevel};
+
+        let runner = MockRunner::success(r#"[{"id": 1}, {"id": 2}]"#);
+        let messages = capture(LogLevel::Debug, || {
+            fetch_pr_comments_with_runner("owner", "repo", 1, &runner).unwrap();
+        });
+
+        assert_eq!(messages.len(), 2);
+        assert!(messages[0].starts_with(
+            "Debug: gh api repos/owner/repo/pulls/1/comments?per_page=100&page=1 took "
+        ));
+        assert!(messages[0].ends_with("ms (ok)"));
+        assert_eq!(
+            messages[1],
+            "Debug: repos/owner/repo/pulls/1/comments: fetched 1 page(s), 2 item(s)"
+        );
+    }
+
+    #[test]
+    fn test_fetch_pr_comments_merges_pages() {
+        let page = |ids: std::ops::Range<i64>| {
+            let items: Vec<Value> = ids.map(|id| serde_json::json!({"id": id})).collect();
+            Ok(serde_json::to_string(&items).unwrap())
+        };
+        let endpoint = "repos/owner/repo/pulls/1/comments?per_page=100";
+        let runner = MockRunner::error(GitHubAPIError::ApiError("unexpected page".to_string()))
+            .with_route(&format!("{endpoint}&page=1"), page(0..100))
+            .with_route(&format!("{endpoint}&page=2"), page(100..130));
+
+        let comments = fetch_pr_comments_with_runner("owner", "repo", 1, &runner).unwrap();
+        assert_eq!(comments.len(), 130);
+        assert_eq!(comments[129]["id"], 129);
+    }
+
+    #[test]
+    fn test_fetch_stops_on_empty_page() {
+        // A full last page is followed by one empty page
+        let items: Vec<Value> = (0..100).map(|id| serde_json::json!({"id": id})).collect();
+        let runner = MockRunner::success("[]").with_route(
+            "repos/owner/repo/pulls/1/reviews?per_page=100&page=1",
+            Ok(serde_json::to_string(&items).unwrap()),
+        );
+        let reviews = fetch_pr_reviews_with_runner("owner", "repo", 1, &runner).unwrap();
+        assert_eq!(reviews.len(), 100);
+    }
+
+    #[test]
+    fn test_fetch_pr_files_follows_pages() {
+        let page = |range: std::ops::Range<i32>| {
+            let files: Vec<Value> = range
+                .map(|i| serde_json::json!({"filename": format!("src/f{i}.rs")}))
+                .collect();
+            Ok(serde_json::to_string(&files).unwrap())
+        };
+        let runner = MockRunner::success("[]")
+            .with_route(
+                "repos/owner/repo/pulls/1/files?per_page=100&page=1",
+                page(0..100),
+            )
+            .with_route(
+                "repos/owner/repo/pulls/1/files?per_page=100&page=2",
+                page(100..103),
+            );
+        let files = fetch_pr_files_with_runner("owner", "repo", 1, &runner).unwrap();
+        assert_eq!(files.len(), 103);
+        assert_eq!(files[102]["filename"], "src/f102.rs");
+    }
+
+    #[test]
+    fn test_fetch_page_error_propagates() {
+        let items: Vec<Value> = (0..100).map(|id| serde_json::json!({"id": id})).collect();
+        let runner = MockRunner::error(GitHubAPIError::ApiError("rate limited".to_string()))
+            .with_route(
+                "repos/owner/repo/pulls/1/files?per_page=100&page=1",
+                Ok(serde_json::to_string(&items).unwrap()),
+            );
+        let result = fetch_pr_files_with_runner("owner", "repo", 1, &runner);
+        assert!(matches!(result.unwrap_err(), GitHubAPIError::ApiError(_)));
+    }
+
+    #[test]
+    fn test_fetch_logs_nothing_at_info() {
+        use crate::logging::{capture, LogLevel};
+
+        let runner = MockRunner::success("[]");
+        let messages = capture(LogLevel::Info, || {
+            fetch_pr_comments_with_runner("owner", "repo", 1, &runner).unwrap();
+        });
+        assert!(messages.is_empty());
+    }
+
+    #[test]
+    fn test_fetch_logs_failed_calls() {
+        use crate::logging::{capture, LogLevel};
+
+        let runner = MockRunner::error(GitHubAPIError::ApiError("Not found".to_string()));
+        let messages = capture(LogLevel::Debug, || {
+            let _ = fetch_pr_checks_with_runner("owner", "repo", 1, &runner);
+            let _ = fetch_pr_info_with_runner("owner", "repo", 1, &runner);
+        });
+        assert!(messages[0].starts_with("Debug: gh api graphql took "));
+        assert!(messages[0].ends_with("(failed)"));
+        assert!(messages[1].ends_with("(failed)"));
+    }
+
+    #[test]
+    fn test_fetch_pr_info_best_effort_logs_warning() {
+        use crate::logging::{capture, LogLevel};
+
+        let runner = MockRunner::error(GitHubAPIError::ApiError("Server error".to_string()));
+        let messages = capture(LogLevel::Warn, || {
+            fetch_pr_info_best_effort_with_runner("owner", "repo", 1, &runner);
+        });
+        assert_eq!(messages.len(), 1);
+        assert!(messages[0].starts_with("Warning: could not fetch PR info"));
+    }
+
+    #[test]
+    fn test_fetch_file_source() {
+        // "fn main() {}\n" in base64, wrapped as the API does
+        let runner = MockRunner::error(GitHubAPIError::ApiError("Not found".to_string()))
+            .with_route(
+                "repos/owner/repo/contents/src/main.rs?ref=abc123",
+                Ok(r#"{"encoding": "base64", "content": "Zm4gbWFp\nbigpIHt9Cg==\n"}"#.to_string()),
+            );
+        let source =
+            fetch_file_source_with_runner("owner", "repo", "src/main.rs", Some("abc123"), &runner)
+                .unwrap();
+        assert_eq!(source, "fn main() {}\n");
+        assert!(
+            fetch_file_source_with_runner("owner", "repo", "src/main.rs", None, &runner).is_err()
+        );
+
+        let runner = MockRunner::success(r#"{"content": "not base64!"}"#);
+        let err = fetch_file_source_with_runner("o", "r", "a.rs", None, &runner).unwrap_err();
+        assert!(err.to_string().contains("Failed to decode a.rs"));
+
+        // 0xFF 0xFE is not UTF-8
+        let runner = MockRunner::success(r#"{"content": "//4="}"#);
+        let err = fetch_file_source_with_runner("o", "r", "a.bin", None, &runner).unwrap_err();
+        assert!(err.to_string().contains("a.bin is not a UTF-8 text file"));
+
+        let runner = MockRunner::success("not json");
+        assert!(fetch_file_source_with_runner("o", "r", "a.rs", None, &runner).is_err());
+    }
+
+    #[test]
+    fn test_extract_file_context() {
+        let source = "one\ntwo\nthree\nfour\nfive\n";
+        assert_eq!(
+            extract_file_context(source, 3, 1).unwrap(),
+            "two\nthree\nfour"
+        );
+        assert_eq!(extract_file_context(source, 3, 0).unwrap(), "three");
+        // Clamped at both ends of the file
+        assert_eq!(
+            extract_file_context(source, 1, 2).unwrap(),
+            "one\ntwo\nthree"
+        );
+        assert_eq!(
+            extract_file_context(source, 5, 10).unwrap(),
+            source.trim_end()
+        );
+        // Outside the file
+        assert!(extract_file_context(source, 6, 2).is_none());
+        assert!(extract_file_context(source, 0, 2).is_none());
+        assert!(extract_file_context(source, -1, 2).is_none());
+        assert!(extract_file_context("", 1, 2).is_none());
+    }
+
+    #[test]
+    fn test_file_source_cache_fetches_each_file_once() {
+        use crate::logging::{capture, LogLevel};
+        use base64::Engine;
+        let source: String = (1..=20).map(|n| format!("line {n}\n")).collect();
+        let encoded = base64::engine::general_purpose::STANDARD.encode(&source);
+        let runner = MockRunner::error(GitHubAPIError::ApiError("Not found".to_string()))
+            .with_route(
+                "repos/owner/repo/contents/src/lib.rs?ref=abc123",
+                Ok(format!(r#"{{"content": "{encoded}"}}"#)),
+            );
+        let mut cache = FileSourceCache::default();
+        let context = |cache: &mut FileSourceCache, path: &str, line: i32| {
+            cache.context_with_runner("owner", "repo", path, Some("abc123"), line, 1, &runner)
+        };
+
+        assert_eq!(
+            context(&mut cache, "src/lib.rs", 10).unwrap(),
+            "line 9\nline 10\nline 11"
+        );
+        assert_eq!(
+            context(&mut cache, "src/lib.rs", 20).unwrap(),
+            "line 19\nline 20"
+        );
+        assert!(context(&mut cache, "src/lib.rs", 21).is_none());
+
+        let messages = capture(LogLevel::Warn, || {
+            assert!(context(&mut cache, "missing.rs", 1).is_none());
+            assert!(context(&mut cache, "missing.rs", 2).is_none());
+        });
+        assert_eq!(messages.len(), 1);
+        assert!(messages[0].contains("could not fetch missing.rs, using diff hunks"));
+        assert_eq!(cache.sources.len(), 2);
+    }
+
+    #[test]
+    fn test_file_source_cache_public_api() {
+        let mut cache = FileSourceCache::default();
+        let context = cache.context(
+            "nonexistent-owner-xyz",
+            "nonexistent-repo-xyz",
+            "a.rs",
+            None,
+            1,
+            3,
+        );
+        assert!(context.is_none());
+    }
+
+    #[test]
+    fn test_fetch_file_source_public_api() {
+        let result = fetch_file_source(
+            "nonexistent-owner-xyz",
+            "nonexistent-repo-xyz",
+            "a.rs",
+            None,
+        );
+        assert!(result.is_err());
+    }
+
+    #[test]
+    fn test_fetch_issue_title() {
+        let runner = MockRunner::success(r#"{"number": 12, "title": "Crash on start"}"#);
+        let title = fetch_issue_title_with_runner("owner", "repo", 12, &runner).unwrap();
+        assert_eq!(title, "Crash on start");
+
+        let runner = MockRunner::success(r#"{"number": 12}"#);
+        let err = fetch_issue_title_with_runner("owner", "repo", 12, &runner).unwrap_err();
+        assert!(matches!(err, GitHubAPIError::ParseError(_)));
+
+        let runner = MockRunner::success("not json");
+        assert!(fetch_issue_title_with_runner("owner", "repo", 12, &runner).is_err());
+    }
+
+    #[test]
+    fn test_issue_titles_inlined_via_runner() {
+        use crate::logging::{capture, LogLevel};
+        use crate::parser::inline_issue_titles;
+
+        let runner = MockRunner::error(GitHubAPIError::ApiError("Not found".to_string()))
+            .with_route(
+                "repos/owner/repo/issues/12",
+                Ok(r#"{"title": "Crash on start"}"#.to_string()),
+            );
+        let mut cache = IssueTitleCache::default();
+        let mut inlined = String::new();
+        let messages = capture(LogLevel::Warn, || {
+            inlined = inline_issue_titles(
+                "Fixes #12, see #12 and #99 and #99",
+                "owner",
+                "repo",
+                |issue| cache.title_with_runner(issue, &runner),
+            );
+        });
+        assert_eq!(
+            inlined,
+            "Fixes (#12: Crash on start), see (#12: Crash on start) and #99 and #99"
+        );
+        // The failed lookup is cached, so it warns once
+        assert_eq!(messages.len(), 1);
+        assert!(messages[0].starts_with("Warning: could not fetch owner/repo#99"));
+
+        // Cached titles are served without the runner
+        let offline = MockRunner::error(GitHubAPIError::CommandFailed("offline".to_string()));
+        let issue = IssueRef {
+            owner: "owner".to_string(),
+            repo: "repo".to_string(),
+            number: 12,
+        };
+        assert_eq!(
+            cache.title_with_runner(&issue, &offline).as_deref(),
+            Some("Crash on start")
+        );
+    }
+
+    #[test]
+    fn test_fetch_issue_title_public_api() {
+        assert!(fetch_issue_title("nonexistent-owner-xyz", "nonexistent-repo-xyz", 99999).is_err());
+        let issue = IssueRef {
+            owner: "nonexistent-owner-xyz".to_string(),
+            repo: "nonexistent-repo-xyz".to_string(),
+            number: 99999,
+        };
+        assert_eq!(IssueTitleCache::default().title(&issue), None);
+    }
+
     #[test]
     fn test_fetch_pr_comments_public_api() {
         // Test the public API that uses DEFAULT_RUNNER
@@ -408,6 +1592,15 @@ mod tests {
     }
 
     #[test]
+    fn test_fetch_issue_comments_public_api() {
+        // Test the public API that uses DEFAULT_RUNNER
+        let result = fetch_issue_comments("nonexistent-owner-xyz", "nonexistent-repo-xyz", 99999);
+        // Should return an error (GhNotFound, ApiError, or CommandFailed)
+        assert!(result.is_err());
+    }
+
+    #[test]
+    #[allow(deprecated)]
     fn test_fetch_pr_review_comments_public_api() {
         // Test the public API that uses DEFAULT_RUNNER
         let result =
@@ -424,6 +1617,206 @@ mod tests {
         assert!(result.is_err());
     }
 
+    #[test]
+    fn test_gh_command_disables_pager_and_prompts() {
+        let command = gh_command("gh");
+        let envs: Vec<(String, Option<String>)> = command
+            .get_envs()
+            .map(|(k, v)| {
+                (
+                    k.to_string_lossy().into_owned(),
+                    v.map(|v| v.to_string_lossy().into_owned()),
+                )
+            })
+            .collect();
+
+        assert_eq!(command.get_program(), "gh");
+        assert!(envs.contains(&("GH_PAGER".to_string(), Some("cat".to_string()))));
+        assert!(envs.contains(&("GH_PROMPT_DISABLED".to_string(), Some("1".to_string()))));
+    }
+
+    #[test]
+    fn test_gh_command_sets_proxy_and_ca_bundle() {
+        let network = NetworkOptions {
+            proxy: Some("http://proxy.corp:8080".to_string()),
+            ca_bundle: Some("/etc/ssl/corp-ca.pem".to_string()),
+            retries: 0,
+            hostname: None,
+            timeout: None,
+        };
+        let command = gh_command_with_network("gh", &network);
+        let envs: Vec<(String, Option<String>)> = command
+            .get_envs()
+            .map(|(k, v)| {
+                (
+                    k.to_string_lossy().into_owned(),
+                    v.map(|v| v.to_string_lossy().into_owned()),
+                )
+            })
+            .collect();
+
+        let proxy = Some("http://proxy.corp:8080".to_string());
+        let ca = Some("/etc/ssl/corp-ca.pem".to_string());
+        assert!(envs.contains(&("HTTPS_PROXY".to_string(), proxy)));
+        assert!(envs.contains(&("GIT_SSL_CAINFO".to_string(), ca.clone())));
+        assert!(envs.contains(&("SSL_CERT_FILE".to_string(), ca)));
+        assert!(envs.contains(&("GH_PAGER".to_string(), Some("cat".to_string()))));
+    }
+
+    #[test]
+    fn test_network_options_default_sets_nothing() {
+        assert!(NetworkOptions::default().env_vars().is_empty());
+        assert!(NetworkOptions::default().api_args().is_empty());
+        let command = gh_command_with_network("gh", &NetworkOptions::default());
+        assert!(!command
+            .get_envs()
+            .any(|(k, _)| k == "HTTPS_PROXY" || k == "SSL_CERT_FILE"));
+    }
+
+    #[test]
+    fn test_output_with_timeout_kills_slow_command() {
+        // sleep stands in for a gh call hung on the network
+        let started = Instant::now();
+        let result = output_with_timeout(
+            Command::new("sleep").arg("10"),
+            Some(Duration::from_millis(100)),
+        );
+        assert!(started.elapsed() < Duration::from_secs(5));
+        let err = result.unwrap_err();
+        assert!(matches!(err, GitHubAPIError::Timeout(_)));
+        assert!(err.to_string().contains("timed out after 100ms"));
+    }
+
+    #[test]
+    fn test_output_with_timeout_captures_fast_command() {
+        let output = output_with_timeout(
+            Command::new("echo").arg("hello"),
+            Some(Duration::from_secs(10)),
+        )
+        .unwrap();
+        assert!(output.status.success());
+        assert_eq!(output.stdout, b"hello\n");
+
+        let output = output_with_timeout(Command::new("echo").arg("hi"), None).unwrap();
+        assert_eq!(output.stdout, b"hi\n");
+
+        let err = output_with_timeout(
+            &mut Command::new("definitely-not-a-real-command-xyz"),
+            Some(Duration::from_secs(1)),
+        )
+        .unwrap_err();
+        assert!(matches!(err, GitHubAPIError::GhNotFound));
+    }
+
+    #[test]
+    fn test_set_network_options_applies_to_gh_command() {
+        // Only this test touches the global settings; restore them afterwards
+        let network = NetworkOptions {
+            proxy: Some("http://proxy.corp:8080".to_string()),
+            ca_bundle: None,
+            retries: 0,
+            hostname: Some("github.mycorp.com".to_string()),
+            timeout: None,
+        };
+        set_network_options(network);
+        let has_proxy = gh_command("gh").get_envs().any(|(k, _)| k == "HTTPS_PROXY");
+        let api_args: Vec<String> = gh_api_command("gh")
+            .get_args()
+            .map(|a| a.to_string_lossy().into_owned())
+            .collect();
+        set_network_options(NetworkOptions::default());
+        assert!(has_proxy);
+        assert_eq!(api_args, vec!["api", "--hostname", "github.mycorp.com"]);
+    }
+
+    #[test]
+    fn test_is_transient() {
+        for message in [
+            "Failed to fetch from GitHub: HTTP 502: Bad Gateway",
+            "Failed to fetch from GitHub: HTTP 503: Service Unavailable",
+            "You have exceeded a secondary rate limit",
+            "API rate limit exceeded for user",
+        ] {
+            let error = GitHubAPIError::ApiError(message.to_string());
+            assert!(is_transient(&error), "{message}");
+        }
+        for message in [
+            "Failed to fetch from GitHub: HTTP 404: Not Found",
+            "Failed to fetch from GitHub: HTTP 401: Bad credentials",
+            "HTTP 5000 widgets",
+        ] {
+            let error = GitHubAPIError::ApiError(message.to_string());
+            assert!(!is_transient(&error), "{message}");
+        }
+        assert!(!is_transient(&GitHubAPIError::GhNotFound));
+    }
+
+    #[test]
+    fn test_with_retries_recovers_from_transient_failures() {
+        // A stand-in for gh that fails twice with a 502, then succeeds
+        let calls = std::cell::Cell::new(0);
+        let flaky = || {
+            calls.set(calls.get() + 1);
+            if calls.get() <= 2 {
+                Err(GitHubAPIError::ApiError(
+                    "HTTP 502: Bad Gateway".to_string(),
+                ))
+            } else {
+                Ok("[]".to_string())
+            }
+        };
+        assert_eq!(with_retries(2, Duration::ZERO, flaky).unwrap(), "[]");
+        assert_eq!(calls.get(), 3);
+
+        // Out of retries: the last error is returned
+        calls.set(0);
+        assert!(with_retries(1, Duration::ZERO, flaky).is_err());
+        assert_eq!(calls.get(), 2);
+    }
+
+    #[test]
+    fn test_with_retries_skips_permanent_failures() {
+        let calls = std::cell::Cell::new(0);
+        let result = with_retries(3, Duration::ZERO, || {
+            calls.set(calls.get() + 1);
+            Err(GitHubAPIError::ApiError("HTTP 404: Not Found".to_string()))
+        });
+        assert!(result.is_err());
+        assert_eq!(calls.get(), 1);
+    }
+
+    #[test]
+    fn test_fetch_concurrently_overlaps_fetches() {
+        let delay = Duration::from_millis(300);
+        let started = Instant::now();
+        let (comments, info) = fetch_concurrently(
+            || {
+                thread::sleep(delay);
+                Ok::<_, GitHubAPIError>(vec![1, 2])
+            },
+            || {
+                thread::sleep(delay);
+                Ok("info")
+            },
+        )
+        .unwrap();
+        assert_eq!(comments, vec![1, 2]);
+        assert_eq!(info, "info");
+        // Sequential calls would take at least twice the delay
+        assert!(started.elapsed() < delay * 2);
+    }
+
+    #[test]
+    fn test_fetch_concurrently_error_precedence() {
+        let fail = |msg: &str| Err::<(), _>(GitHubAPIError::ApiError(msg.to_string()));
+
+        let err = fetch_concurrently(|| fail("comments"), || fail("info")).unwrap_err();
+        assert_eq!(err.to_string(), "GitHub API error: comments");
+
+        let err = fetch_concurrently(|| Ok(()), || fail("info")).unwrap_err();
+        assert_eq!(err.to_string(), "GitHub API error: info");
+    }
+
     #[test]
     fn test_gh_cli_runner_run_directly() {
         // Test the GhCliRunner directly
@@ -536,6 +1929,54 @@ mod tests {
         assert!(result.is_err());
     }
 
+    #[test]
+    fn test_fetch_resolved_state_success() {
+        let graphql_response = r#"{"data":{"repository":{"pullRequest":{"reviewThreads":{"nodes":[
+            {"isResolved":true,"comments":{"nodes":[{"databaseId":1},{"databaseId":2}]}},
+            {"isResolved":false,"comments":{"nodes":[{"databaseId":3}]}}
+        ]}}}}}"#;
+        let runner = MockRunner::success("[]").with_graphql(Ok(graphql_response.to_string()));
+        let state = fetch_resolved_state_with_runner("owner", "repo", 1, &runner).unwrap();
+        assert_eq!(state, HashMap::from([(1, true), (2, true), (3, false)]));
+    }
+
+    #[test]
+    fn test_fetch_resolved_state_pages_through_threads() {
+        let first = r#"{"data":{"repository":{"pullRequest":{"reviewThreads":{
+            "pageInfo":{"hasNextPage":true,"endCursor":"c1"},
+            "nodes":[{"isResolved":true,"comments":{"nodes":[{"databaseId":1}]}}]
+        }}}}}"#;
+        let second = r#"{"data":{"repository":{"pullRequest":{"reviewThreads":{
+            "pageInfo":{"hasNextPage":false,"endCursor":"c2"},
+            "nodes":[{"isResolved":false,"comments":{
+                "pageInfo":{"hasNextPage":true},"nodes":[{"databaseId":101}]
+            }}]
+        }}}}}"#;
+        let runner = MockRunner::success("[]")
+            .with_graphql(Ok(first.to_string()))
+            .with_graphql_page("c1", second);
+        let state = fetch_resolved_state_with_runner("owner", "repo", 1, &runner).unwrap();
+        assert_eq!(state, HashMap::from([(1, true), (101, false)]));
+    }
+
+    #[test]
+    fn test_fetch_resolved_state_errors() {
+        let runner = MockRunner::success("[]").with_graphql(Ok("not valid json".to_string()));
+        let err = fetch_resolved_state_with_runner("owner", "repo", 1, &runner).unwrap_err();
+        assert!(matches!(err, GitHubAPIError::ParseError(_)));
+
+        let runner = MockRunner::success("[]")
+            .with_graphql(Err(GitHubAPIError::ApiError("GraphQL error".to_string())));
+        let err = fetch_resolved_state_with_runner("owner", "repo", 1, &runner).unwrap_err();
+        assert!(matches!(err, GitHubAPIError::ApiError(_)));
+    }
+
+    #[test]
+    fn test_fetch_resolved_state_public_api() {
+        let result = fetch_resolved_state("nonexistent-owner-xyz", "nonexistent-repo-xyz", 99999);
+        assert!(result.is_err());
+    }
+
     #[test]
     fn test_mock_runner_graphql_falls_back_to_response() {
         // When no graphql_response is set, run_graphql falls back to the main response
@@ -545,6 +1986,57 @@ mod tests {
         assert!(result.unwrap().contains("test"));
     }
 
+    #[test]
+    fn test_resolve_current_pr() {
+        let runner = MockRunner::error(GitHubAPIError::CommandFailed("unexpected".to_string()))
+            .with_route(
+                "pr view --json number,url",
+                Ok(r#"{"number": 42, "url": "https://github.com/owner/repo/pull/42"}"#.to_string()),
+            );
+        let (host, owner, repo, pr) = resolve_current_pr_with_runner(&runner).unwrap();
+        assert_eq!(host.as_deref(), Some("github.com"));
+        assert_eq!((owner.as_str(), repo.as_str(), pr), ("owner", "repo", 42));
+    }
+
+    #[test]
+    fn test_resolve_current_pr_enterprise_host() {
+        let runner = MockRunner::error(GitHubAPIError::CommandFailed("unexpected".to_string()))
+            .with_route(
+                "pr view --json number,url",
+                Ok(r#"{"number": 7, "url": "https://ghe.corp.com/team/app/pull/7"}"#.to_string()),
+            );
+        let (host, owner, repo, pr) = resolve_current_pr_with_runner(&runner).unwrap();
+        assert_eq!(host.as_deref(), Some("ghe.corp.com"));
+        assert_eq!((owner.as_str(), repo.as_str(), pr), ("team", "app", 7));
+    }
+
+    #[test]
+    fn test_resolve_current_pr_errors() {
+        let no_pr = MockRunner::error(GitHubAPIError::ApiError("no pull requests found".into()));
+        assert!(matches!(
+            resolve_current_pr_with_runner(&no_pr).unwrap_err(),
+            GitHubAPIError::ApiError(_)
+        ));
+
+        for response in ["not json", r#"{"number": 42}"#, r#"{"url": "nope"}"#] {
+            let runner = MockRunner::success(response);
+            let err = resolve_current_pr_with_runner(&runner).unwrap_err();
+            assert!(matches!(err, GitHubAPIError::ParseError(_)), "{response}");
+        }
+    }
+
+    #[test]
+    fn test_resolve_current_pr_public_api() {
+        // Depends on the working directory; either finds a PR or errors
+        let _ = resolve_current_pr();
+    }
+
+    #[test]
+    fn test_gh_cli_runner_pr_view_directly() {
+        // Errors outside a checkout with a PR, but covers the code path
+        let _ = GhCliRunner.run_pr_view("number,url");
+    }
+
     #[test]
     fn test_gh_cli_runner_graphql_directly() {
         // Test the GhCliRunner graphql directly - will error but covers the code path
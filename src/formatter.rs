@@ -1,9 +1,536 @@
 //! Output formatting for PR comments and check statuses in multiple styles.
 
-use crate::models::{CheckConclusion, CheckStatus, ChecksReport, PRComment};
-use crate::parser::group_by_file;
-use serde_json::json;
-use std::collections::HashSet;
+use crate::cli::GroupExpr;
+use crate::models::{
+    annotate_snippet, CheckConclusion, CheckStatus, ChecksReport, FileCoverage, PRComment,
+    ReviewStats, Severity,
+};
+use crate::parser::{
+    compute_stats, extract_links, extract_tasks, find_line_references, group_by, group_by_author,
+    group_by_file, group_by_severity,
+};
+use crate::sanitizer::{
+    collapse_blank_lines, escape_html, flatten_markdown, flatten_markdown_indent_code, strip_ansi,
+    to_slack_mrkdwn, word_count,
+};
+use chrono::{DateTime, SecondsFormat, Utc};
+use serde::Serialize;
+use serde_json::{json, Value};
+use std::borrow::Cow;
+use std::collections::{HashMap, HashSet};
+use std::fs;
+use std::io;
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicUsize, Ordering};
+use std::sync::{OnceLock, RwLock};
+
+/// Options passed to every registered comment formatter.
+///
+/// Formatters read only the fields they care about; PR metadata is optional
+/// because it may not be available (e.g. when formatting fixtures).
+#[derive(Debug, Clone)]
+pub struct FormatOptions {
+    pub pr_url: Option<String>,
+    pub pr_title: Option<String>,
+    /// GraphQL node ID for the PR (e.g., "PR_kwDO...").
+    pub pr_node_id: Option<String>,
+    /// Short PR reference ("owner/repo#123"), used by event output.
+    pub pr_ref: Option<String>,
+    /// (base, head) branch names, shown in the claude header when set.
+    pub pr_branches: Option<(String, String)>,
+    /// PR state description (see [`crate::models::PRInfo::state_summary`]), e.g. "open (draft)".
+    pub pr_state: Option<String>,
+    /// Login of the PR author.
+    pub pr_author: Option<String>,
+    pub include_snippet: bool,
+    pub snippet_lines: usize,
+    /// Lines (before, after) around the last changed hunk line to show in
+    /// snippets; None takes the last `snippet_lines` lines instead.
+    pub context_window: Option<(usize, usize)>,
+    /// Hard-wrap snippet lines longer than this many characters.
+    pub wrap_snippet: Option<usize>,
+    /// Snippets keep a diff marker on every line (`+`, `-`, or a space for
+    /// context) and markdown fences are tagged `diff`.
+    pub annotate_diff: bool,
+    /// Render the comment body before its code snippet (claude and grouped
+    /// formats) instead of code first.
+    pub snippet_after: bool,
+    /// Include bot accounts in the mentions format.
+    pub mention_bots: bool,
+    /// File paths in PR diff order; when set, file groups follow this order
+    /// instead of sorting alphabetically.
+    pub file_order: Option<Vec<String>>,
+    /// Show author avatars in HTML output.
+    pub html_avatars: bool,
+    /// Keep the input comment order (e.g. from `--sort`) instead of sorting
+    /// by line within each file, or by date in the flat format.
+    pub keep_order: bool,
+    /// Note line numbers referenced in comment bodies (claude format).
+    pub line_references: bool,
+    /// Display bot authors without the "[bot]" suffix (JSON keeps the login).
+    pub normalize_bot_names: bool,
+    /// Include the unprocessed diff hunk in JSON output.
+    pub include_raw_diff: bool,
+    /// Per-file (additions, deletions), shown next to file headers when present.
+    pub diff_stats: HashMap<String, (i64, i64)>,
+    /// Truncate comment bodies longer than this many characters, preferring
+    /// a sentence boundary. Applied to every format by [`format_comments`].
+    pub max_body_chars: Option<usize>,
+    /// Reduce runs of blank lines in comment bodies to a single blank line.
+    /// Applied to every format by [`format_comments`].
+    pub collapse_blank_lines: bool,
+    /// Group comments by this computed key instead of by file.
+    pub group_by: Option<GroupExpr>,
+    /// Append "(N words)" to comment headers (claude and grouped formats).
+    pub show_word_count: bool,
+    /// Keep ANSI escape sequences in bodies and snippets. By default they are
+    /// stripped, since every built-in format targets files, markdown
+    /// renderers or LLMs rather than a terminal.
+    pub keep_ansi: bool,
+    /// Prefix bodies of comments asking for tests with [`TEST_REQUEST_MARKER`].
+    pub flag_test_requests: bool,
+    /// Prefix bodies of comments from changes-requested reviews with
+    /// [`BLOCKING_MARKER`].
+    pub flag_blocking: bool,
+    /// Text shown instead of [`NO_COMMENTS_MESSAGE`] when there are no
+    /// comments (JSON output is always an empty array).
+    pub empty_message: Option<String>,
+}
+
+impl Default for FormatOptions {
+    fn default() -> Self {
+        Self {
+            pr_url: None,
+            pr_title: None,
+            pr_node_id: None,
+            pr_ref: None,
+            pr_branches: None,
+            pr_state: None,
+            pr_author: None,
+            include_snippet: true,
+            snippet_lines: 15,
+            context_window: None,
+            wrap_snippet: None,
+            annotate_diff: false,
+            snippet_after: false,
+            mention_bots: false,
+            file_order: None,
+            html_avatars: false,
+            keep_order: false,
+            line_references: false,
+            normalize_bot_names: false,
+            include_raw_diff: false,
+            diff_stats: HashMap::new(),
+            max_body_chars: None,
+            collapse_blank_lines: false,
+            group_by: None,
+            show_word_count: false,
+            keep_ansi: false,
+            flag_test_requests: false,
+            flag_blocking: false,
+            empty_message: None,
+        }
+    }
+}
+
+impl FormatOptions {
+    /// Creates options with the given snippet settings and no PR metadata.
+    pub fn with_snippet(include_snippet: bool, snippet_lines: usize) -> Self {
+        Self {
+            include_snippet,
+            snippet_lines,
+            ..Self::default()
+        }
+    }
+}
+
+/// Default text shown when there are no comments to format.
+pub const NO_COMMENTS_MESSAGE: &str = "No comments found.";
+
+/// Returns the configured empty-result text, or [`NO_COMMENTS_MESSAGE`].
+fn empty_message(options: &FormatOptions) -> &str {
+    options
+        .empty_message
+        .as_deref()
+        .unwrap_or(NO_COMMENTS_MESSAGE)
+}
+
+/// Returns the empty-result text as a complete text-format output.
+fn no_comments_message(options: &FormatOptions) -> String {
+    format!("{}\n", empty_message(options))
+}
+
+/// Maximum characters kept from a single snippet line before it is cut off.
+///
+/// Minified or generated files can produce one enormous diff line; anything
+/// beyond this is noise for both terminals and LLMs.
+pub const MAX_SNIPPET_LINE_CHARS: usize = 1000;
+
+/// Marker appended to snippet lines cut off at [`MAX_SNIPPET_LINE_CHARS`].
+pub const TRUNCATED_LINE_MARKER: &str = " (\u{2026}truncated)";
+
+/// Truncates lines longer than `max_chars` characters, appending a marker.
+pub fn truncate_long_lines(text: &str, max_chars: usize) -> String {
+    text.lines()
+        .map(|line| match line.char_indices().nth(max_chars) {
+            Some((cut, _)) => format!("{}{TRUNCATED_LINE_MARKER}", &line[..cut]),
+            None => line.to_string(),
+        })
+        .collect::<Vec<_>>()
+        .join("\n")
+}
+
+/// Returns the notice appended to output cut short by [`truncate_output`].
+fn truncated_output_notice(shown: usize, total: usize) -> String {
+    format!("\n[output truncated: {shown} of {total} bytes shown]\n")
+}
+
+/// Estimates the LLM token count of `text` as one token per four characters,
+/// rounded up. Real tokenizers vary, so this is only a rough guide.
+pub fn estimate_tokens(text: &str) -> usize {
+    text.chars().count().div_ceil(4)
+}
+
+/// Describes the size of rendered output, e.g. "Output: ~250 tokens (~1000 chars)".
+pub fn output_size_summary(output: &str) -> String {
+    format!(
+        "Output: ~{} tokens (~{} chars)",
+        estimate_tokens(output),
+        output.chars().count()
+    )
+}
+
+/// Limits rendered output to `max_bytes` bytes, whatever the format.
+///
+/// A JSON array drops trailing elements so it stays valid JSON, and the
+/// record formats (`ndjson`, `ndjson-events`, `csv`) drop trailing records
+/// so every line stays parseable. Anything else is cut at a UTF-8 character
+/// boundary, preferably a line end, and ends with a notice that counts
+/// toward the limit. The result is never longer than `max_bytes`; when the
+/// notice can't go in the output it is returned for stderr instead.
+pub fn truncate_output(
+    output: &str,
+    max_bytes: usize,
+    format_name: &str,
+) -> (String, Option<String>) {
+    if output.len() <= max_bytes {
+        return (output.to_string(), None);
+    }
+    let stderr_notice = |shown: usize| {
+        Some(
+            truncated_output_notice(shown, output.len())
+                .trim()
+                .to_string(),
+        )
+    };
+
+    if let Ok(Value::Array(mut items)) = serde_json::from_str::<Value>(output) {
+        while items.pop().is_some() {
+            let json = serde_json::to_string_pretty(&items).unwrap_or_default();
+            if json.len() <= max_bytes {
+                let shown = json.len();
+                return (json, stderr_notice(shown));
+            }
+        }
+        return (String::new(), stderr_notice(0));
+    }
+
+    if matches!(format_name, "ndjson" | "ndjson-events" | "csv") {
+        let cut = last_record_end(output, max_bytes, format_name == "csv");
+        return (output[..cut].to_string(), stderr_notice(cut));
+    }
+
+    let notice_len = truncated_output_notice(output.len(), output.len()).len();
+    let fits_notice = notice_len <= max_bytes;
+    let mut cut = if fits_notice {
+        max_bytes - notice_len
+    } else {
+        max_bytes
+    };
+    while !output.is_char_boundary(cut) {
+        cut -= 1;
+    }
+    let cut = output[..cut].rfind('\n').map_or(cut, |i| i + 1);
+    if fits_notice {
+        let notice = truncated_output_notice(cut, output.len());
+        (format!("{}{notice}", &output[..cut]), None)
+    } else {
+        (output[..cut].to_string(), stderr_notice(cut))
+    }
+}
+
+/// Returns the end of the last whole line-based record within `max_bytes`.
+///
+/// With `quoted`, line breaks inside double-quoted CSV fields don't end a
+/// record.
+fn last_record_end(output: &str, max_bytes: usize, quoted: bool) -> usize {
+    let mut in_quotes = false;
+    let mut end = 0;
+    for (i, b) in output.bytes().enumerate().take(max_bytes) {
+        match b {
+            b'"' if quoted => in_quotes = !in_quotes,
+            b'\n' if !in_quotes => end = i + 1,
+            _ => {}
+        }
+    }
+    end
+}
+
+/// Marker appended to bodies cut short by [`smart_truncate`].
+pub const TRUNCATED_BODY_MARKER: &str = "\u{2026}";
+
+/// Truncates text to at most `max_chars` characters (including the trailing
+/// "…"), cutting at the last sentence end before the limit when there is one,
+/// else at the last whitespace, else mid-word.
+pub fn smart_truncate(text: &str, max_chars: usize) -> String {
+    if text.chars().count() <= max_chars {
+        return text.to_string();
+    }
+
+    let limit = max_chars.saturating_sub(TRUNCATED_BODY_MARKER.chars().count());
+    let cut = text
+        .char_indices()
+        .nth(limit)
+        .map_or(text.len(), |(i, _)| i);
+
+    // A sentence ends at . ! or ? followed by whitespace (or the cut itself)
+    let is_sentence_end = |i: usize, c: char| {
+        matches!(c, '.' | '!' | '?')
+            && text[i + 1..]
+                .chars()
+                .next()
+                .is_none_or(|next| next.is_whitespace())
+    };
+    let head = &text[..cut];
+    let end = head
+        .char_indices()
+        .rev()
+        .find(|(i, c)| is_sentence_end(*i, *c))
+        .map(|(i, _)| i + 1)
+        .or_else(|| head.rfind(char::is_whitespace))
+        .filter(|end| *end > 0)
+        .unwrap_or(cut);
+
+    format!("{}{TRUNCATED_BODY_MARKER}", text[..end].trim_end())
+}
+
+/// Hard-wraps lines longer than `width` characters onto continuation lines.
+///
+/// A width of 0 leaves the text unchanged.
+pub fn wrap_long_lines(text: &str, width: usize) -> String {
+    if width == 0 {
+        return text.to_string();
+    }
+
+    let mut wrapped = Vec::new();
+    for line in text.lines() {
+        let chars: Vec<char> = line.chars().collect();
+        if chars.len() <= width {
+            wrapped.push(line.to_string());
+            continue;
+        }
+        for chunk in chars.chunks(width) {
+            wrapped.push(chunk.iter().collect::<String>());
+        }
+    }
+    wrapped.join("\n")
+}
+
+/// Returns the code snippet for a comment with line-length limits applied.
+///
+/// Pathologically long lines are always truncated; wrapping is applied
+/// afterwards when `options.wrap_snippet` is set.
+pub fn snippet_for(comment: &PRComment, options: &FormatOptions) -> String {
+    let snippet = match (&comment.file_context, options.context_window) {
+        // Diff annotations need the hunk's +/- markers
+        (Some(context), _) if !options.annotate_diff => context.clone(),
+        (_, Some((before, after))) => {
+            let window = comment.get_code_snippet_window(before, after);
+            if options.annotate_diff {
+                annotate_snippet(&window)
+            } else {
+                window
+            }
+        }
+        _ if options.annotate_diff => comment.get_annotated_snippet(options.snippet_lines),
+        _ => comment.get_code_snippet(options.snippet_lines),
+    };
+    if snippet.is_empty() {
+        return snippet;
+    }
+
+    let snippet = truncate_long_lines(&snippet, MAX_SNIPPET_LINE_CHARS);
+    match options.wrap_snippet {
+        Some(width) => wrap_long_lines(&snippet, width),
+        None => snippet,
+    }
+}
+
+/// Returns the markdown code-fence language for a file, by extension, or
+/// an empty string when the extension is unknown.
+pub fn language_for_path(path: &str) -> &'static str {
+    let Some((_, ext)) = path.rsplit_once('.') else {
+        return "";
+    };
+    match ext.to_ascii_lowercase().as_str() {
+        "py" => "python",
+        "go" => "go",
+        "rs" => "rust",
+        "ts" => "typescript",
+        "tsx" => "tsx",
+        "js" | "mjs" | "cjs" => "javascript",
+        "jsx" => "jsx",
+        "rb" => "ruby",
+        "java" => "java",
+        "kt" | "kts" => "kotlin",
+        "swift" => "swift",
+        "c" | "h" => "c",
+        "cc" | "cpp" | "cxx" | "hpp" => "cpp",
+        "cs" => "csharp",
+        "php" => "php",
+        "scala" => "scala",
+        "sh" | "bash" => "bash",
+        "sql" => "sql",
+        "html" | "htm" => "html",
+        "css" => "css",
+        "scss" => "scss",
+        "json" => "json",
+        "yml" | "yaml" => "yaml",
+        "toml" => "toml",
+        "md" => "markdown",
+        _ => "",
+    }
+}
+
+/// Returns the opening fence for a comment's snippet block: tagged `diff`
+/// when snippets keep their diff markers, else with the file's language.
+fn snippet_fence(comment: &PRComment, options: &FormatOptions) -> String {
+    let language = if options.annotate_diff {
+        "diff"
+    } else {
+        language_for_path(&comment.file_path)
+    };
+    format!("```{language}\n")
+}
+
+/// Groups comments for output: by `options.group_by` when set, else by file.
+fn group_comments<'a>(
+    comments: &'a [PRComment],
+    options: &FormatOptions,
+) -> HashMap<String, Vec<&'a PRComment>> {
+    match options.group_by {
+        Some(GroupExpr::Author) => group_by_author(comments),
+        Some(expr) => group_by(comments, |c| expr.key_for(c)),
+        None => group_by_file(comments),
+    }
+}
+
+/// Orders file group keys for output.
+///
+/// Files follow `options.file_order` when set (unlisted files last); otherwise
+/// they are sorted alphabetically for consistent output.
+fn ordered_files<'a, T>(
+    grouped: &'a HashMap<String, T>,
+    options: &FormatOptions,
+) -> Vec<&'a String> {
+    let mut files: Vec<&String> = grouped.keys().collect();
+    files.sort();
+    if let Some(order) = &options.file_order {
+        files.sort_by_key(|f| order.iter().position(|o| o == *f).unwrap_or(usize::MAX));
+    }
+    files
+}
+
+/// Returns the file header text, annotated with "(+N -M)" when diff stats
+/// are known for the file.
+fn file_header(file: &str, options: &FormatOptions) -> String {
+    match options.diff_stats.get(file) {
+        Some((additions, deletions)) => format!("{file} (+{additions} -{deletions})"),
+        None => file.to_string(),
+    }
+}
+
+/// Returns the line under a claude group header saying how many comments
+/// the group holds, e.g. "(3 comments on this file)".
+fn group_count_line(count: usize, options: &FormatOptions) -> String {
+    let noun = if count == 1 { "comment" } else { "comments" };
+    let scope = match options.group_by {
+        None => "on this file",
+        Some(GroupExpr::Author) => "by this author",
+        Some(_) => "in this group",
+    };
+    format!("({count} {noun} {scope})\n\n")
+}
+
+/// Returns the (heading, sentence) wording for how comments are grouped,
+/// e.g. ("File", "file").
+fn grouping_label(options: &FormatOptions) -> (&'static str, &'static str) {
+    match options.group_by {
+        None => ("File", "file"),
+        Some(GroupExpr::Dir(_)) => ("Directory", "directory"),
+        Some(GroupExpr::Ext) => ("File Type", "file type"),
+        Some(GroupExpr::Author) => ("Author", "author"),
+        Some(GroupExpr::Severity) => ("Severity", "severity"),
+    }
+}
+
+/// Returns where a comment sits, for its header: the line info, prefixed
+/// with the file path when groups mix files (any grouping but by file).
+fn comment_location(comment: &PRComment, options: &FormatOptions) -> String {
+    if options.group_by.is_none() || comment.file_path.is_empty() {
+        comment.get_line_info()
+    } else {
+        format!("{}, {}", comment.file_path, comment.get_line_info())
+    }
+}
+
+/// Orders one group's comments for output: by line number, then by date,
+/// unless `options.keep_order` is set. Groups that mix files (any grouping
+/// but by file) order by file first.
+fn sort_file_comments<'a>(
+    file_comments: &[&'a PRComment],
+    options: &FormatOptions,
+) -> Vec<&'a PRComment> {
+    let mut sorted = file_comments.to_vec();
+    if !options.keep_order {
+        let by_file = options.group_by.is_some();
+        sorted.sort_by(|a, b| {
+            (if by_file {
+                a.file_path.cmp(&b.file_path)
+            } else {
+                std::cmp::Ordering::Equal
+            })
+            .then_with(|| a.line_number.cmp(&b.line_number))
+            .then_with(|| a.created_at.cmp(&b.created_at))
+            .then_with(|| a.id.cmp(&b.id))
+        });
+    }
+    sorted
+}
+
+/// Keeps the first `limit` comments in the order the grouped formatters
+/// render them: group by group (see [`ordered_files`]), then by line within
+/// each group. The kept comments stay in their existing order.
+pub fn limit_in_render_order(
+    comments: Vec<PRComment>,
+    limit: usize,
+    options: &FormatOptions,
+) -> Vec<PRComment> {
+    if limit == 0 || comments.len() <= limit {
+        return comments;
+    }
+    let grouped = group_comments(&comments, options);
+    let keep: HashSet<i64> = ordered_files(&grouped, options)
+        .into_iter()
+        .flat_map(|file| sort_file_comments(&grouped[file], options))
+        .take(limit)
+        .map(|c| c.id)
+        .collect();
+    comments
+        .into_iter()
+        .filter(|c| keep.contains(&c.id))
+        .collect()
+}
 
 /// Formats a single comment for LLM consumption.
 pub fn format_comment_for_llm(
@@ -11,48 +538,98 @@ pub fn format_comment_for_llm(
     include_snippet: bool,
     snippet_lines: usize,
 ) -> String {
+    format_comment_with_options(
+        comment,
+        &FormatOptions::with_snippet(include_snippet, snippet_lines),
+    )
+}
+
+/// Formats a single comment for LLM consumption using the given options.
+pub fn format_comment_with_options(comment: &PRComment, options: &FormatOptions) -> String {
     let mut output = String::new();
 
     // File and line info header
+    let file = match comment.file_path.as_str() {
+        "" => "(review)",
+        path => path,
+    };
     output.push_str(&format!(
-        "### {} ({})\n\n",
-        comment.file_path,
-        comment.get_line_info()
+        "### {file} ({}){}{}\n\n",
+        comment.get_line_info(),
+        line_references_note(comment, options),
+        word_count_note(comment, options)
     ));
 
     // Author
-    output.push_str(&format!("**Author:** {}\n", comment.author));
-
-    // Date formatted as YYYY-MM-DD HH:MM UTC
     output.push_str(&format!(
-        "**Date:** {}\n\n",
-        comment.created_at.format("%Y-%m-%d %H:%M UTC")
+        "**Author:** {}\n",
+        comment.display_author(options.normalize_bot_names)
     ));
 
-    // Code snippet
-    if include_snippet {
-        let snippet = comment.get_code_snippet(snippet_lines);
+    // Date formatted as YYYY-MM-DD HH:MM UTC, with the edit time if it differs
+    let created = comment.created_at.format("%Y-%m-%d %H:%M UTC");
+    if comment.was_edited() {
+        output.push_str(&format!(
+            "**Created:** {created} (edited {})\n\n",
+            comment.updated_at.format("%Y-%m-%d %H:%M UTC")
+        ));
+    } else {
+        output.push_str(&format!("**Date:** {created}\n\n"));
+    }
+
+    // Code snippet and comment body, in the configured order
+    let snippet = snippet_block(comment, options);
+    let body = format!("**Comment:**\n{}\n", comment.body);
+    if options.snippet_after {
+        output.push_str(&body);
         if !snippet.is_empty() {
-            output.push_str("**Code context:**\n```\n");
-            output.push_str(&snippet);
-            output.push_str("\n```\n\n");
+            // The block's trailing blank line moves between body and snippet
+            output.push('\n');
+            output.push_str(&snippet[..snippet.len() - 1]);
         }
+    } else {
+        output.push_str(&snippet);
+        output.push_str(&body);
     }
 
-    // Comment body
-    output.push_str(&format!("**Comment:**\n{}\n", comment.body));
-
     output
 }
 
+/// Returns the "**Code context:**" block for a comment, or an empty string
+/// when snippets are disabled or the comment has none.
+fn snippet_block(comment: &PRComment, options: &FormatOptions) -> String {
+    if !options.include_snippet {
+        return String::new();
+    }
+    let snippet = snippet_for(comment, options);
+    if snippet.is_empty() {
+        return snippet;
+    }
+    format!(
+        "**Code context:**\n{}{snippet}\n```\n\n",
+        snippet_fence(comment, options)
+    )
+}
+
 /// Formats comments grouped by file.
 pub fn format_comments_grouped(
     comments: &[PRComment],
     include_snippet: bool,
     snippet_lines: usize,
+) -> String {
+    format_comments_grouped_with_options(
+        comments,
+        &FormatOptions::with_snippet(include_snippet, snippet_lines),
+    )
+}
+
+/// Formats comments grouped by file using the given options.
+pub fn format_comments_grouped_with_options(
+    comments: &[PRComment],
+    options: &FormatOptions,
 ) -> String {
     if comments.is_empty() {
-        return "No comments found.\n".to_string();
+        return no_comments_message(options);
     }
 
     let mut output = String::new();
@@ -70,30 +647,19 @@ pub fn format_comments_grouped(
     ));
 
     // Group by file
-    let grouped = group_by_file(comments);
+    let grouped = group_comments(comments, options);
 
-    // Sort files for consistent output
-    let mut files: Vec<_> = grouped.keys().collect();
-    files.sort();
+    // Sort files for consistent output (or diff order when requested)
+    let files = ordered_files(&grouped, options);
 
     for file in files {
         let file_comments = grouped.get(file).unwrap();
-        output.push_str(&format!("## {file}\n\n"));
+        output.push_str(&format!("## {}\n\n", file_header(file, options)));
 
-        // Sort by line number, then by date
-        let mut sorted_comments: Vec<_> = file_comments.iter().collect();
-        sorted_comments.sort_by(|a, b| {
-            a.line_number
-                .cmp(&b.line_number)
-                .then_with(|| a.created_at.cmp(&b.created_at))
-        });
+        let sorted_comments = sort_file_comments(file_comments, options);
 
         for comment in sorted_comments {
-            output.push_str(&format_comment_for_llm(
-                comment,
-                include_snippet,
-                snippet_lines,
-            ));
+            output.push_str(&format_comment_with_options(comment, options));
             output.push_str("\n---\n\n");
         }
     }
@@ -101,14 +667,109 @@ pub fn format_comments_grouped(
     output
 }
 
+/// Sections of the severity view, most pressing first, with the inferred
+/// severities each one collects.
+const SEVERITY_SECTIONS: [(&str, &[Severity]); 4] = [
+    ("Issues", &[Severity::Critical, Severity::Warning]),
+    ("Suggestions", &[Severity::Suggestion]),
+    ("Nits", &[Severity::Nit]),
+    ("Info", &[Severity::None]),
+];
+
+/// Formats comments across the whole PR in severity sections (Issues,
+/// Suggestions, Nits, Info) instead of per file, for a prioritized view.
+///
+/// Each comment is rendered as in the grouped format; empty sections are
+/// omitted.
+pub fn format_comments_by_severity(comments: &[PRComment], options: &FormatOptions) -> String {
+    if comments.is_empty() {
+        return no_comments_message(options);
+    }
+
+    let mut output = String::from("# PR Review Comments by Severity\n\n");
+    let pr_info = pr_info_lines(options);
+    if !pr_info.is_empty() {
+        output.push_str(&format!("{pr_info}\n"));
+    }
+    output.push_str(&format!("**Total comments:** {}\n\n", comments.len()));
+    output.push_str(&severity_sections(comments, options, false));
+    output
+}
+
+/// Formats comments in severity sections for LLM consumption, with the
+/// claude format's PR header, instructions and GitHub links.
+pub fn format_for_claude_by_severity(comments: &[PRComment], options: &FormatOptions) -> String {
+    if comments.is_empty() {
+        return no_comments_message(options);
+    }
+
+    let mut output = String::from("# Pull Request Review Comments\n\n");
+    output.push_str(&pr_info_lines(options));
+    let file_count = comments
+        .iter()
+        .map(|c| &c.file_path)
+        .collect::<HashSet<_>>()
+        .len();
+    output.push_str(&format!(
+        "\n**Total comments:** {} across {} file(s)\n\n",
+        comments.len(),
+        file_count
+    ));
+
+    output.push_str("## Instructions\n\n");
+    output.push_str("Please address each of the following review comments. ");
+    output.push_str("The comments are grouped by severity, most pressing first.\n\n");
+    output.push_str(&severity_sections(comments, options, true));
+    output
+}
+
+/// Renders the non-empty severity sections, each comment followed by its
+/// GitHub link when `links` is set.
+fn severity_sections(comments: &[PRComment], options: &FormatOptions, links: bool) -> String {
+    let mut output = String::new();
+    let grouped = group_by_severity(comments);
+    for (section, severities) in SEVERITY_SECTIONS {
+        let section_comments: Vec<&PRComment> = severities
+            .iter()
+            .filter_map(|s| grouped.get(s))
+            .flatten()
+            .copied()
+            .collect();
+        if section_comments.is_empty() {
+            continue;
+        }
+
+        output.push_str(&format!("## {section}\n\n"));
+        for comment in section_comments {
+            output.push_str(&format_comment_with_options(comment, options));
+            if links && !comment.html_url.is_empty() {
+                output.push_str(&format!("\n[View on GitHub]({})\n", comment.html_url));
+            }
+            output.push_str("\n---\n\n");
+        }
+    }
+    output
+}
+
 /// Formats comments in a flat list sorted by date (most recent first).
 pub fn format_comments_flat(
     comments: &[PRComment],
     include_snippet: bool,
     snippet_lines: usize,
+) -> String {
+    format_comments_flat_with_options(
+        comments,
+        &FormatOptions::with_snippet(include_snippet, snippet_lines),
+    )
+}
+
+/// Formats comments in a flat list sorted by date using the given options.
+pub fn format_comments_flat_with_options(
+    comments: &[PRComment],
+    options: &FormatOptions,
 ) -> String {
     if comments.is_empty() {
-        return "No comments found.\n".to_string();
+        return no_comments_message(options);
     }
 
     let mut output = String::new();
@@ -117,17 +778,19 @@ pub fn format_comments_flat(
         comments.len()
     ));
 
-    // Sort by date (most recent first)
+    // Sort by date (most recent first), then by ID for equal timestamps
     let mut sorted_comments: Vec<_> = comments.iter().collect();
-    sorted_comments.sort_by(|a, b| b.created_at.cmp(&a.created_at));
+    if !options.keep_order {
+        sorted_comments.sort_by(|a, b| {
+            b.created_at
+                .cmp(&a.created_at)
+                .then_with(|| a.id.cmp(&b.id))
+        });
+    }
 
     for (i, comment) in sorted_comments.iter().enumerate() {
         output.push_str(&format!("## Comment {}\n\n", i + 1));
-        output.push_str(&format_comment_for_llm(
-            comment,
-            include_snippet,
-            snippet_lines,
-        ));
+        output.push_str(&format_comment_with_options(comment, options));
         output.push_str("\n---\n\n");
     }
 
@@ -136,25 +799,32 @@ pub fn format_comments_flat(
 
 /// Formats comments in a minimal/compact style for quick overview.
 pub fn format_comments_minimal(comments: &[PRComment]) -> String {
+    format_comments_minimal_with_options(comments, &FormatOptions::default())
+}
+
+/// Formats comments in a minimal/compact style using the given options.
+pub fn format_comments_minimal_with_options(
+    comments: &[PRComment],
+    options: &FormatOptions,
+) -> String {
     if comments.is_empty() {
-        return "No comments found.\n".to_string();
+        return no_comments_message(options);
     }
 
     let mut output = String::new();
 
     for comment in comments {
-        // Truncate body to 100 chars
-        let truncated_body = if comment.body.len() > 100 {
-            format!("{}...", &comment.body[..100])
-        } else {
-            comment.body.clone()
+        // Truncate body to 100 chars, cutting on a char boundary
+        let truncated_body = match comment.body.char_indices().nth(100) {
+            Some((cut, _)) => format!("{}...", &comment.body[..cut]),
+            None => comment.body.clone(),
         };
 
         output.push_str(&format!(
             "\u{1F4C4} {} ({}) - {}: {}\n",
             comment.file_path,
             comment.get_line_info(),
-            comment.author,
+            comment.display_author(options.normalize_bot_names),
             truncated_body.replace('\n', " ")
         ));
     }
@@ -174,94 +844,394 @@ pub fn format_comments_minimal(comments: &[PRComment]) -> String {
     output
 }
 
-/// Formats comments for Claude/LLM consumption with full context.
+/// Characters of comment text kept per row by `--format table` unless
+/// `--max-body-chars` says otherwise.
+pub const TABLE_BODY_CHARS: usize = 80;
+
+/// Formats comments as a GitHub-flavored markdown table with File, Line,
+/// Author and Comment columns, for scanning in a PR description.
 ///
-/// The `pr_node_id` is the GraphQL node ID for the PR (e.g., "PR_kwDO...").
-/// This is needed when replying to comments via the GitHub GraphQL API.
-pub fn format_for_claude(
+/// Bodies are joined onto one line and cut to `max_body_chars` characters;
+/// pipes are escaped so no cell can break its row.
+pub fn format_comments_table(comments: &[PRComment], max_body_chars: usize) -> String {
+    table_with_body_chars(comments, max_body_chars, &FormatOptions::default())
+}
+
+/// Formats comments as a markdown table using the given options.
+pub fn format_comments_table_with_options(
     comments: &[PRComment],
-    pr_url: Option<&str>,
-    pr_title: Option<&str>,
-    pr_node_id: Option<&str>,
-    include_snippet: bool,
-    snippet_lines: usize,
+    options: &FormatOptions,
+) -> String {
+    let max_body_chars = options.max_body_chars.unwrap_or(TABLE_BODY_CHARS);
+    table_with_body_chars(comments, max_body_chars, options)
+}
+
+fn table_with_body_chars(
+    comments: &[PRComment],
+    max_body_chars: usize,
+    options: &FormatOptions,
 ) -> String {
     if comments.is_empty() {
-        return "No comments found.\n".to_string();
+        return no_comments_message(options);
     }
 
-    let mut output = String::new();
+    let mut output =
+        String::from("| File | Line | Author | Comment |\n| --- | --- | --- | --- |\n");
+    for comment in comments {
+        let line = if comment.line_number.or(comment.start_line).is_none() {
+            String::new()
+        } else {
+            let info = comment.get_line_info();
+            info.trim_start_matches("lines ")
+                .trim_start_matches("line ")
+                .to_string()
+        };
+        let body = smart_truncate(&comment.body, max_body_chars);
+        output.push_str(&format!(
+            "| {} | {} | {} | {} |\n",
+            table_cell(&comment.file_path),
+            line,
+            table_cell(comment.display_author(options.normalize_bot_names)),
+            table_cell(&body)
+        ));
+    }
+    output
+}
 
-    // Header
-    output.push_str("# Pull Request Review Comments\n\n");
+/// Flattens text onto one line and escapes pipes for a markdown table cell.
+fn table_cell(text: &str) -> String {
+    text.split_whitespace()
+        .collect::<Vec<_>>()
+        .join(" ")
+        .replace('|', "\\|")
+}
 
-    // PR info if available
-    if let Some(title) = pr_title {
-        output.push_str(&format!("**PR Title:** {title}\n"));
+/// Header row of [`format_comments_csv`].
+pub const CSV_HEADER: &str = "file,line,author,body,url";
+
+/// Formats comments as CSV (RFC 4180) with a `file,line,author,body,url`
+/// header row, for triage in a spreadsheet.
+///
+/// The line is blank for comments without one. Fields with commas, quotes
+/// or newlines are quoted, so multi-line bodies survive import. Empty input
+/// yields just the header.
+pub fn format_comments_csv(comments: &[PRComment], options: &FormatOptions) -> String {
+    let mut output = format!("{CSV_HEADER}\n");
+    for comment in comments {
+        let line = comment
+            .line_number
+            .map(|l| l.to_string())
+            .unwrap_or_default();
+        let fields = [
+            comment.file_path.as_str(),
+            line.as_str(),
+            comment.display_author(options.normalize_bot_names),
+            comment.body.as_str(),
+            comment.html_url.as_str(),
+        ];
+        let row: Vec<Cow<str>> = fields.iter().map(|f| csv_field(f)).collect();
+        output.push_str(&row.join(","));
+        output.push('\n');
     }
-    if let Some(url) = pr_url {
-        output.push_str(&format!("**PR URL:** {url}\n"));
+    output
+}
+
+/// Quotes a CSV field when it contains a delimiter, quote or line break.
+fn csv_field(field: &str) -> Cow<'_, str> {
+    if field.contains([',', '"', '\n', '\r']) {
+        Cow::Owned(format!("\"{}\"", field.replace('"', "\"\"")))
+    } else {
+        Cow::Borrowed(field)
     }
-    if let Some(node_id) = pr_node_id {
-        output.push_str(&format!("**PR Node ID:** `{node_id}` (for GraphQL API)\n"));
+}
+
+/// Formats comments as plain prose with no markdown, for screen readers and
+/// voice output.
+///
+/// Each comment reads "Comment by <author> on <file> <line info>: <body>",
+/// with markdown in the body flattened. Code snippets are omitted.
+pub fn format_comments_plain(comments: &[PRComment], options: &FormatOptions) -> String {
+    if comments.is_empty() {
+        return no_comments_message(options);
     }
 
-    // Summary
     let file_count = comments
         .iter()
         .map(|c| &c.file_path)
         .collect::<HashSet<_>>()
         .len();
-    output.push_str(&format!(
-        "\n**Total comments:** {} across {} file(s)\n\n",
+    let mut output = format!(
+        "Pull request review comments. {} comment(s) across {} file(s).\n\n",
         comments.len(),
         file_count
-    ));
-
-    // Instructions
-    output.push_str("## Instructions\n\n");
-    output.push_str("Please address each of the following review comments. ");
-    output.push_str("The comments are grouped by file for easier navigation.\n\n");
-
-    // Group by file
-    let grouped = group_by_file(comments);
+    );
 
-    // Sort files for consistent output
-    let mut files: Vec<_> = grouped.keys().collect();
-    files.sort();
+    let grouped = group_comments(comments, options);
+    for file in ordered_files(&grouped, options) {
+        for comment in sort_file_comments(&grouped[file], options) {
+            let location = if comment.is_general() {
+                "the pull request".to_string()
+            } else {
+                format!("{} {}", comment.file_path, comment.get_line_info())
+            };
+            output.push_str(&format!(
+                "Comment by {} on {location}: {}\n\n",
+                comment.display_author(options.normalize_bot_names),
+                flatten_markdown(&comment.body)
+            ));
+        }
+    }
 
-    output.push_str("## Comments by File\n\n");
+    output
+}
+
+/// Formats comments as plain text grouped by file, for terminals and
+/// ticketing systems that mangle markdown.
+pub fn format_comments_text(
+    comments: &[PRComment],
+    include_snippet: bool,
+    snippet_lines: usize,
+) -> String {
+    format_comments_text_with_options(
+        comments,
+        &FormatOptions::with_snippet(include_snippet, snippet_lines),
+    )
+}
+
+/// Formats comments as plain text grouped by file using the given options.
+///
+/// File headers are underlined rather than marked with `#`, body markdown
+/// is flattened, and code snippets and fenced code in bodies are indented by
+/// four spaces instead of fenced.
+pub fn format_comments_text_with_options(
+    comments: &[PRComment],
+    options: &FormatOptions,
+) -> String {
+    if comments.is_empty() {
+        return no_comments_message(options);
+    }
+
+    let file_count = comments
+        .iter()
+        .map(|c| &c.file_path)
+        .collect::<HashSet<_>>()
+        .len();
+    let mut output = format!(
+        "PR Review Comments\nTotal comments: {} across {} file(s)\n\n",
+        comments.len(),
+        file_count
+    );
+
+    let grouped = group_comments(comments, options);
+    for file in ordered_files(&grouped, options) {
+        let header = if file.is_empty() {
+            "(review)".to_string()
+        } else {
+            file_header(file, options)
+        };
+        let underline = "=".repeat(header.chars().count());
+        output.push_str(&format!("{header}\n{underline}\n\n"));
+
+        for comment in sort_file_comments(&grouped[file], options) {
+            output.push_str(&format!(
+                "{} by {} ({})\n{}\n\n",
+                comment_location(comment, options),
+                comment.display_author(options.normalize_bot_names),
+                comment.created_at.format("%Y-%m-%d %H:%M UTC"),
+                flatten_markdown_indent_code(&comment.body)
+            ));
+
+            let snippet = if options.include_snippet {
+                snippet_for(comment, options)
+            } else {
+                String::new()
+            };
+            if !snippet.is_empty() {
+                for line in snippet.trim_end_matches('\n').lines() {
+                    output.push_str(format!("    {line}").trim_end());
+                    output.push('\n');
+                }
+                output.push('\n');
+            }
+        }
+    }
+
+    output
+}
+
+/// Maximum characters in a Slack mrkdwn text block.
+pub const SLACK_BLOCK_LIMIT: usize = 3000;
+
+/// Formats comments as a Slack mrkdwn message, linking the header to `pr_url`.
+pub fn format_comments_slack(comments: &[PRComment], pr_url: Option<&str>) -> String {
+    format_comments_slack_with_options(
+        comments,
+        &FormatOptions {
+            pr_url: pr_url.map(String::from),
+            ..FormatOptions::default()
+        },
+    )
+}
+
+/// Formats comments as a Slack mrkdwn message using the given options.
+///
+/// Slack has no headers, so each comment starts with a `*File:*` line. The
+/// message stays within [`SLACK_BLOCK_LIMIT`] characters: comments that do
+/// not fit are dropped and counted in a closing "...(N more)" note.
+pub fn format_comments_slack_with_options(
+    comments: &[PRComment],
+    options: &FormatOptions,
+) -> String {
+    if comments.is_empty() {
+        return no_comments_message(options);
+    }
+
+    let label = to_slack_mrkdwn(options.pr_title.as_deref().unwrap_or("PR review comments"));
+    let mut output = match &options.pr_url {
+        Some(url) => format!("*<{url}|{label}>*"),
+        None => format!("*{label}*"),
+    };
+    output.push_str(&format!(" ({} comment(s))\n\n", comments.len()));
+
+    let more_note = |count: usize| format!("...({count} more)\n");
+    let mut used = output.chars().count();
+    for (shown, comment) in comments.iter().enumerate() {
+        let entry = slack_entry(comment, options);
+        let rest = comments.len() - shown - 1;
+        let note_len = if rest == 0 {
+            0
+        } else {
+            more_note(rest).chars().count()
+        };
+        let entry_len = entry.chars().count();
+        if used + entry_len + note_len > SLACK_BLOCK_LIMIT {
+            output.push_str(&more_note(rest + 1));
+            break;
+        }
+        output.push_str(&entry);
+        used += entry_len;
+    }
+
+    output
+}
+
+/// Formats one comment for a Slack message, with the body capped so a
+/// single comment never fills the whole block.
+fn slack_entry(comment: &PRComment, options: &FormatOptions) -> String {
+    let file = if comment.file_path.is_empty() {
+        "(review)"
+    } else {
+        &comment.file_path
+    };
+    let link = if comment.html_url.is_empty() {
+        String::new()
+    } else {
+        format!(" <{}|view>", comment.html_url)
+    };
+    let body = to_slack_mrkdwn(&smart_truncate(&comment.body, SLACK_BLOCK_LIMIT / 2));
+    format!(
+        "*File:* `{file}` ({}) by *{}*{link}\n{body}\n\n",
+        comment.get_line_info(),
+        comment.display_author(options.normalize_bot_names)
+    )
+}
+
+/// Formats comments for Claude/LLM consumption with full context.
+///
+/// The `pr_node_id` is the GraphQL node ID for the PR (e.g., "PR_kwDO...").
+/// This is needed when replying to comments via the GitHub GraphQL API.
+pub fn format_for_claude(
+    comments: &[PRComment],
+    pr_url: Option<&str>,
+    pr_title: Option<&str>,
+    pr_node_id: Option<&str>,
+    include_snippet: bool,
+    snippet_lines: usize,
+) -> String {
+    let options = FormatOptions {
+        pr_url: pr_url.map(String::from),
+        pr_title: pr_title.map(String::from),
+        pr_node_id: pr_node_id.map(String::from),
+        ..FormatOptions::with_snippet(include_snippet, snippet_lines)
+    };
+    format_for_claude_with_options(comments, &options)
+}
+
+/// Formats comments for Claude/LLM consumption using the given options.
+pub fn format_for_claude_with_options(comments: &[PRComment], options: &FormatOptions) -> String {
+    if comments.is_empty() {
+        return no_comments_message(options);
+    }
+
+    let mut output = String::new();
+
+    // Header
+    output.push_str("# Pull Request Review Comments\n\n");
+
+    // PR info if available
+    output.push_str(&pr_info_lines(options));
+
+    // Summary
+    let file_count = comments
+        .iter()
+        .map(|c| &c.file_path)
+        .collect::<HashSet<_>>()
+        .len();
+    output.push_str(&format!(
+        "\n**Total comments:** {} across {} file(s)\n\n",
+        comments.len(),
+        file_count
+    ));
+
+    // Instructions
+    output.push_str("## Instructions\n\n");
+    output.push_str("Please address each of the following review comments. ");
+    let (heading, grouping) = grouping_label(options);
+    output.push_str(&format!(
+        "The comments are grouped by {grouping} for easier navigation.\n\n"
+    ));
+
+    // Group by file
+    let grouped = group_comments(comments, options);
+
+    // Sort files for consistent output (or diff order when requested)
+    let files = ordered_files(&grouped, options);
+
+    output.push_str(&format!("## Comments by {heading}\n\n"));
 
     for file in files {
         let file_comments = grouped.get(file).unwrap();
-        output.push_str(&format!("### {file}\n\n"));
+        output.push_str(&format!("### {}\n\n", file_header(file, options)));
+        output.push_str(&group_count_line(file_comments.len(), options));
 
-        // Sort by line number, then by date
-        let mut sorted_comments: Vec<_> = file_comments.iter().collect();
-        sorted_comments.sort_by(|a, b| {
-            a.line_number
-                .cmp(&b.line_number)
-                .then_with(|| a.created_at.cmp(&b.created_at))
-        });
+        let sorted_comments = sort_file_comments(file_comments, options);
 
         for comment in sorted_comments {
             output.push_str(&format!(
-                "#### {} ({})\n\n",
-                comment.get_line_info(),
-                comment.author
+                "#### {} ({}){}{}{}\n\n",
+                comment_location(comment, options),
+                comment.display_author(options.normalize_bot_names),
+                severity_tag(comment),
+                line_references_note(comment, options),
+                word_count_note(comment, options)
             ));
 
-            // Code snippet
-            if include_snippet {
-                let snippet = comment.get_code_snippet(snippet_lines);
-                if !snippet.is_empty() {
-                    output.push_str("**Code context:**\n```\n");
-                    output.push_str(&snippet);
-                    output.push_str("\n```\n\n");
-                }
+            // Code snippet and comment body, in the configured order
+            let snippet = snippet_block(comment, options);
+            let mut body = format!("**Review comment:**\n{}\n\n", comment.body);
+            if let Some(suggestion) = comment.extract_suggestion() {
+                body.push_str(&format!(
+                    "**Suggested change:**\n```{}\n{suggestion}\n```\n\n",
+                    language_for_path(&comment.file_path)
+                ));
+            }
+            if options.snippet_after {
+                output.push_str(&body);
+                output.push_str(&snippet);
+            } else {
+                output.push_str(&snippet);
+                output.push_str(&body);
             }
-
-            output.push_str(&format!("**Review comment:**\n{}\n\n", comment.body));
             output.push_str(&format!("[View on GitHub]({})\n\n", comment.html_url));
             output.push_str("---\n\n");
         }
@@ -270,6 +1240,147 @@ pub fn format_for_claude(
     output
 }
 
+/// Returns the "**PR Title:**"-style lines for whatever PR details are set.
+fn pr_info_lines(options: &FormatOptions) -> String {
+    let mut output = String::new();
+    if let Some(title) = &options.pr_title {
+        output.push_str(&format!("**PR Title:** {title}\n"));
+    }
+    if let Some(url) = &options.pr_url {
+        output.push_str(&format!("**PR URL:** {url}\n"));
+    }
+    if let Some(node_id) = &options.pr_node_id {
+        output.push_str(&format!("**PR Node ID:** `{node_id}` (for GraphQL API)\n"));
+    }
+    if let Some(state) = &options.pr_state {
+        output.push_str(&format!("**PR State:** {state}\n"));
+    }
+    if let Some(author) = &options.pr_author {
+        output.push_str(&format!("**PR Author:** {author}\n"));
+    }
+    if let Some((base, head)) = &options.pr_branches {
+        output.push_str(&format!("**Base:** {base} \u{2190} **Head:** {head}\n"));
+    }
+    output
+}
+
+/// Returns " (references line N)" for line numbers mentioned in the comment
+/// body, or an empty string if there are none or the pass is disabled.
+fn line_references_note(comment: &PRComment, options: &FormatOptions) -> String {
+    if !options.line_references {
+        return String::new();
+    }
+    let refs = find_line_references(&comment.body);
+    match refs.as_slice() {
+        [] => String::new(),
+        [line] => format!(" (references line {line})"),
+        lines => {
+            let list: Vec<String> = lines.iter().map(|l| l.to_string()).collect();
+            format!(" (references lines {})", list.join(", "))
+        }
+    }
+}
+
+/// Returns " [WARNING]"-style tag for the comment's inferred severity, or
+/// an empty string when it has none.
+fn severity_tag(comment: &PRComment) -> String {
+    match comment.infer_severity() {
+        Severity::None => String::new(),
+        severity => format!(" [{}]", severity.label().to_uppercase()),
+    }
+}
+
+/// Returns " (N words)" for the comment body, or an empty string if
+/// word counts are disabled.
+fn word_count_note(comment: &PRComment, options: &FormatOptions) -> String {
+    if !options.show_word_count {
+        return String::new();
+    }
+    match word_count(&comment.body) {
+        1 => " (1 word)".to_string(),
+        n => format!(" ({n} words)"),
+    }
+}
+
+/// A comment as serialized by the JSON format.
+#[derive(Debug, Clone, Serialize, PartialEq)]
+pub struct JsonComment {
+    pub file: String,
+    /// Effective line: the current line, or the original one when outdated.
+    pub line: Option<i32>,
+    /// True when `line` is the original position and may be stale.
+    pub outdated: bool,
+    pub author: String,
+    pub body: String,
+    /// Processed code snippet; null when excluded or unavailable.
+    pub snippet: Option<String>,
+    pub url: String,
+    /// GraphQL node ID, used as `inReplyTo` when replying via GraphQL.
+    pub node_id: Option<String>,
+    /// Verbatim diff hunk (with `@@` header and +/- markers); omitted unless
+    /// requested.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub raw_diff_hunk: Option<String>,
+}
+
+impl JsonComment {
+    /// Builds the JSON representation of a comment.
+    pub fn from_comment(comment: &PRComment, options: &FormatOptions) -> Self {
+        let snippet = if options.include_snippet {
+            Some(snippet_for(comment, options)).filter(|s| !s.is_empty())
+        } else {
+            None
+        };
+
+        Self {
+            file: comment.file_path.clone(),
+            line: comment.line_number,
+            outdated: comment.outdated,
+            author: comment.author.clone(),
+            body: comment.body.clone(),
+            snippet,
+            url: comment.html_url.clone(),
+            node_id: comment.node_id.clone(),
+            raw_diff_hunk: options.include_raw_diff.then(|| comment.diff_hunk.clone()),
+        }
+    }
+
+    /// Returns the JSON Schema of one serialized comment.
+    ///
+    /// Fields that are always serialized are required (Options may be null);
+    /// `raw_diff_hunk` is only present with `--include-raw-diff`. Tests check
+    /// that the properties match what serialization produces.
+    pub fn schema() -> Value {
+        json!({
+            "type": "object",
+            "properties": {
+                "file": {"type": "string", "description": "Path of the commented file; empty for review-level comments"},
+                "line": {"type": ["integer", "null"], "description": "Effective line: the current line, or the original one when outdated"},
+                "outdated": {"type": "boolean", "description": "True when line is the original position and may be stale"},
+                "author": {"type": "string"},
+                "body": {"type": "string"},
+                "snippet": {"type": ["string", "null"], "description": "Processed code snippet; null when excluded or unavailable"},
+                "url": {"type": "string"},
+                "node_id": {"type": ["string", "null"], "description": "GraphQL node ID, used as inReplyTo when replying via GraphQL"},
+                "raw_diff_hunk": {"type": "string", "description": "Verbatim diff hunk; present only when requested"}
+            },
+            "required": ["file", "line", "outdated", "author", "body", "snippet", "url", "node_id"],
+            "additionalProperties": false
+        })
+    }
+}
+
+/// Returns the JSON Schema of `--format json` output: an array of
+/// [`JsonComment`] objects.
+pub fn json_output_schema() -> Value {
+    json!({
+        "$schema": "https://json-schema.org/draft/2020-12/schema",
+        "title": "pr-comments JSON output",
+        "type": "array",
+        "items": JsonComment::schema()
+    })
+}
+
 /// Formats comments as JSON for programmatic use.
 ///
 /// Includes `node_id` field which is the GraphQL node ID needed for
@@ -279,761 +1390,3509 @@ pub fn format_as_json(
     include_snippet: bool,
     snippet_lines: usize,
 ) -> String {
+    format_as_json_with_options(
+        comments,
+        &FormatOptions::with_snippet(include_snippet, snippet_lines),
+    )
+}
+
+/// Formats comments as JSON using the given options.
+pub fn format_as_json_with_options(comments: &[PRComment], options: &FormatOptions) -> String {
     let json_comments: Vec<_> = comments
+        .iter()
+        .map(|c| JsonComment::from_comment(c, options))
+        .collect();
+
+    serde_json::to_string_pretty(&json_comments).unwrap_or_else(|_| "[]".to_string())
+}
+
+/// Formats comments as newline-delimited JSON: one compact object per line,
+/// with the same fields as the JSON format and no surrounding array.
+pub fn format_as_ndjson(
+    comments: &[PRComment],
+    include_snippet: bool,
+    snippet_lines: usize,
+) -> String {
+    format_as_ndjson_with_options(
+        comments,
+        &FormatOptions::with_snippet(include_snippet, snippet_lines),
+    )
+}
+
+/// Formats comments as newline-delimited JSON using the given options.
+///
+/// Empty input yields an empty string, so the stream simply has no lines.
+pub fn format_as_ndjson_with_options(comments: &[PRComment], options: &FormatOptions) -> String {
+    comments
         .iter()
         .map(|c| {
-            let snippet = if include_snippet {
-                let s = c.get_code_snippet(snippet_lines);
-                if s.is_empty() {
-                    None
-                } else {
-                    Some(s)
+            serde_json::to_string(&JsonComment::from_comment(c, options)).unwrap_or_default() + "\n"
+        })
+        .collect()
+}
+
+/// One line of `--format ndjson-events` output.
+#[derive(Debug, Serialize)]
+#[serde(tag = "event", rename_all = "snake_case")]
+enum NdjsonEvent<'a> {
+    PrStart {
+        pr: Option<&'a str>,
+        title: Option<&'a str>,
+        url: Option<&'a str>,
+        comment_count: usize,
+    },
+    Comment {
+        pr: Option<&'a str>,
+        id: i64,
+        created_at: String,
+        #[serde(flatten)]
+        comment: JsonComment,
+    },
+    PrEnd {
+        pr: Option<&'a str>,
+        comment_count: usize,
+    },
+}
+
+/// Formats comments as an append-only event stream, one JSON object per
+/// line: a `pr_start` event, one `comment` event per comment (the JSON
+/// format's fields plus `id` and `created_at`), then a `pr_end` event.
+///
+/// Unlike the JSON format this is meant for event stores and log shippers,
+/// so every event carries the PR reference.
+pub fn format_comments_ndjson_events(comments: &[PRComment], options: &FormatOptions) -> String {
+    let pr = options.pr_ref.as_deref();
+    let start = NdjsonEvent::PrStart {
+        pr,
+        title: options.pr_title.as_deref(),
+        url: options.pr_url.as_deref(),
+        comment_count: comments.len(),
+    };
+    let events = comments.iter().map(|c| NdjsonEvent::Comment {
+        pr,
+        id: c.id,
+        created_at: c.created_at.to_rfc3339_opts(SecondsFormat::Secs, true),
+        comment: JsonComment::from_comment(c, options),
+    });
+    let end = NdjsonEvent::PrEnd {
+        pr,
+        comment_count: comments.len(),
+    };
+
+    std::iter::once(start)
+        .chain(events)
+        .chain([end])
+        .map(|event| serde_json::to_string(&event).unwrap_or_default() + "\n")
+        .collect()
+}
+
+/// Formats comments as a standalone HTML page grouped by file.
+pub fn format_as_html(
+    comments: &[PRComment],
+    pr_title: Option<&str>,
+    pr_url: Option<&str>,
+    include_snippet: bool,
+    snippet_lines: usize,
+) -> String {
+    let options = FormatOptions {
+        pr_title: pr_title.map(String::from),
+        pr_url: pr_url.map(String::from),
+        ..FormatOptions::with_snippet(include_snippet, snippet_lines)
+    };
+    format_as_html_with_options(comments, &options)
+}
+
+/// Returns unique `id` anchors for file sections, e.g. "file-src-main-rs"
+/// for "src/main.rs", in the order given.
+fn html_anchors(files: &[&String]) -> Vec<String> {
+    let mut seen = HashSet::new();
+    files
+        .iter()
+        .map(|file| {
+            let slug: String = file
+                .chars()
+                .map(|c| {
+                    if c.is_ascii_alphanumeric() {
+                        c.to_ascii_lowercase()
+                    } else {
+                        '-'
+                    }
+                })
+                .collect();
+            let base = format!("file-{slug}");
+            let mut anchor = base.clone();
+            let mut n = 1;
+            while !seen.insert(anchor.clone()) {
+                n += 1;
+                anchor = format!("{base}-{n}");
+            }
+            anchor
+        })
+        .collect()
+}
+
+/// Formats comments as a standalone HTML page grouped by file.
+///
+/// Author names link to their GitHub profiles; avatars are shown when
+/// `options.html_avatars` is set and the API provided an avatar URL. A file
+/// index links to each file's section, and every comment has a
+/// `comment-{id}` anchor for linking from elsewhere.
+pub fn format_as_html_with_options(comments: &[PRComment], options: &FormatOptions) -> String {
+    let title = options
+        .pr_title
+        .as_deref()
+        .unwrap_or("Pull Request Review Comments");
+
+    let mut output = String::new();
+    output.push_str("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n");
+    output.push_str(&format!("<title>{}</title>\n", escape_html(title)));
+    output.push_str("</head>\n<body>\n");
+    output.push_str(&format!("<h1>{}</h1>\n", escape_html(title)));
+    if let Some(url) = &options.pr_url {
+        let url = escape_html(url);
+        output.push_str(&format!("<p><a href=\"{url}\">{url}</a></p>\n"));
+    }
+
+    if comments.is_empty() {
+        output.push_str(&format!(
+            "<p>{}</p>\n</body>\n</html>\n",
+            escape_html(empty_message(options))
+        ));
+        return output;
+    }
+
+    output.push_str(&format!("<p>Total comments: {}</p>\n", comments.len()));
+
+    let grouped = group_comments(comments, options);
+    let files = ordered_files(&grouped, options);
+    let anchors = html_anchors(&files);
+
+    output.push_str("<ul class=\"files\">\n");
+    for (file, anchor) in files.iter().zip(&anchors) {
+        output.push_str(&format!(
+            "<li><a href=\"#{anchor}\">{}</a> ({})</li>\n",
+            escape_html(file),
+            grouped[*file].len()
+        ));
+    }
+    output.push_str("</ul>\n");
+
+    for (file, anchor) in files.iter().zip(&anchors) {
+        let file_comments = grouped.get(*file).unwrap();
+        output.push_str(&format!("<h2 id=\"{anchor}\">{}</h2>\n", escape_html(file)));
+
+        let sorted_comments = sort_file_comments(file_comments, options);
+
+        for comment in sorted_comments {
+            output.push_str(&format!(
+                "<div class=\"comment\" id=\"comment-{}\">\n<p>",
+                comment.id
+            ));
+            if options.html_avatars {
+                if let Some(avatar) = &comment.author_avatar_url {
+                    output.push_str(&format!(
+                        "<img class=\"avatar\" src=\"{}\" alt=\"\" width=\"20\" height=\"20\"> ",
+                        escape_html(avatar)
+                    ));
                 }
-            } else {
-                None
-            };
+            }
+            output.push_str(&format!(
+                "<a href=\"{}\">{}</a> &middot; {} &middot; {}</p>\n",
+                escape_html(&comment.author_profile_url()),
+                escape_html(comment.display_author(options.normalize_bot_names)),
+                escape_html(&comment_location(comment, options)),
+                comment.created_at.format("%Y-%m-%d %H:%M")
+            ));
+
+            if options.include_snippet {
+                let snippet = snippet_for(comment, options);
+                if !snippet.is_empty() {
+                    output.push_str(&format!(
+                        "<pre><code>{}</code></pre>\n",
+                        escape_html(&snippet)
+                    ));
+                }
+            }
+
+            output.push_str(&format!(
+                "<pre class=\"body\">{}</pre>\n",
+                escape_html(&comment.body)
+            ));
+            output.push_str(&format!(
+                "<p><a href=\"{}\">View on GitHub</a></p>\n</div>\n",
+                escape_html(&comment.html_url)
+            ));
+        }
+    }
+
+    output.push_str("</body>\n</html>\n");
+    output
+}
+
+/// Formats (key, count) tallies as a tab-separated table, one row per key.
+pub fn format_counts(counts: &[(String, usize)]) -> String {
+    counts
+        .iter()
+        .map(|(key, count)| format!("{key}\t{count}\n"))
+        .collect()
+}
+
+/// Marker prefixed to bodies of comments asking for tests when
+/// [`FormatOptions::flag_test_requests`] is set.
+pub const TEST_REQUEST_MARKER: &str = "\u{1F9EA} test requested";
+
+/// Marker prefixed to bodies of blocking comments when
+/// [`FormatOptions::flag_blocking`] is set.
+pub const BLOCKING_MARKER: &str = "\u{26D4} blocking";
+
+/// Prefix of comments inlined into source by [`format_annotated_source`].
+pub const SOURCE_ANNOTATION_MARKER: &str = "// <<";
+
+/// Renders a whole file with line numbers and its comments inlined below
+/// the lines they target, as `// << author: body`.
+///
+/// Comments without a usable line (file-level, or past the end of the file)
+/// are listed above the first line. Bodies are joined onto one line.
+pub fn format_annotated_source(path: &str, source: &str, comments: &[&PRComment]) -> String {
+    let lines: Vec<&str> = source.lines().collect();
+    let width = lines.len().max(1).to_string().len();
+    let annotation = |comment: &PRComment, indent: &str| {
+        let body: Vec<&str> = comment
+            .body
+            .lines()
+            .map(str::trim)
+            .filter(|l| !l.is_empty())
+            .collect();
+        format!(
+            "{:width$} | {indent}{SOURCE_ANNOTATION_MARKER} {}: {}\n",
+            "",
+            comment.author,
+            body.join(" ")
+        )
+    };
+
+    let mut by_line: HashMap<usize, Vec<&PRComment>> = HashMap::new();
+    let mut unplaced = Vec::new();
+    for comment in sort_file_comments(comments, &FormatOptions::default()) {
+        match comment.line_number {
+            Some(line) if line >= 1 && (line as usize) <= lines.len() => {
+                by_line.entry(line as usize).or_default().push(comment)
+            }
+            _ => unplaced.push(comment),
+        }
+    }
+
+    let mut output = format!("## {path}\n\n```\n");
+    for comment in unplaced {
+        output.push_str(&annotation(comment, ""));
+    }
+    for (index, line) in lines.iter().enumerate() {
+        output.push_str(&format!("{:>width$} | {line}\n", index + 1));
+        let indent = &line[..line.len() - line.trim_start().len()];
+        for comment in by_line.get(&(index + 1)).into_iter().flatten() {
+            output.push_str(&annotation(comment, indent));
+        }
+    }
+    output.push_str("```\n");
+    output
+}
+
+/// Age buckets for `--format age-buckets`: label and exclusive upper bound
+/// in days. Older comments fall into ">7d".
+const AGE_BUCKETS: [(&str, i64); 3] = [("<1d", 1), ("1-3d", 3), ("3-7d", 7)];
+
+/// Tallies comments by how long ago they were created, relative to `now`,
+/// as a tab-separated table with one row per bucket (including empty ones).
+pub fn format_age_buckets(comments: &[PRComment], now: DateTime<Utc>) -> String {
+    let mut counts = [0usize; AGE_BUCKETS.len() + 1];
+    for comment in comments {
+        let age = now - comment.created_at;
+        let bucket = AGE_BUCKETS
+            .iter()
+            .position(|(_, days)| age < chrono::Duration::days(*days))
+            .unwrap_or(AGE_BUCKETS.len());
+        counts[bucket] += 1;
+    }
+
+    let labels = AGE_BUCKETS.iter().map(|(label, _)| *label).chain([">7d"]);
+    let rows: Vec<(String, usize)> = labels.map(String::from).zip(counts).collect();
+    format_counts(&rows)
+}
+
+/// Registry adapter for [`format_age_buckets`], measuring ages from now.
+fn format_age_buckets_with_options(comments: &[PRComment], _options: &FormatOptions) -> String {
+    format_age_buckets(comments, Utc::now())
+}
+
+/// Formats the comment authors as a space-separated line of "@login"
+/// handles, each once, in the order they first commented, for dropping
+/// into a notification.
+///
+/// Bots are left out unless `options.mention_bots` is set. Prints nothing
+/// when there is no one to mention, so scripts can test for empty output.
+pub fn format_mentions(comments: &[PRComment], options: &FormatOptions) -> String {
+    let mut seen = HashSet::new();
+    let handles: Vec<String> = comments
+        .iter()
+        .filter(|c| options.mention_bots || !c.is_bot())
+        .filter(|c| seen.insert(c.author.as_str()))
+        .map(|c| format!("@{}", c.author))
+        .collect();
+    if handles.is_empty() {
+        return String::new();
+    }
+    format!("{}\n", handles.join(" "))
+}
+
+/// Formats review coverage as a tab-separated table: file, commented/changed
+/// lines and percentage, then an overall TOTAL row.
+pub fn format_coverage(coverage: &[FileCoverage]) -> String {
+    let row = |name: &str, commented: usize, changed: usize| {
+        let percent = if changed > 0 {
+            format!("{:.1}%", commented as f64 * 100.0 / changed as f64)
+        } else {
+            "n/a".to_string()
+        };
+        format!("{name}\t{commented}/{changed}\t{percent}\n")
+    };
+
+    let mut output: String = coverage
+        .iter()
+        .map(|f| row(&f.filename, f.commented_lines, f.changed_lines))
+        .collect();
+    output.push_str(&row(
+        "TOTAL",
+        coverage.iter().map(|f| f.commented_lines).sum(),
+        coverage.iter().map(|f| f.changed_lines).sum(),
+    ));
+    output
+}
+
+/// Formats [`ReviewStats`] as a summary: totals, time span, the busiest
+/// file, and tallies by author and file. Used by both `--stats` and
+/// `--with-stats`.
+pub fn format_review_stats(stats: &ReviewStats) -> String {
+    let mut output = String::from("# Review Stats\n\n");
+    output.push_str(&format!("**Total comments:** {}\n", stats.total_comments));
+    output.push_str(&format!("**Files:** {}\n", stats.unique_files));
+    output.push_str(&format!("**Authors:** {}\n", stats.unique_authors));
+    if let Some((earliest, latest)) = stats.time_span {
+        output.push_str(&format!(
+            "**Time span:** {} to {}\n",
+            earliest.format("%Y-%m-%d %H:%M UTC"),
+            latest.format("%Y-%m-%d %H:%M UTC")
+        ));
+    }
+    if let Some((file, count)) = &stats.busiest_file {
+        output.push_str(&format!("**Busiest file:** {file} ({count} comments)\n"));
+    }
+    for (heading, counts) in [
+        ("author", &stats.comments_per_author),
+        ("file", &stats.comments_per_file),
+    ] {
+        if counts.is_empty() {
+            continue;
+        }
+        output.push_str(&format!("\n**By {heading}:**\n"));
+        for (name, count) in counts {
+            output.push_str(&format!("- {name}: {count}\n"));
+        }
+    }
+    output
+}
+
+/// Prepends the stats summary for `comments` to an already formatted body.
+pub fn with_stats(comments: &[PRComment], body: &str) -> String {
+    format!("{}\n{body}", format_review_stats(&compute_stats(comments)))
+}
+
+/// Formats unchecked task list items from all comment bodies as a
+/// consolidated checklist, each linked back to where it was raised.
+pub fn format_tasks(comments: &[PRComment]) -> String {
+    let mut output = String::from("# Outstanding tasks\n\n");
+    let mut found = false;
+    for comment in comments {
+        let location = if comment.is_general() {
+            "review comment".to_string()
+        } else {
+            format!("{} {}", comment.file_path, comment.get_line_info())
+        };
+        for task in extract_tasks(&comment.body) {
+            output.push_str(&format!("- [ ] {task} ({location}, @{})\n", comment.author));
+            found = true;
+        }
+    }
+    if !found {
+        output.push_str("None.\n");
+    }
+    output
+}
+
+/// Appends the outstanding tasks in `comments` to an already formatted body.
+pub fn with_tasks(comments: &[PRComment], body: &str) -> String {
+    format!("{body}\n{}", format_tasks(comments))
+}
+
+/// Formats every URL referenced in comment bodies as a list, deduplicated
+/// in order of first appearance.
+pub fn format_links(comments: &[PRComment]) -> String {
+    let mut links: Vec<String> = Vec::new();
+    for link in comments.iter().flat_map(|c| extract_links(&c.body)) {
+        if !links.contains(&link) {
+            links.push(link);
+        }
+    }
+
+    let mut output = String::from("# Referenced links\n\n");
+    if links.is_empty() {
+        output.push_str("None.\n");
+    }
+    for link in links {
+        output.push_str(&format!("- {link}\n"));
+    }
+    output
+}
+
+/// Appends the links referenced in `comments` to an already formatted body.
+pub fn with_links(comments: &[PRComment], body: &str) -> String {
+    format!("{body}\n{}", format_links(comments))
+}
+
+/// Builds a YAML front-matter block describing the output, for docs
+/// pipelines that read metadata from the top of a file.
+pub fn build_front_matter(
+    owner: &str,
+    repo: &str,
+    pr_number: i32,
+    total: usize,
+    generated_at: DateTime<Utc>,
+) -> String {
+    format!(
+        "---\nowner: {owner}\nrepo: {repo}\npr: {pr_number}\ntotal: {total}\ngenerated_at: {}\n---\n",
+        generated_at.to_rfc3339_opts(SecondsFormat::Secs, true)
+    )
+}
+
+/// Surrounds formatted output with the contents of a prepend and/or append
+/// file, verbatim, for embedding into a larger prompt or document.
+pub fn wrap_with_files(
+    body: &str,
+    prepend: Option<&Path>,
+    append: Option<&Path>,
+) -> io::Result<String> {
+    let read = |path: Option<&Path>| path.map(fs::read_to_string).transpose();
+    let prefix = read(prepend)?.unwrap_or_default();
+    let suffix = read(append)?.unwrap_or_default();
+    Ok(format!("{prefix}{body}{suffix}"))
+}
+
+/// Signature shared by all comment formatters in the registry.
+pub type FormatFn = fn(&[PRComment], &FormatOptions) -> String;
+
+/// Returns the global format registry, seeding it with the built-in formats.
+fn registry() -> &'static RwLock<HashMap<String, FormatFn>> {
+    static REGISTRY: OnceLock<RwLock<HashMap<String, FormatFn>>> = OnceLock::new();
+    REGISTRY.get_or_init(|| {
+        let builtins: [(&str, FormatFn); 15] = [
+            ("claude", format_for_claude_with_options),
+            ("grouped", format_comments_grouped_with_options),
+            ("flat", format_comments_flat_with_options),
+            ("minimal", format_comments_minimal_with_options),
+            ("json", format_as_json_with_options),
+            ("html", format_as_html_with_options),
+            ("plain", format_comments_plain),
+            ("age-buckets", format_age_buckets_with_options),
+            ("ndjson-events", format_comments_ndjson_events),
+            ("mentions", format_mentions),
+            ("table", format_comments_table_with_options),
+            ("csv", format_comments_csv),
+            ("ndjson", format_as_ndjson_with_options),
+            ("text", format_comments_text_with_options),
+            ("slack", format_comments_slack_with_options),
+        ];
+        RwLock::new(
+            builtins
+                .into_iter()
+                .map(|(name, f)| (name.to_string(), f))
+                .collect(),
+        )
+    })
+}
+
+/// Registers a comment formatter under `name`, replacing any existing entry.
+///
+/// This lets library users add output formats without touching the CLI
+/// dispatch; registered formats are available via [`format_comments`].
+pub fn register_format(name: &str, format_fn: FormatFn) {
+    registry()
+        .write()
+        .unwrap_or_else(|e| e.into_inner())
+        .insert(name.to_string(), format_fn);
+}
+
+/// Returns the names of all registered formats, sorted alphabetically.
+pub fn registered_formats() -> Vec<String> {
+    let mut names: Vec<String> = registry()
+        .read()
+        .unwrap_or_else(|e| e.into_inner())
+        .keys()
+        .cloned()
+        .collect();
+    names.sort();
+    names
+}
+
+/// Formats comments using the formatter registered under `name`.
+///
+/// Returns None if no formatter is registered with that name.
+pub fn format_comments(
+    name: &str,
+    comments: &[PRComment],
+    options: &FormatOptions,
+) -> Option<String> {
+    format_comments_multi(&[name], comments, options)?.pop()
+}
+
+/// Formats comments in each of the named formats, in order, preparing the
+/// bodies once for all of them.
+///
+/// Returns None if any name has no registered formatter.
+pub fn format_comments_multi(
+    names: &[&str],
+    comments: &[PRComment],
+    options: &FormatOptions,
+) -> Option<Vec<String>> {
+    let format_fns: Vec<FormatFn> = names
+        .iter()
+        .map(|name| lookup_format(name))
+        .collect::<Option<_>>()?;
+    Some(format_comments_with(&format_fns, comments, options))
+}
+
+/// Formats comments with each of the given formatters, in order, applying
+/// the same body preparation as [`format_comments`].
+///
+/// For layouts that are not registered formats, such as
+/// [`format_comments_by_severity`].
+pub fn format_comments_with(
+    format_fns: &[FormatFn],
+    comments: &[PRComment],
+    options: &FormatOptions,
+) -> Vec<String> {
+    let prepared = prepare_comments(comments, options);
+    format_fns.iter().map(|f| f(&prepared, options)).collect()
+}
+
+/// Returns the formatter registered under `name`.
+pub fn lookup_format(name: &str) -> Option<FormatFn> {
+    registry()
+        .read()
+        .unwrap_or_else(|e| e.into_inner())
+        .get(name)
+        .copied()
+}
+
+/// Applies format-independent options (ANSI stripping, blank-line
+/// collapsing, body truncation) before dispatch, so every registered format
+/// honors them.
+fn prepare_comments<'a>(
+    comments: &'a [PRComment],
+    options: &FormatOptions,
+) -> Cow<'a, [PRComment]> {
+    let has_ansi = !options.keep_ansi
+        && comments
+            .iter()
+            .any(|c| c.body.contains('\x1b') || c.diff_hunk.contains('\x1b'));
+    if options.max_body_chars.is_none()
+        && !options.collapse_blank_lines
+        && !has_ansi
+        && !options.flag_test_requests
+        && !options.flag_blocking
+    {
+        return Cow::Borrowed(comments);
+    }
+    Cow::Owned(
+        comments
+            .iter()
+            .map(|c| PRComment {
+                body: marked_body(c, options),
+                diff_hunk: if options.keep_ansi {
+                    c.diff_hunk.clone()
+                } else {
+                    strip_ansi(&c.diff_hunk).into_owned()
+                },
+                ..c.clone()
+            })
+            .collect(),
+    )
+}
+
+/// Prepares a comment's body and prefixes the markers enabled in `options`.
+fn marked_body(comment: &PRComment, options: &FormatOptions) -> String {
+    let mut body = prepare_body(&comment.body, options);
+    if options.flag_test_requests && comment.requests_tests() {
+        body = format!("{TEST_REQUEST_MARKER}: {body}");
+    }
+    if options.flag_blocking && comment.blocking {
+        body = format!("{BLOCKING_MARKER}: {body}");
+    }
+    body
+}
+
+/// Strips ANSI escapes, collapses blank lines, then truncates, so the limit
+/// counts visible text.
+fn prepare_body(body: &str, options: &FormatOptions) -> String {
+    let body = if options.keep_ansi {
+        Cow::Borrowed(body)
+    } else {
+        strip_ansi(body)
+    };
+    let body = if options.collapse_blank_lines {
+        collapse_blank_lines(&body)
+    } else {
+        body.into_owned()
+    };
+    match options.max_body_chars {
+        Some(max_chars) => smart_truncate(&body, max_chars),
+        None => body,
+    }
+}
+
+/// Returns the file extension for output in the named format.
+pub fn file_extension(format_name: &str) -> &'static str {
+    match format_name {
+        "json" => "json",
+        "html" => "html",
+        "csv" => "csv",
+        "ndjson" | "ndjson-events" => "ndjson",
+        "text" | "plain" | "slack" => "txt",
+        _ => "md",
+    }
+}
+
+/// Writes `contents` to `path` atomically: the data goes to a temp file in
+/// the same directory, which is then renamed into place.
+///
+/// Readers see either the old file or the complete new one, never a partial
+/// write, even when several runs write the same path.
+pub fn write_atomic(path: &Path, contents: &str) -> io::Result<()> {
+    static NEXT_TEMP: AtomicUsize = AtomicUsize::new(0);
+
+    let file_name = path
+        .file_name()
+        .ok_or_else(|| io::Error::new(io::ErrorKind::InvalidInput, "path has no file name"))?;
+    let temp_path = path.with_file_name(format!(
+        ".{}.{}-{}.tmp",
+        file_name.to_string_lossy(),
+        std::process::id(),
+        NEXT_TEMP.fetch_add(1, Ordering::Relaxed)
+    ));
+
+    let result = fs::write(&temp_path, contents).and_then(|()| fs::rename(&temp_path, path));
+    if result.is_err() {
+        let _ = fs::remove_file(&temp_path);
+    }
+    result
+}
+
+/// Writes each comment to its own file in `dir`, named `<id>.<ext>`.
+///
+/// Each file holds that single comment formatted with the named format. The
+/// directory is created if missing. Returns the written paths in input order.
+pub fn write_comment_files(
+    dir: &Path,
+    format_name: &str,
+    comments: &[PRComment],
+    options: &FormatOptions,
+) -> io::Result<Vec<PathBuf>> {
+    let format_fn = lookup_format(format_name).ok_or_else(|| {
+        io::Error::new(
+            io::ErrorKind::InvalidInput,
+            format!("Unknown output format: {format_name}"),
+        )
+    })?;
+
+    fs::create_dir_all(dir)?;
+    let extension = file_extension(format_name);
+    prepare_comments(comments, options)
+        .iter()
+        .map(|comment| {
+            let path = dir.join(format!("{}.{extension}", comment.id));
+            write_atomic(&path, &format_fn(std::slice::from_ref(comment), options))?;
+            Ok(path)
+        })
+        .collect()
+}
+
+/// Formats a checks report for Claude/LLM consumption with full context.
+pub fn format_checks_for_claude(report: &ChecksReport) -> String {
+    let mut output = String::new();
+
+    output.push_str("# Pull Request Check Status\n\n");
+
+    if let Some(title) = &report.pr_title {
+        output.push_str(&format!("**PR Title:** {title}\n"));
+    }
+    if let Some(url) = &report.pr_url {
+        output.push_str(&format!("**PR URL:** {url}\n"));
+    }
+
+    output.push_str(&format!("**Overall Status:** {}\n", report.rollup_state));
+
+    let summary = report.summary_counts();
+    output.push_str(&format!(
+        "**Summary:** {} passed, {} failed, {} pending, {} skipped ({} total)\n\n",
+        summary.passed, summary.failed, summary.pending, summary.skipped, summary.total
+    ));
+
+    // Failed required checks (highest priority)
+    let failed_req = report.failed_required();
+    if !failed_req.is_empty() {
+        output.push_str("## Failed Required Checks\n\n");
+        for check in &failed_req {
+            format_check_detail(&mut output, check);
+        }
+    }
+
+    // Failed optional checks
+    let failed_opt = report.failed_optional();
+    if !failed_opt.is_empty() {
+        output.push_str("## Failed Optional Checks\n\n");
+        for check in &failed_opt {
+            format_check_detail(&mut output, check);
+        }
+    }
+
+    // Pending checks
+    let pending = report.pending();
+    if !pending.is_empty() {
+        output.push_str("## Pending Checks\n\n");
+        for check in &pending {
+            format_check_brief(&mut output, check);
+        }
+        output.push('\n');
+    }
+
+    // Passed required checks (brief)
+    let passed_req = report.passed_required();
+    if !passed_req.is_empty() {
+        output.push_str("## Passed Required Checks\n\n");
+        for check in &passed_req {
+            format_check_brief(&mut output, check);
+        }
+        output.push('\n');
+    }
+
+    // Passed optional checks (brief)
+    let passed_opt = report.passed_optional();
+    if !passed_opt.is_empty() {
+        output.push_str("## Passed Optional Checks\n\n");
+        for check in &passed_opt {
+            format_check_brief(&mut output, check);
+        }
+        output.push('\n');
+    }
+
+    // Skipped checks
+    let skipped = report.skipped();
+    if !skipped.is_empty() {
+        output.push_str("## Skipped Checks\n\n");
+        for check in &skipped {
+            format_check_brief(&mut output, check);
+        }
+        output.push('\n');
+    }
+
+    if report.checks.is_empty() {
+        output.push_str("No checks found for this pull request.\n");
+    }
+
+    output
+}
+
+/// Formats a single check with full details (for failed checks).
+fn format_check_detail(output: &mut String, check: &CheckStatus) {
+    output.push_str(&format!(
+        "### [{}] {}{}\n\n",
+        check.conclusion.display_icon(),
+        check.name,
+        if check.required { " (required)" } else { "" }
+    ));
+
+    if let Some(desc) = &check.description {
+        output.push_str(&format!("**Description:** {desc}\n"));
+    }
+    if let Some(workflow) = &check.workflow_name {
+        output.push_str(&format!("**Workflow:** {workflow}\n"));
+    }
+    if let Some(app) = &check.app_name {
+        output.push_str(&format!("**App:** {app}\n"));
+    }
+    if let Some(url) = &check.details_url {
+        output.push_str(&format!("**Details:** {url}\n"));
+    }
+    output.push('\n');
+}
+
+/// Formats a single check as a brief one-liner.
+fn format_check_brief(output: &mut String, check: &CheckStatus) {
+    output.push_str(&format!(
+        "- [{}] {}\n",
+        check.conclusion.display_icon(),
+        check.name
+    ));
+}
+
+/// Formats a checks report in minimal/compact style.
+pub fn format_checks_minimal(report: &ChecksReport) -> String {
+    let mut output = String::new();
+
+    let summary = report.summary_counts();
+    output.push_str(&format!(
+        "Status: {} | {} passed, {} failed, {} pending, {} skipped\n",
+        report.rollup_state, summary.passed, summary.failed, summary.pending, summary.skipped
+    ));
+
+    // Sort: failures first, then pending, then success, then skipped
+    let mut sorted_checks: Vec<&CheckStatus> = report.checks.iter().collect();
+    sorted_checks.sort_by_key(|c| match c.conclusion {
+        CheckConclusion::Failure
+        | CheckConclusion::TimedOut
+        | CheckConclusion::ActionRequired
+        | CheckConclusion::Cancelled => 0,
+        CheckConclusion::Pending => 1,
+        CheckConclusion::Success => 2,
+        _ => 3,
+    });
+
+    for check in &sorted_checks {
+        output.push_str(&format!(
+            "[{}]{} {}\n",
+            check.conclusion.display_icon(),
+            if check.required { "*" } else { " " },
+            check.name
+        ));
+    }
+
+    if !sorted_checks.is_empty() {
+        output.push_str("* = required\n");
+    } else {
+        output.push_str("No checks found.\n");
+    }
+
+    output
+}
+
+/// Formats a checks report as JSON.
+pub fn format_checks_as_json(report: &ChecksReport) -> String {
+    serde_json::to_string_pretty(report).unwrap_or_else(|_| "{}".to_string())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::models::{CheckType, RollupState};
+    use chrono::{TimeZone, Utc};
+
+    fn create_test_comment(id: i64, file: &str, line: Option<i32>, author: &str) -> PRComment {
+        PRComment::new(
+            id,
+            Some(format!("PRRC_test{id}")),
+            file.to_string(),
+            line,
+            None,
+            author.to_string(),
+            "Test comment body".to_string(),
+            Utc.with_ymd_and_hms(2024, 1, 15, 10, 30, 0).unwrap(),
+            Utc.with_ymd_and_hms(2024, 1, 15, 10, 30, 0).unwrap(),
+            "@@ -1,5 +1,5 @@\n line1\n line2".to_string(),
+            "https://github.com/owner/repo/pull/1#discussion_r1".to_string(),
+        )
+    }
+
+    #[test]
+    fn test_format_comment_for_llm_marks_edited_comments() {
+        let mut comment = create_test_comment(1, "src/main.rs", Some(42), "testuser");
+        let output = format_comment_for_llm(&comment, false, 10);
+        assert!(output.contains("**Date:** 2024-01-15 10:30 UTC\n"));
+        assert!(!output.contains("edited"));
+
+        comment.created_at = Utc.with_ymd_and_hms(2024, 1, 15, 11, 0, 0).unwrap();
+        comment.updated_at = Utc.with_ymd_and_hms(2024, 1, 15, 12, 0, 0).unwrap();
+        let output = format_comment_for_llm(&comment, false, 10);
+        assert!(
+            output.contains("**Created:** 2024-01-15 11:00 UTC (edited 2024-01-15 12:00 UTC)\n")
+        );
+        assert!(!output.contains("**Date:**"));
+    }
+
+    #[test]
+    fn test_format_comment_for_llm_includes_file_and_line() {
+        let comment = create_test_comment(1, "src/main.rs", Some(42), "testuser");
+        let output = format_comment_for_llm(&comment, true, 10);
+        assert!(output.contains("src/main.rs"));
+        assert!(output.contains("line 42"));
+    }
+
+    #[test]
+    fn test_format_for_claude_tags_severity() {
+        let mut bot = create_test_comment(1, "src/main.rs", Some(42), "coderabbitai[bot]");
+        bot.body = "\u{26A0}\u{FE0F} **Potential issue:** off by one".to_string();
+        let mut nit = create_test_comment(2, "src/main.rs", Some(50), "user1");
+        nit.body = "nit: rename".to_string();
+        // Plain comments get no tag
+        let plain = create_test_comment(3, "src/main.rs", Some(60), "user2");
+
+        let output = format_for_claude(&[bot.clone(), nit, plain], None, None, None, false, 10);
+        assert!(output.contains("#### line 42 (coderabbitai[bot]) [WARNING]\n"));
+        assert!(output.contains("#### line 50 (user1) [NIT]\n"));
+        assert!(output.contains("#### line 60 (user2)\n"));
+
+        // Other formats are unchanged
+        let output = format_comment_for_llm(&bot, false, 10);
+        assert!(output.starts_with("### src/main.rs (line 42)\n"));
+    }
+
+    #[test]
+    fn test_format_comment_for_llm_includes_author() {
+        let comment = create_test_comment(1, "src/main.rs", Some(42), "testuser");
+        let output = format_comment_for_llm(&comment, true, 10);
+        assert!(output.contains("testuser"));
+    }
+
+    #[test]
+    fn test_format_comment_for_llm_includes_date() {
+        let comment = create_test_comment(1, "src/main.rs", Some(42), "testuser");
+        let output = format_comment_for_llm(&comment, true, 10);
+        assert!(output.contains("2024-01-15"));
+    }
+
+    #[test]
+    fn test_format_comment_for_llm_includes_snippet() {
+        let comment = create_test_comment(1, "src/main.rs", Some(42), "testuser");
+        let output = format_comment_for_llm(&comment, true, 10);
+        assert!(output.contains("line1"));
+        assert!(output.contains("Code context"));
+    }
+
+    #[test]
+    fn test_format_comment_for_llm_excludes_snippet() {
+        let comment = create_test_comment(1, "src/main.rs", Some(42), "testuser");
+        let output = format_comment_for_llm(&comment, false, 10);
+        assert!(!output.contains("line1"));
+        assert!(!output.contains("Code context"));
+    }
+
+    #[test]
+    fn test_format_comment_for_llm_includes_body() {
+        let comment = create_test_comment(1, "src/main.rs", Some(42), "testuser");
+        let output = format_comment_for_llm(&comment, true, 10);
+        assert!(output.contains("Test comment body"));
+    }
+
+    #[test]
+    fn test_format_comments_grouped_groups_by_file() {
+        let comments = vec![
+            create_test_comment(1, "file1.rs", Some(10), "user1"),
+            create_test_comment(2, "file2.rs", Some(20), "user2"),
+            create_test_comment(3, "file1.rs", Some(30), "user3"),
+        ];
+        let output = format_comments_grouped(&comments, true, 10);
+        assert!(output.contains("## file1.rs"));
+        assert!(output.contains("## file2.rs"));
+    }
+
+    #[test]
+    fn test_format_comments_grouped_shows_total_count() {
+        let comments = vec![
+            create_test_comment(1, "file1.rs", Some(10), "user1"),
+            create_test_comment(2, "file2.rs", Some(20), "user2"),
+        ];
+        let output = format_comments_grouped(&comments, true, 10);
+        assert!(output.contains("Total comments:** 2"));
+    }
+
+    #[test]
+    fn test_format_comments_grouped_shows_file_count() {
+        let comments = vec![
+            create_test_comment(1, "file1.rs", Some(10), "user1"),
+            create_test_comment(2, "file2.rs", Some(20), "user2"),
+        ];
+        let output = format_comments_grouped(&comments, true, 10);
+        assert!(output.contains("2 file(s)"));
+    }
+
+    #[test]
+    fn test_format_comments_grouped_empty() {
+        let output = format_comments_grouped(&[], true, 10);
+        assert!(output.contains("No comments found"));
+    }
+
+    #[test]
+    fn test_format_comments_flat_shows_total_count() {
+        let comments = vec![
+            create_test_comment(1, "file1.rs", Some(10), "user1"),
+            create_test_comment(2, "file2.rs", Some(20), "user2"),
+        ];
+        let output = format_comments_flat(&comments, true, 10);
+        assert!(output.contains("Total comments:** 2"));
+    }
+
+    #[test]
+    fn test_format_comments_flat_ties_ordered_by_id() {
+        // Equal timestamps: output must not depend on input order
+        let render = |ids: [i64; 3]| {
+            let comments: Vec<PRComment> = ids
+                .iter()
+                .map(|&id| create_test_comment(id, "a.rs", Some(1), &format!("user{id}")))
+                .collect();
+            format_comments_flat(&comments, false, 10)
+        };
+        let output = render([3, 1, 2]);
+        assert_eq!(output, render([2, 3, 1]));
+        let first = output.find("user1").unwrap();
+        let second = output.find("user2").unwrap();
+        let third = output.find("user3").unwrap();
+        assert!(first < second && second < third);
+    }
+
+    #[test]
+    fn test_sort_file_comments_ties_ordered_by_id() {
+        let a = create_test_comment(2, "a.rs", Some(5), "user1");
+        let b = create_test_comment(1, "a.rs", Some(5), "user2");
+        let sorted = sort_file_comments(&[&a, &b], &FormatOptions::default());
+        let ids: Vec<i64> = sorted.iter().map(|c| c.id).collect();
+        assert_eq!(ids, vec![1, 2]);
+    }
+
+    #[test]
+    fn test_format_comments_flat_empty() {
+        let output = format_comments_flat(&[], true, 10);
+        assert!(output.contains("No comments found"));
+    }
+
+    #[test]
+    fn test_format_comments_minimal_shows_emoji() {
+        let comments = vec![create_test_comment(1, "file1.rs", Some(10), "user1")];
+        let output = format_comments_minimal(&comments);
+        assert!(output.contains("\u{1F4C4}")); // File emoji
+    }
+
+    #[test]
+    fn test_format_comments_minimal_truncates_long_body() {
+        let mut comment = create_test_comment(1, "file1.rs", Some(10), "user1");
+        comment.body = "a".repeat(150);
+        let comments = vec![comment];
+        let output = format_comments_minimal(&comments);
+        assert!(output.contains("..."));
+    }
+
+    #[test]
+    fn test_format_comments_minimal_truncates_multibyte_body() {
+        let mut comment = create_test_comment(1, "file1.rs", Some(10), "user1");
+        comment.body = format!("a{}", "\u{1F600}\u{6F22}".repeat(80));
+        let output = format_comments_minimal(&[comment]);
+        let expected = format!("a{}\u{1F600}...", "\u{1F600}\u{6F22}".repeat(49));
+        assert!(output.contains(&expected));
+    }
+
+    #[test]
+    fn test_format_comments_minimal_shows_summary() {
+        let comments = vec![
+            create_test_comment(1, "file1.rs", Some(10), "user1"),
+            create_test_comment(2, "file2.rs", Some(20), "user2"),
+        ];
+        let output = format_comments_minimal(&comments);
+        assert!(output.contains("2 comment(s)"));
+        assert!(output.contains("2 file(s)"));
+    }
+
+    #[test]
+    fn test_format_comments_minimal_empty() {
+        let output = format_comments_minimal(&[]);
+        assert!(output.contains("No comments found"));
+    }
+
+    #[test]
+    fn test_format_for_claude_includes_header() {
+        let comments = vec![create_test_comment(1, "file1.rs", Some(10), "user1")];
+        let output = format_for_claude(&comments, None, None, None, true, 15);
+        assert!(output.contains("Pull Request Review Comments"));
+    }
+
+    #[test]
+    fn test_format_for_claude_includes_pr_title() {
+        let comments = vec![create_test_comment(1, "file1.rs", Some(10), "user1")];
+        let output = format_for_claude(&comments, None, Some("Test PR Title"), None, true, 15);
+        assert!(output.contains("Test PR Title"));
+    }
+
+    #[test]
+    fn test_format_for_claude_includes_pr_url() {
+        let comments = vec![create_test_comment(1, "file1.rs", Some(10), "user1")];
+        let output = format_for_claude(
+            &comments,
+            Some("https://github.com/owner/repo/pull/123"),
+            None,
+            None,
+            true,
+            15,
+        );
+        assert!(output.contains("https://github.com/owner/repo/pull/123"));
+    }
+
+    #[test]
+    fn test_format_for_claude_includes_pr_node_id() {
+        let comments = vec![create_test_comment(1, "file1.rs", Some(10), "user1")];
+        let output = format_for_claude(&comments, None, None, Some("PR_kwDOE2CVus7test"), true, 15);
+        assert!(output.contains("PR_kwDOE2CVus7test"));
+        assert!(output.contains("PR Node ID"));
+    }
+
+    #[test]
+    fn test_format_for_claude_includes_instructions() {
+        let comments = vec![create_test_comment(1, "file1.rs", Some(10), "user1")];
+        let output = format_for_claude(&comments, None, None, None, true, 15);
+        assert!(output.contains("Instructions"));
+        assert!(output.contains("address"));
+    }
+
+    #[test]
+    fn test_format_for_claude_empty() {
+        let output = format_for_claude(&[], None, None, None, true, 15);
+        assert!(output.contains("No comments found"));
+    }
+
+    #[test]
+    fn test_format_as_json() {
+        let comments = vec![create_test_comment(1, "file1.rs", Some(10), "user1")];
+        let output = format_as_json(&comments, true, 10);
+        let parsed: serde_json::Value = serde_json::from_str(&output).unwrap();
+        assert!(parsed.is_array());
+        assert_eq!(parsed.as_array().unwrap().len(), 1);
+        assert_eq!(parsed[0]["file"], "file1.rs");
+        assert_eq!(parsed[0]["line"], 10);
+        assert_eq!(parsed[0]["author"], "user1");
+    }
+
+    #[test]
+    fn test_format_as_ndjson() {
+        let mut comments = vec![
+            create_test_comment(1, "src/main.rs", Some(10), "user1"),
+            create_test_comment(2, "src/lib.rs", Some(20), "user2"),
+        ];
+        comments[1].body = "Multi\nline \"quoted\" body".to_string();
+        let output = format_as_ndjson(&comments, false, 15);
+
+        assert!(output.ends_with('\n'));
+        let lines: Vec<&str> = output.lines().collect();
+        assert_eq!(lines.len(), 2);
+        let parsed: Vec<Value> = lines
+            .iter()
+            .map(|line| serde_json::from_str(line).unwrap())
+            .collect();
+        assert_eq!(parsed[0]["file"], "src/main.rs");
+        assert_eq!(parsed[1]["author"], "user2");
+        assert_eq!(parsed[1]["body"], "Multi\nline \"quoted\" body");
+        assert!(parsed[1]["snippet"].is_null());
+    }
+
+    #[test]
+    fn test_format_as_ndjson_empty() {
+        assert_eq!(format_as_ndjson(&[], true, 15), "");
+    }
+
+    #[test]
+    fn test_format_as_json_outdated_complex_comment() {
+        // A comment on code that has since changed: GitHub nulls `line` and
+        // `start_line` and keeps the original positions
+        let data = serde_json::json!({
+            "id": 2001,
+            "node_id": "PRRC_kwDOcomplex",
+            "path": "src/parser.rs",
+            "line": null,
+            "original_line": 88,
+            "start_line": null,
+            "original_start_line": 84,
+            "in_reply_to_id": 2000,
+            "user": {"login": "reviewer[bot]", "avatar_url": "https://avatars.example/u/1"},
+            "body": "<!-- marker -->Range looks off.",
+            "created_at": "2024-01-15T10:30:00Z",
+            "updated_at": "2024-01-16T09:00:00Z",
+            "diff_hunk": "@@ -84,5 +84,5 @@\n a\n b",
+            "html_url": "https://github.com/o/r/pull/1#discussion_r2001",
+            "reactions": {"+1": 2}
+        });
+        let comment = crate::parser::parse_comment(&data).unwrap();
+        let output = format_as_json(&[comment], false, 10);
+        let parsed: serde_json::Value = serde_json::from_str(&output).unwrap();
+        assert_eq!(parsed[0]["outdated"], true);
+        assert_eq!(parsed[0]["line"], 88);
+
+        let current = vec![create_test_comment(1, "file1.rs", Some(10), "user1")];
+        let parsed: serde_json::Value =
+            serde_json::from_str(&format_as_json(&current, false, 10)).unwrap();
+        assert_eq!(parsed[0]["outdated"], false);
+    }
+
+    #[test]
+    fn test_json_output_schema_declares_required_fields() {
+        let schema = json_output_schema();
+        assert_eq!(schema["type"], "array");
+        let required = schema["items"]["required"].as_array().unwrap();
+        for field in ["file", "author", "body"] {
+            assert!(required.contains(&json!(field)), "{field} not required");
+        }
+        assert!(!required.contains(&json!("raw_diff_hunk")));
+    }
+
+    #[test]
+    fn test_json_schema_matches_serialized_fields() {
+        let keys = |value: Value| -> Vec<String> {
+            let mut keys: Vec<String> = value.as_object().unwrap().keys().cloned().collect();
+            keys.sort();
+            keys
+        };
+        let schema = JsonComment::schema();
+        let comment = create_test_comment(1, "a.rs", Some(1), "user1");
+
+        // Every property appears when all options are on...
+        let options = FormatOptions {
+            include_raw_diff: true,
+            ..FormatOptions::default()
+        };
+        let full = serde_json::to_value(JsonComment::from_comment(&comment, &options)).unwrap();
+        assert_eq!(keys(full), keys(schema["properties"].clone()));
+
+        // ...and exactly the required ones by default
+        let minimal = serde_json::to_value(JsonComment::from_comment(
+            &comment,
+            &FormatOptions::default(),
+        ))
+        .unwrap();
+        let mut required: Vec<String> = schema["required"]
+            .as_array()
+            .unwrap()
+            .iter()
+            .map(|v| v.as_str().unwrap().to_string())
+            .collect();
+        required.sort();
+        assert_eq!(keys(minimal), required);
+    }
+
+    #[test]
+    fn test_format_as_json_omits_raw_diff_hunk_by_default() {
+        let comments = vec![create_test_comment(1, "file1.rs", Some(10), "user1")];
+        let output = format_as_json(&comments, true, 10);
+        let parsed: serde_json::Value = serde_json::from_str(&output).unwrap();
+        assert!(parsed[0].get("raw_diff_hunk").is_none());
+    }
+
+    #[test]
+    fn test_format_as_json_includes_raw_diff_hunk() {
+        let comments = vec![create_test_comment(1, "file1.rs", Some(10), "user1")];
+        let options = FormatOptions {
+            include_raw_diff: true,
+            ..FormatOptions::default()
+        };
+        let output = format_as_json_with_options(&comments, &options);
+        let parsed: serde_json::Value = serde_json::from_str(&output).unwrap();
+        assert_eq!(
+            parsed[0]["raw_diff_hunk"],
+            "@@ -1,5 +1,5 @@\n line1\n line2"
+        );
+        // The processed snippet still drops the header
+        assert_eq!(parsed[0]["snippet"], " line1\n line2");
+    }
+
+    #[test]
+    fn test_format_as_json_no_snippet() {
+        let comments = vec![create_test_comment(1, "file1.rs", Some(10), "user1")];
+        let output = format_as_json(&comments, false, 10);
+        let parsed: serde_json::Value = serde_json::from_str(&output).unwrap();
+        assert!(parsed[0]["snippet"].is_null());
+    }
+
+    #[test]
+    fn test_format_as_json_empty_snippet() {
+        // Covers formatter.rs line 278: empty snippet returns None
+        let comment = PRComment::new(
+            1,
+            Some("PRRC_test1".to_string()),
+            "file1.rs".to_string(),
+            Some(10),
+            None,
+            "user1".to_string(),
+            "Test body".to_string(),
+            Utc.with_ymd_and_hms(2024, 1, 15, 10, 30, 0).unwrap(),
+            Utc.with_ymd_and_hms(2024, 1, 15, 10, 30, 0).unwrap(),
+            "".to_string(), // Empty diff hunk
+            "https://github.com/owner/repo/pull/1#discussion_r1".to_string(),
+        );
+        let comments = vec![comment];
+        let output = format_as_json(&comments, true, 10); // include_snippet=true but diff is empty
+        let parsed: serde_json::Value = serde_json::from_str(&output).unwrap();
+        assert!(parsed[0]["snippet"].is_null());
+    }
+
+    #[test]
+    fn test_format_for_claude_sorts_by_line_then_date() {
+        // Covers formatter.rs lines 235-237: sorting by line_number then created_at
+        let comments = vec![
+            PRComment::new(
+                1,
+                Some("PRRC_test1".to_string()),
+                "file1.rs".to_string(),
+                Some(10),
+                None,
+                "user1".to_string(),
+                "Earlier comment".to_string(),
+                Utc.with_ymd_and_hms(2024, 1, 15, 8, 0, 0).unwrap(),
+                Utc.with_ymd_and_hms(2024, 1, 15, 8, 0, 0).unwrap(),
+                "".to_string(),
+                "https://github.com/owner/repo/pull/1#discussion_r1".to_string(),
+            ),
+            PRComment::new(
+                2,
+                Some("PRRC_test2".to_string()),
+                "file1.rs".to_string(),
+                Some(10), // Same line number
+                None,
+                "user2".to_string(),
+                "Later comment".to_string(),
+                Utc.with_ymd_and_hms(2024, 1, 15, 10, 0, 0).unwrap(), // Later time
+                Utc.with_ymd_and_hms(2024, 1, 15, 10, 0, 0).unwrap(),
+                "".to_string(),
+                "https://github.com/owner/repo/pull/1#discussion_r2".to_string(),
+            ),
+        ];
+        let output = format_for_claude(&comments, None, None, None, false, 10);
+        // Earlier comment should appear first in the output
+        let earlier_pos = output.find("Earlier comment").unwrap();
+        let later_pos = output.find("Later comment").unwrap();
+        assert!(
+            earlier_pos < later_pos,
+            "Comments should be sorted by date when line numbers are equal"
+        );
+    }
+
+    #[test]
+    fn test_format_comments_grouped_sorts_by_line_then_date() {
+        // Also tests the sorting logic in format_comments_grouped
+        let comments = vec![
+            PRComment::new(
+                1,
+                Some("PRRC_test1".to_string()),
+                "file1.rs".to_string(),
+                Some(10),
+                None,
+                "user1".to_string(),
+                "Earlier comment".to_string(),
+                Utc.with_ymd_and_hms(2024, 1, 15, 8, 0, 0).unwrap(),
+                Utc.with_ymd_and_hms(2024, 1, 15, 8, 0, 0).unwrap(),
+                "".to_string(),
+                "https://github.com/owner/repo/pull/1#discussion_r1".to_string(),
+            ),
+            PRComment::new(
+                2,
+                Some("PRRC_test2".to_string()),
+                "file1.rs".to_string(),
+                Some(10), // Same line number
+                None,
+                "user2".to_string(),
+                "Later comment".to_string(),
+                Utc.with_ymd_and_hms(2024, 1, 15, 10, 0, 0).unwrap(), // Later time
+                Utc.with_ymd_and_hms(2024, 1, 15, 10, 0, 0).unwrap(),
+                "".to_string(),
+                "https://github.com/owner/repo/pull/1#discussion_r2".to_string(),
+            ),
+        ];
+        let output = format_comments_grouped(&comments, false, 10);
+        // Earlier comment should appear first in the output
+        let earlier_pos = output.find("Earlier comment").unwrap();
+        let later_pos = output.find("Later comment").unwrap();
+        assert!(
+            earlier_pos < later_pos,
+            "Comments should be sorted by date when line numbers are equal"
+        );
+    }
+
+    #[test]
+    fn test_format_as_json_includes_node_id() {
+        let comments = vec![create_test_comment(1, "file1.rs", Some(10), "user1")];
+        let output = format_as_json(&comments, true, 10);
+        let parsed: serde_json::Value = serde_json::from_str(&output).unwrap();
+        assert_eq!(parsed[0]["node_id"], "PRRC_test1");
+    }
+
+    #[test]
+    fn test_format_as_json_node_id_none() {
+        let comment = PRComment::new(
+            1,
+            None, // No node_id
+            "file1.rs".to_string(),
+            Some(10),
+            None,
+            "user1".to_string(),
+            "Test body".to_string(),
+            Utc.with_ymd_and_hms(2024, 1, 15, 10, 30, 0).unwrap(),
+            Utc.with_ymd_and_hms(2024, 1, 15, 10, 30, 0).unwrap(),
+            "".to_string(),
+            "https://github.com/owner/repo/pull/1#discussion_r1".to_string(),
+        );
+        let comments = vec![comment];
+        let output = format_as_json(&comments, true, 10);
+        let parsed: serde_json::Value = serde_json::from_str(&output).unwrap();
+        assert!(parsed[0]["node_id"].is_null());
+    }
+
+    // ---- Check formatter tests ----
+
+    fn create_test_check_status(
+        name: &str,
+        conclusion: CheckConclusion,
+        required: bool,
+    ) -> CheckStatus {
+        CheckStatus {
+            name: name.to_string(),
+            conclusion,
+            required,
+            description: None,
+            details_url: None,
+            started_at: None,
+            completed_at: None,
+            check_type: CheckType::CheckRun,
+            workflow_name: None,
+            app_name: None,
+        }
+    }
+
+    fn create_test_checks_report() -> ChecksReport {
+        ChecksReport {
+            pr_title: Some("Test PR".to_string()),
+            pr_url: Some("https://github.com/owner/repo/pull/1".to_string()),
+            rollup_state: RollupState::Failure,
+            checks: vec![
+                create_test_check_status("build", CheckConclusion::Success, true),
+                create_test_check_status("lint", CheckConclusion::Failure, true),
+                create_test_check_status("coverage", CheckConclusion::Success, false),
+                create_test_check_status("optional-lint", CheckConclusion::Failure, false),
+                create_test_check_status("deploy", CheckConclusion::Pending, false),
+                create_test_check_status("docs", CheckConclusion::Skipped, false),
+            ],
+        }
+    }
+
+    #[test]
+    fn test_format_checks_for_claude_header() {
+        let report = create_test_checks_report();
+        let output = format_checks_for_claude(&report);
+        assert!(output.contains("# Pull Request Check Status"));
+        assert!(output.contains("**PR Title:** Test PR"));
+        assert!(output.contains("**PR URL:** https://github.com/owner/repo/pull/1"));
+        assert!(output.contains("**Overall Status:** FAILURE"));
+    }
+
+    #[test]
+    fn test_format_checks_for_claude_summary() {
+        let report = create_test_checks_report();
+        let output = format_checks_for_claude(&report);
+        assert!(output.contains("2 passed"));
+        assert!(output.contains("2 failed"));
+        assert!(output.contains("1 pending"));
+        assert!(output.contains("1 skipped"));
+        assert!(output.contains("6 total"));
+    }
+
+    #[test]
+    fn test_format_checks_for_claude_sections() {
+        let report = create_test_checks_report();
+        let output = format_checks_for_claude(&report);
+        assert!(output.contains("## Failed Required Checks"));
+        assert!(output.contains("## Failed Optional Checks"));
+        assert!(output.contains("## Pending Checks"));
+        assert!(output.contains("## Passed Required Checks"));
+        assert!(output.contains("## Passed Optional Checks"));
+        assert!(output.contains("## Skipped Checks"));
+    }
+
+    #[test]
+    fn test_format_checks_for_claude_failed_required_detail() {
+        let report = create_test_checks_report();
+        let output = format_checks_for_claude(&report);
+        assert!(output.contains("[FAIL] lint (required)"));
+    }
+
+    #[test]
+    fn test_format_checks_for_claude_with_details() {
+        let mut report = create_test_checks_report();
+        report.checks[1].description = Some("Build failed".to_string());
+        report.checks[1].workflow_name = Some("CI".to_string());
+        report.checks[1].app_name = Some("github-actions".to_string());
+        report.checks[1].details_url = Some("https://ci.example.com".to_string());
+        let output = format_checks_for_claude(&report);
+        assert!(output.contains("**Description:** Build failed"));
+        assert!(output.contains("**Workflow:** CI"));
+        assert!(output.contains("**App:** github-actions"));
+        assert!(output.contains("**Details:** https://ci.example.com"));
+    }
+
+    #[test]
+    fn test_format_checks_for_claude_empty() {
+        let report = ChecksReport {
+            pr_title: None,
+            pr_url: None,
+            rollup_state: RollupState::Success,
+            checks: vec![],
+        };
+        let output = format_checks_for_claude(&report);
+        assert!(output.contains("No checks found"));
+    }
+
+    #[test]
+    fn test_format_checks_for_claude_all_passing() {
+        let report = ChecksReport {
+            pr_title: Some("All Good".to_string()),
+            pr_url: None,
+            rollup_state: RollupState::Success,
+            checks: vec![
+                create_test_check_status("build", CheckConclusion::Success, true),
+                create_test_check_status("test", CheckConclusion::Success, true),
+            ],
+        };
+        let output = format_checks_for_claude(&report);
+        assert!(output.contains("**Overall Status:** SUCCESS"));
+        assert!(output.contains("## Passed Required Checks"));
+        assert!(!output.contains("## Failed"));
+    }
+
+    #[test]
+    fn test_format_checks_for_claude_no_title_or_url() {
+        let report = ChecksReport {
+            pr_title: None,
+            pr_url: None,
+            rollup_state: RollupState::Success,
+            checks: vec![create_test_check_status(
+                "build",
+                CheckConclusion::Success,
+                true,
+            )],
+        };
+        let output = format_checks_for_claude(&report);
+        assert!(!output.contains("**PR Title:**"));
+        assert!(!output.contains("**PR URL:**"));
+    }
+
+    #[test]
+    fn test_format_checks_minimal_header() {
+        let report = create_test_checks_report();
+        let output = format_checks_minimal(&report);
+        assert!(output.contains("Status: FAILURE"));
+        assert!(output.contains("2 passed"));
+        assert!(output.contains("2 failed"));
+    }
+
+    #[test]
+    fn test_format_checks_minimal_required_marker() {
+        let report = create_test_checks_report();
+        let output = format_checks_minimal(&report);
+        // Required checks should have * marker
+        assert!(output.contains("[PASS]* build"));
+        assert!(output.contains("[FAIL]* lint"));
+        // Optional checks should have space
+        assert!(output.contains("[PASS]  coverage"));
+        assert!(output.contains("* = required"));
+    }
+
+    #[test]
+    fn test_format_checks_minimal_sorted_by_priority() {
+        let report = create_test_checks_report();
+        let output = format_checks_minimal(&report);
+        // Failures should appear before successes
+        let fail_pos = output.find("[FAIL]").unwrap();
+        let last_pass_pos = output.rfind("[PASS]").unwrap();
+        assert!(
+            fail_pos < last_pass_pos,
+            "Failures should appear before passes"
+        );
+    }
+
+    #[test]
+    fn test_format_checks_minimal_empty() {
+        let report = ChecksReport {
+            pr_title: None,
+            pr_url: None,
+            rollup_state: RollupState::Success,
+            checks: vec![],
+        };
+        let output = format_checks_minimal(&report);
+        assert!(output.contains("No checks found"));
+    }
+
+    #[test]
+    fn test_format_checks_as_json_valid() {
+        let report = create_test_checks_report();
+        let output = format_checks_as_json(&report);
+        let parsed: serde_json::Value = serde_json::from_str(&output).unwrap();
+        assert_eq!(parsed["pr_title"], "Test PR");
+        assert_eq!(parsed["rollup_state"], "FAILURE");
+        assert!(parsed["checks"].is_array());
+        assert_eq!(parsed["checks"].as_array().unwrap().len(), 6);
+    }
+
+    #[test]
+    fn test_format_checks_as_json_empty() {
+        let report = ChecksReport {
+            pr_title: None,
+            pr_url: None,
+            rollup_state: RollupState::Success,
+            checks: vec![],
+        };
+        let output = format_checks_as_json(&report);
+        let parsed: serde_json::Value = serde_json::from_str(&output).unwrap();
+        assert!(parsed["checks"].as_array().unwrap().is_empty());
+    }
+
+    fn create_long_line_comment(line_len: usize) -> PRComment {
+        let mut comment = create_test_comment(1, "bundle.min.js", Some(1), "user1");
+        comment.diff_hunk = format!("@@ -1,1 +1,1 @@\n+{}", "x".repeat(line_len));
+        comment
+    }
+
+    #[test]
+    fn test_truncate_long_lines() {
+        let text = format!("short\n{}", "a".repeat(5000));
+        let result = truncate_long_lines(&text, 100);
+        let lines: Vec<&str> = result.lines().collect();
+        assert_eq!(lines[0], "short");
+        assert_eq!(
+            lines[1],
+            format!("{}{TRUNCATED_LINE_MARKER}", "a".repeat(100))
+        );
+    }
+
+    #[test]
+    fn test_truncate_long_lines_multibyte_boundary() {
+        let text = "\u{e9}".repeat(10);
+        let result = truncate_long_lines(&text, 4);
+        assert!(result.starts_with(&"\u{e9}".repeat(4)));
+        assert!(result.ends_with(TRUNCATED_LINE_MARKER));
+    }
+
+    #[test]
+    fn test_wrap_long_lines() {
+        let text = format!("short\n{}", "b".repeat(25));
+        let result = wrap_long_lines(&text, 10);
+        let lines: Vec<&str> = result.lines().collect();
+        assert_eq!(lines, vec!["short", "bbbbbbbbbb", "bbbbbbbbbb", "bbbbb"]);
+    }
+
+    #[test]
+    fn test_wrap_long_lines_zero_width() {
+        assert_eq!(wrap_long_lines("unchanged", 0), "unchanged");
+    }
+
+    #[test]
+    fn test_snippet_for_truncates_5000_char_line() {
+        let comment = create_long_line_comment(5000);
+        let snippet = snippet_for(&comment, &FormatOptions::default());
+        assert!(snippet.ends_with(TRUNCATED_LINE_MARKER));
+        assert!(snippet.chars().count() < 5000);
+        assert_eq!(
+            snippet.chars().count(),
+            MAX_SNIPPET_LINE_CHARS + TRUNCATED_LINE_MARKER.chars().count()
+        );
+    }
+
+    #[test]
+    fn test_snippet_for_wraps_5000_char_line() {
+        let comment = create_long_line_comment(5000);
+        let options = FormatOptions {
+            wrap_snippet: Some(100),
+            ..FormatOptions::default()
+        };
+        let snippet = snippet_for(&comment, &options);
+        assert!(snippet.lines().all(|l| l.chars().count() <= 100));
+        // Truncation happens before wrapping, so the marker survives on the last line
+        assert!(snippet.lines().last().unwrap().ends_with("truncated)"));
+        assert_eq!(
+            snippet.replace('\n', "").chars().count(),
+            MAX_SNIPPET_LINE_CHARS + TRUNCATED_LINE_MARKER.chars().count()
+        );
+    }
+
+    #[test]
+    fn test_language_for_path() {
+        let cases = [
+            ("app/models.py", "python"),
+            ("cmd/main.go", "go"),
+            ("src/lib.rs", "rust"),
+            ("web/index.ts", "typescript"),
+            ("web/App.tsx", "tsx"),
+            ("web/util.js", "javascript"),
+            ("web/Button.jsx", "jsx"),
+            ("lib/task.rb", "ruby"),
+            ("Main.JAVA", "java"),
+            ("App.kt", "kotlin"),
+            ("View.swift", "swift"),
+            ("src/x.h", "c"),
+            ("include/x.hpp", "cpp"),
+            ("Program.cs", "csharp"),
+            ("index.php", "php"),
+            ("Job.scala", "scala"),
+            ("scripts/run.sh", "bash"),
+            ("migrations/001.sql", "sql"),
+            ("page.html", "html"),
+            ("site.css", "css"),
+            ("site.scss", "scss"),
+            ("package.json", "json"),
+            ("deploy.yml", "yaml"),
+            ("Cargo.toml", "toml"),
+            ("README.md", "markdown"),
+            ("Makefile", ""),
+            ("data.xyz", ""),
+            ("", ""),
+        ];
+        for (path, expected) in cases {
+            assert_eq!(language_for_path(path), expected, "path: {path}");
+        }
+    }
+
+    #[test]
+    fn test_snippet_fence_language() {
+        let comments = vec![
+            create_test_comment(1, "app/models.py", Some(10), "user1"),
+            create_test_comment(2, "Makefile", Some(3), "user1"),
+        ];
+        for name in ["claude", "grouped"] {
+            let output = format_comments(name, &comments, &FormatOptions::default()).unwrap();
+            assert!(output.contains("```python\n line1"), "{name}");
+            assert!(output.contains("**Code context:**\n```\n line1"), "{name}");
+        }
+    }
+
+    #[test]
+    fn test_snippet_after() {
+        let comments = vec![create_test_comment(1, "src/lib.rs", Some(10), "user1")];
+        let after = FormatOptions {
+            snippet_after: true,
+            ..Default::default()
+        };
+        for (name, label) in [
+            ("grouped", "**Comment:**"),
+            ("claude", "**Review comment:**"),
+        ] {
+            let default = format_comments(name, &comments, &FormatOptions::default()).unwrap();
+            assert!(default.find("```rust").unwrap() < default.find(label).unwrap());
+
+            let output = format_comments(name, &comments, &after).unwrap();
+            assert!(output.find(label).unwrap() < output.find("```rust").unwrap());
+            assert_eq!(output.len(), default.len(), "{name}");
+        }
+
+        // Without a snippet the order makes no difference
+        let no_snippet = FormatOptions {
+            include_snippet: false,
+            ..Default::default()
+        };
+        let after_no_snippet = FormatOptions {
+            snippet_after: true,
+            ..no_snippet.clone()
+        };
+        assert_eq!(
+            format_comment_with_options(&comments[0], &after_no_snippet),
+            format_comment_with_options(&comments[0], &no_snippet)
+        );
+    }
+
+    #[test]
+    fn test_annotate_diff() {
+        let mut comment = create_test_comment(1, "a.rs", Some(10), "user1");
+        comment.diff_hunk = "@@ -1,2 +1,2 @@\nkeep()\n-old()\n+new()".to_string();
+        let options = FormatOptions {
+            annotate_diff: true,
+            ..Default::default()
+        };
+        assert_eq!(snippet_for(&comment, &options), " keep()\n-old()\n+new()");
+        assert_eq!(
+            snippet_for(&comment, &FormatOptions::default()),
+            "keep()\n-old()\n+new()"
+        );
+
+        let comments = vec![comment];
+        for name in ["claude", "grouped"] {
+            let output = format_comments(name, &comments, &options).unwrap();
+            assert!(
+                output.contains("```diff\n keep()\n-old()\n+new()\n```"),
+                "{name}"
+            );
+            assert!(!output.contains("@@"), "{name}");
+        }
+        let output = format_comments("claude", &comments, &FormatOptions::default()).unwrap();
+        assert!(!output.contains("```diff"));
+    }
+
+    #[test]
+    fn test_snippet_for_empty_hunk() {
+        let mut comment = create_test_comment(1, "file1.rs", Some(1), "user1");
+        comment.diff_hunk = String::new();
+        assert_eq!(snippet_for(&comment, &FormatOptions::default()), "");
+    }
+
+    #[test]
+    fn test_formatters_apply_snippet_wrapping() {
+        let comments = vec![create_long_line_comment(300)];
+        let options = FormatOptions {
+            wrap_snippet: Some(80),
+            ..FormatOptions::default()
+        };
+        for name in ["claude", "grouped", "flat"] {
+            let output = format_comments(name, &comments, &options).unwrap();
+            assert!(output.contains(&"x".repeat(80)), "{name} lost the snippet");
+            assert!(
+                !output.contains(&"x".repeat(81)),
+                "{name} output has an unwrapped line"
+            );
+        }
+        let json = format_comments("json", &comments, &options).unwrap();
+        let parsed: serde_json::Value = serde_json::from_str(&json).unwrap();
+        assert_eq!(parsed[0]["snippet"].as_str().unwrap().lines().count(), 4);
+    }
+
+    #[test]
+    fn test_format_options_with_snippet() {
+        let options = FormatOptions::with_snippet(false, 3);
+        assert!(!options.include_snippet);
+        assert_eq!(options.snippet_lines, 3);
+        assert!(options.wrap_snippet.is_none());
+    }
+
+    #[test]
+    fn test_format_options_file_order_controls_groups() {
+        let comments = vec![
+            create_test_comment(1, "a.rs", Some(10), "user1"),
+            create_test_comment(2, "b.rs", Some(20), "user2"),
+            create_test_comment(3, "c.rs", Some(30), "user3"),
+        ];
+        let options = FormatOptions {
+            file_order: Some(vec!["c.rs".to_string(), "a.rs".to_string()]),
+            ..FormatOptions::default()
+        };
+
+        for (name, prefix) in [("grouped", "## "), ("claude", "### ")] {
+            let output = format_comments(name, &comments, &options).unwrap();
+            let c_pos = output.find(&format!("{prefix}c.rs")).unwrap();
+            let a_pos = output.find(&format!("{prefix}a.rs")).unwrap();
+            let b_pos = output.find(&format!("{prefix}b.rs")).unwrap();
+            assert!(c_pos < a_pos && a_pos < b_pos, "{name} ignored diff order");
+        }
+    }
+
+    #[test]
+    fn test_format_as_html_links_author_profiles() {
+        let comments = vec![create_test_comment(1, "src/main.rs", Some(10), "user1")];
+        let output = format_as_html_with_options(&comments, &FormatOptions::default());
+
+        assert!(output.contains("<!DOCTYPE html>"));
+        assert!(output.contains("<h2 id=\"file-src-main-rs\">src/main.rs</h2>"));
+        assert!(output.contains(r#"<a href="https://github.com/user1">user1</a>"#));
+        assert!(!output.contains("<img"));
+    }
+
+    #[test]
+    fn test_format_as_html_avatars_when_enabled() {
+        let mut comment = create_test_comment(1, "src/main.rs", Some(10), "user1");
+        comment.author_avatar_url =
+            Some("https://avatars.githubusercontent.com/u/1?v=4".to_string());
+        let comments = vec![comment];
+
+        let without = format_as_html_with_options(&comments, &FormatOptions::default());
+        assert!(!without.contains("avatars.githubusercontent.com"));
+
+        let options = FormatOptions {
+            html_avatars: true,
+            ..FormatOptions::default()
+        };
+        let with = format_as_html_with_options(&comments, &options);
+        assert!(with.contains(
+            r#"<img class="avatar" src="https://avatars.githubusercontent.com/u/1?v=4""#
+        ));
+        assert!(with.contains(r#"<a href="https://github.com/user1">user1</a>"#));
+    }
+
+    #[test]
+    fn test_format_as_html_avatars_missing_url() {
+        let comments = vec![create_test_comment(1, "src/main.rs", Some(10), "user1")];
+        let options = FormatOptions {
+            html_avatars: true,
+            ..FormatOptions::default()
+        };
+        let output = format_as_html_with_options(&comments, &options);
+        assert!(!output.contains("<img"));
+    }
+
+    #[test]
+    fn test_format_as_html_escapes_content() {
+        let mut comment = create_test_comment(1, "src/main.rs", Some(10), "user1");
+        comment.body = "Use <T> & avoid \"unwrap\"".to_string();
+        let options = FormatOptions {
+            pr_title: Some("Fix <script>".to_string()),
+            pr_url: Some("https://github.com/o/r/pull/1".to_string()),
+            ..FormatOptions::default()
+        };
+        let output = format_as_html_with_options(&[comment], &options);
+
+        assert!(output.contains("<h1>Fix &lt;script&gt;</h1>"));
+        assert!(output.contains("Use &lt;T&gt; &amp; avoid &quot;unwrap&quot;"));
+        assert!(output.contains(r#"<a href="https://github.com/o/r/pull/1">"#));
+        assert!(output.contains("<pre><code>"));
+    }
+
+    #[test]
+    fn test_format_as_html_escapes_script_body() {
+        let mut comment = create_test_comment(1, "src/<b>.rs", Some(10), "user1");
+        comment.body = "<script>alert('x')</script>".to_string();
+        comment.diff_hunk = "@@ -1 +1 @@\n+let s = \"</code><script>\";".to_string();
+        let output = format_as_html(&[comment], Some("Review"), None, true, 15);
+
+        assert!(!output.contains("<script>"));
+        assert!(output.contains("&lt;script&gt;alert(&#39;x&#39;)&lt;/script&gt;"));
+        assert!(output.contains("&lt;/code&gt;&lt;script&gt;"));
+        assert!(output.contains(">src/&lt;b&gt;.rs</h2>"));
+    }
+
+    #[test]
+    fn test_format_as_html_anchors() {
+        let comments = vec![
+            create_test_comment(7, "src/a.rs", Some(10), "user1"),
+            create_test_comment(8, "src/a.rs", Some(20), "user1"),
+            create_test_comment(9, "src-a.rs", Some(5), "user2"),
+        ];
+        let output = format_as_html(&comments, None, None, false, 15);
+
+        assert!(output.contains("<li><a href=\"#file-src-a-rs\">src-a.rs</a> (1)</li>"));
+        assert!(output.contains("<li><a href=\"#file-src-a-rs-2\">src/a.rs</a> (2)</li>"));
+        assert!(output.contains("<h2 id=\"file-src-a-rs-2\">src/a.rs</h2>"));
+        assert!(output.contains("<div class=\"comment\" id=\"comment-8\">"));
+        assert!(!output.contains("<pre><code>"));
+    }
+
+    #[test]
+    fn test_format_as_html_empty() {
+        let output = format_as_html_with_options(&[], &FormatOptions::default());
+        assert!(output.contains("<p>No comments found.</p>"));
+        assert!(output.ends_with("</html>\n"));
+    }
+
+    #[test]
+    fn test_format_options_keep_order() {
+        let comments = vec![
+            create_test_comment(1, "a.rs", Some(30), "user1"),
+            create_test_comment(2, "a.rs", Some(10), "user2"),
+        ];
+        let options = FormatOptions {
+            keep_order: true,
+            ..FormatOptions::default()
+        };
+
+        for name in ["grouped", "claude", "flat", "html"] {
+            let output = format_comments(name, &comments, &options).unwrap();
+            let first = output.find("line 30").unwrap();
+            let second = output.find("line 10").unwrap();
+            assert!(first < second, "{name} reordered comments");
+        }
+
+        let output = format_comments("grouped", &comments, &FormatOptions::default()).unwrap();
+        assert!(output.find("line 10").unwrap() < output.find("line 30").unwrap());
+    }
+
+    #[test]
+    fn test_file_extension() {
+        assert_eq!(file_extension("json"), "json");
+        assert_eq!(file_extension("html"), "html");
+        assert_eq!(file_extension("csv"), "csv");
+        assert_eq!(file_extension("ndjson"), "ndjson");
+        assert_eq!(file_extension("ndjson-events"), "ndjson");
+        assert_eq!(file_extension("text"), "txt");
+        assert_eq!(file_extension("plain"), "txt");
+        assert_eq!(file_extension("slack"), "txt");
+        assert_eq!(file_extension("claude"), "md");
+        assert_eq!(file_extension("custom"), "md");
+    }
+
+    #[test]
+    fn test_write_comment_files() {
+        let dir = tempfile::tempdir().unwrap();
+        let out_dir = dir.path().join("comments");
+        let comments = vec![
+            create_test_comment(101, "a.rs", Some(10), "user1"),
+            create_test_comment(202, "b.rs", Some(20), "user2"),
+        ];
+
+        let paths =
+            write_comment_files(&out_dir, "claude", &comments, &FormatOptions::default()).unwrap();
+
+        assert_eq!(paths, vec![out_dir.join("101.md"), out_dir.join("202.md")]);
+        let first = fs::read_to_string(&paths[0]).unwrap();
+        assert!(first.contains("### a.rs"));
+        assert!(!first.contains("b.rs"));
+        let second = fs::read_to_string(&paths[1]).unwrap();
+        assert!(second.contains("### b.rs"));
+        assert_eq!(fs::read_dir(&out_dir).unwrap().count(), 2);
+    }
+
+    #[test]
+    fn test_write_comment_files_json() {
+        let dir = tempfile::tempdir().unwrap();
+        let comments = vec![create_test_comment(7, "a.rs", Some(1), "user1")];
+
+        let paths =
+            write_comment_files(dir.path(), "json", &comments, &FormatOptions::default()).unwrap();
+
+        assert_eq!(paths, vec![dir.path().join("7.json")]);
+        let parsed: serde_json::Value =
+            serde_json::from_str(&fs::read_to_string(&paths[0]).unwrap()).unwrap();
+        assert_eq!(parsed[0]["file"], "a.rs");
+    }
+
+    #[test]
+    fn test_write_comment_files_unknown_format() {
+        let dir = tempfile::tempdir().unwrap();
+        let err =
+            write_comment_files(dir.path(), "nope", &[], &FormatOptions::default()).unwrap_err();
+        assert_eq!(err.kind(), io::ErrorKind::InvalidInput);
+    }
+
+    #[test]
+    fn test_write_comment_files_unwritable_dir() {
+        let dir = tempfile::tempdir().unwrap();
+        let file = dir.path().join("not-a-dir");
+        fs::write(&file, "").unwrap();
+        let result = write_comment_files(&file, "claude", &[], &FormatOptions::default());
+        assert!(result.is_err());
+    }
+
+    #[test]
+    fn test_write_atomic_replaces_file_without_leftovers() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("comments.md");
+        fs::write(&path, "old output that is longer than the new one").unwrap();
+
+        let comments = vec![create_test_comment(1, "a.rs", Some(10), "user1")];
+        let output = format_comments("claude", &comments, &FormatOptions::default()).unwrap();
+        write_atomic(&path, &output).unwrap();
+
+        assert_eq!(fs::read_to_string(&path).unwrap(), output);
+        let entries: Vec<_> = fs::read_dir(dir.path())
+            .unwrap()
+            .map(|e| e.unwrap().file_name())
+            .collect();
+        assert_eq!(entries, vec!["comments.md"]);
+    }
+
+    #[test]
+    fn test_format_comments_by_severity() {
+        let mut bot = create_test_comment(1, "src/api.rs", Some(42), "coderabbitai[bot]");
+        bot.body = "\u{1F534} **Potential issue:** the lock is never released".to_string();
+        let mut nit = create_test_comment(2, "src/api.rs", Some(7), "user1");
+        nit.body = "nit: trailing whitespace".to_string();
+        let mut review = create_test_comment(3, "", None, "user2");
+        review.body = "Looks good overall".to_string();
+        let comments = vec![nit, review, bot];
+
+        let output = format_comments_by_severity(&comments, &FormatOptions::default());
+        assert!(output.starts_with("# PR Review Comments by Severity\n\n**Total comments:** 3"));
+        let issues = output
+            .find("## Issues\n\n### src/api.rs (line 42)\n\n**Author:** coderabbitai[bot]")
+            .unwrap();
+        let nits = output.find("## Nits\n\n### src/api.rs (line 7)").unwrap();
+        let info = output
+            .find("## Info\n\n### (review) (line unknown)")
+            .unwrap();
+        assert!(issues < nits && nits < info);
+        assert!(!output.contains("## Suggestions"));
+        assert!(output.contains("the lock is never released"));
+        assert!(output.contains("```\n"));
+        assert!(!output.contains("View on GitHub"));
+
+        let options = FormatOptions {
+            include_snippet: false,
+            ..Default::default()
+        };
+        assert!(!format_comments_by_severity(&comments, &options).contains("```"));
+        assert_eq!(
+            format_comments_by_severity(&[], &options),
+            format!("{NO_COMMENTS_MESSAGE}\n")
+        );
+    }
+
+    #[test]
+    fn test_format_for_claude_by_severity() {
+        let mut bot = create_test_comment(1, "src/api.rs", Some(42), "coderabbitai[bot]");
+        bot.body = "\u{1F534} **Potential issue:** see line 40".to_string();
+        let comments = vec![bot];
+        let options = FormatOptions {
+            pr_title: Some("Fix locking".to_string()),
+            pr_url: Some("https://github.com/o/r/pull/1".to_string()),
+            snippet_after: true,
+            line_references: true,
+            show_word_count: true,
+            ..Default::default()
+        };
+
+        let output = format_for_claude_by_severity(&comments, &options);
+        assert!(output.starts_with(
+            "# Pull Request Review Comments\n\n**PR Title:** Fix locking\n\
+             **PR URL:** https://github.com/o/r/pull/1\n\n**Total comments:** 1 across 1 file(s)"
+        ));
+        assert!(output.contains("## Instructions\n\nPlease address each"));
+        assert!(output.contains("## Issues\n\n### src/api.rs (line 42) (references line 40) ("));
+        assert!(output.find("see line 40").unwrap() < output.find("**Code context:**").unwrap());
+        assert!(output.contains(&format!("[View on GitHub]({})", comments[0].html_url)));
+        assert_eq!(
+            format_for_claude_by_severity(&[], &options),
+            format!("{NO_COMMENTS_MESSAGE}\n")
+        );
+    }
+
+    #[test]
+    fn test_format_comments_with_prepares_bodies() {
+        let mut comment = create_test_comment(1, "a.rs", Some(1), "user1");
+        comment.body = "nit: \x1b[31mred\x1b[0m".to_string();
+        let outputs = format_comments_with(
+            &[
+                format_comments_by_severity,
+                lookup_format("minimal").unwrap(),
+            ],
+            &[comment],
+            &FormatOptions::default(),
+        );
+        assert_eq!(outputs.len(), 2);
+        assert!(outputs.iter().all(|o| o.contains("nit: red")));
+    }
+
+    #[test]
+    fn test_general_comments_bucket() {
+        let mut general = create_test_comment(2, "(general)", None, "maintainer");
+        general.body = "Can you rebase on main?".to_string();
+        general.diff_hunk = String::new();
+        let comments = vec![create_test_comment(1, "a.rs", Some(10), "user1"), general];
+
+        let output = format_comments_grouped_with_options(&comments, &FormatOptions::default());
+        assert!(output.contains("## (general)\n"));
+        assert!(output.contains("Can you rebase on main?"));
+        assert!(output.contains("## a.rs\n"));
+
+        let output = format_comments("plain", &comments, &FormatOptions::default()).unwrap();
+        assert!(output.contains("Comment by maintainer on the pull request: Can you rebase"));
+    }
+
+    #[test]
+    fn test_flag_test_requests() {
+        let mut comments = vec![
+            create_test_comment(1, "a.rs", Some(10), "user1"),
+            create_test_comment(2, "a.rs", Some(20), "user1"),
+        ];
+        comments[0].body = "please add a test for this".to_string();
+        let options = FormatOptions {
+            flag_test_requests: true,
+            ..Default::default()
+        };
+        for name in ["claude", "grouped", "flat", "minimal", "plain", "json"] {
+            let output = format_comments(name, &comments, &options).unwrap();
+            assert!(
+                output.contains("\u{1F9EA} test requested: please add a test for this"),
+                "{name} missing marker"
+            );
+            assert_eq!(output.matches(TEST_REQUEST_MARKER).count(), 1, "{name}");
+        }
 
-            json!({
-                "file": c.file_path,
-                "line": c.line_number,
-                "author": c.author,
-                "body": c.body,
-                "snippet": snippet,
-                "url": c.html_url,
-                "node_id": c.node_id
-            })
-        })
-        .collect();
+        let plain = format_comments("claude", &comments, &FormatOptions::default()).unwrap();
+        assert!(!plain.contains(TEST_REQUEST_MARKER));
+    }
 
-    serde_json::to_string_pretty(&json_comments).unwrap_or_else(|_| "[]".to_string())
-}
+    #[test]
+    fn test_flag_blocking() {
+        let mut comments = vec![
+            create_test_comment(1, "a.rs", Some(10), "user1"),
+            create_test_comment(2, "a.rs", Some(20), "user1"),
+        ];
+        comments[0].blocking = true;
+        comments[0].body = "please add a test for this".to_string();
+        let options = FormatOptions {
+            flag_blocking: true,
+            flag_test_requests: true,
+            ..Default::default()
+        };
+        for name in ["claude", "grouped", "flat", "minimal", "plain", "json"] {
+            let output = format_comments(name, &comments, &options).unwrap();
+            assert!(
+                output.contains("\u{26D4} blocking: \u{1F9EA} test requested: please add"),
+                "{name} missing marker"
+            );
+            assert_eq!(output.matches(BLOCKING_MARKER).count(), 1, "{name}");
+        }
 
-/// Formats a checks report for Claude/LLM consumption with full context.
-pub fn format_checks_for_claude(report: &ChecksReport) -> String {
-    let mut output = String::new();
+        let plain = format_comments("claude", &comments, &FormatOptions::default()).unwrap();
+        assert!(!plain.contains(BLOCKING_MARKER));
+    }
 
-    output.push_str("# Pull Request Check Status\n\n");
+    #[test]
+    fn test_format_comments_multi_writes_each_format() {
+        let comments = vec![create_test_comment(1, "a.rs", Some(10), "user1")];
+        let options = FormatOptions::default();
+        let outputs = format_comments_multi(&["claude", "json"], &comments, &options).unwrap();
 
-    if let Some(title) = &report.pr_title {
-        output.push_str(&format!("**PR Title:** {title}\n"));
+        let dir = tempfile::tempdir().unwrap();
+        let (md, json) = (
+            dir.path().join("review.md"),
+            dir.path().join("comments.json"),
+        );
+        write_atomic(&md, &outputs[0]).unwrap();
+        write_atomic(&json, &outputs[1]).unwrap();
+
+        let md = fs::read_to_string(md).unwrap();
+        assert_eq!(md, format_comments("claude", &comments, &options).unwrap());
+        assert!(md.starts_with("# Pull Request Review Comments"));
+        let parsed: Vec<Value> = serde_json::from_str(&fs::read_to_string(json).unwrap()).unwrap();
+        assert_eq!(parsed[0]["file"], "a.rs");
+
+        assert!(format_comments_multi(&["claude", "nope"], &comments, &options).is_none());
     }
-    if let Some(url) = &report.pr_url {
-        output.push_str(&format!("**PR URL:** {url}\n"));
+
+    #[test]
+    fn test_write_atomic_cleans_up_on_failed_rename() {
+        // Renaming a file over a non-empty directory fails
+        let dir = tempfile::tempdir().unwrap();
+        let target = dir.path().join("taken");
+        fs::create_dir(&target).unwrap();
+        fs::write(target.join("inner"), "").unwrap();
+
+        assert!(write_atomic(&target, "output").is_err());
+        let entries: Vec<_> = fs::read_dir(dir.path())
+            .unwrap()
+            .map(|e| e.unwrap().file_name())
+            .collect();
+        assert_eq!(entries, vec!["taken"]);
     }
 
-    output.push_str(&format!("**Overall Status:** {}\n", report.rollup_state));
+    #[test]
+    fn test_write_atomic_errors() {
+        let err = write_atomic(Path::new("/"), "output").unwrap_err();
+        assert_eq!(err.kind(), io::ErrorKind::InvalidInput);
 
-    let summary = report.summary_counts();
-    output.push_str(&format!(
-        "**Summary:** {} passed, {} failed, {} pending, {} skipped ({} total)\n\n",
-        summary.passed, summary.failed, summary.pending, summary.skipped, summary.total
-    ));
+        let dir = tempfile::tempdir().unwrap();
+        let missing = dir.path().join("missing").join("out.md");
+        assert!(write_atomic(&missing, "output").is_err());
+    }
 
-    // Failed required checks (highest priority)
-    let failed_req = report.failed_required();
-    if !failed_req.is_empty() {
-        output.push_str("## Failed Required Checks\n\n");
-        for check in &failed_req {
-            format_check_detail(&mut output, check);
-        }
+    #[test]
+    fn test_show_word_count_in_headers() {
+        let mut comment = create_test_comment(1, "a.rs", Some(10), "user1");
+        comment.body = "Please **rename** this `var` to something clearer.".to_string();
+        let mut short = create_test_comment(2, "a.rs", Some(20), "user2");
+        short.body = "LGTM".to_string();
+        let comments = vec![comment, short];
+        let options = FormatOptions {
+            show_word_count: true,
+            ..FormatOptions::default()
+        };
+
+        let output = format_comments("claude", &comments, &options).unwrap();
+        assert!(output.contains("#### line 10 (user1) (7 words)\n"));
+        assert!(output.contains("#### line 20 (user2) (1 word)\n"));
+
+        let output = format_comments("grouped", &comments, &options).unwrap();
+        assert!(output.contains("### a.rs (line 10) (7 words)\n"));
+
+        let output = format_comments("claude", &comments, &FormatOptions::default()).unwrap();
+        assert!(!output.contains("words)"));
     }
 
-    // Failed optional checks
-    let failed_opt = report.failed_optional();
-    if !failed_opt.is_empty() {
-        output.push_str("## Failed Optional Checks\n\n");
-        for check in &failed_opt {
-            format_check_detail(&mut output, check);
-        }
+    #[test]
+    fn test_format_for_claude_notes_line_references() {
+        let mut comment = create_test_comment(1, "a.rs", Some(10), "user1");
+        comment.body = "Same issue as line 88".to_string();
+        let mut other = create_test_comment(2, "a.rs", Some(20), "user2");
+        other.body = "See L3 and line 88, also line 120".to_string();
+        let comments = vec![comment, other];
+
+        let output = format_for_claude_with_options(&comments, &FormatOptions::default());
+        assert!(!output.contains("references"));
+
+        let options = FormatOptions {
+            line_references: true,
+            ..FormatOptions::default()
+        };
+        let output = format_for_claude_with_options(&comments, &options);
+        assert!(output.contains("#### line 10 (user1) (references line 88)"));
+        assert!(output.contains("#### line 20 (user2) (references lines 3, 88, 120)"));
     }
 
-    // Pending checks
-    let pending = report.pending();
-    if !pending.is_empty() {
-        output.push_str("## Pending Checks\n\n");
-        for check in &pending {
-            format_check_brief(&mut output, check);
-        }
-        output.push('\n');
+    #[test]
+    fn test_format_for_claude_no_line_references_note() {
+        let comments = vec![create_test_comment(1, "a.rs", Some(10), "user1")];
+        let options = FormatOptions {
+            line_references: true,
+            ..FormatOptions::default()
+        };
+        let output = format_for_claude_with_options(&comments, &options);
+        assert!(output.contains("#### line 10 (user1)\n"));
     }
 
-    // Passed required checks (brief)
-    let passed_req = report.passed_required();
-    if !passed_req.is_empty() {
-        output.push_str("## Passed Required Checks\n\n");
-        for check in &passed_req {
-            format_check_brief(&mut output, check);
+    #[test]
+    fn test_normalize_bot_names_in_display_only() {
+        let comments = vec![create_test_comment(
+            1,
+            "a.rs",
+            Some(10),
+            "devin-ai-integration[bot]",
+        )];
+        let options = FormatOptions {
+            normalize_bot_names: true,
+            ..FormatOptions::default()
+        };
+
+        for name in ["claude", "grouped", "flat", "minimal", "html"] {
+            let output = format_comments(name, &comments, &options).unwrap();
+            assert!(output.contains("devin-ai-integration"), "{name}");
+            assert!(!output.contains("[bot]"), "{name} kept the [bot] suffix");
         }
-        output.push('\n');
+
+        // JSON keeps the real login
+        let json = format_comments("json", &comments, &options).unwrap();
+        assert!(json.contains("\"devin-ai-integration[bot]\""));
+
+        let output = format_comments("claude", &comments, &FormatOptions::default()).unwrap();
+        assert!(output.contains("devin-ai-integration[bot]"));
     }
 
-    // Passed optional checks (brief)
-    let passed_opt = report.passed_optional();
-    if !passed_opt.is_empty() {
-        output.push_str("## Passed Optional Checks\n\n");
-        for check in &passed_opt {
-            format_check_brief(&mut output, check);
-        }
-        output.push('\n');
+    #[test]
+    fn test_format_review_stats() {
+        let mut comments = vec![
+            create_test_comment(1, "a.rs", Some(10), "user1"),
+            create_test_comment(2, "a.rs", Some(20), "user2"),
+            create_test_comment(3, "", None, "user1"),
+        ];
+        comments[2].created_at = Utc.with_ymd_and_hms(2024, 1, 17, 9, 5, 0).unwrap();
+
+        assert_eq!(
+            format_review_stats(&compute_stats(&comments)),
+            "# Review Stats\n\n**Total comments:** 3\n**Files:** 1\n**Authors:** 2\n\
+             **Time span:** 2024-01-15 10:30 UTC to 2024-01-17 09:05 UTC\n\
+             **Busiest file:** a.rs (2 comments)\n\n\
+             **By author:**\n- user1: 2\n- user2: 1\n\n\
+             **By file:**\n- a.rs: 2\n"
+        );
     }
 
-    // Skipped checks
-    let skipped = report.skipped();
-    if !skipped.is_empty() {
-        output.push_str("## Skipped Checks\n\n");
-        for check in &skipped {
-            format_check_brief(&mut output, check);
-        }
-        output.push('\n');
+    #[test]
+    fn test_format_review_stats_empty() {
+        assert_eq!(
+            format_review_stats(&ReviewStats::default()),
+            "# Review Stats\n\n**Total comments:** 0\n**Files:** 0\n**Authors:** 0\n"
+        );
     }
 
-    if report.checks.is_empty() {
-        output.push_str("No checks found for this pull request.\n");
+    #[test]
+    fn test_group_by_expr_headers() {
+        let comments = vec![
+            create_test_comment(1, "src/a.rs", Some(10), "user1"),
+            create_test_comment(2, "src/b.py", Some(20), "user2"),
+            create_test_comment(3, "docs/c.md", Some(5), "user1"),
+        ];
+        let options = FormatOptions {
+            group_by: Some(GroupExpr::Dir(1)),
+            ..FormatOptions::default()
+        };
+        let output = format_comments("grouped", &comments, &options).unwrap();
+        let docs = output.find("## docs\n").unwrap();
+        let src = output.find("## src\n").unwrap();
+        assert!(docs < src);
+        assert!(!output.contains("## src/a.rs\n"));
+        let claude = format_comments("claude", &comments, &options).unwrap();
+        assert!(claude.contains("## Comments by Directory\n"));
+
+        let options = FormatOptions {
+            group_by: Some(GroupExpr::Ext),
+            ..FormatOptions::default()
+        };
+        let output = format_comments("claude", &comments, &options).unwrap();
+        assert!(output.contains("## Comments by File Type\n"));
+        assert!(output.contains("### md\n"));
+        assert!(output.contains("### py\n"));
+        assert!(output.contains("### rs\n"));
     }
 
-    output
-}
+    #[test]
+    fn test_group_by_author_sections() {
+        let comments = vec![
+            create_test_comment(1, "b.rs", Some(10), "zed"),
+            create_test_comment(2, "a.rs", Some(20), "amy"),
+            create_test_comment(3, "c.rs", Some(5), "zed"),
+        ];
+        let options = FormatOptions {
+            group_by: Some(GroupExpr::Author),
+            ..FormatOptions::default()
+        };
 
-/// Formats a single check with full details (for failed checks).
-fn format_check_detail(output: &mut String, check: &CheckStatus) {
-    output.push_str(&format!(
-        "### [{}] {}{}\n\n",
-        check.conclusion.display_icon(),
-        check.name,
-        if check.required { " (required)" } else { "" }
-    ));
+        let grouped = format_comments("grouped", &comments, &options).unwrap();
+        let amy = grouped.find("## amy\n").unwrap();
+        let zed = grouped.find("## zed\n").unwrap();
+        assert!(amy < zed);
+        assert_eq!(grouped[zed..].matches("**Author:** zed").count(), 2);
 
-    if let Some(desc) = &check.description {
-        output.push_str(&format!("**Description:** {desc}\n"));
+        let claude = format_comments("claude", &comments, &options).unwrap();
+        assert!(claude.contains("## Comments by Author\n"));
+        assert!(claude.contains("The comments are grouped by author for easier navigation."));
+        assert!(claude.find("### amy\n").unwrap() < claude.find("### zed\n").unwrap());
     }
-    if let Some(workflow) = &check.workflow_name {
-        output.push_str(&format!("**Workflow:** {workflow}\n"));
-    }
-    if let Some(app) = &check.app_name {
-        output.push_str(&format!("**App:** {app}\n"));
-    }
-    if let Some(url) = &check.details_url {
-        output.push_str(&format!("**Details:** {url}\n"));
-    }
-    output.push('\n');
-}
 
-/// Formats a single check as a brief one-liner.
-fn format_check_brief(output: &mut String, check: &CheckStatus) {
-    output.push_str(&format!(
-        "- [{}] {}\n",
-        check.conclusion.display_icon(),
-        check.name
-    ));
-}
+    #[test]
+    fn test_group_by_author_headers_include_file() {
+        // zed's comments arrive out of file order
+        let comments = vec![
+            create_test_comment(1, "c.rs", Some(5), "zed"),
+            create_test_comment(2, "a.rs", Some(20), "zed"),
+            create_test_comment(3, "a.rs", Some(3), "zed"),
+        ];
+        let options = FormatOptions {
+            group_by: Some(GroupExpr::Author),
+            ..FormatOptions::default()
+        };
 
-/// Formats a checks report in minimal/compact style.
-pub fn format_checks_minimal(report: &ChecksReport) -> String {
-    let mut output = String::new();
+        let claude = format_comments("claude", &comments, &options).unwrap();
+        let a3 = claude.find("#### a.rs, line 3 (zed)\n").unwrap();
+        let a20 = claude.find("#### a.rs, line 20 (zed)\n").unwrap();
+        let c5 = claude.find("#### c.rs, line 5 (zed)\n").unwrap();
+        assert!(a3 < a20 && a20 < c5);
 
-    let summary = report.summary_counts();
-    output.push_str(&format!(
-        "Status: {} | {} passed, {} failed, {} pending, {} skipped\n",
-        report.rollup_state, summary.passed, summary.failed, summary.pending, summary.skipped
-    ));
+        let text = format_comments("text", &comments, &options).unwrap();
+        assert!(text.contains("a.rs, line 3 by zed"));
+        let html = format_comments("html", &comments, &options).unwrap();
+        assert!(html.contains("&middot; c.rs, line 5 &middot;"));
 
-    // Sort: failures first, then pending, then success, then skipped
-    let mut sorted_checks: Vec<&CheckStatus> = report.checks.iter().collect();
-    sorted_checks.sort_by_key(|c| match c.conclusion {
-        CheckConclusion::Failure
-        | CheckConclusion::TimedOut
-        | CheckConclusion::ActionRequired
-        | CheckConclusion::Cancelled => 0,
-        CheckConclusion::Pending => 1,
-        CheckConclusion::Success => 2,
-        _ => 3,
-    });
+        // Grouped by file, headers stay as they were
+        let claude = format_comments("claude", &comments, &FormatOptions::default()).unwrap();
+        assert!(claude.contains("#### line 3 (zed)\n"));
+        assert!(claude.contains("The comments are grouped by file for easier navigation."));
+        assert!(claude.contains("## Comments by File\n"));
 
-    for check in &sorted_checks {
-        output.push_str(&format!(
-            "[{}]{} {}\n",
-            check.conclusion.display_icon(),
-            if check.required { "*" } else { " " },
-            check.name
-        ));
+        let options = FormatOptions {
+            group_by: Some(GroupExpr::Severity),
+            ..FormatOptions::default()
+        };
+        let claude = format_comments("claude", &comments, &options).unwrap();
+        assert!(claude.contains("## Comments by Severity\n"));
+        assert!(claude.contains("#### c.rs, line 5 (zed)\n"));
     }
 
-    if !sorted_checks.is_empty() {
-        output.push_str("* = required\n");
-    } else {
-        output.push_str("No checks found.\n");
+    #[test]
+    fn test_with_stats_prepends_to_grouped_output() {
+        let comments = vec![
+            create_test_comment(1, "a.rs", Some(10), "user1"),
+            create_test_comment(2, "b.rs", Some(20), "user2"),
+        ];
+        let body = format_comments("grouped", &comments, &FormatOptions::default()).unwrap();
+        let output = with_stats(&comments, &body);
+
+        let stats_pos = output.find("# Review Stats").unwrap();
+        let body_pos = output.find("# PR Review Comments").unwrap();
+        assert!(stats_pos < body_pos);
+        assert!(output.contains("- user1: 1"));
+        assert!(output.contains("## a.rs"));
+        assert!(output.contains("## b.rs"));
     }
 
-    output
-}
+    #[test]
+    fn test_with_tasks_appends_outstanding_tasks() {
+        let mut comment = create_test_comment(1, "src/main.rs", Some(42), "user1");
+        comment.body =
+            "A few things:\n- [x] Fix typo\n- [ ] Add a test\n- [ ] Handle None".to_string();
+        let mut review = create_test_comment(2, "", None, "user2");
+        review.body = "- [ ] Update the changelog".to_string();
+        let comments = vec![comment, review];
 
-/// Formats a checks report as JSON.
-pub fn format_checks_as_json(report: &ChecksReport) -> String {
-    serde_json::to_string_pretty(report).unwrap_or_else(|_| "{}".to_string())
-}
+        let body = format_comments("grouped", &comments, &FormatOptions::default()).unwrap();
+        let output = with_tasks(&comments, &body);
 
-#[cfg(test)]
-mod tests {
-    use super::*;
-    use crate::models::{CheckType, RollupState};
-    use chrono::{TimeZone, Utc};
+        let body_pos = output.find("# PR Review Comments").unwrap();
+        let tasks_pos = output.find("# Outstanding tasks").unwrap();
+        assert!(body_pos < tasks_pos);
+        let tasks = &output[tasks_pos..];
+        assert!(tasks.contains("- [ ] Add a test (src/main.rs line 42, @user1)\n"));
+        assert!(tasks.contains("- [ ] Handle None (src/main.rs line 42, @user1)\n"));
+        assert!(tasks.contains("- [ ] Update the changelog (review comment, @user2)\n"));
+        assert!(!tasks.contains("Fix typo"));
+    }
 
-    fn create_test_comment(id: i64, file: &str, line: Option<i32>, author: &str) -> PRComment {
-        PRComment::new(
-            id,
-            Some(format!("PRRC_test{id}")),
-            file.to_string(),
-            line,
-            None,
-            author.to_string(),
-            "Test comment body".to_string(),
-            Utc.with_ymd_and_hms(2024, 1, 15, 10, 30, 0).unwrap(),
-            Utc.with_ymd_and_hms(2024, 1, 15, 10, 30, 0).unwrap(),
-            "@@ -1,5 +1,5 @@\n line1\n line2".to_string(),
-            "https://github.com/owner/repo/pull/1#discussion_r1".to_string(),
-        )
+    #[test]
+    fn test_build_front_matter() {
+        let generated_at = Utc.with_ymd_and_hms(2024, 1, 15, 10, 30, 0).unwrap();
+        let front_matter = build_front_matter("ROKT", "canal", 123, 4, generated_at);
+        assert_eq!(
+            front_matter,
+            "---\nowner: ROKT\nrepo: canal\npr: 123\ntotal: 4\ngenerated_at: 2024-01-15T10:30:00Z\n---\n"
+        );
+        assert!(front_matter.starts_with("---\n"));
+        assert!(front_matter.ends_with("\n---\n"));
+        assert!(front_matter.contains("\npr: 123\n"));
+        assert!(front_matter.contains("\ntotal: 4\n"));
     }
 
     #[test]
-    fn test_format_comment_for_llm_includes_file_and_line() {
-        let comment = create_test_comment(1, "src/main.rs", Some(42), "testuser");
-        let output = format_comment_for_llm(&comment, true, 10);
-        assert!(output.contains("src/main.rs"));
-        assert!(output.contains("line 42"));
+    fn test_wrap_with_files() {
+        let dir = tempfile::tempdir().unwrap();
+        let before = dir.path().join("before.md");
+        let after = dir.path().join("after.md");
+        fs::write(&before, "<review>\n").unwrap();
+        fs::write(&after, "</review>\nFix the above.\n").unwrap();
+
+        let body = "# PR Review Comments\n\nbody\n";
+        let wrapped = wrap_with_files(body, Some(&before), Some(&after)).unwrap();
+        assert_eq!(
+            wrapped,
+            "<review>\n# PR Review Comments\n\nbody\n</review>\nFix the above.\n"
+        );
+
+        assert_eq!(wrap_with_files(body, None, None).unwrap(), body);
+        assert_eq!(
+            wrap_with_files(body, None, Some(&after)).unwrap(),
+            format!("{body}</review>\nFix the above.\n")
+        );
     }
 
     #[test]
-    fn test_format_comment_for_llm_includes_author() {
-        let comment = create_test_comment(1, "src/main.rs", Some(42), "testuser");
-        let output = format_comment_for_llm(&comment, true, 10);
-        assert!(output.contains("testuser"));
+    fn test_wrap_with_files_missing_file() {
+        let dir = tempfile::tempdir().unwrap();
+        let missing = dir.path().join("missing.md");
+        let err = wrap_with_files("body", Some(&missing), None).unwrap_err();
+        assert_eq!(err.kind(), io::ErrorKind::NotFound);
     }
 
     #[test]
-    fn test_format_comment_for_llm_includes_date() {
-        let comment = create_test_comment(1, "src/main.rs", Some(42), "testuser");
-        let output = format_comment_for_llm(&comment, true, 10);
-        assert!(output.contains("2024-01-15"));
+    fn test_with_links_appends_footer() {
+        let mut comment = create_test_comment(1, "a.rs", Some(10), "user1");
+        comment.body = "See https://docs.rs/serde and https://github.com/o/r/pull/3.".to_string();
+        let mut other = create_test_comment(2, "b.rs", Some(20), "user2");
+        other.body = "Same as https://github.com/o/r/pull/3".to_string();
+        let comments = vec![comment, other];
+
+        let body = format_comments("flat", &comments, &FormatOptions::default()).unwrap();
+        let output = with_links(&comments, &body);
+        assert!(output.starts_with(&body));
+        assert!(output.ends_with(
+            "# Referenced links\n\n- https://docs.rs/serde\n- https://github.com/o/r/pull/3\n"
+        ));
+
+        let plain = vec![create_test_comment(3, "c.rs", Some(1), "user1")];
+        assert_eq!(format_links(&plain), "# Referenced links\n\nNone.\n");
     }
 
     #[test]
-    fn test_format_comment_for_llm_includes_snippet() {
-        let comment = create_test_comment(1, "src/main.rs", Some(42), "testuser");
-        let output = format_comment_for_llm(&comment, true, 10);
-        assert!(output.contains("line1"));
-        assert!(output.contains("Code context"));
+    fn test_format_tasks_none() {
+        let comments = vec![create_test_comment(1, "a.rs", Some(1), "user1")];
+        assert_eq!(format_tasks(&comments), "# Outstanding tasks\n\nNone.\n");
     }
 
     #[test]
-    fn test_format_comment_for_llm_excludes_snippet() {
-        let comment = create_test_comment(1, "src/main.rs", Some(42), "testuser");
-        let output = format_comment_for_llm(&comment, false, 10);
-        assert!(!output.contains("line1"));
-        assert!(!output.contains("Code context"));
+    fn test_format_comments_plain() {
+        let mut comment = create_test_comment(1, "src/main.py", Some(12), "reviewer1");
+        comment.body = "## Issue\n**Don't** use `eval` here:\n```py\neval(x)\n```".to_string();
+        let mut review = create_test_comment(2, "", None, "reviewer2");
+        review.body = "*Looks* good overall".to_string();
+
+        let output = format_comments_plain(&[comment, review], &FormatOptions::default());
+
+        assert!(output.starts_with("Pull request review comments. 2 comment(s) across 2 file(s)."));
+        assert!(output.contains(
+            "Comment by reviewer1 on src/main.py line 12: Issue\nDon't use eval here:\neval(x)"
+        ));
+        assert!(output.contains("Comment by reviewer2 on the pull request: Looks good overall"));
+        for markdown in ['#', '*', '`'] {
+            assert!(!output.contains(markdown), "found {markdown:?}");
+        }
     }
 
     #[test]
-    fn test_format_comment_for_llm_includes_body() {
-        let comment = create_test_comment(1, "src/main.rs", Some(42), "testuser");
-        let output = format_comment_for_llm(&comment, true, 10);
-        assert!(output.contains("Test comment body"));
+    fn test_format_mentions() {
+        let comments = vec![
+            create_test_comment(1, "a.rs", Some(1), "reviewer1"),
+            create_test_comment(2, "a.rs", Some(2), "devin-ai-integration[bot]"),
+            create_test_comment(3, "b.rs", Some(3), "reviewer2"),
+            create_test_comment(4, "b.rs", Some(4), "reviewer1"),
+        ];
+        let output = format_comments("mentions", &comments, &FormatOptions::default()).unwrap();
+        assert_eq!(output, "@reviewer1 @reviewer2\n");
+
+        let options = FormatOptions {
+            mention_bots: true,
+            ..Default::default()
+        };
+        assert_eq!(
+            format_mentions(&comments, &options),
+            "@reviewer1 @devin-ai-integration[bot] @reviewer2\n"
+        );
+        assert_eq!(
+            format_mentions(&comments[1..2], &FormatOptions::default()),
+            ""
+        );
     }
 
     #[test]
-    fn test_format_comments_grouped_groups_by_file() {
-        let comments = vec![
-            create_test_comment(1, "file1.rs", Some(10), "user1"),
-            create_test_comment(2, "file2.rs", Some(20), "user2"),
-            create_test_comment(3, "file1.rs", Some(30), "user3"),
+    fn test_format_comments_table() {
+        let mut comments = vec![
+            create_test_comment(1, "src/a.rs", Some(10), "user1"),
+            create_test_comment(2, "src/b.rs", Some(20), "user2"),
+            create_test_comment(3, "", None, "user3"),
         ];
-        let output = format_comments_grouped(&comments, true, 10);
-        assert!(output.contains("## file1.rs"));
-        assert!(output.contains("## file2.rs"));
+        comments[0].body = "Use a | b here\nnot a || b".to_string();
+        comments[1].start_line = Some(18);
+        comments[1].body = "word ".repeat(30);
+
+        let output = format_comments_table(&comments, 40);
+        let lines: Vec<&str> = output.lines().collect();
+        assert_eq!(lines[0], "| File | Line | Author | Comment |");
+        assert_eq!(lines[1], "| --- | --- | --- | --- |");
+        assert_eq!(
+            lines[2],
+            "| src/a.rs | 10 | user1 | Use a \\| b here not a \\|\\| b |"
+        );
+        assert!(lines[3].starts_with("| src/b.rs | 18-20 | user2 | word word"));
+        assert!(lines[3].ends_with("\u{2026} |"));
+        assert_eq!(lines[4], "|  |  | user3 | Test comment body |");
+        assert_eq!(lines.len(), 5);
+
+        assert_eq!(format_comments_table(&[], 20), "No comments found.\n");
     }
 
     #[test]
-    fn test_format_comments_grouped_shows_total_count() {
-        let comments = vec![
-            create_test_comment(1, "file1.rs", Some(10), "user1"),
-            create_test_comment(2, "file2.rs", Some(20), "user2"),
-        ];
-        let output = format_comments_grouped(&comments, true, 10);
-        assert!(output.contains("Total comments:** 2"));
+    fn test_table_format_body_limit() {
+        let mut comments = vec![create_test_comment(1, "a.rs", Some(1), "user1")];
+        comments[0].body = "x".repeat(200);
+        let default = format_comments("table", &comments, &FormatOptions::default()).unwrap();
+        let row = default.lines().nth(2).unwrap();
+        assert_eq!(row.matches('x').count(), TABLE_BODY_CHARS - 1);
+
+        let options = FormatOptions {
+            max_body_chars: Some(10),
+            ..Default::default()
+        };
+        let short = format_comments("table", &comments, &options).unwrap();
+        assert_eq!(short.lines().nth(2).unwrap().matches('x').count(), 9);
+    }
+
+    /// Minimal RFC 4180 reader for checking CSV output round-trips.
+    fn parse_csv(text: &str) -> Vec<Vec<String>> {
+        let mut rows = Vec::new();
+        let mut row = Vec::new();
+        let mut field = String::new();
+        let mut chars = text.chars().peekable();
+        let mut quoted = false;
+        while let Some(c) = chars.next() {
+            match (quoted, c) {
+                (true, '"') if chars.peek() == Some(&'"') => {
+                    chars.next();
+                    field.push('"');
+                }
+                (true, '"') => quoted = false,
+                (true, c) => field.push(c),
+                (false, '"') => quoted = true,
+                (false, ',') => row.push(std::mem::take(&mut field)),
+                (false, '\n') => {
+                    row.push(std::mem::take(&mut field));
+                    rows.push(std::mem::take(&mut row));
+                }
+                (false, c) => field.push(c),
+            }
+        }
+        rows
     }
 
     #[test]
-    fn test_format_comments_grouped_shows_file_count() {
-        let comments = vec![
-            create_test_comment(1, "file1.rs", Some(10), "user1"),
-            create_test_comment(2, "file2.rs", Some(20), "user2"),
+    fn test_format_comments_csv_round_trips() {
+        let mut comments = vec![
+            create_test_comment(1, "src/a.rs", Some(10), "user1"),
+            create_test_comment(2, "", None, "user2"),
         ];
-        let output = format_comments_grouped(&comments, true, 10);
-        assert!(output.contains("2 file(s)"));
+        comments[0].body = "Nit: use \"x, y\" here,\nand there".to_string();
+        comments[0].html_url = "https://github.com/o/r/pull/1#discussion_r1".to_string();
+
+        let output = format_comments_csv(&comments, &FormatOptions::default());
+        let rows = parse_csv(&output);
+        assert_eq!(rows.len(), 3);
+        assert!(rows.iter().all(|r| r.len() == 5));
+        assert_eq!(rows[0], vec!["file", "line", "author", "body", "url"]);
+        assert_eq!(
+            rows[1],
+            vec![
+                "src/a.rs",
+                "10",
+                "user1",
+                "Nit: use \"x, y\" here,\nand there",
+                "https://github.com/o/r/pull/1#discussion_r1"
+            ]
+        );
+        assert_eq!(rows[2][..4], ["", "", "user2", "Test comment body"]);
     }
 
     #[test]
-    fn test_format_comments_grouped_empty() {
-        let output = format_comments_grouped(&[], true, 10);
-        assert!(output.contains("No comments found"));
+    fn test_custom_empty_message_in_every_format() {
+        let options = FormatOptions {
+            empty_message: Some("NO_REVIEW_COMMENTS".to_string()),
+            ..FormatOptions::default()
+        };
+        use crate::cli::OutputFormat;
+        use clap::ValueEnum;
+
+        for format in OutputFormat::value_variants() {
+            let output = format_comments(format.name(), &[], &options).unwrap();
+            match format {
+                OutputFormat::Json => assert_eq!(output, "[]"),
+                OutputFormat::Html => assert!(output.contains("<p>NO_REVIEW_COMMENTS</p>")),
+                // Tallies and events report zero counts rather than a message
+                OutputFormat::AgeBuckets => assert!(output.starts_with("<1d\t0\n")),
+                OutputFormat::NdjsonEvents => assert_eq!(output.lines().count(), 2),
+                OutputFormat::Mentions | OutputFormat::Ndjson => assert_eq!(output, ""),
+                OutputFormat::Csv => assert_eq!(output, format!("{CSV_HEADER}\n")),
+                _ => assert_eq!(output, "NO_REVIEW_COMMENTS\n", "format {}", format.name()),
+            }
+        }
     }
 
     #[test]
-    fn test_format_comments_flat_shows_total_count() {
+    fn test_format_for_claude_per_file_counts() {
         let comments = vec![
-            create_test_comment(1, "file1.rs", Some(10), "user1"),
-            create_test_comment(2, "file2.rs", Some(20), "user2"),
+            create_test_comment(1, "src/main.py", Some(10), "user1"),
+            create_test_comment(2, "src/main.py", Some(20), "user2"),
+            create_test_comment(3, "src/main.py", Some(30), "user1"),
+            create_test_comment(4, "src/utils.py", Some(5), "user1"),
         ];
-        let output = format_comments_flat(&comments, true, 10);
-        assert!(output.contains("Total comments:** 2"));
+        let output = format_comments("claude", &comments, &FormatOptions::default()).unwrap();
+        assert!(output.contains("**Total comments:** 4 across 2 file(s)"));
+        assert!(output.contains("### src/main.py\n\n(3 comments on this file)\n\n"));
+        assert!(output.contains("### src/utils.py\n\n(1 comment on this file)\n\n"));
+
+        let options = FormatOptions {
+            group_by: Some(GroupExpr::Author),
+            ..FormatOptions::default()
+        };
+        let output = format_comments("claude", &comments, &options).unwrap();
+        assert!(output.contains("(3 comments by this author)"));
+
+        let options = FormatOptions {
+            group_by: Some(GroupExpr::Ext),
+            ..FormatOptions::default()
+        };
+        let output = format_comments("claude", &comments, &options).unwrap();
+        assert!(output.contains("(4 comments in this group)"));
     }
 
     #[test]
-    fn test_format_comments_flat_empty() {
-        let output = format_comments_flat(&[], true, 10);
-        assert!(output.contains("No comments found"));
+    fn test_format_for_claude_suggested_change() {
+        let mut comments = vec![
+            create_test_comment(1, "src/lib.rs", Some(10), "user1"),
+            create_test_comment(2, "src/lib.rs", Some(20), "user1"),
+        ];
+        comments[0].body = "Use `?`:\n```suggestion\n    let x = parse()?;\n```".to_string();
+        let output = format_comments("claude", &comments, &FormatOptions::default()).unwrap();
+        assert!(output.contains("**Suggested change:**\n```rust\n    let x = parse()?;\n```\n"));
+        assert_eq!(output.matches("**Suggested change:**").count(), 1);
     }
 
     #[test]
-    fn test_format_comments_minimal_shows_emoji() {
-        let comments = vec![create_test_comment(1, "file1.rs", Some(10), "user1")];
-        let output = format_comments_minimal(&comments);
-        assert!(output.contains("\u{1F4C4}")); // File emoji
+    fn test_format_for_claude_branches() {
+        let comments = vec![create_test_comment(1, "a.rs", Some(10), "user1")];
+        let info = crate::parser::parse_pr_info(&json!({
+            "title": "Backport fix",
+            "base": {"ref": "main"},
+            "head": {"ref": "feature/x"}
+        }));
+        let options = FormatOptions {
+            pr_title: info.title,
+            pr_branches: info.base_ref.zip(info.head_ref),
+            ..FormatOptions::default()
+        };
+        let output = format_for_claude_with_options(&comments, &options);
+        assert!(output
+            .contains("**PR Title:** Backport fix\n**Base:** main \u{2190} **Head:** feature/x\n"));
+
+        let output = format_for_claude_with_options(&comments, &FormatOptions::default());
+        assert!(!output.contains("**Base:**"));
+        assert!(!output.contains("**PR State:**"));
     }
 
     #[test]
-    fn test_format_comments_minimal_truncates_long_body() {
-        let mut comment = create_test_comment(1, "file1.rs", Some(10), "user1");
-        comment.body = "a".repeat(150);
-        let comments = vec![comment];
-        let output = format_comments_minimal(&comments);
-        assert!(output.contains("..."));
+    fn test_format_for_claude_pr_state() {
+        let comments = vec![create_test_comment(1, "a.rs", Some(10), "user1")];
+        let info = crate::parser::parse_pr_info(&json!({
+            "title": "Refactor",
+            "state": "open",
+            "draft": true,
+            "mergeable": true,
+            "user": {"login": "octocat"},
+            "base": {"ref": "main"},
+            "head": {"ref": "refactor"}
+        }));
+        let options = FormatOptions {
+            pr_title: info.title.clone(),
+            pr_state: info.state_summary(),
+            pr_author: info.author,
+            pr_branches: info.base_ref.zip(info.head_ref),
+            ..FormatOptions::default()
+        };
+        let output = format_for_claude_with_options(&comments, &options);
+        assert!(output.contains(
+            "**PR Title:** Refactor\n**PR State:** open (draft, mergeable)\n**PR Author:** octocat\n**Base:** main \u{2190} **Head:** refactor\n"
+        ));
     }
 
     #[test]
-    fn test_format_comments_minimal_shows_summary() {
+    fn test_format_comments_ndjson_events() {
         let comments = vec![
-            create_test_comment(1, "file1.rs", Some(10), "user1"),
-            create_test_comment(2, "file2.rs", Some(20), "user2"),
+            create_test_comment(1, "a.rs", Some(10), "user1"),
+            create_test_comment(2, "b.rs", None, "user2"),
         ];
-        let output = format_comments_minimal(&comments);
-        assert!(output.contains("2 comment(s)"));
-        assert!(output.contains("2 file(s)"));
-    }
+        let options = FormatOptions {
+            pr_ref: Some("owner/repo#123".to_string()),
+            pr_title: Some("Add feature".to_string()),
+            ..FormatOptions::default()
+        };
+        let output = format_comments("ndjson-events", &comments, &options).unwrap();
+        let events: Vec<Value> = output
+            .lines()
+            .map(|line| serde_json::from_str(line).unwrap())
+            .collect();
 
-    #[test]
-    fn test_format_comments_minimal_empty() {
-        let output = format_comments_minimal(&[]);
-        assert!(output.contains("No comments found"));
+        assert_eq!(events.len(), 4);
+        assert_eq!(
+            events[0],
+            json!({"event": "pr_start", "pr": "owner/repo#123", "title": "Add feature", "url": null, "comment_count": 2})
+        );
+        assert_eq!(events[1]["event"], "comment");
+        assert_eq!(events[1]["pr"], "owner/repo#123");
+        assert_eq!(events[1]["id"], 1);
+        assert_eq!(events[1]["file"], "a.rs");
+        assert_eq!(events[1]["line"], 10);
+        assert_eq!(events[1]["created_at"], "2024-01-15T10:30:00Z");
+        assert_eq!(events[2]["author"], "user2");
+        assert_eq!(
+            events[3],
+            json!({"event": "pr_end", "pr": "owner/repo#123", "comment_count": 2})
+        );
+        // The event tag leads each line, for grep-friendly logs
+        assert!(output.lines().all(|l| l.starts_with("{\"event\":")));
     }
 
     #[test]
-    fn test_format_for_claude_includes_header() {
-        let comments = vec![create_test_comment(1, "file1.rs", Some(10), "user1")];
-        let output = format_for_claude(&comments, None, None, None, true, 15);
-        assert!(output.contains("Pull Request Review Comments"));
-    }
+    fn test_format_annotated_source() {
+        let source = "fn main() {\n    let x = 1;\n    run(x);\n}\n";
+        let mut rename = create_test_comment(1, "src/main.rs", Some(2), "reviewer1");
+        rename.body = "Rename x\n\nto something descriptive".to_string();
+        let mut doc = create_test_comment(2, "src/main.rs", Some(0), "reviewer2");
+        doc.body = "Add a module doc".to_string();
 
-    #[test]
-    fn test_format_for_claude_includes_pr_title() {
-        let comments = vec![create_test_comment(1, "file1.rs", Some(10), "user1")];
-        let output = format_for_claude(&comments, None, Some("Test PR Title"), None, true, 15);
-        assert!(output.contains("Test PR Title"));
+        let output = format_annotated_source("src/main.rs", source, &[&rename, &doc]);
+        assert_eq!(
+            output,
+            "## src/main.rs\n\n```\n  | // << reviewer2: Add a module doc\n\
+             1 | fn main() {\n\
+             2 |     let x = 1;\n  |     // << reviewer1: Rename x to something descriptive\n\
+             3 |     run(x);\n\
+             4 | }\n```\n"
+        );
     }
 
     #[test]
-    fn test_format_for_claude_includes_pr_url() {
-        let comments = vec![create_test_comment(1, "file1.rs", Some(10), "user1")];
-        let output = format_for_claude(
-            &comments,
-            Some("https://github.com/owner/repo/pull/123"),
-            None,
-            None,
-            true,
-            15,
+    fn test_format_age_buckets() {
+        let now = Utc.with_ymd_and_hms(2024, 1, 20, 12, 0, 0).unwrap();
+        let comment_aged = |id, age: chrono::Duration| {
+            let mut comment = create_test_comment(id, "a.rs", Some(1), "user1");
+            comment.created_at = now - age;
+            comment
+        };
+        let comments = vec![
+            comment_aged(1, chrono::Duration::hours(2)),
+            comment_aged(2, chrono::Duration::hours(-1)),
+            comment_aged(3, chrono::Duration::days(1)),
+            comment_aged(4, chrono::Duration::hours(71)),
+            comment_aged(5, chrono::Duration::days(3)),
+            comment_aged(6, chrono::Duration::days(7)),
+            comment_aged(7, chrono::Duration::days(30)),
+        ];
+
+        assert_eq!(
+            format_age_buckets(&comments, now),
+            "<1d\t2\n1-3d\t2\n3-7d\t1\n>7d\t2\n"
         );
-        assert!(output.contains("https://github.com/owner/repo/pull/123"));
+        assert_eq!(
+            format_age_buckets(&[], now),
+            "<1d\t0\n1-3d\t0\n3-7d\t0\n>7d\t0\n"
+        );
+        // Via the registry, ages are measured from now
+        let output = format_comments("age-buckets", &comments[..1], &FormatOptions::default());
+        assert!(output.unwrap().ends_with(">7d\t1\n"));
     }
 
     #[test]
-    fn test_format_for_claude_includes_pr_node_id() {
-        let comments = vec![create_test_comment(1, "file1.rs", Some(10), "user1")];
-        let output = format_for_claude(&comments, None, None, Some("PR_kwDOE2CVus7test"), true, 15);
-        assert!(output.contains("PR_kwDOE2CVus7test"));
-        assert!(output.contains("PR Node ID"));
+    fn test_format_comments_text() {
+        let mut comment = create_test_comment(1, "src/main.py", Some(12), "reviewer1");
+        comment.body = "## Issue\n**Don't** use `eval` here:\n```py\neval(x)\n```".to_string();
+        comment.diff_hunk =
+            "@@ -10,3 +10,3 @@\n def run(x):\n-    return x\n+    return eval(x)".to_string();
+        let mut review = create_test_comment(2, "", None, "reviewer2");
+        review.body = "*Looks* good overall".to_string();
+        review.diff_hunk = String::new();
+
+        let output = format_comments_text(&[comment, review], true, 15);
+
+        assert!(output.starts_with("PR Review Comments\nTotal comments: 2 across 2 file(s)\n\n"));
+        assert!(output.contains("src/main.py\n===========\n\n"));
+        assert!(output.contains(
+            "line 12 by reviewer1 (2024-01-15 10:30 UTC)\nIssue\nDon't use eval here:\n    eval(x)\n\n"
+        ));
+        assert!(output.contains("     def run(x):\n    -    return x\n    +    return eval(x)\n\n"));
+        assert!(output.contains("(review)\n========\n\nline unknown by reviewer2"));
+        assert!(output.contains("Looks good overall\n\nsrc/main.py"));
+        assert!(output.ends_with("return eval(x)\n\n"));
+        for markdown in ["#", "**", "```"] {
+            assert!(!output.contains(markdown), "found {markdown:?}");
+        }
     }
 
     #[test]
-    fn test_format_for_claude_includes_instructions() {
-        let comments = vec![create_test_comment(1, "file1.rs", Some(10), "user1")];
-        let output = format_for_claude(&comments, None, None, None, true, 15);
-        assert!(output.contains("Instructions"));
-        assert!(output.contains("address"));
+    fn test_format_comments_text_keeps_code_verbatim() {
+        let mut comment = create_test_comment(1, "src/ptr.c", Some(4), "reviewer1");
+        comment.body =
+            "Deref *ptr here:\n```c\n*ptr = a * b;\n```\nMatch **/*.c, see `x`".to_string();
+        let output = format_comments_text(&[comment], false, 15);
+        assert!(output.contains("Deref *ptr here:\n    *ptr = a * b;\nMatch **/*.c, see x\n\n"));
     }
 
     #[test]
-    fn test_format_for_claude_empty() {
-        let output = format_for_claude(&[], None, None, None, true, 15);
-        assert!(output.contains("No comments found"));
+    fn test_format_comments_text_without_snippet() {
+        let comment = create_test_comment(1, "src/main.rs", Some(3), "user1");
+        let output = format_comments_text(&[comment], false, 15);
+        assert!(output.ends_with("Test comment body\n\n"));
+        assert!(!output.contains("line1"));
     }
 
     #[test]
-    fn test_format_as_json() {
-        let comments = vec![create_test_comment(1, "file1.rs", Some(10), "user1")];
-        let output = format_as_json(&comments, true, 10);
-        let parsed: serde_json::Value = serde_json::from_str(&output).unwrap();
-        assert!(parsed.is_array());
-        assert_eq!(parsed.as_array().unwrap().len(), 1);
-        assert_eq!(parsed[0]["file"], "file1.rs");
-        assert_eq!(parsed[0]["line"], 10);
-        assert_eq!(parsed[0]["author"], "user1");
+    fn test_format_comments_slack() {
+        let mut comment = create_test_comment(1, "src/main.rs", Some(12), "reviewer1");
+        comment.body = "## Issue\n**Don't** use `x < y` here".to_string();
+        comment.html_url = "https://github.com/o/r/pull/1#discussion_r1".to_string();
+        let mut review = create_test_comment(2, "", None, "reviewer2");
+        review.html_url = String::new();
+
+        let output =
+            format_comments_slack(&[comment, review], Some("https://github.com/o/r/pull/1"));
+
+        assert!(output.starts_with(
+            "*<https://github.com/o/r/pull/1|PR review comments>* (2 comment(s))\n\n"
+        ));
+        assert!(output.contains(
+            "*File:* `src/main.rs` (line 12) by *reviewer1* <https://github.com/o/r/pull/1#discussion_r1|view>\n*Issue*\n*Don't* use `x &lt; y` here\n\n"
+        ));
+        assert!(output
+            .ends_with("*File:* `(review)` (line unknown) by *reviewer2*\nTest comment body\n\n"));
+        assert!(!output.contains("more)"));
+
+        let options = FormatOptions {
+            pr_title: Some("Fix & ship".to_string()),
+            ..FormatOptions::default()
+        };
+        let output = format_comments_slack_with_options(&long_comments(1), &options);
+        assert!(output.starts_with("*Fix &amp; ship* (1 comment(s))\n\n"));
     }
 
-    #[test]
-    fn test_format_as_json_no_snippet() {
-        let comments = vec![create_test_comment(1, "file1.rs", Some(10), "user1")];
-        let output = format_as_json(&comments, false, 10);
-        let parsed: serde_json::Value = serde_json::from_str(&output).unwrap();
-        assert!(parsed[0]["snippet"].is_null());
+    /// Creates `count` comments with 200-character bodies.
+    fn long_comments(count: i64) -> Vec<PRComment> {
+        (1..=count)
+            .map(|id| {
+                let mut comment = create_test_comment(id, "src/lib.rs", Some(id as i32), "user1");
+                comment.body = "x".repeat(200);
+                comment
+            })
+            .collect()
     }
 
     #[test]
-    fn test_format_as_json_empty_snippet() {
-        // Covers formatter.rs line 278: empty snippet returns None
-        let comment = PRComment::new(
-            1,
-            Some("PRRC_test1".to_string()),
-            "file1.rs".to_string(),
-            Some(10),
-            None,
-            "user1".to_string(),
-            "Test body".to_string(),
-            Utc.with_ymd_and_hms(2024, 1, 15, 10, 30, 0).unwrap(),
-            Utc.with_ymd_and_hms(2024, 1, 15, 10, 30, 0).unwrap(),
-            "".to_string(), // Empty diff hunk
-            "https://github.com/owner/repo/pull/1#discussion_r1".to_string(),
-        );
-        let comments = vec![comment];
-        let output = format_as_json(&comments, true, 10); // include_snippet=true but diff is empty
-        let parsed: serde_json::Value = serde_json::from_str(&output).unwrap();
-        assert!(parsed[0]["snippet"].is_null());
+    fn test_format_comments_slack_truncates_to_block_limit() {
+        let output = format_comments_slack(&long_comments(40), None);
+        assert!(output.chars().count() <= SLACK_BLOCK_LIMIT);
+        let shown = output.matches("*File:*").count();
+        assert!(shown > 0 && shown < 40);
+        assert!(output.ends_with(&format!("...({} more)\n", 40 - shown)));
+
+        // A single oversized body is cut rather than dropped
+        let mut comment = create_test_comment(1, "src/lib.rs", Some(1), "user1");
+        comment.body = "word ".repeat(2000);
+        let output = format_comments_slack(&[comment], None);
+        assert!(output.chars().count() <= SLACK_BLOCK_LIMIT);
+        assert!(output.contains(TRUNCATED_BODY_MARKER));
+        assert!(!output.contains("more)"));
     }
 
     #[test]
-    fn test_format_for_claude_sorts_by_line_then_date() {
-        // Covers formatter.rs lines 235-237: sorting by line_number then created_at
+    fn test_limit_in_render_order() {
         let comments = vec![
-            PRComment::new(
-                1,
-                Some("PRRC_test1".to_string()),
-                "file1.rs".to_string(),
-                Some(10),
-                None,
-                "user1".to_string(),
-                "Earlier comment".to_string(),
-                Utc.with_ymd_and_hms(2024, 1, 15, 8, 0, 0).unwrap(),
-                Utc.with_ymd_and_hms(2024, 1, 15, 8, 0, 0).unwrap(),
-                "".to_string(),
-                "https://github.com/owner/repo/pull/1#discussion_r1".to_string(),
-            ),
-            PRComment::new(
-                2,
-                Some("PRRC_test2".to_string()),
-                "file1.rs".to_string(),
-                Some(10), // Same line number
-                None,
-                "user2".to_string(),
-                "Later comment".to_string(),
-                Utc.with_ymd_and_hms(2024, 1, 15, 10, 0, 0).unwrap(), // Later time
-                Utc.with_ymd_and_hms(2024, 1, 15, 10, 0, 0).unwrap(),
-                "".to_string(),
-                "https://github.com/owner/repo/pull/1#discussion_r2".to_string(),
-            ),
+            create_test_comment(1, "b.rs", Some(5), "user1"),
+            create_test_comment(2, "a.rs", Some(30), "user2"),
+            create_test_comment(3, "a.rs", Some(10), "user1"),
+            create_test_comment(4, "c.rs", Some(1), "user2"),
         ];
-        let output = format_for_claude(&comments, None, None, None, false, 10);
-        // Earlier comment should appear first in the output
-        let earlier_pos = output.find("Earlier comment").unwrap();
-        let later_pos = output.find("Later comment").unwrap();
-        assert!(
-            earlier_pos < later_pos,
-            "Comments should be sorted by date when line numbers are equal"
+        let ids = |kept: Vec<PRComment>| kept.iter().map(|c| c.id).collect::<Vec<_>>();
+        let options = FormatOptions::default();
+
+        // a.rs first, by line, then b.rs; input order is kept
+        assert_eq!(
+            ids(limit_in_render_order(comments.clone(), 3, &options)),
+            vec![1, 2, 3]
+        );
+        assert_eq!(
+            ids(limit_in_render_order(comments.clone(), 1, &options)),
+            vec![3]
+        );
+        assert_eq!(
+            ids(limit_in_render_order(comments.clone(), 0, &options)),
+            vec![1, 2, 3, 4]
+        );
+
+        // Sorted lists keep their order within each file
+        let options = FormatOptions {
+            keep_order: true,
+            ..FormatOptions::default()
+        };
+        assert_eq!(
+            ids(limit_in_render_order(comments.clone(), 1, &options)),
+            vec![2]
+        );
+
+        // Diff order and author groups decide which groups come first
+        let options = FormatOptions {
+            file_order: Some(vec!["c.rs".to_string(), "b.rs".to_string()]),
+            ..FormatOptions::default()
+        };
+        assert_eq!(
+            ids(limit_in_render_order(comments.clone(), 2, &options)),
+            vec![1, 4]
+        );
+        let options = FormatOptions {
+            group_by: Some(GroupExpr::Author),
+            ..FormatOptions::default()
+        };
+        assert_eq!(
+            ids(limit_in_render_order(comments, 2, &options)),
+            vec![1, 3]
         );
     }
 
     #[test]
-    fn test_format_comments_grouped_sorts_by_line_then_date() {
-        // Also tests the sorting logic in format_comments_grouped
-        let comments = vec![
-            PRComment::new(
-                1,
-                Some("PRRC_test1".to_string()),
-                "file1.rs".to_string(),
-                Some(10),
-                None,
-                "user1".to_string(),
-                "Earlier comment".to_string(),
-                Utc.with_ymd_and_hms(2024, 1, 15, 8, 0, 0).unwrap(),
-                Utc.with_ymd_and_hms(2024, 1, 15, 8, 0, 0).unwrap(),
-                "".to_string(),
-                "https://github.com/owner/repo/pull/1#discussion_r1".to_string(),
-            ),
-            PRComment::new(
-                2,
-                Some("PRRC_test2".to_string()),
-                "file1.rs".to_string(),
-                Some(10), // Same line number
-                None,
-                "user2".to_string(),
-                "Later comment".to_string(),
-                Utc.with_ymd_and_hms(2024, 1, 15, 10, 0, 0).unwrap(), // Later time
-                Utc.with_ymd_and_hms(2024, 1, 15, 10, 0, 0).unwrap(),
-                "".to_string(),
-                "https://github.com/owner/repo/pull/1#discussion_r2".to_string(),
-            ),
-        ];
-        let output = format_comments_grouped(&comments, false, 10);
-        // Earlier comment should appear first in the output
-        let earlier_pos = output.find("Earlier comment").unwrap();
-        let later_pos = output.find("Later comment").unwrap();
-        assert!(
-            earlier_pos < later_pos,
-            "Comments should be sorted by date when line numbers are equal"
+    fn test_format_comments_slack_empty() {
+        assert_eq!(format_comments_slack(&[], None), "No comments found.\n");
+    }
+
+    #[test]
+    fn test_snippet_for_context_window() {
+        let mut comment = create_test_comment(1, "src/main.rs", Some(3), "user1");
+        comment.diff_hunk = "@@ -1,5 +1,5 @@\n a\n-b\n+c\nd\n e".to_string();
+
+        let options = FormatOptions {
+            context_window: Some((1, 1)),
+            ..FormatOptions::with_snippet(true, 1)
+        };
+        assert_eq!(snippet_for(&comment, &options), "-b\n+c\nd");
+
+        let options = FormatOptions {
+            annotate_diff: true,
+            ..options
+        };
+        assert_eq!(snippet_for(&comment, &options), "-b\n+c\n d");
+    }
+
+    #[test]
+    fn test_snippet_for_prefers_file_context() {
+        let mut comment = create_test_comment(1, "src/main.rs", Some(3), "user1");
+        comment.file_context = Some("fn a() {}\nfn b() {}".to_string());
+
+        let options = FormatOptions::with_snippet(true, 1);
+        assert_eq!(snippet_for(&comment, &options), "fn a() {}\nfn b() {}");
+
+        // Diff annotations still come from the hunk
+        let options = FormatOptions {
+            annotate_diff: true,
+            ..FormatOptions::with_snippet(true, 15)
+        };
+        assert!(snippet_for(&comment, &options).contains("line1"));
+    }
+
+    #[test]
+    fn test_format_comments_text_empty() {
+        assert_eq!(format_comments_text(&[], true, 15), "No comments found.\n");
+    }
+
+    #[test]
+    fn test_format_comments_plain_empty() {
+        assert_eq!(
+            format_comments_plain(&[], &FormatOptions::default()),
+            "No comments found.\n"
         );
     }
 
     #[test]
-    fn test_format_as_json_includes_node_id() {
-        let comments = vec![create_test_comment(1, "file1.rs", Some(10), "user1")];
-        let output = format_as_json(&comments, true, 10);
-        let parsed: serde_json::Value = serde_json::from_str(&output).unwrap();
-        assert_eq!(parsed[0]["node_id"], "PRRC_test1");
+    fn test_diff_stats_annotate_file_headers() {
+        let files = crate::parser::parse_pr_files(&[
+            serde_json::json!({"filename": "a.rs", "status": "modified", "additions": 120, "deletions": 30}),
+            serde_json::json!({"filename": "c.rs", "status": "added", "additions": 5, "deletions": 0}),
+        ]);
+        let comments = vec![
+            create_test_comment(1, "a.rs", Some(10), "user1"),
+            create_test_comment(2, "b.rs", Some(20), "user2"),
+        ];
+        let options = FormatOptions {
+            diff_stats: files
+                .iter()
+                .map(|f| (f.filename.clone(), (f.additions, f.deletions)))
+                .collect(),
+            ..FormatOptions::default()
+        };
+
+        let grouped = format_comments("grouped", &comments, &options).unwrap();
+        assert!(grouped.contains("## a.rs (+120 -30)\n"));
+        assert!(grouped.contains("## b.rs\n"));
+
+        let claude = format_comments("claude", &comments, &options).unwrap();
+        assert!(claude.contains("### a.rs (+120 -30)\n"));
+        assert!(claude.contains("### b.rs\n"));
+
+        let plain = format_comments("grouped", &comments, &FormatOptions::default()).unwrap();
+        assert!(!plain.contains("(+120 -30)"));
     }
 
     #[test]
-    fn test_format_as_json_node_id_none() {
-        let comment = PRComment::new(
-            1,
-            None, // No node_id
-            "file1.rs".to_string(),
-            Some(10),
-            None,
-            "user1".to_string(),
-            "Test body".to_string(),
-            Utc.with_ymd_and_hms(2024, 1, 15, 10, 30, 0).unwrap(),
-            Utc.with_ymd_and_hms(2024, 1, 15, 10, 30, 0).unwrap(),
-            "".to_string(),
-            "https://github.com/owner/repo/pull/1#discussion_r1".to_string(),
+    fn test_estimate_tokens() {
+        assert_eq!(estimate_tokens(""), 0);
+        assert_eq!(estimate_tokens("abc"), 1);
+        assert_eq!(estimate_tokens("abcd"), 1);
+        assert_eq!(estimate_tokens("abcde"), 2);
+        assert_eq!(estimate_tokens(&"x".repeat(1000)), 250);
+        // Characters, not bytes
+        assert_eq!(estimate_tokens("\u{26D4}\u{26D4}\u{26D4}\u{26D4}"), 1);
+    }
+
+    #[test]
+    fn test_output_size_summary() {
+        assert_eq!(
+            output_size_summary(&"x".repeat(1001)),
+            "Output: ~251 tokens (~1001 chars)"
         );
-        let comments = vec![comment];
-        let output = format_as_json(&comments, true, 10);
-        let parsed: serde_json::Value = serde_json::from_str(&output).unwrap();
-        assert!(parsed[0]["node_id"].is_null());
     }
 
-    // ---- Check formatter tests ----
+    #[test]
+    fn test_truncate_output_text() {
+        let output = format!("line one\nline two é\n{}\n", "x".repeat(100));
+        let output = output.as_str();
+        assert_eq!(
+            truncate_output(output, 200, "text"),
+            (output.to_string(), None)
+        );
 
-    fn create_test_check_status(
-        name: &str,
-        conclusion: CheckConclusion,
-        required: bool,
-    ) -> CheckStatus {
-        CheckStatus {
-            name: name.to_string(),
-            conclusion,
-            required,
-            description: None,
-            details_url: None,
-            started_at: None,
-            completed_at: None,
-            check_type: CheckType::CheckRun,
-            workflow_name: None,
-            app_name: None,
-        }
+        let notice_len = truncated_output_notice(0, output.len()).len();
+        // Room for "line one\n" and part of the second line
+        let (truncated, notice) = truncate_output(output, notice_len + 12, "text");
+        assert_eq!(
+            truncated,
+            "line one\n\n[output truncated: 9 of 122 bytes shown]\n"
+        );
+        assert!(truncated.len() <= notice_len + 12);
+        assert_eq!(notice, None);
+
+        // No line end before the cut: split mid-line, never inside "é"
+        let output = format!("abcé{}", "x".repeat(100));
+        let (truncated, _) =
+            truncate_output(&output, truncated_output_notice(105, 105).len() + 4, "text");
+        assert!(truncated.starts_with("abc\n[output truncated: 3 of 105"));
     }
 
-    fn create_test_checks_report() -> ChecksReport {
-        ChecksReport {
-            pr_title: Some("Test PR".to_string()),
-            pr_url: Some("https://github.com/owner/repo/pull/1".to_string()),
-            rollup_state: RollupState::Failure,
-            checks: vec![
-                create_test_check_status("build", CheckConclusion::Success, true),
-                create_test_check_status("lint", CheckConclusion::Failure, true),
-                create_test_check_status("coverage", CheckConclusion::Success, false),
-                create_test_check_status("optional-lint", CheckConclusion::Failure, false),
-                create_test_check_status("deploy", CheckConclusion::Pending, false),
-                create_test_check_status("docs", CheckConclusion::Skipped, false),
-            ],
+    #[test]
+    fn test_truncate_output_smaller_than_notice() {
+        let output = format!("line one\nline two\n{}\n", "x".repeat(100));
+        let (truncated, notice) = truncate_output(&output, 12, "claude");
+        assert_eq!(truncated, "line one\n");
+        assert_eq!(
+            notice.as_deref(),
+            Some("[output truncated: 9 of 119 bytes shown]")
+        );
+
+        let (truncated, _) = truncate_output("[\n  1\n]", 1, "json");
+        assert_eq!(truncated, "");
+    }
+
+    #[test]
+    fn test_truncate_output_record_formats_drop_whole_records() {
+        let comments: Vec<PRComment> = (1..=5)
+            .map(|id| {
+                let mut comment = create_test_comment(id, "a.rs", Some(id as i32), "user1");
+                comment.body = format!("line one\nline \"{id}\"");
+                comment
+            })
+            .collect();
+
+        for format in ["ndjson", "ndjson-events"] {
+            let output = format_comments(format, &comments, &FormatOptions::default()).unwrap();
+            let (truncated, notice) = truncate_output(&output, output.len() / 2, format);
+            assert!(truncated.len() <= output.len() / 2);
+            assert!(output.starts_with(&truncated) && truncated.ends_with('\n'));
+            for line in truncated.lines() {
+                serde_json::from_str::<Value>(line).unwrap();
+            }
+            assert!(notice.unwrap().starts_with("[output truncated: "));
         }
+
+        // Quoted line breaks inside a CSV field don't end a row
+        let output = format_comments("csv", &comments, &FormatOptions::default()).unwrap();
+        let (truncated, _) = truncate_output(&output, output.len() / 2, "csv");
+        assert!(truncated.len() <= output.len() / 2);
+        assert!(truncated.starts_with(CSV_HEADER) && truncated.ends_with('\n'));
+        assert_eq!(truncated.matches('"').count() % 2, 0);
+        assert!(!truncated.contains("[output truncated"));
     }
 
     #[test]
-    fn test_format_checks_for_claude_header() {
-        let report = create_test_checks_report();
-        let output = format_checks_for_claude(&report);
-        assert!(output.contains("# Pull Request Check Status"));
-        assert!(output.contains("**PR Title:** Test PR"));
-        assert!(output.contains("**PR URL:** https://github.com/owner/repo/pull/1"));
-        assert!(output.contains("**Overall Status:** FAILURE"));
+    fn test_truncate_output_json_stays_valid() {
+        let comments: Vec<PRComment> = (1..=5)
+            .map(|id| create_test_comment(id, "a.rs", Some(id as i32 * 10), "user1"))
+            .collect();
+        let output = format_comments("json", &comments, &FormatOptions::default()).unwrap();
+
+        let (truncated, notice) = truncate_output(&output, output.len() / 2, "json");
+        assert!(truncated.len() <= output.len() / 2);
+        let items: Vec<Value> = serde_json::from_str(&truncated).unwrap();
+        assert!(!items.is_empty() && items.len() < 5);
+        assert_eq!(items[0]["line"], 10);
+        assert!(notice.is_some());
+
+        assert_eq!(truncate_output(&output, 10, "json").0, "[]");
     }
 
     #[test]
-    fn test_format_checks_for_claude_summary() {
-        let report = create_test_checks_report();
-        let output = format_checks_for_claude(&report);
-        assert!(output.contains("2 passed"));
-        assert!(output.contains("2 failed"));
-        assert!(output.contains("1 pending"));
-        assert!(output.contains("1 skipped"));
-        assert!(output.contains("6 total"));
+    fn test_smart_truncate_short_text_unchanged() {
+        assert_eq!(smart_truncate("Short. Body.", 12), "Short. Body.");
+        assert_eq!(smart_truncate("", 5), "");
     }
 
     #[test]
-    fn test_format_checks_for_claude_sections() {
-        let report = create_test_checks_report();
-        let output = format_checks_for_claude(&report);
-        assert!(output.contains("## Failed Required Checks"));
-        assert!(output.contains("## Failed Optional Checks"));
-        assert!(output.contains("## Pending Checks"));
-        assert!(output.contains("## Passed Required Checks"));
-        assert!(output.contains("## Passed Optional Checks"));
-        assert!(output.contains("## Skipped Checks"));
+    fn test_smart_truncate_at_sentence_boundary() {
+        let body = "First sentence here. Second one is longer! Third runs on and on.";
+        assert_eq!(smart_truncate(body, 30), "First sentence here.\u{2026}");
+        assert_eq!(
+            smart_truncate(body, 50),
+            "First sentence here. Second one is longer!\u{2026}"
+        );
+        // A boundary right at the limit is used
+        assert_eq!(smart_truncate(body, 21), "First sentence here.\u{2026}");
     }
 
     #[test]
-    fn test_format_checks_for_claude_failed_required_detail() {
-        let report = create_test_checks_report();
-        let output = format_checks_for_claude(&report);
-        assert!(output.contains("[FAIL] lint (required)"));
+    fn test_smart_truncate_ignores_inner_dots() {
+        // Dots inside "v1.2" are not sentence ends; fall back to a word boundary
+        let body = "Use v1.2 or v1.3 instead of the old version please";
+        assert_eq!(smart_truncate(body, 20), "Use v1.2 or v1.3\u{2026}");
     }
 
     #[test]
-    fn test_format_checks_for_claude_with_details() {
-        let mut report = create_test_checks_report();
-        report.checks[1].description = Some("Build failed".to_string());
-        report.checks[1].workflow_name = Some("CI".to_string());
-        report.checks[1].app_name = Some("github-actions".to_string());
-        report.checks[1].details_url = Some("https://ci.example.com".to_string());
-        let output = format_checks_for_claude(&report);
-        assert!(output.contains("**Description:** Build failed"));
-        assert!(output.contains("**Workflow:** CI"));
-        assert!(output.contains("**App:** github-actions"));
-        assert!(output.contains("**Details:** https://ci.example.com"));
+    fn test_smart_truncate_without_boundaries() {
+        assert_eq!(smart_truncate("abcdefghijklmnop", 6), "abcde\u{2026}");
+        assert_eq!(smart_truncate("abcdef", 1), "\u{2026}");
     }
 
     #[test]
-    fn test_format_checks_for_claude_empty() {
-        let report = ChecksReport {
-            pr_title: None,
-            pr_url: None,
-            rollup_state: RollupState::Success,
-            checks: vec![],
+    fn test_max_body_chars_applies_across_formats() {
+        let mut comment = create_test_comment(1, "a.rs", Some(10), "user1");
+        comment.body = "Rename this variable. It shadows the outer binding.".to_string();
+        let comments = vec![comment];
+        let options = FormatOptions {
+            max_body_chars: Some(30),
+            ..FormatOptions::default()
         };
-        let output = format_checks_for_claude(&report);
-        assert!(output.contains("No checks found"));
+
+        for name in registered_formats() {
+            let output = format_comments(&name, &comments, &options).unwrap();
+            assert!(!output.contains("shadows"), "{name} kept the full body");
+        }
+        let output = format_comments("claude", &comments, &options).unwrap();
+        assert!(output.contains("Rename this variable.\u{2026}"));
+
+        let dir = tempfile::tempdir().unwrap();
+        let paths = write_comment_files(dir.path(), "flat", &comments, &options).unwrap();
+        assert!(!fs::read_to_string(&paths[0]).unwrap().contains("shadows"));
     }
 
     #[test]
-    fn test_format_checks_for_claude_all_passing() {
-        let report = ChecksReport {
-            pr_title: Some("All Good".to_string()),
-            pr_url: None,
-            rollup_state: RollupState::Success,
-            checks: vec![
-                create_test_check_status("build", CheckConclusion::Success, true),
-                create_test_check_status("test", CheckConclusion::Success, true),
-            ],
+    fn test_ansi_stripped_from_bodies_and_snippets_by_default() {
+        let mut comment = create_test_comment(1, "a.rs", Some(10), "user1");
+        comment.body = "Test fails:\n\x1b[31mFAILED\x1b[0m test_parse".to_string();
+        comment.diff_hunk = "@@ -1,2 +1,2 @@\n \x1b[32mok\x1b[0m line".to_string();
+        let comments = vec![comment];
+
+        for format in ["claude", "json", "html", "plain"] {
+            let output = format_comments(format, &comments, &FormatOptions::default()).unwrap();
+            assert!(!output.contains('\x1b'), "escape left in {format}");
+            assert!(output.contains("FAILED"));
+        }
+        let output = format_comments("claude", &comments, &FormatOptions::default()).unwrap();
+        assert!(output.contains("FAILED test_parse"));
+        assert!(output.contains("ok line"));
+
+        let options = FormatOptions {
+            keep_ansi: true,
+            ..FormatOptions::default()
         };
-        let output = format_checks_for_claude(&report);
-        assert!(output.contains("**Overall Status:** SUCCESS"));
-        assert!(output.contains("## Passed Required Checks"));
-        assert!(!output.contains("## Failed"));
+        let output = format_comments("claude", &comments, &options).unwrap();
+        assert!(output.contains("\x1b[31mFAILED"));
+        assert!(output.contains("\x1b[32mok"));
     }
 
     #[test]
-    fn test_format_checks_for_claude_no_title_or_url() {
-        let report = ChecksReport {
-            pr_title: None,
-            pr_url: None,
-            rollup_state: RollupState::Success,
-            checks: vec![create_test_check_status(
-                "build",
-                CheckConclusion::Success,
-                true,
-            )],
+    fn test_collapse_blank_lines_applies_across_formats() {
+        let mut comment = create_test_comment(1, "a.rs", Some(10), "user1");
+        comment.body = "Summary\n\n\n\nDetails".to_string();
+        let comments = vec![comment];
+        let options = FormatOptions {
+            collapse_blank_lines: true,
+            ..FormatOptions::default()
         };
-        let output = format_checks_for_claude(&report);
-        assert!(!output.contains("**PR Title:**"));
-        assert!(!output.contains("**PR URL:**"));
+
+        let output = format_comments("flat", &comments, &options).unwrap();
+        assert!(output.contains("Summary\n\nDetails"));
+        let output = format_comments("json", &comments, &options).unwrap();
+        assert!(output.contains(r"Summary\n\nDetails"));
+
+        let output = format_comments("flat", &comments, &FormatOptions::default()).unwrap();
+        assert!(output.contains("Summary\n\n\n\nDetails"));
     }
 
     #[test]
-    fn test_format_checks_minimal_header() {
-        let report = create_test_checks_report();
-        let output = format_checks_minimal(&report);
-        assert!(output.contains("Status: FAILURE"));
-        assert!(output.contains("2 passed"));
-        assert!(output.contains("2 failed"));
+    fn test_collapse_blank_lines_before_truncation() {
+        let mut comment = create_test_comment(1, "a.rs", Some(10), "user1");
+        comment.body = "Fix this.\n\n\n\n\n\nAnd that.".to_string();
+        let options = FormatOptions {
+            collapse_blank_lines: true,
+            max_body_chars: Some(20),
+            ..FormatOptions::default()
+        };
+        let prepared = prepare_comments(std::slice::from_ref(&comment), &options);
+        assert_eq!(prepared[0].body, "Fix this.\n\nAnd that.");
     }
 
     #[test]
-    fn test_format_checks_minimal_required_marker() {
-        let report = create_test_checks_report();
-        let output = format_checks_minimal(&report);
-        // Required checks should have * marker
-        assert!(output.contains("[PASS]* build"));
-        assert!(output.contains("[FAIL]* lint"));
-        // Optional checks should have space
-        assert!(output.contains("[PASS]  coverage"));
-        assert!(output.contains("* = required"));
+    fn test_format_counts() {
+        let counts = vec![("user1".to_string(), 2), ("user2".to_string(), 1)];
+        assert_eq!(format_counts(&counts), "user1\t2\nuser2\t1\n");
     }
 
     #[test]
-    fn test_format_checks_minimal_sorted_by_priority() {
-        let report = create_test_checks_report();
-        let output = format_checks_minimal(&report);
-        // Failures should appear before successes
-        let fail_pos = output.find("[FAIL]").unwrap();
-        let last_pass_pos = output.rfind("[PASS]").unwrap();
-        assert!(
-            fail_pos < last_pass_pos,
-            "Failures should appear before passes"
+    fn test_format_coverage() {
+        let coverage = vec![
+            FileCoverage {
+                filename: "src/lib.rs".to_string(),
+                commented_lines: 3,
+                changed_lines: 120,
+            },
+            FileCoverage {
+                filename: "src/old.rs".to_string(),
+                commented_lines: 0,
+                changed_lines: 0,
+            },
+            FileCoverage {
+                filename: "README.md".to_string(),
+                commented_lines: 2,
+                changed_lines: 10,
+            },
+        ];
+        assert_eq!(
+            format_coverage(&coverage),
+            "src/lib.rs\t3/120\t2.5%\nsrc/old.rs\t0/0\tn/a\nREADME.md\t2/10\t20.0%\nTOTAL\t5/130\t3.8%\n"
         );
+        assert_eq!(format_coverage(&[]), "TOTAL\t0/0\tn/a\n");
     }
 
     #[test]
-    fn test_format_checks_minimal_empty() {
-        let report = ChecksReport {
-            pr_title: None,
-            pr_url: None,
-            rollup_state: RollupState::Success,
-            checks: vec![],
+    fn test_format_counts_empty() {
+        assert_eq!(format_counts(&[]), "");
+    }
+
+    #[test]
+    fn test_registry_has_builtin_formats() {
+        let names = registered_formats();
+        for name in ["claude", "grouped", "flat", "minimal", "json"] {
+            assert!(names.contains(&name.to_string()), "missing {name}");
+        }
+    }
+
+    #[test]
+    fn test_format_comments_dispatches_builtins() {
+        let comments = vec![create_test_comment(1, "file1.rs", Some(10), "user1")];
+        let options = FormatOptions {
+            pr_title: Some("Test PR Title".to_string()),
+            ..FormatOptions::default()
         };
-        let output = format_checks_minimal(&report);
-        assert!(output.contains("No checks found"));
+
+        let claude = format_comments("claude", &comments, &options).unwrap();
+        assert!(claude.contains("Test PR Title"));
+
+        let grouped = format_comments("grouped", &comments, &options).unwrap();
+        assert!(grouped.contains("## file1.rs"));
+
+        let flat = format_comments("flat", &comments, &options).unwrap();
+        assert!(flat.contains("## Comment 1"));
+
+        let minimal = format_comments("minimal", &comments, &options).unwrap();
+        assert!(minimal.contains("1 comment(s)"));
+
+        let json = format_comments("json", &comments, &options).unwrap();
+        let parsed: serde_json::Value = serde_json::from_str(&json).unwrap();
+        assert_eq!(parsed[0]["file"], "file1.rs");
     }
 
     #[test]
-    fn test_format_checks_as_json_valid() {
-        let report = create_test_checks_report();
-        let output = format_checks_as_json(&report);
-        let parsed: serde_json::Value = serde_json::from_str(&output).unwrap();
-        assert_eq!(parsed["pr_title"], "Test PR");
-        assert_eq!(parsed["rollup_state"], "FAILURE");
-        assert!(parsed["checks"].is_array());
-        assert_eq!(parsed["checks"].as_array().unwrap().len(), 6);
+    fn test_format_comments_unknown_format() {
+        let comments = vec![create_test_comment(1, "file1.rs", Some(10), "user1")];
+        assert!(format_comments("no-such-format", &comments, &FormatOptions::default()).is_none());
     }
 
     #[test]
-    fn test_format_checks_as_json_empty() {
-        let report = ChecksReport {
-            pr_title: None,
-            pr_url: None,
-            rollup_state: RollupState::Success,
-            checks: vec![],
+    fn test_register_custom_format() {
+        fn count_format(comments: &[PRComment], options: &FormatOptions) -> String {
+            format!(
+                "{} comment(s), snippets={}",
+                comments.len(),
+                options.include_snippet
+            )
+        }
+
+        register_format("test-count", count_format);
+        assert!(registered_formats().contains(&"test-count".to_string()));
+
+        let comments = vec![
+            create_test_comment(1, "file1.rs", Some(10), "user1"),
+            create_test_comment(2, "file2.rs", Some(20), "user2"),
+        ];
+        let options = FormatOptions {
+            include_snippet: false,
+            ..FormatOptions::default()
         };
-        let output = format_checks_as_json(&report);
-        let parsed: serde_json::Value = serde_json::from_str(&output).unwrap();
-        assert!(parsed["checks"].as_array().unwrap().is_empty());
+        let output = format_comments("test-count", &comments, &options).unwrap();
+        assert_eq!(output, "2 comment(s), snippets=false");
+    }
+
+    #[test]
+    fn test_format_options_default() {
+        let options = FormatOptions::default();
+        assert!(options.include_snippet);
+        assert_eq!(options.snippet_lines, 15);
+        assert!(options.pr_url.is_none());
     }
 
     #[test]
@@ -3,13 +3,24 @@
 //! A library for fetching and formatting GitHub PR comments for LLM consumption.
 
 pub mod cli;
+pub mod clipboard;
+pub mod config;
 pub mod error;
 pub mod fetcher;
 pub mod formatter;
+pub mod logging;
 pub mod models;
 pub mod parser;
+pub mod remap;
 pub mod sanitizer;
+pub mod selftest;
+pub mod snapshot;
 
-pub use cli::{Args, OutputFormat, REPO_URL};
+pub use cli::{Args, OutputFormat, SortKey, REPO_URL};
 pub use error::{GitHubAPIError, ParseError};
-pub use models::{CheckConclusion, CheckStatus, CheckType, ChecksReport, PRComment, RollupState};
+pub use formatter::{format_comments, register_format, FormatFn, FormatOptions};
+pub use logging::LogLevel;
+pub use models::{
+    CheckConclusion, CheckStatus, CheckType, ChecksReport, FileCoverage, IssueRef, PRComment,
+    PRFile, PRInfo, Reactions, RollupState, Severity,
+};
@@ -0,0 +1,194 @@
+//! Leveled logging to stderr, controlled by `--log-level`.
+//!
+//! Messages are filtered against a process-wide level. Tests can capture
+//! messages on the current thread with [`capture`] instead of writing to stderr.
+
+use clap::ValueEnum;
+use std::cell::RefCell;
+use std::fmt;
+use std::sync::atomic::{AtomicU8, Ordering};
+
+/// Log verbosity, from least to most verbose.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, ValueEnum, Default)]
+pub enum LogLevel {
+    /// Only errors
+    Error,
+    /// Errors and warnings
+    Warn,
+    /// Errors, warnings and progress notes (default)
+    #[default]
+    Info,
+    /// Everything, including gh call timings and filter reductions
+    Debug,
+}
+
+impl LogLevel {
+    /// Returns the prefix printed before messages at this level.
+    ///
+    /// Info messages are printed as-is so regular progress notes read the
+    /// same as before leveled logging existed.
+    fn prefix(&self) -> &'static str {
+        match self {
+            LogLevel::Error => "Error: ",
+            LogLevel::Warn => "Warning: ",
+            LogLevel::Info => "",
+            LogLevel::Debug => "Debug: ",
+        }
+    }
+}
+
+/// Process-wide maximum level that is emitted.
+static LEVEL: AtomicU8 = AtomicU8::new(LogLevel::Info as u8);
+
+thread_local! {
+    /// Active capture on this thread: its level and the captured messages.
+    static CAPTURE: RefCell<Option<(LogLevel, Vec<String>)>> = const { RefCell::new(None) };
+}
+
+/// Sets the process-wide log level.
+pub fn set_level(level: LogLevel) {
+    LEVEL.store(level as u8, Ordering::Relaxed);
+}
+
+/// Returns the process-wide log level.
+pub fn level() -> LogLevel {
+    match LEVEL.load(Ordering::Relaxed) {
+        0 => LogLevel::Error,
+        1 => LogLevel::Warn,
+        2 => LogLevel::Info,
+        _ => LogLevel::Debug,
+    }
+}
+
+/// Returns true if messages at `level` would be emitted on this thread.
+pub fn enabled(level: LogLevel) -> bool {
+    let max = CAPTURE.with(|c| c.borrow().as_ref().map(|(l, _)| *l));
+    level <= max.unwrap_or_else(self::level)
+}
+
+/// Emits a message at `level` if enabled.
+///
+/// Prefer the `log_*!` macros, which skip formatting disabled messages.
+pub fn log(level: LogLevel, message: fmt::Arguments) {
+    if !enabled(level) {
+        return;
+    }
+    let line = format!("{}{message}", level.prefix());
+    let captured = CAPTURE.with(|c| match c.borrow_mut().as_mut() {
+        Some((_, messages)) => {
+            messages.push(line.clone());
+            true
+        }
+        None => false,
+    });
+    if !captured {
+        eprintln!("{line}");
+    }
+}
+
+/// Runs `f` with messages up to `level` captured on this thread, and
+/// returns them instead of writing them to stderr.
+pub fn capture<F: FnOnce()>(level: LogLevel, f: F) -> Vec<String> {
+    CAPTURE.with(|c| *c.borrow_mut() = Some((level, Vec::new())));
+    f();
+    CAPTURE
+        .with(|c| c.borrow_mut().take())
+        .map(|(_, messages)| messages)
+        .unwrap_or_default()
+}
+
+/// Logs an error message.
+#[macro_export]
+macro_rules! log_error {
+    ($($arg:tt)*) => {
+        $crate::logging::log($crate::logging::LogLevel::Error, format_args!($($arg)*))
+    };
+}
+
+/// Logs a warning message.
+#[macro_export]
+macro_rules! log_warn {
+    ($($arg:tt)*) => {
+        $crate::logging::log($crate::logging::LogLevel::Warn, format_args!($($arg)*))
+    };
+}
+
+/// Logs an informational message.
+#[macro_export]
+macro_rules! log_info {
+    ($($arg:tt)*) => {
+        $crate::logging::log($crate::logging::LogLevel::Info, format_args!($($arg)*))
+    };
+}
+
+/// Logs a debug message.
+#[macro_export]
+macro_rules! log_debug {
+    ($($arg:tt)*) => {
+        $crate::logging::log($crate::logging::LogLevel::Debug, format_args!($($arg)*))
+    };
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_level_ordering() {
+        assert!(LogLevel::Error < LogLevel::Warn);
+        assert!(LogLevel::Warn < LogLevel::Info);
+        assert!(LogLevel::Info < LogLevel::Debug);
+    }
+
+    #[test]
+    fn test_capture_filters_by_level() {
+        let messages = capture(LogLevel::Warn, || {
+            log_error!("boom");
+            log_warn!("careful");
+            log_info!("progress");
+            log_debug!("details");
+        });
+        assert_eq!(messages, vec!["Error: boom", "Warning: careful"]);
+    }
+
+    #[test]
+    fn test_capture_debug_prefixes() {
+        let messages = capture(LogLevel::Debug, || {
+            log_info!("Output written to {}", "out.md");
+            log_debug!("{} -> {} comments", 5, 3);
+        });
+        assert_eq!(
+            messages,
+            vec!["Output written to out.md", "Debug: 5 -> 3 comments"]
+        );
+    }
+
+    #[test]
+    fn test_capture_ends_after_closure() {
+        let _ = capture(LogLevel::Debug, || {});
+        assert!(CAPTURE.with(|c| c.borrow().is_none()));
+    }
+
+    #[test]
+    fn test_set_level_round_trip() {
+        // Only this test touches the global level; restore it afterwards
+        let original = level();
+        for l in [
+            LogLevel::Error,
+            LogLevel::Warn,
+            LogLevel::Info,
+            LogLevel::Debug,
+        ] {
+            set_level(l);
+            assert_eq!(level(), l);
+        }
+        set_level(original);
+    }
+
+    #[test]
+    fn test_log_to_stderr_when_not_capturing() {
+        // Disabled messages are dropped; enabled ones go to stderr
+        log(LogLevel::Error, format_args!("logging test message"));
+        log_debug!("dropped unless the global level is debug");
+    }
+}
@@ -1,58 +1,198 @@
 //! PR Comments CLI - Fetch and format GitHub PR comments for LLM consumption.
 
-use clap::Parser;
+use clap::{ArgMatches, CommandFactory, FromArgMatches};
 use pr_comments::{
-    cli::{resolve_pr_args, Args, OutputFormat, REPO_URL},
-    fetcher::{fetch_pr_checks, fetch_pr_comments, fetch_pr_info, fetch_pr_reviews},
+    cli::{
+        resolve_config, resolve_pr_args, resolve_pr_host, Args, GroupBy, GroupExpr, OutputFormat,
+        REPO_URL,
+    },
+    clipboard::copy_to_clipboard,
+    config::discover_config,
+    fetcher::{
+        fetch_concurrently, fetch_file_source, fetch_issue_comments, fetch_my_last_review_time,
+        fetch_pr_checks, fetch_pr_comments, fetch_pr_files, fetch_pr_info,
+        fetch_pr_info_best_effort, fetch_pr_reviews, fetch_resolved_state, resolve_current_pr,
+        set_network_hostname, set_network_options, FileSourceCache, IssueTitleCache,
+        NetworkOptions,
+    },
     formatter::{
-        format_as_json, format_checks_as_json, format_checks_for_claude, format_checks_minimal,
-        format_comments_flat, format_comments_grouped, format_comments_minimal, format_for_claude,
+        build_front_matter, format_annotated_source, format_checks_as_json,
+        format_checks_for_claude, format_checks_minimal, format_comments_by_severity,
+        format_comments_with, format_counts, format_coverage, format_for_claude_by_severity,
+        format_review_stats, json_output_schema, limit_in_render_order, lookup_format,
+        output_size_summary, truncate_output, with_links, with_stats, with_tasks, wrap_with_files,
+        write_atomic, write_comment_files, FormatOptions,
     },
+    log_debug, log_error, log_info, log_warn,
+    logging::set_level,
+    models::{PRComment, PRInfo, GENERAL_FILE_PATH},
     parser::{
-        filter_by_author, get_most_recent_per_file, parse_checks_response, parse_comments,
-        parse_review_comments,
+        apply_resolved_state, compute_stats, count_by, dedupe_comments, filter_blocking,
+        filter_bots, filter_by_author_regex, filter_by_authors, filter_by_file_glob,
+        filter_by_line, filter_by_line_range, filter_by_min_severity, filter_by_snippet_regex,
+        filter_changed_files, filter_hotspots, filter_human_responses_to_bots, filter_orphaned,
+        filter_resolved, filter_suggestions, filter_test_files, filter_test_requests,
+        filter_updated_since, filter_with_links, get_most_recent_per_file, group_by_file,
+        inline_issue_titles, limit_comments, limit_most_recent, parse_all_comments,
+        parse_checks_response, parse_comments_file, parse_issue_comments, parse_pr_files,
+        parse_pr_info, review_coverage, sort_by_agent_order, sort_by_diff_order, sort_comments,
+        test_file_matcher,
     },
+    remap::remap_lines,
+    sanitizer::redact_secrets,
+    selftest::run_selftest,
+    snapshot::{default_cache_dir, emitted_comments, load_snapshot, save_snapshot, snapshot_path},
 };
-use std::fs;
+use serde_json::Value;
+use std::collections::HashMap;
 use std::io::{self, Write};
+use std::path::{Path, PathBuf};
 use std::process::{Command, ExitCode};
+use std::time::Duration;
 
 fn main() -> ExitCode {
-    let args = Args::parse();
+    let matches = Args::command().get_matches();
+    let args = Args::from_arg_matches(&matches).unwrap_or_else(|e| e.exit());
+    set_level(args.log_level);
+    let args = match resolve_args(args, &matches) {
+        Ok(args) => args,
+        Err(e) => {
+            log_error!("{e}");
+            return ExitCode::FAILURE;
+        }
+    };
+    set_network_options(NetworkOptions {
+        proxy: args.proxy.clone(),
+        ca_bundle: args.ca_bundle.clone(),
+        retries: args.retries,
+        hostname: resolve_pr_host(&args),
+        timeout: (args.timeout > 0).then(|| Duration::from_secs(args.timeout)),
+    });
 
     match run(args) {
-        Ok(()) => ExitCode::SUCCESS,
+        Ok(code) => code,
         Err(e) => {
-            eprintln!("Error: {e}");
+            log_error!("{e}");
             ExitCode::FAILURE
         }
     }
 }
 
-fn run(args: Args) -> Result<(), Box<dyn std::error::Error>> {
+/// Layers defaults under the explicit flags: PR_COMMENTS_* variables first,
+/// then the config file, then built-in defaults.
+fn resolve_args(args: Args, matches: &ArgMatches) -> Result<Args, Box<dyn std::error::Error>> {
+    let config = discover_config(args.config.as_deref().map(Path::new), Path::new("."))?;
+    let args = config.apply(args, matches);
+    Ok(resolve_config(args, matches, |key| {
+        std::env::var(key).ok()
+    })?)
+}
+
+fn run(args: Args) -> Result<ExitCode, Box<dyn std::error::Error>> {
     // Handle self-update before resolving PR arguments
     if args.is_update_request() {
-        return run_update();
+        run_update()?;
+        return Ok(ExitCode::SUCCESS);
+    }
+
+    // Offline pipeline check, no PR or network needed
+    if args.selftest {
+        io::stdout().write_all(run_selftest()?.as_bytes())?;
+        return Ok(ExitCode::SUCCESS);
+    }
+
+    // Schema for validating JSON output in pipelines
+    if args.emit_schema {
+        let schema = serde_json::to_string_pretty(&json_output_schema())?;
+        io::stdout().write_all(format!("{schema}\n").as_bytes())?;
+        return Ok(ExitCode::SUCCESS);
     }
 
     // Resolve PR arguments
-    let (owner, repo, pr_number) = resolve_pr_args(&args)?;
+    let (owner, repo, pr_number) = if args.current {
+        let (host, owner, repo, pr_number) = resolve_current_pr()?;
+        // The checkout's remote may be a GitHub Enterprise host; --host wins
+        if args.host.is_none() {
+            set_network_hostname(host);
+        }
+        (owner, repo, pr_number)
+    } else {
+        resolve_pr_args(&args)?
+    };
 
-    let output = if args.checks {
-        run_checks(&owner, &repo, pr_number, &args)?
+    let (output, shown) = if args.checks {
+        (run_checks(&owner, &repo, pr_number, &args)?, Vec::new())
     } else {
         run_comments(&owner, &repo, pr_number, &args)?
     };
+    let code = if args.checks {
+        ExitCode::SUCCESS
+    } else {
+        ExitCode::from(args.exit_code_for_comments(shown.len()))
+    };
+
+    // Size cap for paste targets and context windows, whatever the format
+    let (output, truncated) = match args.max_output_bytes {
+        Some(max) if output.len() > max => {
+            log_warn!("output is {} bytes, truncating to {max}", output.len());
+            let (output, notice) = truncate_output(&output, max, args.format.name());
+            if let Some(notice) = notice {
+                log_warn!("{notice}");
+            }
+            (output, true)
+        }
+        _ => (output, false),
+    };
+
+    if args.show_size {
+        log_info!("{}", output_size_summary(&output));
+    }
 
     // Write output
     if let Some(output_path) = &args.output {
-        fs::write(output_path, &output)?;
-        eprintln!("Output written to {output_path}");
+        write_atomic(Path::new(output_path), &output)?;
+        log_info!("Output written to {output_path}");
+    } else if args.clipboard {
+        match copy_to_clipboard(&output) {
+            Ok(tool) => log_info!("Output copied to clipboard ({tool})"),
+            Err(e) => {
+                log_warn!("could not copy to clipboard, printing instead: {e}");
+                io::stdout().write_all(output.as_bytes())?;
+            }
+        }
     } else {
         io::stdout().write_all(output.as_bytes())?;
     }
 
-    Ok(())
+    // Only once the output is out, so comments lost to a failed write or
+    // cut by --max-output-bytes are shown again next time
+    if args.only_new {
+        let path = only_new_snapshot_path(&owner, &repo, pr_number)?;
+        let mut snapshot = load_snapshot(&path)?;
+        snapshot.record(emitted_comments(&shown, &output, truncated));
+        save_snapshot(&path, &snapshot)?;
+    }
+
+    Ok(code)
+}
+
+/// Returns the computed grouping key for the formatters, if not by file.
+fn group_expr(args: &Args) -> Option<GroupExpr> {
+    match args.group_by {
+        GroupBy::Author => Some(GroupExpr::Author),
+        GroupBy::Severity => Some(GroupExpr::Severity),
+        GroupBy::File => args.group_by_expr,
+    }
+}
+
+/// Returns the `--only-new` snapshot file for a PR.
+fn only_new_snapshot_path(
+    owner: &str,
+    repo: &str,
+    pr_number: i32,
+) -> Result<PathBuf, Box<dyn std::error::Error>> {
+    let cache_dir = default_cache_dir().ok_or("Cannot locate a cache directory (HOME is unset)")?;
+    Ok(snapshot_path(&cache_dir, owner, repo, pr_number))
 }
 
 fn run_checks(
@@ -68,14 +208,21 @@ fn run_checks(
         OutputFormat::Claude => format_checks_for_claude(&report),
         OutputFormat::Json => format_checks_as_json(&report),
         OutputFormat::Minimal => format_checks_minimal(&report),
-        OutputFormat::Grouped | OutputFormat::Flat => {
-            eprintln!(
+        OutputFormat::Grouped
+        | OutputFormat::Flat
+        | OutputFormat::Html
+        | OutputFormat::Plain
+        | OutputFormat::AgeBuckets
+        | OutputFormat::NdjsonEvents
+        | OutputFormat::Mentions
+        | OutputFormat::Table
+        | OutputFormat::Csv
+        | OutputFormat::Ndjson
+        | OutputFormat::Text
+        | OutputFormat::Slack => {
+            log_info!(
                 "Note: --format {} is not supported with --checks, using claude format",
-                match args.format {
-                    OutputFormat::Grouped => "grouped",
-                    OutputFormat::Flat => "flat",
-                    _ => unreachable!(),
-                }
+                args.format.name()
             );
             format_checks_for_claude(&report)
         }
@@ -85,7 +232,7 @@ fn run_checks(
 }
 
 fn run_update() -> Result<(), Box<dyn std::error::Error>> {
-    eprintln!("Updating pr-comments from {REPO_URL}...");
+    log_info!("Updating pr-comments from {REPO_URL}...");
 
     let status = Command::new("cargo")
         .args(["install", "--git", REPO_URL])
@@ -93,74 +240,501 @@ fn run_update() -> Result<(), Box<dyn std::error::Error>> {
         .map_err(|e| format!("Failed to run cargo. Is the Rust toolchain installed?\n  {e}"))?;
 
     if status.success() {
-        eprintln!("pr-comments updated successfully!");
+        log_info!("pr-comments updated successfully!");
         Ok(())
     } else {
         Err(format!("cargo install exited with status: {status}").into())
     }
 }
 
+/// Raw review comments and reviews for a PR, with its info, before parsing.
+struct PrData {
+    comments: Vec<Value>,
+    reviews: Vec<Value>,
+    info: PRInfo,
+}
+
+/// Loads raw review comments, reviews and PR info: from `--from-file`
+/// without touching the network, otherwise from the GitHub API.
+fn load_pr_data(
+    owner: &str,
+    repo: &str,
+    pr_number: i32,
+    args: &Args,
+) -> Result<PrData, Box<dyn std::error::Error>> {
+    if let Some(path) = &args.from_file {
+        let online = args.api_only_flags();
+        if !online.is_empty() {
+            return Err(format!(
+                "--from-file replays comments offline and can't be combined with {}",
+                online.join(", ")
+            )
+            .into());
+        }
+        let contents = std::fs::read_to_string(path)
+            .map_err(|e| format!("Failed to read comments file {path}: {e}"))?;
+        return Ok(PrData {
+            comments: parse_comments_file(&contents)?,
+            reviews: Vec::new(),
+            info: PRInfo {
+                title: args.pr_title.clone(),
+                url: args.pr_url.clone(),
+                ..PRInfo::default()
+            },
+        });
+    }
+
+    // Fetch line-specific comments and reviews alongside PR info. PR info
+    // only decorates the output, so it is best-effort unless required
+    let ((comments, reviews), info) = fetch_concurrently(
+        || {
+            Ok((
+                fetch_pr_comments(owner, repo, pr_number)?,
+                fetch_pr_reviews(owner, repo, pr_number)?,
+            ))
+        },
+        || {
+            if args.require_pr_info {
+                fetch_pr_info(owner, repo, pr_number).map(|info| parse_pr_info(&info))
+            } else {
+                Ok(fetch_pr_info_best_effort(owner, repo, pr_number))
+            }
+        },
+    )?;
+    Ok(PrData {
+        comments,
+        reviews,
+        info,
+    })
+}
+
+/// Fetches, filters and formats review comments, returning the output and
+/// the comments it covers.
 fn run_comments(
     owner: &str,
     repo: &str,
     pr_number: i32,
     args: &Args,
-) -> Result<String, Box<dyn std::error::Error>> {
-    // Fetch line-specific comments, reviews, and PR info
-    let raw_comments = fetch_pr_comments(owner, repo, pr_number)?;
-    let raw_reviews = fetch_pr_reviews(owner, repo, pr_number)?;
-    let pr_info = fetch_pr_info(owner, repo, pr_number)?;
+) -> Result<(String, Vec<PRComment>), Box<dyn std::error::Error>> {
+    let PrData {
+        comments: raw_comments,
+        reviews: raw_reviews,
+        info: pr_info,
+    } = load_pr_data(owner, repo, pr_number, args)?;
+    let raw_general = if args.include_general {
+        fetch_issue_comments(owner, repo, pr_number)?
+    } else {
+        Vec::new()
+    };
+
+    // The PR's changed files, fetched only when an option needs them
+    let pr_files = if args.diff_order
+        || args.show_diff_stats
+        || args.orphaned_only
+        || args.only_changed_files
+        || args.coverage
+    {
+        parse_pr_files(&fetch_pr_files(owner, repo, pr_number)?)
+    } else {
+        Vec::new()
+    };
+
+    // Parse line-specific comments and merge review-level comments
+    let mut comments = parse_all_comments(&raw_comments, &raw_reviews);
+    comments.extend(parse_issue_comments(&raw_general));
+    log_debug!("parsed {} comment(s)", comments.len());
+
+    // Move positions to the current working tree before line-based filters
+    if let Some(patch_path) = &args.remap_with {
+        let patch = std::fs::read_to_string(patch_path)
+            .map_err(|e| format!("Failed to read patch {patch_path}: {e}"))?;
+        let (remapped, deleted) = remap_lines(comments, &patch);
+        comments = remapped;
+        if deleted > 0 {
+            log_warn!("{deleted} comment(s) are on lines the patch deletes, marked outdated");
+        }
+    }
 
-    // Parse line-specific comments
-    let mut comments = parse_comments(&raw_comments);
+    // Force-push duplicates, collapsed before any filter sees them
+    if args.dedupe {
+        comments = apply_filter("dedupe", comments, dedupe_comments);
+    }
+
+    // Re-review: only what changed since a given time
+    if let Some(cutoff) = args.since {
+        comments = apply_filter("since", comments, |c| filter_updated_since(c, cutoff, true));
+    }
+
+    // Second-pass review: only what changed since my last review
+    if args.since_review {
+        match fetch_my_last_review_time(owner, repo, pr_number)? {
+            Some(since) => {
+                comments = apply_filter("since-review", comments, |c| {
+                    filter_updated_since(c, since, false)
+                })
+            }
+            None => log_info!("Note: no submitted review found for you, showing all comments"),
+        }
+    }
+
+    // Trim bot-started threads down to human engagement (before author
+    // filtering, which would otherwise break up threads)
+    if args.human_responses_to_bots {
+        comments = apply_filter(
+            "human-responses-to-bots",
+            comments,
+            filter_human_responses_to_bots,
+        );
+    }
+
+    // Apply author filter; a regex takes precedence over exact logins
+    if let Some(pattern) = &args.author_regex {
+        if !args.author.is_empty() {
+            log_warn!("--author is ignored when --author-regex is given");
+        }
+        comments = apply_filter("author-regex", comments, |c| {
+            filter_by_author_regex(c, pattern)
+        });
+    } else if !args.author.is_empty() {
+        comments = apply_filter("author", comments, |c| filter_by_authors(c, &args.author));
+    }
 
-    // Parse and merge review-level comments (reviews with body text)
-    let review_comments = parse_review_comments(&raw_reviews);
-    comments.extend(review_comments);
+    if let Some(glob) = &args.file {
+        comments = apply_filter("file", comments, |c| filter_by_file_glob(c, glob));
+    }
+
+    // Thread resolution isn't in the REST payload, so it's fetched separately
+    if args.resolved || args.unresolved {
+        let state = fetch_resolved_state(owner, repo, pr_number)?;
+        comments = apply_resolved_state(comments, &state);
+        let (name, resolved) = if args.resolved {
+            ("resolved", true)
+        } else {
+            ("unresolved", false)
+        };
+        comments = apply_filter(name, comments, |c| filter_resolved(c, &state, resolved));
+    }
+
+    if args.no_bots {
+        comments = apply_filter("no-bots", comments, filter_bots);
+    }
+
+    // Severity is inferred from keywords in the body
+    if let Some(min) = args.min_severity {
+        comments = apply_filter("min-severity", comments, |c| filter_by_min_severity(c, min));
+    }
 
-    // Apply author filter
-    if args.author.is_some() {
-        comments = filter_by_author(comments, args.author.as_deref());
+    // Apply line filter
+    if let Some(line) = args.line {
+        comments = apply_filter("line", comments, |c| filter_by_line(c, line));
+    }
+
+    // Only the region being edited
+    if args.min_line.is_some() || args.max_line.is_some() {
+        comments = apply_filter("line-range", comments, |c| {
+            filter_by_line_range(c, args.min_line, args.max_line)
+        });
+    }
+
+    // Keep comments whose code context touches a symbol
+    if let Some(pattern) = &args.snippet_grep {
+        comments = apply_filter("snippet-grep", comments, |c| {
+            filter_by_snippet_regex(c, pattern)
+        });
+    }
+
+    // Keep comments that point at docs, related PRs and the like
+    if args.with_links {
+        comments = apply_filter("with-links", comments, filter_with_links);
+    }
+
+    if args.blocking_only {
+        comments = apply_filter("blocking-only", comments, filter_blocking);
+    }
+
+    // Comments that come with a ready-made fix
+    if args.suggestions_only {
+        comments = apply_filter("suggestions-only", comments, filter_suggestions);
+    }
+
+    if args.test_requests_only {
+        comments = apply_filter("test-requests-only", comments, filter_test_requests);
+    }
+
+    // Keep only comments on files that have left the diff
+    if args.orphaned_only {
+        comments = apply_filter("orphaned", comments, |c| filter_orphaned(c, &pr_files));
+    }
+
+    // Drop stale comments on files that have left the diff
+    if args.only_changed_files {
+        comments = apply_filter("only-changed-files", comments, |c| {
+            filter_changed_files(c, &pr_files)
+        });
+    }
+
+    // Focus on production code
+    if args.exclude_tests {
+        let matcher = test_file_matcher(&args.test_glob);
+        comments = apply_filter("exclude-tests", comments, |c| {
+            filter_test_files(c, &matcher)
+        });
+    }
+
+    // Keep only clusters of nearby comments, the PR's problem areas
+    if let Some(min_comments) = args.hotspots_only {
+        comments = apply_filter("hotspots", comments, |c| {
+            filter_hotspots(c, min_comments, args.hotspot_radius)
+        });
     }
 
     // Apply most-recent filter
     if args.most_recent {
-        comments = get_most_recent_per_file(comments);
-    }
-
-    // Get PR info for formatting
-    let pr_url = pr_info
-        .get("html_url")
-        .and_then(|v| v.as_str())
-        .map(String::from);
-    let pr_title = pr_info
-        .get("title")
-        .and_then(|v| v.as_str())
-        .map(String::from);
-    // GraphQL node ID for the PR (used for replying to comments via GraphQL API)
-    let pr_node_id = pr_info
-        .get("node_id")
-        .and_then(|v| v.as_str())
-        .map(String::from);
-
-    // Format output
-    let include_snippet = !args.no_snippet;
-    let output = match args.format {
-        OutputFormat::Claude => format_for_claude(
-            &comments,
-            pr_url.as_deref(),
-            pr_title.as_deref(),
-            pr_node_id.as_deref(),
-            include_snippet,
-            args.snippet_lines,
-        ),
-        OutputFormat::Grouped => {
-            format_comments_grouped(&comments, include_snippet, args.snippet_lines)
+        comments = apply_filter("most-recent", comments, get_most_recent_per_file);
+    }
+
+    // Iterative review: drop comments shown in earlier --only-new runs
+    if args.only_new {
+        let snapshot = load_snapshot(&only_new_snapshot_path(owner, repo, pr_number)?)?;
+        let (new, unchanged) = snapshot.diff(comments);
+        log_info!("Note: {unchanged} comment(s) unchanged since the last run");
+        comments = new;
+    }
+
+    // Annotate issue references, fetching each title once, after filtering
+    // so only shown comments cost API calls
+    if args.resolve_issues {
+        let mut titles = IssueTitleCache::default();
+        for comment in &mut comments {
+            comment.body =
+                inline_issue_titles(&comment.body, owner, repo, |issue| titles.title(issue));
         }
-        OutputFormat::Flat => format_comments_flat(&comments, include_snippet, args.snippet_lines),
-        OutputFormat::Minimal => format_comments_minimal(&comments),
-        OutputFormat::Json => format_as_json(&comments, include_snippet, args.snippet_lines),
+    }
+
+    // Order comments (and file groups) to match the PR diff
+    let file_order = if args.diff_order {
+        let order: Vec<String> = pr_files.iter().map(|f| f.filename.clone()).collect();
+        comments = sort_by_diff_order(comments, &order);
+        Some(order)
+    } else {
+        None
     };
 
-    Ok(output)
+    // Explicit sort order, kept by the formatters within each file group
+    if let Some(key) = args.sort {
+        comments = sort_comments(comments, key);
+    }
+
+    // Bottom-to-top within each file, so agents' edits don't shift later lines
+    if args.agent_order {
+        comments = sort_by_agent_order(comments);
+    }
+
+    // Cap the comment count after sorting: the most recent for flat output
+    // (which lists newest first), else the first N as they will be rendered
+    let keep_order = args.sort.is_some() || args.agent_order;
+    if args.limit > 0 {
+        comments = apply_filter("limit", comments, |c| match args.format {
+            OutputFormat::Flat if keep_order => limit_comments(c, args.limit),
+            OutputFormat::Flat => limit_most_recent(c, args.limit),
+            _ => {
+                let order = FormatOptions {
+                    file_order: file_order.clone(),
+                    group_by: group_expr(args),
+                    keep_order,
+                    ..FormatOptions::default()
+                };
+                limit_in_render_order(c, args.limit, &order)
+            }
+        });
+    }
+
+    // Full-file context for shown comments only, fetched once per file
+    if let Some(radius) = args.full_context {
+        let mut sources = FileSourceCache::default();
+        for comment in &mut comments {
+            if let (Some(line), false) = (comment.line_number, comment.is_general()) {
+                comment.file_context = sources.context(
+                    owner,
+                    repo,
+                    &comment.file_path,
+                    pr_info.head_sha.as_deref(),
+                    line,
+                    radius,
+                );
+            }
+        }
+    }
+
+    // Scrub secrets from bodies and snippets before anything is rendered
+    if args.redact {
+        for comment in &mut comments {
+            comment.body = redact_secrets(&comment.body);
+            comment.diff_hunk = redact_secrets(&comment.diff_hunk);
+            comment.file_context = comment.file_context.as_deref().map(redact_secrets);
+        }
+    }
+
+    // Review coverage replaces the formatted comments entirely
+    if args.coverage {
+        let report = format_coverage(&review_coverage(&comments, &pr_files));
+        return Ok((report, comments));
+    }
+
+    // Full files with inline comments replace the formatted comments entirely
+    if args.fetch_source {
+        let grouped = group_by_file(&comments);
+        let mut paths: Vec<&String> = grouped
+            .keys()
+            .filter(|p| !p.is_empty() && p.as_str() != GENERAL_FILE_PATH)
+            .collect();
+        paths.sort();
+
+        let mut output = String::new();
+        for path in paths {
+            match fetch_file_source(owner, repo, path, pr_info.head_sha.as_deref()) {
+                Ok(source) => {
+                    let source = if args.redact {
+                        redact_secrets(&source)
+                    } else {
+                        source
+                    };
+                    output.push_str(&format_annotated_source(path, &source, &grouped[path]));
+                    output.push('\n');
+                }
+                Err(e) => log_warn!("could not fetch {path}, skipping it: {e}"),
+            }
+        }
+        return Ok((output, comments));
+    }
+
+    // Tallies replace the formatted comments entirely
+    if let Some(key) = args.count_by {
+        let tallies = format_counts(&count_by(&comments, |c| key.key_for(c)));
+        return Ok((tallies, comments));
+    }
+
+    // A summary in place of the comments
+    if args.stats {
+        let summary = format_review_stats(&compute_stats(&comments));
+        return Ok((summary, comments));
+    }
+
+    // Format output via the formatter registry
+    let pr_state = pr_info.state_summary();
+    let options = FormatOptions {
+        pr_url: pr_info.url,
+        pr_title: pr_info.title,
+        // GraphQL node ID for the PR (used for replying to comments via GraphQL API)
+        pr_node_id: pr_info.node_id,
+        pr_ref: Some(format!("{owner}/{repo}#{pr_number}")),
+        pr_state,
+        pr_author: pr_info.author,
+        pr_branches: if args.include_branches {
+            pr_info.base_ref.zip(pr_info.head_ref)
+        } else {
+            None
+        },
+        include_snippet: !args.no_snippet,
+        snippet_lines: args.snippet_lines,
+        context_window: args.context_window(),
+        wrap_snippet: args.wrap_snippet,
+        annotate_diff: args.annotate_diff,
+        snippet_after: args.snippet_after,
+        mention_bots: args.mention_bots,
+        file_order,
+        html_avatars: args.html_avatars,
+        keep_order,
+        line_references: args.line_refs,
+        normalize_bot_names: args.normalize_bot_names,
+        include_raw_diff: args.include_raw_diff,
+        max_body_chars: args.max_body_chars,
+        collapse_blank_lines: args.collapse_blank_lines,
+        group_by: group_expr(args),
+        show_word_count: args.show_word_count,
+        keep_ansi: args.keep_ansi,
+        flag_test_requests: args.flag_test_requests,
+        // Every comment is blocking once filtered down to them
+        flag_blocking: !args.blocking_only,
+        empty_message: args.empty_message.clone(),
+        diff_stats: if args.show_diff_stats {
+            pr_files
+                .iter()
+                .map(|f| (f.filename.clone(), (f.additions, f.deletions)))
+                .collect()
+        } else {
+            HashMap::new()
+        },
+    };
+    let format_name = args.format.name();
+
+    // One file per comment; print the written paths for downstream tooling
+    if let Some(dir) = &args.split_by_comment {
+        let paths = write_comment_files(Path::new(dir), format_name, &comments, &options)?;
+        log_info!("Wrote {} comment file(s) to {dir}", paths.len());
+        let listing = paths.iter().map(|p| format!("{}\n", p.display())).collect();
+        return Ok((listing, comments));
+    }
+
+    let lookup =
+        |name: &str| lookup_format(name).ok_or_else(|| format!("Unknown output format: {name}"));
+    // The severity sections are a markdown layout; other formats (JSON,
+    // CSV, HTML, ...) keep their own shape, grouped by severity if they group
+    let mut format_fns = vec![match (args.group_by, args.format) {
+        (GroupBy::Severity, OutputFormat::Claude) => format_for_claude_by_severity,
+        (GroupBy::Severity, OutputFormat::Grouped) => format_comments_by_severity,
+        _ => lookup(format_name)?,
+    }];
+    // A second artifact (e.g. JSON for machines) alongside the primary output
+    if let Some(also_format) = args.also_format {
+        format_fns.push(lookup(also_format.name())?);
+    }
+    let mut outputs = format_comments_with(&format_fns, &comments, &options);
+    let mut output = outputs.remove(0);
+    if let (Some(also_output), Some(also)) = (&args.also_output, outputs.pop()) {
+        write_atomic(Path::new(also_output), &also)?;
+        log_info!("Secondary output written to {also_output}");
+    }
+
+    if args.with_stats {
+        output = with_stats(&comments, &output);
+    }
+
+    if args.extract_tasks {
+        output = with_tasks(&comments, &output);
+    }
+
+    if args.extract_links {
+        output = with_links(&comments, &output);
+    }
+
+    // Front matter must come first, ahead of any stats summary
+    if args.front_matter {
+        let front_matter =
+            build_front_matter(owner, repo, pr_number, comments.len(), chrono::Utc::now());
+        output = format!("{front_matter}{output}");
+    }
+
+    // Caller-supplied wrapper content goes outermost
+    if args.prepend_file.is_some() || args.append_file.is_some() {
+        output = wrap_with_files(
+            &output,
+            args.prepend_file.as_deref().map(Path::new),
+            args.append_file.as_deref().map(Path::new),
+        )?;
+    }
+
+    Ok((output, comments))
+}
+
+/// Applies a comment filter, logging how many comments it removed.
+fn apply_filter(
+    name: &str,
+    comments: Vec<PRComment>,
+    filter: impl FnOnce(Vec<PRComment>) -> Vec<PRComment>,
+) -> Vec<PRComment> {
+    let before = comments.len();
+    let filtered = filter(comments);
+    log_debug!("{name} filter: {before} -> {} comment(s)", filtered.len());
+    filtered
 }
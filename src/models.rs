@@ -4,6 +4,9 @@ use chrono::{DateTime, Utc};
 use serde::{Deserialize, Serialize};
 use std::fmt;
 
+/// File path given to PR conversation comments, which have no file.
+pub const GENERAL_FILE_PATH: &str = "(general)";
+
 /// Represents a parsed pull request comment from GitHub.
 #[derive(Debug, Clone, Serialize, Deserialize, PartialEq)]
 pub struct PRComment {
@@ -19,10 +22,35 @@ pub struct PRComment {
     pub updated_at: DateTime<Utc>,
     pub diff_hunk: String,
     pub html_url: String,
+    /// ID of the comment this one replies to, if it is part of a review thread.
+    pub in_reply_to_id: Option<i64>,
+    /// Avatar image URL of the comment author (`user.avatar_url`).
+    pub author_avatar_url: Option<String>,
+    /// Reaction counts on the comment.
+    pub reactions: Reactions,
+    /// True when the line came from `original_line` because the code has
+    /// since changed, so the position may be stale.
+    pub outdated: bool,
+    /// True when the comment's review thread is marked resolved. Only known
+    /// after merging in thread state from GraphQL.
+    pub resolved: bool,
+    /// ID of the review this comment was submitted with
+    /// (`pull_request_review_id`; a review's own ID for review bodies).
+    pub review_id: Option<i64>,
+    /// True when the comment belongs to a review that requested changes,
+    /// so it blocks merging.
+    pub blocking: bool,
+    /// Lines around the comment from the full file at the PR head, shown
+    /// instead of the diff hunk when set (`--full-context`).
+    pub file_context: Option<String>,
 }
 
 impl PRComment {
-    /// Creates a new PRComment with all fields.
+    /// Creates a new PRComment from the core API fields.
+    ///
+    /// Optional metadata (such as `in_reply_to_id` or `author_avatar_url`)
+    /// defaults to None and can be
+    /// set on the returned value.
     #[allow(clippy::too_many_arguments)]
     pub fn new(
         id: i64,
@@ -49,17 +77,125 @@ impl PRComment {
             updated_at,
             diff_hunk,
             html_url,
+            in_reply_to_id: None,
+            author_avatar_url: None,
+            reactions: Reactions::default(),
+            outdated: false,
+            resolved: false,
+            review_id: None,
+            blocking: false,
+            file_context: None,
+        }
+    }
+
+    /// Returns the GitHub profile URL of the comment author.
+    ///
+    /// Bot accounts are GitHub Apps, whose pages live under `/apps/`.
+    pub fn author_profile_url(&self) -> String {
+        if self.is_bot() {
+            format!("https://github.com/apps/{}", self.display_author(true))
+        } else {
+            format!("https://github.com/{}", self.author)
         }
     }
 
+    /// Returns the net reaction score: endorsements (+1, heart, hooray) minus
+    /// objections (-1, confused).
+    pub fn reaction_score(&self) -> i64 {
+        let r = &self.reactions;
+        r.plus_one + r.heart + r.hooray - r.minus_one - r.confused
+    }
+
+    /// Returns the author name for display.
+    ///
+    /// With `normalize_bot_names`, a trailing "[bot]" is stripped (e.g.
+    /// "devin-ai-integration[bot]" becomes "devin-ai-integration"). The real
+    /// login in `author` is unchanged for filtering and JSON output.
+    pub fn display_author(&self, normalize_bot_names: bool) -> &str {
+        if normalize_bot_names && self.is_bot() {
+            &self.author[..self.author.len() - "[bot]".len()]
+        } else {
+            &self.author
+        }
+    }
+
+    /// Returns true if the comment was written by a bot account.
+    ///
+    /// GitHub App accounts have logins ending in "[bot]" (e.g.
+    /// "devin-ai-integration[bot]"); the suffix is matched case-insensitively.
+    pub fn is_bot(&self) -> bool {
+        self.author.to_ascii_lowercase().ends_with("[bot]")
+    }
+
+    /// Infers the comment's severity from keywords in its body.
+    ///
+    /// Bot reviewers and humans commonly prefix findings with words like
+    /// "Potential issue", "nitpick" or "suggestion"; the most severe match wins.
+    pub fn infer_severity(&self) -> Severity {
+        let body = self.body.to_lowercase();
+        SEVERITY_KEYWORDS
+            .iter()
+            .find(|(_, keywords)| keywords.iter().any(|k| contains_word(&body, k)))
+            .map(|(severity, _)| *severity)
+            .unwrap_or(Severity::None)
+    }
+
+    /// Returns true if the comment explicitly asks for tests or coverage
+    /// (e.g. "please add a unit test", "missing coverage").
+    pub fn requests_tests(&self) -> bool {
+        let body = self.body.to_lowercase();
+        TEST_REQUEST_PHRASES
+            .iter()
+            .any(|phrase| contains_word(&body, phrase))
+    }
+
+    /// Returns the contents of the first ```` ```suggestion ```` block in the
+    /// body: the replacement code the reviewer proposed.
+    ///
+    /// An empty block (a suggested deletion) yields an empty string; an
+    /// unclosed block runs to the end of the body.
+    pub fn extract_suggestion(&self) -> Option<String> {
+        let mut lines = self.body.lines();
+        let fence = lines.by_ref().find_map(|line| {
+            let line = line.trim();
+            let info = line.trim_start_matches('`');
+            let fence = &line[..line.len() - info.len()];
+            (fence.len() >= 3 && info.trim() == "suggestion").then_some(fence)
+        })?;
+        let suggestion: Vec<&str> = lines
+            .take_while(|line| !line.trim().starts_with(fence))
+            .collect();
+        Some(suggestion.join("\n"))
+    }
+
+    /// Returns true for a comment not attached to any file: a review body
+    /// (empty path) or a PR conversation comment ([`GENERAL_FILE_PATH`]).
+    pub fn is_general(&self) -> bool {
+        self.file_path.is_empty() || self.file_path == GENERAL_FILE_PATH
+    }
+
+    /// Returns true if the comment was updated more than a minute after it
+    /// was created; GitHub bumps `updated_at` slightly on creation itself.
+    pub fn was_edited(&self) -> bool {
+        self.updated_at - self.created_at > chrono::Duration::minutes(1)
+    }
+
+    /// Returns true for a file-level comment, which some clients position
+    /// at line 0 rather than leaving the line null.
+    pub fn is_file_level(&self) -> bool {
+        self.line_number == Some(0)
+    }
+
     /// Returns a human-readable line info string.
     ///
     /// Examples:
     /// - "line 42"
     /// - "lines 10-20"
+    /// - "file-level" for line 0 (rendered "(file-level)" in headers)
     /// - "line unknown"
     pub fn get_line_info(&self) -> String {
         match (self.line_number, self.start_line) {
+            (Some(0), _) => "file-level".to_string(),
             (Some(line), Some(start)) if start != line => {
                 format!("lines {start}-{line}")
             }
@@ -72,7 +208,9 @@ impl PRComment {
     /// Extracts a code snippet from the diff hunk.
     ///
     /// Removes the @@ header line and returns up to `max_lines` of code,
-    /// taking the last N lines (most relevant to the comment).
+    /// taking the last N lines (most relevant to the comment). For range
+    /// comments the window is widened to cover the whole range, up to
+    /// [`MAX_RANGE_SNIPPET_LINES`].
     pub fn get_code_snippet(&self, max_lines: usize) -> String {
         if self.diff_hunk.is_empty() {
             return String::new();
@@ -89,6 +227,7 @@ impl PRComment {
         }
 
         // Take the last max_lines lines (most relevant to the comment)
+        let max_lines = max_lines.max(self.range_window(&lines));
         let start = if lines.len() > max_lines {
             lines.len() - max_lines
         } else {
@@ -97,6 +236,322 @@ impl PRComment {
 
         lines[start..].join("\n")
     }
+
+    /// Extracts the code snippet like [`get_code_snippet`](Self::get_code_snippet),
+    /// but guarantees every line starts with a diff marker: `+` for added,
+    /// `-` for removed, and a space for context lines, so a reader can tell
+    /// what the change actually touched.
+    pub fn get_annotated_snippet(&self, max_lines: usize) -> String {
+        annotate_snippet(&self.get_code_snippet(max_lines))
+    }
+
+    /// Extracts a code snippet centered on the hunk's last changed (`+` or
+    /// `-`) line, with up to `before` lines above it and `after` below.
+    ///
+    /// Falls back to the hunk's last line when nothing changed, so the
+    /// window ends at the commented line like [`get_code_snippet`](Self::get_code_snippet).
+    pub fn get_code_snippet_window(&self, before: usize, after: usize) -> String {
+        let lines: Vec<&str> = self
+            .diff_hunk
+            .lines()
+            .filter(|line| !line.starts_with("@@"))
+            .collect();
+        let Some(last) = lines.len().checked_sub(1) else {
+            return String::new();
+        };
+
+        let center = lines
+            .iter()
+            .rposition(|line| line.starts_with(['+', '-']))
+            .unwrap_or(last);
+        let start = center.saturating_sub(before);
+        let end = (center + after).min(last);
+        lines[start..=end].join("\n")
+    }
+
+    /// Returns how many trailing hunk lines are needed to show the whole
+    /// start_line..line_number range, or 0 for single-line comments.
+    ///
+    /// The hunk ends at the commented line; removed ("-") lines are not part
+    /// of the new-side range but are shown when interleaved with it.
+    fn range_window(&self, lines: &[&str]) -> usize {
+        let (Some(start), Some(end)) = (self.start_line, self.line_number) else {
+            return 0;
+        };
+        if start >= end {
+            return 0;
+        }
+
+        let range_len = (end - start + 1) as usize;
+        let mut new_side = 0;
+        let mut window = 0;
+        for line in lines.iter().rev() {
+            if window == MAX_RANGE_SNIPPET_LINES || new_side == range_len {
+                break;
+            }
+            window += 1;
+            if !line.starts_with('-') {
+                new_side += 1;
+            }
+        }
+        window
+    }
+}
+
+/// Prefixes every snippet line that lacks a diff marker with a space, so
+/// context lines read as context: `+` added, `-` removed, `\` notes.
+pub fn annotate_snippet(snippet: &str) -> String {
+    snippet
+        .lines()
+        .map(|line| {
+            if line.starts_with(['+', '-', ' ', '\\']) {
+                line.to_string()
+            } else {
+                format!(" {line}")
+            }
+        })
+        .collect::<Vec<_>>()
+        .join("\n")
+}
+
+/// Maximum snippet lines shown for a range comment, however long the range.
+pub const MAX_RANGE_SNIPPET_LINES: usize = 100;
+
+/// Reaction counts on a comment, as returned in the API's `reactions` object.
+#[derive(Debug, Clone, Default, Serialize, Deserialize, PartialEq)]
+pub struct Reactions {
+    #[serde(rename = "+1")]
+    pub plus_one: i64,
+    #[serde(rename = "-1")]
+    pub minus_one: i64,
+    pub laugh: i64,
+    pub hooray: i64,
+    pub confused: i64,
+    pub heart: i64,
+    pub rocket: i64,
+    pub eyes: i64,
+}
+
+/// Pull request metadata used to annotate formatted output.
+///
+/// All fields are optional: PR info is fetched best-effort, and output is
+/// still produced without it.
+#[derive(Debug, Clone, Default, Serialize, Deserialize, PartialEq)]
+pub struct PRInfo {
+    pub title: Option<String>,
+    pub url: Option<String>,
+    /// GraphQL node ID for the PR (e.g., "PR_kwDO..."). Used for replying via GraphQL.
+    pub node_id: Option<String>,
+    /// Commit SHA of the PR head (`head.sha`), the contents `ref` for
+    /// `--fetch-source` and `--full-context`.
+    pub head_sha: Option<String>,
+    /// Branch the PR merges into (`base.ref`), e.g. "main".
+    pub base_ref: Option<String>,
+    /// Branch the PR comes from (`head.ref`), e.g. "feature/x".
+    pub head_ref: Option<String>,
+    /// "open" or "closed" (`state`); merged PRs are closed.
+    pub state: Option<String>,
+    pub draft: bool,
+    /// Whether the PR merges cleanly; None while GitHub is still computing it.
+    pub mergeable: Option<bool>,
+    /// Login of the PR author (`user.login`).
+    pub author: Option<String>,
+}
+
+impl PRInfo {
+    /// Describes the PR state for display, e.g. "open (draft, has conflicts)".
+    ///
+    /// Returns None when the state is unknown.
+    pub fn state_summary(&self) -> Option<String> {
+        let state = self.state.as_deref()?;
+        let mut notes = Vec::new();
+        if self.draft {
+            notes.push("draft");
+        }
+        match self.mergeable {
+            Some(true) => notes.push("mergeable"),
+            Some(false) => notes.push("has conflicts"),
+            None => {}
+        }
+        Some(if notes.is_empty() {
+            state.to_string()
+        } else {
+            format!("{state} ({})", notes.join(", "))
+        })
+    }
+}
+
+/// A file changed in a pull request, as listed by the PR files endpoint.
+///
+/// The API returns files in diff order, which is also the order GitHub shows
+/// them in the "Files changed" tab.
+#[derive(Debug, Clone, Serialize, Deserialize, PartialEq)]
+pub struct PRFile {
+    pub filename: String,
+    /// Change status, e.g. "added", "modified", "removed", "renamed".
+    pub status: String,
+    pub additions: i64,
+    pub deletions: i64,
+    pub changes: i64,
+}
+
+/// Summary counts for a set of review comments.
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct ReviewStats {
+    pub total_comments: usize,
+    /// Distinct files with comments (review-level comments don't count).
+    pub unique_files: usize,
+    pub unique_authors: usize,
+    /// Creation times of the earliest and latest comments.
+    pub time_span: Option<(DateTime<Utc>, DateTime<Utc>)>,
+    /// (author, count) pairs, most comments first.
+    pub comments_per_author: Vec<(String, usize)>,
+    /// (file, count) pairs, most comments first; review-level comments are
+    /// not listed.
+    pub comments_per_file: Vec<(String, usize)>,
+    /// The file with the most comments and its count; ties go to the
+    /// alphabetically first path.
+    pub busiest_file: Option<(String, usize)>,
+}
+
+/// How many of a file's changed lines received review comments.
+#[derive(Debug, Clone, PartialEq)]
+pub struct FileCoverage {
+    pub filename: String,
+    /// Distinct lines covered by comments (ranges count every line), capped
+    /// at `changed_lines`.
+    pub commented_lines: usize,
+    /// Lines added or modified in the PR (the file's `additions`).
+    pub changed_lines: usize,
+}
+
+impl FileCoverage {
+    /// Returns the commented fraction of changed lines, or None when the
+    /// file has no changed lines (e.g. pure deletions).
+    pub fn ratio(&self) -> Option<f64> {
+        (self.changed_lines > 0).then(|| self.commented_lines as f64 / self.changed_lines as f64)
+    }
+}
+
+/// An issue or PR referenced from a comment body (`#123`, `owner/repo#456`).
+#[derive(Debug, Clone, PartialEq, Eq, Hash)]
+pub struct IssueRef {
+    pub owner: String,
+    pub repo: String,
+    pub number: i64,
+}
+
+/// Inferred severity of a review comment, ordered from least to most severe.
+#[derive(Debug, Clone, Copy, Serialize, Deserialize, PartialEq, Eq, PartialOrd, Ord, Hash)]
+#[serde(rename_all = "lowercase")]
+pub enum Severity {
+    None,
+    Nit,
+    Suggestion,
+    Warning,
+    Critical,
+}
+
+impl Severity {
+    /// Returns the lowercase label for this severity.
+    pub fn label(&self) -> &'static str {
+        match self {
+            Severity::None => "none",
+            Severity::Nit => "nit",
+            Severity::Suggestion => "suggestion",
+            Severity::Warning => "warning",
+            Severity::Critical => "critical",
+        }
+    }
+}
+
+impl std::str::FromStr for Severity {
+    type Err = String;
+
+    fn from_str(s: &str) -> Result<Self, Self::Err> {
+        match s.to_ascii_lowercase().as_str() {
+            "none" => Ok(Severity::None),
+            "nit" => Ok(Severity::Nit),
+            "suggestion" => Ok(Severity::Suggestion),
+            "warning" => Ok(Severity::Warning),
+            "critical" => Ok(Severity::Critical),
+            _ => Err(format!(
+                "unknown severity '{s}': expected none, nit, suggestion, warning or critical"
+            )),
+        }
+    }
+}
+
+impl fmt::Display for Severity {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "{}", self.label())
+    }
+}
+
+/// Keyword heuristics for severity inference, checked from most to least severe.
+const SEVERITY_KEYWORDS: &[(Severity, &[&str])] = &[
+    (
+        Severity::Critical,
+        &[
+            "critical",
+            "security",
+            "vulnerability",
+            "blocker",
+            "must fix",
+            "data loss",
+        ],
+    ),
+    (
+        Severity::Warning,
+        &[
+            "potential issue",
+            "bug",
+            "warning",
+            "incorrect",
+            "race condition",
+        ],
+    ),
+    (
+        Severity::Suggestion,
+        &[
+            "suggestion",
+            "consider",
+            "recommend",
+            "might want",
+            "could you",
+        ],
+    ),
+    (Severity::Nit, &["nit", "nitpick", "typo", "minor"]),
+];
+
+/// Phrases marking a request for tests, matched as whole words.
+const TEST_REQUEST_PHRASES: &[&str] = &[
+    "add a test",
+    "add tests",
+    "add a unit test",
+    "add unit test",
+    "add unit tests",
+    "add an integration test",
+    "add integration tests",
+    "add test coverage",
+    "add coverage",
+    "needs a test",
+    "needs tests",
+    "write a test",
+    "write tests",
+    "missing test",
+    "missing tests",
+    "missing coverage",
+    "untested",
+];
+
+/// Returns true if `word` appears in `text` delimited by non-alphanumeric characters.
+fn contains_word(text: &str, word: &str) -> bool {
+    text.match_indices(word).any(|(start, _)| {
+        let before = text[..start].chars().next_back();
+        let after = text[start + word.len()..].chars().next();
+        !before.is_some_and(char::is_alphanumeric) && !after.is_some_and(char::is_alphanumeric)
+    })
 }
 
 /// The conclusion/result of a CI check.
@@ -331,6 +786,206 @@ mod tests {
         assert_eq!(comment.author, "testuser");
     }
 
+    #[test]
+    fn test_was_edited() {
+        let mut comment = create_test_comment();
+        assert!(!comment.was_edited());
+
+        comment.updated_at = comment.created_at + chrono::Duration::minutes(1);
+        assert!(!comment.was_edited());
+
+        comment.updated_at = comment.created_at + chrono::Duration::hours(1);
+        assert!(comment.was_edited());
+    }
+
+    #[test]
+    fn test_comment_new_defaults_in_reply_to() {
+        let comment = create_test_comment();
+        assert!(comment.in_reply_to_id.is_none());
+    }
+
+    #[test]
+    fn test_reaction_score() {
+        let mut comment = create_test_comment();
+        assert_eq!(comment.reaction_score(), 0);
+
+        comment.reactions = Reactions {
+            plus_one: 3,
+            minus_one: 1,
+            laugh: 5,
+            hooray: 1,
+            confused: 2,
+            heart: 2,
+            rocket: 4,
+            eyes: 7,
+        };
+        // 3 + 2 + 1 - 1 - 2; laugh, rocket and eyes are neutral
+        assert_eq!(comment.reaction_score(), 3);
+    }
+
+    #[test]
+    fn test_author_profile_url() {
+        let mut comment = create_test_comment();
+        assert_eq!(comment.author_profile_url(), "https://github.com/testuser");
+
+        comment.author = "devin-ai-integration[bot]".to_string();
+        assert_eq!(
+            comment.author_profile_url(),
+            "https://github.com/apps/devin-ai-integration"
+        );
+    }
+
+    #[test]
+    fn test_display_author() {
+        let mut comment = create_test_comment();
+        assert_eq!(comment.display_author(true), "testuser");
+
+        comment.author = "devin-ai-integration[bot]".to_string();
+        assert_eq!(comment.display_author(false), "devin-ai-integration[bot]");
+        assert_eq!(comment.display_author(true), "devin-ai-integration");
+
+        comment.author = "Renovate[BOT]".to_string();
+        assert_eq!(comment.display_author(true), "Renovate");
+    }
+
+    #[test]
+    fn test_is_bot() {
+        let mut comment = create_test_comment();
+        assert!(!comment.is_bot());
+
+        comment.author = "devin-ai-integration[bot]".to_string();
+        assert!(comment.is_bot());
+
+        comment.author = "Dependabot[BOT]".to_string();
+        assert!(comment.is_bot());
+
+        comment.author = "robotics-fan".to_string();
+        assert!(!comment.is_bot());
+    }
+
+    #[test]
+    fn test_is_general() {
+        let mut comment = create_test_comment();
+        assert!(!comment.is_general());
+        comment.file_path = String::new();
+        assert!(comment.is_general());
+        comment.file_path = GENERAL_FILE_PATH.to_string();
+        assert!(comment.is_general());
+    }
+
+    #[test]
+    fn test_pr_info_state_summary() {
+        let mut info = PRInfo::default();
+        assert_eq!(info.state_summary(), None);
+
+        info.state = Some("open".to_string());
+        assert_eq!(info.state_summary().as_deref(), Some("open"));
+        info.draft = true;
+        assert_eq!(info.state_summary().as_deref(), Some("open (draft)"));
+        info.mergeable = Some(false);
+        assert_eq!(
+            info.state_summary().as_deref(),
+            Some("open (draft, has conflicts)")
+        );
+        info.draft = false;
+        info.mergeable = Some(true);
+        assert_eq!(info.state_summary().as_deref(), Some("open (mergeable)"));
+    }
+
+    #[test]
+    fn test_severity_from_str() {
+        for severity in [
+            Severity::None,
+            Severity::Nit,
+            Severity::Suggestion,
+            Severity::Warning,
+            Severity::Critical,
+        ] {
+            assert_eq!(severity.label().parse(), Ok(severity));
+        }
+        assert_eq!("WARNING".parse(), Ok(Severity::Warning));
+        assert!("major".parse::<Severity>().unwrap_err().contains("'major'"));
+    }
+
+    #[test]
+    fn test_infer_severity() {
+        let mut comment = create_test_comment();
+        let cases = [
+            ("Security: this leaks the token", Severity::Critical),
+            (
+                "\u{1F534} **Potential issue:** off by one",
+                Severity::Warning,
+            ),
+            ("This looks like a bug", Severity::Warning),
+            ("Consider extracting a helper", Severity::Suggestion),
+            ("nit: trailing whitespace", Severity::Nit),
+            ("Nitpick: rename this", Severity::Nit),
+            ("Looks good to me", Severity::None),
+            // "nit" inside another word must not match
+            ("Add a unit test here", Severity::None),
+        ];
+        for (body, expected) in cases {
+            comment.body = body.to_string();
+            assert_eq!(comment.infer_severity(), expected, "body: {body}");
+        }
+    }
+
+    #[test]
+    fn test_extract_suggestion() {
+        let mut comment = create_test_comment();
+        comment.body =
+            "Simpler:\n```suggestion\nlet total = items\n    .iter()\n    .sum();\n```\nThoughts?"
+                .to_string();
+        assert_eq!(
+            comment.extract_suggestion().as_deref(),
+            Some("let total = items\n    .iter()\n    .sum();")
+        );
+
+        // Longer fences can wrap code containing ```; empty means delete
+        comment.body = "````suggestion\n```\n````".to_string();
+        assert_eq!(comment.extract_suggestion().as_deref(), Some("```"));
+        comment.body = "Drop this:\n```suggestion\n```".to_string();
+        assert_eq!(comment.extract_suggestion().as_deref(), Some(""));
+
+        for body in [
+            "No code here",
+            "```rust\nlet x = 1;\n```",
+            "``suggestion\nx\n``",
+        ] {
+            comment.body = body.to_string();
+            assert_eq!(comment.extract_suggestion(), None, "body: {body}");
+        }
+    }
+
+    #[test]
+    fn test_requests_tests() {
+        let mut comment = create_test_comment();
+        for body in [
+            "Please add a test for the empty case.",
+            "please add unit tests here",
+            "This branch has missing coverage",
+            "Untested error path",
+        ] {
+            comment.body = body.to_string();
+            assert!(comment.requests_tests(), "body: {body}");
+        }
+        for body in ["The tests pass locally", "add a testing note", "Looks good"] {
+            comment.body = body.to_string();
+            assert!(!comment.requests_tests(), "body: {body}");
+        }
+    }
+
+    #[test]
+    fn test_severity_label_and_ordering() {
+        assert_eq!(Severity::None.label(), "none");
+        assert_eq!(Severity::Nit.label(), "nit");
+        assert_eq!(Severity::Suggestion.label(), "suggestion");
+        assert_eq!(Severity::Warning.label(), "warning");
+        assert_eq!(format!("{}", Severity::Critical), "critical");
+        assert!(Severity::Critical > Severity::Warning);
+        assert!(Severity::Nit > Severity::None);
+    }
+
     #[test]
     fn test_get_line_info_single_line() {
         let comment = create_test_comment();
@@ -353,6 +1008,19 @@ mod tests {
         assert_eq!(comment.get_line_info(), "line 10");
     }
 
+    #[test]
+    fn test_get_line_info_file_level() {
+        let mut comment = create_test_comment();
+        comment.line_number = Some(0);
+        comment.start_line = None;
+        assert!(comment.is_file_level());
+        assert_eq!(comment.get_line_info(), "file-level");
+
+        comment.line_number = None;
+        assert!(!comment.is_file_level());
+        assert_eq!(comment.get_line_info(), "line unknown");
+    }
+
     #[test]
     fn test_get_line_info_no_line() {
         let mut comment = create_test_comment();
@@ -369,6 +1037,60 @@ mod tests {
         assert!(snippet.contains("line1"));
     }
 
+    #[test]
+    fn test_get_annotated_snippet() {
+        let mut comment = create_test_comment();
+        comment.diff_hunk =
+            "@@ -1,3 +1,3 @@\n fn main() {\n-    old();\n+    new();\n\n}\n\\ No newline at end of file"
+                .to_string();
+        let snippet = comment.get_annotated_snippet(10);
+        assert!(!snippet.contains("@@"));
+        assert_eq!(
+            snippet,
+            " fn main() {\n-    old();\n+    new();\n \n }\n\\ No newline at end of file"
+        );
+        assert_eq!(
+            comment.get_annotated_snippet(1),
+            "\\ No newline at end of file"
+        );
+
+        comment.diff_hunk = String::new();
+        assert_eq!(comment.get_annotated_snippet(10), "");
+    }
+
+    #[test]
+    fn test_get_code_snippet_window() {
+        let mut comment = create_test_comment();
+        comment.diff_hunk = "@@ -1,9 +1,9 @@\n a\n b\n c\n-old\n+new\n d\n e\n f\n g".to_string();
+
+        assert_eq!(
+            comment.get_code_snippet_window(2, 2),
+            " c\n-old\n+new\n d\n e"
+        );
+        assert_eq!(comment.get_code_snippet_window(0, 0), "+new");
+        // Clamped at both ends of the hunk
+        assert_eq!(
+            comment.get_code_snippet_window(10, 10),
+            " a\n b\n c\n-old\n+new\n d\n e\n f\n g"
+        );
+        assert_eq!(
+            comment.get_code_snippet_window(1, 10),
+            "-old\n+new\n d\n e\n f\n g"
+        );
+    }
+
+    #[test]
+    fn test_get_code_snippet_window_without_changes() {
+        let mut comment = create_test_comment();
+        // Centered on the last line, as GitHub ends hunks at the commented line
+        assert_eq!(comment.get_code_snippet_window(1, 3), " line2\n line3");
+
+        comment.diff_hunk = "@@ -1,1 +1,1 @@".to_string();
+        assert_eq!(comment.get_code_snippet_window(3, 3), "");
+        comment.diff_hunk = String::new();
+        assert_eq!(comment.get_code_snippet_window(3, 3), "");
+    }
+
     #[test]
     fn test_get_code_snippet_truncates() {
         let mut comment = create_test_comment();
@@ -382,6 +1104,64 @@ mod tests {
         assert!(snippet.contains("line10"));
     }
 
+    fn sample_comment_with_range() -> PRComment {
+        let mut comment = create_test_comment();
+        comment.start_line = Some(25);
+        comment.line_number = Some(30);
+        let body: Vec<String> = (1..=30).map(|n| format!(" code line {n}")).collect();
+        comment.diff_hunk = format!("@@ -1,30 +1,30 @@\n{}", body.join("\n"));
+        comment
+    }
+
+    #[test]
+    fn test_get_code_snippet_covers_range() {
+        let comment = sample_comment_with_range();
+        let snippet = comment.get_code_snippet(3);
+        for n in 25..=30 {
+            assert!(
+                snippet.contains(&format!("code line {n}")),
+                "missing line {n}"
+            );
+        }
+        assert!(!snippet.contains("code line 24"));
+    }
+
+    #[test]
+    fn test_get_code_snippet_range_counts_only_new_side_lines() {
+        let mut comment = sample_comment_with_range();
+        comment.start_line = Some(29);
+        comment.diff_hunk =
+            "@@ -1,4 +1,3 @@\n code line 28\n code line 29\n-removed line\n+code line 30"
+                .to_string();
+        let snippet = comment.get_code_snippet(1);
+        assert_eq!(snippet, " code line 29\n-removed line\n+code line 30");
+    }
+
+    #[test]
+    fn test_get_code_snippet_range_capped() {
+        let mut comment = sample_comment_with_range();
+        comment.start_line = Some(1);
+        comment.line_number = Some(500);
+        let body: Vec<String> = (1..=500).map(|n| format!(" code line {n}")).collect();
+        comment.diff_hunk = format!("@@ -1,500 +1,500 @@\n{}", body.join("\n"));
+        let snippet = comment.get_code_snippet(3);
+        assert_eq!(snippet.lines().count(), MAX_RANGE_SNIPPET_LINES);
+    }
+
+    #[test]
+    fn test_get_code_snippet_range_larger_than_max_lines_not_shrunk() {
+        let comment = sample_comment_with_range();
+        assert_eq!(comment.get_code_snippet(10).lines().count(), 10);
+    }
+
+    #[test]
+    fn test_get_code_snippet_inverted_range_ignored() {
+        let mut comment = sample_comment_with_range();
+        comment.start_line = Some(30);
+        comment.line_number = Some(25);
+        assert_eq!(comment.get_code_snippet(2).lines().count(), 2);
+    }
+
     #[test]
     fn test_get_code_snippet_empty_diff() {
         let mut comment = create_test_comment();
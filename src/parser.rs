@@ -1,13 +1,19 @@
 //! JSON parsing and comment filtering functions.
 
+use crate::cli::SortKey;
 use crate::error::GitHubAPIError;
 use crate::models::{
-    CheckConclusion, CheckStatus, CheckType, ChecksReport, PRComment, RollupState,
+    CheckConclusion, CheckStatus, CheckType, ChecksReport, FileCoverage, IssueRef, PRComment,
+    PRFile, PRInfo, Reactions, ReviewStats, RollupState, Severity, GENERAL_FILE_PATH,
 };
 use crate::sanitizer::strip_html;
 use chrono::{DateTime, Utc};
+use globset::{Glob, GlobSet, GlobSetBuilder};
+use regex::Regex;
 use serde_json::Value;
-use std::collections::HashMap;
+use std::cmp::Ordering;
+use std::collections::{BTreeMap, HashMap, HashSet};
+use std::sync::OnceLock;
 
 /// Parses a GitHub ISO 8601 datetime string into a DateTime<Utc>.
 ///
@@ -33,12 +39,13 @@ pub fn parse_comment(comment_data: &Value) -> Option<PRComment> {
         .unwrap_or("")
         .to_string();
 
-    // Try line first, then fall back to original_line
-    let line_number = comment_data
-        .get("line")
-        .and_then(|v| v.as_i64())
-        .or_else(|| comment_data.get("original_line").and_then(|v| v.as_i64()))
-        .map(|v| v as i32);
+    // Try line first, then fall back to original_line. GitHub clears `line`
+    // once the commented code changes, so the fallback marks it outdated.
+    // An explicit line 0 is kept: it positions a file-level comment.
+    let current_line = comment_data.get("line").and_then(|v| v.as_i64());
+    let original_line = comment_data.get("original_line").and_then(|v| v.as_i64());
+    let line_number = current_line.or(original_line).map(|v| v as i32);
+    let outdated = current_line.is_none() && original_line.is_some();
 
     // Try start_line first, then fall back to original_start_line
     let start_line = comment_data
@@ -59,6 +66,8 @@ pub fn parse_comment(comment_data: &Value) -> Option<PRComment> {
         .unwrap_or("unknown")
         .to_string();
 
+    let author_avatar_url = parse_avatar_url(comment_data);
+
     let raw_body = comment_data
         .get("body")
         .and_then(|v| v.as_str())
@@ -83,7 +92,12 @@ pub fn parse_comment(comment_data: &Value) -> Option<PRComment> {
         .unwrap_or("")
         .to_string();
 
-    Some(PRComment::new(
+    let in_reply_to_id = comment_data.get("in_reply_to_id").and_then(|v| v.as_i64());
+    let review_id = comment_data
+        .get("pull_request_review_id")
+        .and_then(|v| v.as_i64());
+
+    let mut comment = PRComment::new(
         id,
         node_id,
         file_path,
@@ -95,7 +109,40 @@ pub fn parse_comment(comment_data: &Value) -> Option<PRComment> {
         updated_at,
         diff_hunk,
         html_url,
-    ))
+    );
+    comment.in_reply_to_id = in_reply_to_id;
+    comment.review_id = review_id;
+    comment.author_avatar_url = author_avatar_url;
+    comment.reactions = parse_reactions(comment_data);
+    comment.outdated = outdated;
+    Some(comment)
+}
+
+/// Extracts reaction counts from the `reactions` object; missing counts are 0.
+fn parse_reactions(data: &Value) -> Reactions {
+    let Some(reactions) = data.get("reactions") else {
+        return Reactions::default();
+    };
+    let count = |key: &str| reactions.get(key).and_then(|v| v.as_i64()).unwrap_or(0);
+
+    Reactions {
+        plus_one: count("+1"),
+        minus_one: count("-1"),
+        laugh: count("laugh"),
+        hooray: count("hooray"),
+        confused: count("confused"),
+        heart: count("heart"),
+        rocket: count("rocket"),
+        eyes: count("eyes"),
+    }
+}
+
+/// Extracts the author's avatar URL from `user.avatar_url`, if present.
+fn parse_avatar_url(data: &Value) -> Option<String> {
+    data.get("user")
+        .and_then(|u| u.get("avatar_url"))
+        .and_then(|v| v.as_str())
+        .map(|s| s.to_string())
 }
 
 /// Parses multiple comments from GitHub API JSON.
@@ -103,6 +150,31 @@ pub fn parse_comments(comments_data: &[Value]) -> Vec<PRComment> {
     comments_data.iter().filter_map(parse_comment).collect()
 }
 
+/// Parses a PR conversation comment (from the issues endpoint) into a
+/// PRComment filed under [`GENERAL_FILE_PATH`].
+///
+/// These have no path, line or diff hunk.
+pub fn parse_issue_comment(comment_data: &Value) -> Option<PRComment> {
+    let mut comment = parse_comment(comment_data)?;
+    comment.file_path = GENERAL_FILE_PATH.to_string();
+    Some(comment)
+}
+
+/// Parses a saved comments file: a JSON array of review comments as the
+/// pulls comments endpoint returns them (e.g. from `gh api`).
+pub fn parse_comments_file(contents: &str) -> Result<Vec<Value>, GitHubAPIError> {
+    serde_json::from_str(contents)
+        .map_err(|e| GitHubAPIError::ParseError(format!("Expected a JSON array of comments: {e}")))
+}
+
+/// Parses multiple PR conversation comments.
+pub fn parse_issue_comments(comments_data: &[Value]) -> Vec<PRComment> {
+    comments_data
+        .iter()
+        .filter_map(parse_issue_comment)
+        .collect()
+}
+
 /// Parses a single review from GitHub API JSON into a PRComment.
 ///
 /// Reviews are top-level comments attached to a review submission,
@@ -141,7 +213,7 @@ pub fn parse_review_comment(review_data: &Value) -> Option<PRComment> {
         .to_string();
 
     // Review-level comments don't have file paths or line numbers
-    Some(PRComment::new(
+    let mut comment = PRComment::new(
         id,
         node_id,
         String::new(), // No file path for review-level comments
@@ -153,7 +225,16 @@ pub fn parse_review_comment(review_data: &Value) -> Option<PRComment> {
         submitted_at,  // Use submitted_at for both created and updated
         String::new(), // No diff hunk
         html_url,
-    ))
+    );
+    comment.author_avatar_url = parse_avatar_url(review_data);
+    comment.review_id = Some(id);
+    comment.blocking = is_changes_requested(review_data);
+    Some(comment)
+}
+
+/// Returns true if a review from the reviews endpoint requested changes.
+fn is_changes_requested(review_data: &Value) -> bool {
+    review_data.get("state").and_then(|v| v.as_str()) == Some("CHANGES_REQUESTED")
 }
 
 /// Parses multiple reviews from GitHub API JSON into PRComments.
@@ -166,6 +247,504 @@ pub fn parse_review_comments(reviews_data: &[Value]) -> Vec<PRComment> {
         .collect()
 }
 
+/// Parses line-specific comments and merges in review-level comments
+/// (reviews with body text).
+///
+/// Line comments submitted with a changes-requested review are marked
+/// blocking.
+pub fn parse_all_comments(comments_data: &[Value], reviews_data: &[Value]) -> Vec<PRComment> {
+    let blocking: HashSet<i64> = reviews_data
+        .iter()
+        .filter(|r| is_changes_requested(r))
+        .filter_map(|r| r.get("id")?.as_i64())
+        .collect();
+    let mut comments = parse_comments(comments_data);
+    for comment in &mut comments {
+        comment.blocking = comment.review_id.is_some_and(|id| blocking.contains(&id));
+    }
+    comments.extend(parse_review_comments(reviews_data));
+    comments
+}
+
+/// Parses PR metadata from the pulls endpoint response.
+pub fn parse_pr_info(info_data: &Value) -> PRInfo {
+    let field = |key: &str| {
+        info_data
+            .get(key)
+            .and_then(|v| v.as_str())
+            .map(String::from)
+    };
+
+    let nested = |pointer: &str| {
+        info_data
+            .pointer(pointer)
+            .and_then(|v| v.as_str())
+            .map(String::from)
+    };
+
+    PRInfo {
+        title: field("title"),
+        url: field("html_url"),
+        node_id: field("node_id"),
+        head_sha: nested("/head/sha"),
+        base_ref: nested("/base/ref"),
+        head_ref: nested("/head/ref"),
+        state: field("state"),
+        draft: info_data
+            .get("draft")
+            .and_then(|v| v.as_bool())
+            .unwrap_or(false),
+        mergeable: info_data.get("mergeable").and_then(|v| v.as_bool()),
+        author: nested("/user/login"),
+    }
+}
+
+/// Parses a single entry from the PR files endpoint into a PRFile.
+pub fn parse_pr_file(file_data: &Value) -> Option<PRFile> {
+    let filename = file_data.get("filename")?.as_str()?.to_string();
+    let status = file_data
+        .get("status")
+        .and_then(|v| v.as_str())
+        .unwrap_or("")
+        .to_string();
+    let count = |key: &str| file_data.get(key).and_then(|v| v.as_i64()).unwrap_or(0);
+
+    Some(PRFile {
+        filename,
+        status,
+        additions: count("additions"),
+        deletions: count("deletions"),
+        changes: count("changes"),
+    })
+}
+
+/// Parses the PR files endpoint response, preserving diff order.
+pub fn parse_pr_files(files_data: &[Value]) -> Vec<PRFile> {
+    files_data.iter().filter_map(parse_pr_file).collect()
+}
+
+/// Keeps only comments on files that are no longer part of the PR's diff.
+///
+/// After a rebase, comments can point at files the PR no longer changes.
+/// Review-level comments (no file path) are never orphaned.
+pub fn filter_orphaned(comments: Vec<PRComment>, files: &[PRFile]) -> Vec<PRComment> {
+    let current: HashSet<&str> = files.iter().map(|f| f.filename.as_str()).collect();
+    comments
+        .into_iter()
+        .filter(|c| !c.is_general() && !current.contains(c.file_path.as_str()))
+        .collect()
+}
+
+/// Keeps only comments on files still part of the PR's diff, the inverse of
+/// [`filter_orphaned`]. Review-level comments (no file path) are kept.
+pub fn filter_changed_files(comments: Vec<PRComment>, files: &[PRFile]) -> Vec<PRComment> {
+    let current: HashSet<&str> = files.iter().map(|f| f.filename.as_str()).collect();
+    comments
+        .into_iter()
+        .filter(|c| c.is_general() || current.contains(c.file_path.as_str()))
+        .collect()
+}
+
+/// Clusters comments that sit close together in the same file.
+///
+/// Within a file, comments are sorted by line and a comment joins the
+/// previous one's cluster when it is at most `radius` lines away, so a
+/// cluster can span more than `radius` lines through a chain of neighbors.
+/// Comments without a line (review-level or file-level) are not clustered.
+/// Clusters are returned in file order, each sorted by line.
+pub fn cluster_by_proximity(comments: &[PRComment], radius: i32) -> Vec<Vec<&PRComment>> {
+    let mut positioned: Vec<&PRComment> = comments
+        .iter()
+        .filter(|c| c.line_number.is_some_and(|l| l > 0))
+        .collect();
+    positioned.sort_by(|a, b| {
+        a.file_path
+            .cmp(&b.file_path)
+            .then_with(|| a.line_number.cmp(&b.line_number))
+            .then_with(|| a.id.cmp(&b.id))
+    });
+
+    let mut clusters: Vec<Vec<&PRComment>> = Vec::new();
+    for comment in positioned {
+        let joins_last = clusters.last().and_then(|c| c.last()).is_some_and(|prev| {
+            prev.file_path == comment.file_path
+                && comment.line_number.unwrap_or(0) - prev.line_number.unwrap_or(0) <= radius
+        });
+        match clusters.last_mut() {
+            Some(cluster) if joins_last => cluster.push(comment),
+            _ => clusters.push(vec![comment]),
+        }
+    }
+    clusters
+}
+
+/// Keeps only comments in hotspots: clusters (see [`cluster_by_proximity`])
+/// holding at least `min_comments` comments. Input order is preserved.
+pub fn filter_hotspots(
+    comments: Vec<PRComment>,
+    min_comments: usize,
+    radius: i32,
+) -> Vec<PRComment> {
+    let hot: HashSet<i64> = cluster_by_proximity(&comments, radius)
+        .into_iter()
+        .filter(|cluster| cluster.len() >= min_comments)
+        .flatten()
+        .map(|c| c.id)
+        .collect();
+    comments
+        .into_iter()
+        .filter(|c| hot.contains(&c.id))
+        .collect()
+}
+
+/// Globs identifying test files, matched against the full path and the
+/// file name.
+pub const DEFAULT_TEST_GLOBS: &[&str] = &[
+    "*_test.go",
+    "test_*.py",
+    "*_test.py",
+    "**/tests/**",
+    "*.spec.ts",
+    "*.test.ts",
+];
+
+/// Builds a matcher from [`DEFAULT_TEST_GLOBS`] plus `extra` globs.
+pub fn test_file_matcher(extra: &[Glob]) -> GlobSet {
+    let mut builder = GlobSetBuilder::new();
+    for pattern in DEFAULT_TEST_GLOBS {
+        builder.add(Glob::new(pattern).unwrap());
+    }
+    for glob in extra {
+        builder.add(glob.clone());
+    }
+    // Only an oversized pattern set fails to compile; match nothing then
+    builder.build().unwrap_or_default()
+}
+
+/// Returns true if `path` (or its file name) matches a test-file glob.
+pub fn is_test_file(path: &str, matcher: &GlobSet) -> bool {
+    let file_name = path.rsplit('/').next().unwrap_or(path);
+    matcher.is_match(path) || matcher.is_match(file_name)
+}
+
+/// Drops comments on test files. Review-level comments are kept.
+pub fn filter_test_files(comments: Vec<PRComment>, matcher: &GlobSet) -> Vec<PRComment> {
+    comments
+        .into_iter()
+        .filter(|c| c.is_general() || !is_test_file(&c.file_path, matcher))
+        .collect()
+}
+
+/// Sorts comments to follow the PR's diff order.
+///
+/// Comments are ordered by the position of their file in `file_order`, then by
+/// line number. Files not in the list (e.g. review-level comments) come last,
+/// sorted by path.
+pub fn sort_by_diff_order(mut comments: Vec<PRComment>, file_order: &[String]) -> Vec<PRComment> {
+    let positions: HashMap<&str, usize> = file_order
+        .iter()
+        .enumerate()
+        .map(|(i, f)| (f.as_str(), i))
+        .collect();
+
+    comments.sort_by(|a, b| {
+        let pos_a = positions
+            .get(a.file_path.as_str())
+            .copied()
+            .unwrap_or(usize::MAX);
+        let pos_b = positions
+            .get(b.file_path.as_str())
+            .copied()
+            .unwrap_or(usize::MAX);
+        pos_a
+            .cmp(&pos_b)
+            .then_with(|| a.file_path.cmp(&b.file_path))
+            .then_with(|| a.line_number.cmp(&b.line_number))
+            .then_with(|| a.id.cmp(&b.id))
+    });
+    comments
+}
+
+/// Returns when `login` last submitted a review, from raw reviews API JSON.
+///
+/// Pending (unsubmitted) reviews have no `submitted_at` and are ignored.
+pub fn latest_review_submitted_by(reviews_data: &[Value], login: &str) -> Option<DateTime<Utc>> {
+    reviews_data
+        .iter()
+        .filter(|r| {
+            r.get("user")
+                .and_then(|u| u.get("login"))
+                .and_then(|l| l.as_str())
+                == Some(login)
+        })
+        .filter_map(|r| r.get("submitted_at")?.as_str())
+        .filter_map(|s| parse_datetime(s).ok())
+        .max()
+}
+
+/// Orders comments the way LLM agents prefer to work through them: files
+/// alphabetically, comments within a file bottom-to-top.
+///
+/// Applying edits from the bottom of a file up keeps earlier line numbers
+/// valid. Review-level comments (no line) come last within their file, and
+/// comments on the same line are ordered by ID.
+pub fn agent_order(a: &PRComment, b: &PRComment) -> Ordering {
+    a.file_path
+        .cmp(&b.file_path)
+        .then_with(|| b.line_number.cmp(&a.line_number))
+        .then_with(|| a.id.cmp(&b.id))
+}
+
+/// Sorts comments by [`agent_order`].
+pub fn sort_by_agent_order(mut comments: Vec<PRComment>) -> Vec<PRComment> {
+    comments.sort_by(agent_order);
+    comments
+}
+
+/// Keeps comments last updated after `cutoff`, so edited comments count as
+/// new. With `inclusive`, comments updated exactly at `cutoff` are kept too.
+///
+/// `--since` is inclusive (a date means from its start); `--since-review` is
+/// not, so comments submitted with the review itself are left out.
+pub fn filter_updated_since(
+    comments: Vec<PRComment>,
+    cutoff: DateTime<Utc>,
+    inclusive: bool,
+) -> Vec<PRComment> {
+    comments
+        .into_iter()
+        .filter(|c| c.updated_at > cutoff || (inclusive && c.updated_at == cutoff))
+        .collect()
+}
+
+/// Sorts comments by the given key, breaking ties by ID.
+pub fn sort_comments(mut comments: Vec<PRComment>, key: SortKey) -> Vec<PRComment> {
+    match key {
+        SortKey::Line => comments.sort_by_key(|c| (c.line_number, c.created_at, c.id)),
+        SortKey::Date => {
+            comments.sort_by_key(|c| (std::cmp::Reverse(c.created_at), c.id));
+        }
+        SortKey::Author => {
+            comments.sort_by_cached_key(|c| (c.author.to_lowercase(), c.created_at, c.id));
+        }
+        SortKey::File => {
+            comments.sort_by(|a, b| {
+                a.file_path
+                    .cmp(&b.file_path)
+                    .then_with(|| a.line_number.cmp(&b.line_number))
+                    .then_with(|| a.id.cmp(&b.id))
+            });
+        }
+        SortKey::Reactions => return sort_by_reaction_score(comments),
+    }
+    comments
+}
+
+/// Sorts comments by net reaction score, most endorsed first, then by ID.
+pub fn sort_by_reaction_score(mut comments: Vec<PRComment>) -> Vec<PRComment> {
+    comments.sort_by_key(|c| (std::cmp::Reverse(c.reaction_score()), c.id));
+    comments
+}
+
+/// Computes per-file review coverage: how many changed lines received
+/// comments, in the files' diff order.
+///
+/// Without the patch text a commented line can't be checked against the
+/// changed lines, so counts are capped at the file's additions.
+pub fn review_coverage(comments: &[PRComment], files: &[PRFile]) -> Vec<FileCoverage> {
+    files
+        .iter()
+        .map(|file| {
+            let lines: HashSet<i32> = comments
+                .iter()
+                .filter(|c| c.file_path == file.filename)
+                .filter_map(|c| match (c.start_line, c.line_number) {
+                    (Some(start), Some(end)) if start <= end => Some(start..=end),
+                    (_, Some(line)) | (Some(line), None) => Some(line..=line),
+                    (None, None) => None,
+                })
+                .flatten()
+                .collect();
+            let changed_lines = file.additions.max(0) as usize;
+            FileCoverage {
+                filename: file.filename.clone(),
+                commented_lines: lines.len().min(changed_lines),
+                changed_lines,
+            }
+        })
+        .collect()
+}
+
+/// Finds line numbers referenced in a comment body, in order of appearance.
+///
+/// Recognizes "line 88" / "lines 88" (case-insensitive) and GitHub-style
+/// "L88" anchors. Duplicates are dropped.
+pub fn find_line_references(body: &str) -> Vec<i32> {
+    let bytes = body.as_bytes();
+    let mut refs = Vec::new();
+
+    for i in 0..bytes.len() {
+        if i > 0 && bytes[i - 1].is_ascii_alphanumeric() {
+            continue;
+        }
+
+        let digits_start = if bytes[i..].len() >= 4 && bytes[i..i + 4].eq_ignore_ascii_case(b"line")
+        {
+            let mut j = i + 4;
+            if j < bytes.len() && bytes[j].eq_ignore_ascii_case(&b's') {
+                j += 1;
+            }
+            let spaces = bytes[j..].iter().take_while(|b| **b == b' ').count();
+            (spaces > 0).then_some(j + spaces)
+        } else if bytes[i] == b'L' {
+            Some(i + 1)
+        } else {
+            None
+        };
+
+        let Some(start) = digits_start else {
+            continue;
+        };
+        let digits = bytes[start..]
+            .iter()
+            .take_while(|b| b.is_ascii_digit())
+            .count();
+        let end = start + digits;
+        if digits == 0 || (end < bytes.len() && bytes[end].is_ascii_alphanumeric()) {
+            continue;
+        }
+        if let Ok(line) = body[start..end].parse::<i32>() {
+            if !refs.contains(&line) {
+                refs.push(line);
+            }
+        }
+    }
+
+    refs
+}
+
+/// Extracts unchecked markdown task list items (`- [ ] do X`) from a body.
+///
+/// Accepts `-`, `*` and `+` bullets, with any indentation. Checked items
+/// (`[x]`) and empty tasks are skipped.
+pub fn extract_tasks(body: &str) -> Vec<String> {
+    body.lines()
+        .filter_map(|line| {
+            let rest = line.trim_start().strip_prefix(['-', '*', '+'])?;
+            let task = rest.strip_prefix(" [ ]")?;
+            if !task.is_empty() && !task.starts_with(char::is_whitespace) {
+                return None;
+            }
+            let task = task.trim();
+            (!task.is_empty()).then(|| task.to_string())
+        })
+        .collect()
+}
+
+/// Returns the http(s) URLs in a body, in order of appearance, without
+/// duplicates.
+///
+/// Trailing sentence punctuation is not part of the URL, and markdown link
+/// syntax (`[text](url)`, `<url>`) is handled.
+pub fn extract_links(body: &str) -> Vec<String> {
+    static URL: OnceLock<Regex> = OnceLock::new();
+    let url = URL.get_or_init(|| Regex::new(r#"https?://[^\s<>()\[\]"'`]+"#).unwrap());
+
+    let mut links: Vec<String> = Vec::new();
+    for m in url.find_iter(body) {
+        let link = m.as_str().trim_end_matches(['.', ',', ';', ':', '!', '?']);
+        if !links.iter().any(|l| l == link) {
+            links.push(link.to_string());
+        }
+    }
+    links
+}
+
+/// Matches `#123` and `owner/repo#123` issue references.
+///
+/// The leading group keeps references out of words, paths, URLs, hostnames
+/// and HTML entities (`&#123;`), since the regex crate has no lookbehind.
+fn issue_ref_regex() -> &'static Regex {
+    static ISSUE_REF: OnceLock<Regex> = OnceLock::new();
+    ISSUE_REF
+        .get_or_init(|| Regex::new(r"(^|[^\w.&#/-])((?:([\w.-]+)/([\w.-]+))?#(\d+))\b").unwrap())
+}
+
+/// Returns the issue references in a body, in order of appearance, without
+/// duplicates. Short `#123` references resolve against `owner`/`repo`.
+pub fn extract_issue_refs(body: &str, owner: &str, repo: &str) -> Vec<IssueRef> {
+    let mut refs: Vec<IssueRef> = Vec::new();
+    for caps in issue_ref_regex().captures_iter(body) {
+        let issue = issue_ref_from_captures(&caps, owner, repo);
+        if !refs.contains(&issue) {
+            refs.push(issue);
+        }
+    }
+    refs
+}
+
+fn issue_ref_from_captures(caps: &regex::Captures, owner: &str, repo: &str) -> IssueRef {
+    IssueRef {
+        owner: caps.get(3).map_or(owner, |m| m.as_str()).to_string(),
+        repo: caps.get(4).map_or(repo, |m| m.as_str()).to_string(),
+        // The regex only matches digits; overflowing numbers fall back to 0
+        number: caps[5].parse().unwrap_or_default(),
+    }
+}
+
+/// Replaces each issue reference with "(#123: Title)" when `title_for`
+/// knows its title, leaving unresolved references as written.
+pub fn inline_issue_titles(
+    body: &str,
+    owner: &str,
+    repo: &str,
+    mut title_for: impl FnMut(&IssueRef) -> Option<String>,
+) -> String {
+    issue_ref_regex()
+        .replace_all(body, |caps: &regex::Captures| {
+            let issue = issue_ref_from_captures(caps, owner, repo);
+            match title_for(&issue) {
+                Some(title) => format!("{}({}: {title})", &caps[1], &caps[2]),
+                None => caps[0].to_string(),
+            }
+        })
+        .into_owned()
+}
+
+/// Returns true if the body contains an http(s) URL.
+pub fn has_links(body: &str) -> bool {
+    !extract_links(body).is_empty()
+}
+
+/// Keeps only comments whose body contains a link.
+pub fn filter_with_links(comments: Vec<PRComment>) -> Vec<PRComment> {
+    comments
+        .into_iter()
+        .filter(|c| has_links(&c.body))
+        .collect()
+}
+
+/// Keeps only comments asking for tests or coverage.
+pub fn filter_test_requests(comments: Vec<PRComment>) -> Vec<PRComment> {
+    comments
+        .into_iter()
+        .filter(PRComment::requests_tests)
+        .collect()
+}
+
+/// Keeps only comments with a ```` ```suggestion ```` block.
+pub fn filter_suggestions(comments: Vec<PRComment>) -> Vec<PRComment> {
+    comments
+        .into_iter()
+        .filter(|c| c.extract_suggestion().is_some())
+        .collect()
+}
+
+/// Keeps only comments from reviews that requested changes.
+pub fn filter_blocking(comments: Vec<PRComment>) -> Vec<PRComment> {
+    comments.into_iter().filter(|c| c.blocking).collect()
+}
+
 /// Filters comments by author username.
 ///
 /// If author is None or empty, returns all comments.
@@ -176,6 +755,249 @@ pub fn filter_by_author(comments: Vec<PRComment>, author: Option<&str>) -> Vec<P
     }
 }
 
+/// Filters comments to those on `line` in any file.
+///
+/// A range comment matches if `line` falls within start_line..=line_number.
+pub fn filter_by_line(comments: Vec<PRComment>, line: i32) -> Vec<PRComment> {
+    comments
+        .into_iter()
+        .filter(|c| match (c.start_line, c.line_number) {
+            (Some(start), Some(end)) => (start..=end).contains(&line),
+            (_, Some(end)) => end == line,
+            (Some(start), None) => start == line,
+            (None, None) => false,
+        })
+        .collect()
+}
+
+/// Filters comments to those whose line falls within `min..=max`; a missing
+/// bound is unbounded.
+///
+/// Comments without a line number are excluded.
+pub fn filter_by_line_range(
+    comments: Vec<PRComment>,
+    min: Option<i32>,
+    max: Option<i32>,
+) -> Vec<PRComment> {
+    comments
+        .into_iter()
+        .filter(|c| {
+            c.line_number.is_some_and(|line| {
+                min.is_none_or(|min| line >= min) && max.is_none_or(|max| line <= max)
+            })
+        })
+        .collect()
+}
+
+/// Filters comments to those on files whose path matches `glob`.
+///
+/// `*` also matches across `/`, so `*.go` covers nested files too.
+/// Review-level comments have no path and are excluded.
+pub fn filter_by_file_glob(comments: Vec<PRComment>, glob: &Glob) -> Vec<PRComment> {
+    let matcher = glob.compile_matcher();
+    comments
+        .into_iter()
+        .filter(|c| !c.is_general() && matcher.is_match(&c.file_path))
+        .collect()
+}
+
+/// Filters comments to those whose code context matches `pattern`.
+///
+/// Both the full code snippet and the raw diff hunk (with its `@@` header
+/// and +/- markers) are searched, so either form of pattern works.
+pub fn filter_by_snippet_regex(comments: Vec<PRComment>, pattern: &Regex) -> Vec<PRComment> {
+    comments
+        .into_iter()
+        .filter(|c| {
+            pattern.is_match(&c.get_code_snippet(usize::MAX)) || pattern.is_match(&c.diff_hunk)
+        })
+        .collect()
+}
+
+/// Filters comments to those whose author login matches `pattern`.
+///
+/// The pattern is unanchored, so use `^...$` to match a whole login.
+pub fn filter_by_author_regex(comments: Vec<PRComment>, pattern: &Regex) -> Vec<PRComment> {
+    comments
+        .into_iter()
+        .filter(|c| pattern.is_match(&c.author))
+        .collect()
+}
+
+/// Filters comments to those written by any of `logins`.
+///
+/// Empty logins are ignored; if none remain, returns all comments.
+pub fn filter_by_authors(comments: Vec<PRComment>, logins: &[String]) -> Vec<PRComment> {
+    let logins: Vec<&str> = logins
+        .iter()
+        .map(String::as_str)
+        .filter(|l| !l.is_empty())
+        .collect();
+    if logins.is_empty() {
+        return comments;
+    }
+    comments
+        .into_iter()
+        .filter(|c| logins.contains(&c.author.as_str()))
+        .collect()
+}
+
+/// Drops comments written by bot accounts (logins ending in "[bot]").
+pub fn filter_bots(comments: Vec<PRComment>) -> Vec<PRComment> {
+    comments.into_iter().filter(|c| !c.is_bot()).collect()
+}
+
+/// Keeps comments whose inferred severity is at least `min`.
+pub fn filter_by_min_severity(comments: Vec<PRComment>, min: Severity) -> Vec<PRComment> {
+    comments
+        .into_iter()
+        .filter(|c| c.infer_severity() >= min)
+        .collect()
+}
+
+/// Keeps only comments written by bot accounts.
+pub fn filter_only_bots(comments: Vec<PRComment>) -> Vec<PRComment> {
+    comments.into_iter().filter(PRComment::is_bot).collect()
+}
+
+/// Resolves the root comment ID of the thread a comment belongs to.
+///
+/// Follows `in_reply_to_id` links through `parents` until reaching a comment
+/// with no parent or a parent that is not in the map.
+fn thread_root_id(id: i64, parents: &HashMap<i64, Option<i64>>) -> i64 {
+    let mut current = id;
+    // Bound the walk by the number of comments to guard against cycles
+    for _ in 0..parents.len() {
+        match parents.get(&current).copied().flatten() {
+            Some(parent) if parents.contains_key(&parent) => current = parent,
+            _ => break,
+        }
+    }
+    current
+}
+
+/// Groups comments into review threads, keyed by the root comment's ID.
+///
+/// Each thread holds its root and every reply (including replies to
+/// replies), oldest first. Replies whose parent is missing from `comments`
+/// start their own thread.
+pub fn group_by_thread(comments: &[PRComment]) -> HashMap<i64, Vec<&PRComment>> {
+    let parents: HashMap<i64, Option<i64>> =
+        comments.iter().map(|c| (c.id, c.in_reply_to_id)).collect();
+
+    let mut threads: HashMap<i64, Vec<&PRComment>> = HashMap::new();
+    for comment in comments {
+        threads
+            .entry(thread_root_id(comment.id, &parents))
+            .or_default()
+            .push(comment);
+    }
+    for thread in threads.values_mut() {
+        thread.sort_by(|a, b| {
+            a.created_at
+                .cmp(&b.created_at)
+                .then_with(|| a.id.cmp(&b.id))
+        });
+    }
+    threads
+}
+
+/// Keeps only human engagement with bot review threads.
+///
+/// For each thread rooted at a bot comment, the root is kept along with any
+/// non-bot replies; bot replies are dropped, and threads without any human
+/// reply are dropped entirely. Threads rooted at human comments are unchanged.
+pub fn filter_human_responses_to_bots(comments: Vec<PRComment>) -> Vec<PRComment> {
+    let parents: HashMap<i64, Option<i64>> =
+        comments.iter().map(|c| (c.id, c.in_reply_to_id)).collect();
+    let bot_roots: HashMap<i64, bool> = comments.iter().map(|c| (c.id, c.is_bot())).collect();
+
+    // Bot-rooted threads that have at least one human reply
+    let engaged_roots: HashSet<i64> = comments
+        .iter()
+        .filter(|c| c.in_reply_to_id.is_some() && !c.is_bot())
+        .map(|c| thread_root_id(c.id, &parents))
+        .filter(|root| bot_roots.get(root).copied().unwrap_or(false))
+        .collect();
+
+    comments
+        .into_iter()
+        .filter(|c| {
+            let root = thread_root_id(c.id, &parents);
+            if !bot_roots.get(&root).copied().unwrap_or(false) {
+                return true;
+            }
+            engaged_roots.contains(&root) && (c.id == root || !c.is_bot())
+        })
+        .collect()
+}
+
+/// Returns the inclusive line span a comment covers, or None if it has no line.
+fn line_span(comment: &PRComment) -> Option<(i32, i32)> {
+    let end = comment.line_number?;
+    Some((comment.start_line.unwrap_or(end).min(end), end))
+}
+
+/// Returns true if two comments are the same text by the same author on
+/// overlapping lines of the same file.
+fn is_duplicate(a: &PRComment, b: &PRComment) -> bool {
+    let overlapping = match (line_span(a), line_span(b)) {
+        (Some((a_start, a_end)), Some((b_start, b_end))) => a_start <= b_end && b_start <= a_end,
+        (None, None) => true,
+        _ => false,
+    };
+    overlapping && a.author == b.author && a.file_path == b.file_path && a.body == b.body
+}
+
+/// Collapses duplicate comments, which GitHub can surface after a force-push
+/// with the same body on both the current and original position.
+///
+/// Comments with the same author, file and body on overlapping lines are
+/// merged, keeping the most recently updated one in the first one's place.
+pub fn dedupe_comments(comments: Vec<PRComment>) -> Vec<PRComment> {
+    let mut kept: Vec<PRComment> = Vec::with_capacity(comments.len());
+    for comment in comments {
+        match kept.iter_mut().find(|k| is_duplicate(k, &comment)) {
+            Some(existing) => {
+                // Equal timestamps go to the higher (later) ID
+                if (comment.updated_at, comment.id) > (existing.updated_at, existing.id) {
+                    *existing = comment;
+                }
+            }
+            None => kept.push(comment),
+        }
+    }
+    kept
+}
+
+/// Keeps the first `limit` comments of an already-sorted list. A limit of 0
+/// keeps every comment.
+pub fn limit_comments(mut comments: Vec<PRComment>, limit: usize) -> Vec<PRComment> {
+    if limit > 0 {
+        comments.truncate(limit);
+    }
+    comments
+}
+
+/// Keeps at most `limit` comments, the most recently updated ones, in their
+/// existing order. A limit of 0 keeps every comment.
+pub fn limit_most_recent(comments: Vec<PRComment>, limit: usize) -> Vec<PRComment> {
+    if limit == 0 || comments.len() <= limit {
+        return comments;
+    }
+
+    // Equal timestamps go to the higher (later) ID, as in get_most_recent_per_file
+    let mut by_recency: Vec<(DateTime<Utc>, i64)> =
+        comments.iter().map(|c| (c.updated_at, c.id)).collect();
+    by_recency.sort_unstable_by(|a, b| b.cmp(a));
+    let cutoff = by_recency[limit - 1];
+
+    comments
+        .into_iter()
+        .filter(|c| (c.updated_at, c.id) >= cutoff)
+        .collect()
+}
+
 /// Gets the most recent comment per file.
 ///
 /// Groups comments by file_path and keeps only the most recently updated one.
@@ -185,7 +1007,8 @@ pub fn get_most_recent_per_file(comments: Vec<PRComment>) -> Vec<PRComment> {
     for comment in comments {
         let file_path = comment.file_path.clone();
         if let Some(existing) = file_map.get(&file_path) {
-            if comment.updated_at > existing.updated_at {
+            // Equal timestamps go to the higher (later) ID
+            if (comment.updated_at, comment.id) > (existing.updated_at, existing.id) {
                 file_map.insert(file_path, comment);
             }
         } else {
@@ -193,33 +1016,160 @@ pub fn get_most_recent_per_file(comments: Vec<PRComment>) -> Vec<PRComment> {
         }
     }
 
-    file_map.into_values().collect()
+    // HashMap order varies between runs; sort so output is reproducible
+    let mut recent: Vec<PRComment> = file_map.into_values().collect();
+    recent.sort_by(|a, b| a.file_path.cmp(&b.file_path));
+    recent
+}
+
+/// Tallies comments by the key returned from `key_fn`.
+///
+/// Returns (key, count) pairs sorted by count descending, then key ascending.
+pub fn count_by<F>(comments: &[PRComment], key_fn: F) -> Vec<(String, usize)>
+where
+    F: Fn(&PRComment) -> String,
+{
+    let mut counts: HashMap<String, usize> = HashMap::new();
+    for comment in comments {
+        *counts.entry(key_fn(comment)).or_default() += 1;
+    }
+
+    let mut tallies: Vec<(String, usize)> = counts.into_iter().collect();
+    tallies.sort_by(|a, b| b.1.cmp(&a.1).then_with(|| a.0.cmp(&b.0)));
+    tallies
+}
+
+/// Computes summary counts for `comments`.
+pub fn compute_stats(comments: &[PRComment]) -> ReviewStats {
+    let comments_per_author = count_by(comments, |c| c.author.clone());
+    let on_files: Vec<PRComment> = comments
+        .iter()
+        .filter(|c| !c.is_general())
+        .cloned()
+        .collect();
+    let comments_per_file = count_by(&on_files, |c| c.file_path.clone());
+    let earliest = comments.iter().map(|c| c.created_at).min();
+    let latest = comments.iter().map(|c| c.created_at).max();
+    ReviewStats {
+        total_comments: comments.len(),
+        unique_files: comments_per_file.len(),
+        unique_authors: comments_per_author.len(),
+        time_span: earliest.zip(latest),
+        busiest_file: comments_per_file.first().cloned(),
+        comments_per_author,
+        comments_per_file,
+    }
 }
 
 /// Groups comments by file path.
 pub fn group_by_file(comments: &[PRComment]) -> HashMap<String, Vec<&PRComment>> {
-    let mut grouped: HashMap<String, Vec<&PRComment>> = HashMap::new();
+    group_by(comments, |c| c.file_path.clone())
+}
+
+/// Groups comments by author login.
+pub fn group_by_author(comments: &[PRComment]) -> HashMap<String, Vec<&PRComment>> {
+    group_by(comments, |c| c.author.clone())
+}
 
+/// Groups comments by inferred severity, ordering each group by file, line
+/// and ID.
+pub fn group_by_severity(comments: &[PRComment]) -> BTreeMap<Severity, Vec<&PRComment>> {
+    let mut grouped: BTreeMap<Severity, Vec<&PRComment>> = BTreeMap::new();
     for comment in comments {
         grouped
-            .entry(comment.file_path.clone())
+            .entry(comment.infer_severity())
             .or_default()
             .push(comment);
     }
+    for group in grouped.values_mut() {
+        group.sort_by(|a, b| {
+            (&a.file_path, a.line_number, a.id).cmp(&(&b.file_path, b.line_number, b.id))
+        });
+    }
+    grouped
+}
+
+/// Groups comments by a computed key, keeping their relative order.
+pub fn group_by<F>(comments: &[PRComment], key: F) -> HashMap<String, Vec<&PRComment>>
+where
+    F: Fn(&PRComment) -> String,
+{
+    let mut grouped: HashMap<String, Vec<&PRComment>> = HashMap::new();
+
+    for comment in comments {
+        grouped.entry(key(comment)).or_default().push(comment);
+    }
 
     grouped
 }
 
-/// Parses a GraphQL response into a ChecksReport.
-pub fn parse_checks_response(response: &Value) -> Result<ChecksReport, GitHubAPIError> {
-    let pr = response
-        .pointer("/data/repository/pullRequest")
+/// Parses a review threads GraphQL response into each comment's thread
+/// resolution, keyed by comment ID.
+pub fn parse_resolved_state(response: &Value) -> Result<HashMap<i64, bool>, GitHubAPIError> {
+    let threads = response
+        .pointer("/data/repository/pullRequest/reviewThreads/nodes")
+        .and_then(|n| n.as_array())
         .ok_or_else(|| {
-            GitHubAPIError::ParseError("Missing pullRequest in GraphQL response".to_string())
+            GitHubAPIError::ParseError("Missing reviewThreads in GraphQL response".to_string())
         })?;
 
-    let pr_title = pr.get("title").and_then(|v| v.as_str()).map(String::from);
-    let pr_url = pr.get("url").and_then(|v| v.as_str()).map(String::from);
+    let mut state = HashMap::new();
+    for thread in threads {
+        let resolved = thread
+            .get("isResolved")
+            .and_then(|v| v.as_bool())
+            .unwrap_or(false);
+        let ids = thread
+            .pointer("/comments/nodes")
+            .and_then(|n| n.as_array())
+            .into_iter()
+            .flatten()
+            .filter_map(|c| c.get("databaseId").and_then(|v| v.as_i64()));
+        state.extend(ids.map(|id| (id, resolved)));
+    }
+    Ok(state)
+}
+
+/// Records each comment's thread resolution from `state`.
+///
+/// Comments missing from `state` (e.g. review bodies) stay unresolved.
+pub fn apply_resolved_state(
+    comments: Vec<PRComment>,
+    state: &HashMap<i64, bool>,
+) -> Vec<PRComment> {
+    comments
+        .into_iter()
+        .map(|mut c| {
+            c.resolved = state.get(&c.id).copied().unwrap_or_default();
+            c
+        })
+        .collect()
+}
+
+/// Keeps review thread comments whose resolution matches `resolved`.
+///
+/// Comments outside any review thread (missing from `state`) are dropped.
+pub fn filter_resolved(
+    comments: Vec<PRComment>,
+    state: &HashMap<i64, bool>,
+    resolved: bool,
+) -> Vec<PRComment> {
+    comments
+        .into_iter()
+        .filter(|c| state.get(&c.id) == Some(&resolved))
+        .collect()
+}
+
+/// Parses a GraphQL response into a ChecksReport.
+pub fn parse_checks_response(response: &Value) -> Result<ChecksReport, GitHubAPIError> {
+    let pr = response
+        .pointer("/data/repository/pullRequest")
+        .ok_or_else(|| {
+            GitHubAPIError::ParseError("Missing pullRequest in GraphQL response".to_string())
+        })?;
+
+    let pr_title = pr.get("title").and_then(|v| v.as_str()).map(String::from);
+    let pr_url = pr.get("url").and_then(|v| v.as_str()).map(String::from);
 
     let commit = pr
         .pointer("/commits/nodes/0/commit")
@@ -473,6 +1423,48 @@ mod tests {
 
         let comment = parse_comment(&data).unwrap();
         assert_eq!(comment.line_number, Some(42));
+        assert!(comment.outdated);
+    }
+
+    #[test]
+    fn test_parse_comment_line_zero_is_file_level() {
+        let data = json!({
+            "id": 123,
+            "path": "src/main.rs",
+            "line": 0,
+            "original_line": 0,
+            "user": {"login": "testuser"},
+            "body": "This file needs a module doc",
+            "created_at": "2024-01-15T10:30:00Z",
+            "updated_at": "2024-01-15T10:30:00Z"
+        });
+
+        let comment = parse_comment(&data).unwrap();
+        assert_eq!(comment.line_number, Some(0));
+        assert!(!comment.outdated);
+        assert_eq!(comment.get_line_info(), "file-level");
+
+        let output = crate::formatter::format_comments_minimal(&[comment]);
+        assert!(output.contains("(file-level)"));
+        assert!(!output.contains("line 0"));
+    }
+
+    #[test]
+    fn test_parse_comment_current_line_not_outdated() {
+        let data = json!({
+            "id": 123,
+            "path": "src/main.rs",
+            "line": 40,
+            "original_line": 42,
+            "user": {"login": "testuser"},
+            "body": "Test comment",
+            "created_at": "2024-01-15T10:30:00Z",
+            "updated_at": "2024-01-15T10:30:00Z"
+        });
+
+        let comment = parse_comment(&data).unwrap();
+        assert_eq!(comment.line_number, Some(40));
+        assert!(!comment.outdated);
     }
 
     #[test]
@@ -589,6 +1581,1007 @@ mod tests {
         ]
     }
 
+    fn create_thread_comment(id: i64, author: &str, in_reply_to_id: Option<i64>) -> PRComment {
+        let mut comment = PRComment::new(
+            id,
+            None,
+            "file1.rs".to_string(),
+            Some(10),
+            None,
+            author.to_string(),
+            format!("Comment {id}"),
+            Utc.with_ymd_and_hms(2024, 1, 15, 10, 0, 0).unwrap(),
+            Utc.with_ymd_and_hms(2024, 1, 15, 10, 0, 0).unwrap(),
+            "".to_string(),
+            "".to_string(),
+        );
+        comment.in_reply_to_id = in_reply_to_id;
+        comment
+    }
+
+    #[test]
+    fn test_parse_comment_in_reply_to_id() {
+        let data = json!({
+            "id": 124,
+            "in_reply_to_id": 123,
+            "path": "src/main.rs",
+            "user": {"login": "testuser"},
+            "body": "Reply",
+            "created_at": "2024-01-15T10:30:00Z",
+            "updated_at": "2024-01-15T10:30:00Z"
+        });
+
+        let comment = parse_comment(&data).unwrap();
+        assert_eq!(comment.in_reply_to_id, Some(123));
+    }
+
+    #[test]
+    fn test_parse_comment_avatar_url() {
+        let data = json!({
+            "id": 123,
+            "path": "src/main.rs",
+            "user": {"login": "testuser", "avatar_url": "https://avatars.githubusercontent.com/u/1?v=4"},
+            "body": "Comment",
+            "created_at": "2024-01-15T10:30:00Z",
+            "updated_at": "2024-01-15T10:30:00Z"
+        });
+
+        let comment = parse_comment(&data).unwrap();
+        assert_eq!(
+            comment.author_avatar_url.as_deref(),
+            Some("https://avatars.githubusercontent.com/u/1?v=4")
+        );
+    }
+
+    #[test]
+    fn test_parse_review_comment_avatar_url() {
+        let data = json!({
+            "id": 1,
+            "user": {"login": "reviewer", "avatar_url": "https://avatars.githubusercontent.com/u/2?v=4"},
+            "body": "Looks good",
+            "submitted_at": "2024-01-15T10:30:00Z"
+        });
+
+        let comment = parse_review_comment(&data).unwrap();
+        assert_eq!(
+            comment.author_avatar_url.as_deref(),
+            Some("https://avatars.githubusercontent.com/u/2?v=4")
+        );
+    }
+
+    #[test]
+    fn test_parse_comment_reactions() {
+        let data = json!({
+            "id": 123,
+            "path": "src/main.rs",
+            "user": {"login": "testuser"},
+            "body": "Comment",
+            "created_at": "2024-01-15T10:30:00Z",
+            "updated_at": "2024-01-15T10:30:00Z",
+            "reactions": {
+                "url": "https://api.github.com/repos/o/r/pulls/comments/123/reactions",
+                "total_count": 6,
+                "+1": 2, "-1": 1, "laugh": 0, "hooray": 1,
+                "confused": 1, "heart": 1, "rocket": 0, "eyes": 0
+            }
+        });
+
+        let comment = parse_comment(&data).unwrap();
+        assert_eq!(comment.reactions.plus_one, 2);
+        assert_eq!(comment.reactions.minus_one, 1);
+        assert_eq!(comment.reactions.heart, 1);
+        assert_eq!(comment.reaction_score(), 2);
+    }
+
+    #[test]
+    fn test_parse_comment_without_reactions() {
+        let data = json!({
+            "id": 123,
+            "user": {"login": "testuser"},
+            "created_at": "2024-01-15T10:30:00Z",
+            "updated_at": "2024-01-15T10:30:00Z"
+        });
+
+        let comment = parse_comment(&data).unwrap();
+        assert_eq!(comment.reactions, Reactions::default());
+    }
+
+    #[test]
+    fn test_filter_updated_since() {
+        let mut comments = create_test_comments();
+        comments[0].updated_at = DateTime::UNIX_EPOCH;
+        let cutoff = comments[1].updated_at;
+
+        // The boundary is inclusive; the epoch timestamp is always older
+        let filtered = filter_updated_since(comments.clone(), cutoff, true);
+        assert!(filtered.iter().any(|c| c.id == 2));
+        assert!(filtered.iter().all(|c| c.updated_at >= cutoff && c.id != 1));
+
+        let all = filter_updated_since(comments, DateTime::UNIX_EPOCH, true);
+        assert_eq!(all.len(), 3);
+    }
+
+    #[test]
+    fn test_sort_by_reaction_score() {
+        let mut comments = create_test_comments();
+        comments[1].reactions.plus_one = 3;
+        comments[2].reactions.confused = 1;
+        comments[0].reactions.heart = 1;
+        comments[0].reactions.minus_one = 1;
+
+        let sorted = sort_by_reaction_score(comments);
+        let ids: Vec<i64> = sorted.iter().map(|c| c.id).collect();
+        assert_eq!(ids, vec![2, 1, 3]);
+    }
+
+    #[test]
+    fn test_sort_by_agent_order() {
+        let mut comments = create_test_comments();
+        for (id, line) in [(4, Some(12)), (5, Some(55)), (6, None)] {
+            let mut comment = create_thread_comment(id, "user1", None);
+            comment.file_path = "src/main.py".to_string();
+            comment.line_number = line;
+            comments.push(comment);
+        }
+
+        let sorted = sort_by_agent_order(comments);
+        let ids: Vec<i64> = sorted.iter().map(|c| c.id).collect();
+        // file1.rs: 20 then 10; file2.rs; src/main.py: 55 before 12, line-less last
+        assert_eq!(ids, vec![2, 1, 3, 5, 4, 6]);
+        assert_eq!(agent_order(&sorted[3], &sorted[4]), Ordering::Less);
+    }
+
+    #[test]
+    fn test_group_by_thread() {
+        let raw = vec![
+            json!({"id": 124, "in_reply_to_id": 123, "path": "src/main.rs", "line": 10,
+                   "user": {"login": "author"}, "body": "Fixed",
+                   "created_at": "2024-01-15T11:00:00Z", "updated_at": "2024-01-15T11:00:00Z"}),
+            json!({"id": 123, "path": "src/main.rs", "line": 10,
+                   "user": {"login": "reviewer"}, "body": "Handle the error",
+                   "created_at": "2024-01-15T10:00:00Z", "updated_at": "2024-01-15T10:00:00Z"}),
+            json!({"id": 125, "in_reply_to_id": 124, "path": "src/main.rs", "line": 10,
+                   "user": {"login": "reviewer"}, "body": "Thanks",
+                   "created_at": "2024-01-15T12:00:00Z", "updated_at": "2024-01-15T12:00:00Z"}),
+            json!({"id": 200, "path": "src/lib.rs", "line": 3,
+                   "user": {"login": "reviewer"}, "body": "Unrelated",
+                   "created_at": "2024-01-15T09:00:00Z", "updated_at": "2024-01-15T09:00:00Z"}),
+            json!({"id": 300, "in_reply_to_id": 999, "path": "src/lib.rs", "line": 8,
+                   "user": {"login": "author"}, "body": "Reply to a deleted comment",
+                   "created_at": "2024-01-15T09:00:00Z", "updated_at": "2024-01-15T09:00:00Z"}),
+        ];
+        let comments = parse_comments(&raw);
+        let threads = group_by_thread(&comments);
+
+        assert_eq!(threads.len(), 3);
+        let ids: Vec<i64> = threads[&123].iter().map(|c| c.id).collect();
+        assert_eq!(ids, vec![123, 124, 125]);
+        assert_eq!(threads[&200].len(), 1);
+        assert_eq!(threads[&300].len(), 1);
+    }
+
+    #[test]
+    fn test_parse_comment_without_in_reply_to_id() {
+        let data = json!({
+            "id": 123,
+            "path": "src/main.rs",
+            "user": {"login": "testuser"},
+            "body": "Root",
+            "created_at": "2024-01-15T10:30:00Z",
+            "updated_at": "2024-01-15T10:30:00Z"
+        });
+
+        let comment = parse_comment(&data).unwrap();
+        assert!(comment.in_reply_to_id.is_none());
+        assert!(comment.author_avatar_url.is_none());
+    }
+
+    #[test]
+    fn test_filter_human_responses_to_bots_keeps_engaged_thread() {
+        let comments = vec![
+            create_thread_comment(1, "devin-ai-integration[bot]", None),
+            create_thread_comment(2, "reviewer1", Some(1)),
+            create_thread_comment(3, "devin-ai-integration[bot]", Some(1)),
+        ];
+        let filtered = filter_human_responses_to_bots(comments);
+        let ids: Vec<i64> = filtered.iter().map(|c| c.id).collect();
+        assert_eq!(ids, vec![1, 2]);
+    }
+
+    #[test]
+    fn test_filter_human_responses_to_bots_drops_bot_only_thread() {
+        let comments = vec![
+            create_thread_comment(1, "devin-ai-integration[bot]", None),
+            create_thread_comment(2, "devin-ai-integration[bot]", Some(1)),
+            create_thread_comment(3, "coderabbitai[bot]", None),
+        ];
+        let filtered = filter_human_responses_to_bots(comments);
+        assert!(filtered.is_empty());
+    }
+
+    #[test]
+    fn test_filter_human_responses_to_bots_keeps_human_threads() {
+        let comments = vec![
+            create_thread_comment(1, "reviewer1", None),
+            create_thread_comment(2, "devin-ai-integration[bot]", Some(1)),
+            create_thread_comment(3, "reviewer2", None),
+        ];
+        let filtered = filter_human_responses_to_bots(comments);
+        assert_eq!(filtered.len(), 3);
+    }
+
+    #[test]
+    fn test_filter_human_responses_to_bots_nested_reply_chain() {
+        // Reply to a reply still resolves to the bot root
+        let comments = vec![
+            create_thread_comment(1, "devin-ai-integration[bot]", None),
+            create_thread_comment(2, "devin-ai-integration[bot]", Some(1)),
+            create_thread_comment(3, "reviewer1", Some(2)),
+        ];
+        let filtered = filter_human_responses_to_bots(comments);
+        let ids: Vec<i64> = filtered.iter().map(|c| c.id).collect();
+        assert_eq!(ids, vec![1, 3]);
+    }
+
+    #[test]
+    fn test_filter_human_responses_to_bots_orphan_reply() {
+        // A reply whose parent was not fetched is treated as its own root
+        let comments = vec![create_thread_comment(5, "reviewer1", Some(99))];
+        let filtered = filter_human_responses_to_bots(comments);
+        assert_eq!(filtered.len(), 1);
+    }
+
+    #[test]
+    fn test_thread_root_id_cycle_terminates() {
+        let parents: HashMap<i64, Option<i64>> = [(1, Some(2)), (2, Some(1))].into_iter().collect();
+        let root = thread_root_id(1, &parents);
+        assert!(root == 1 || root == 2);
+    }
+
+    #[test]
+    fn test_parse_pr_info() {
+        let info = parse_pr_info(&json!({
+            "title": "Add feature",
+            "html_url": "https://github.com/owner/repo/pull/1",
+            "node_id": "PR_kwDOtest",
+            "head": {"sha": "abc123"}
+        }));
+        assert_eq!(info.title.as_deref(), Some("Add feature"));
+        assert_eq!(
+            info.url.as_deref(),
+            Some("https://github.com/owner/repo/pull/1")
+        );
+        assert_eq!(info.node_id.as_deref(), Some("PR_kwDOtest"));
+        assert_eq!(info.head_sha.as_deref(), Some("abc123"));
+    }
+
+    #[test]
+    fn test_parse_pr_info_branches() {
+        let info = parse_pr_info(&json!({
+            "title": "Backport fix",
+            "base": {"ref": "release/1.2", "sha": "def456"},
+            "head": {"ref": "feature/x", "sha": "abc123"}
+        }));
+        assert_eq!(info.base_ref.as_deref(), Some("release/1.2"));
+        assert_eq!(info.head_ref.as_deref(), Some("feature/x"));
+    }
+
+    #[test]
+    fn test_parse_pr_info_full_payload() {
+        let info = parse_pr_info(&json!({
+            "url": "https://api.github.com/repos/owner/repo/pulls/7",
+            "id": 1,
+            "node_id": "PR_kwDOfull",
+            "html_url": "https://github.com/owner/repo/pull/7",
+            "number": 7,
+            "state": "open",
+            "locked": false,
+            "title": "Refactor parser",
+            "user": {"login": "octocat", "id": 583231, "type": "User"},
+            "body": "Splits the parser into modules",
+            "draft": true,
+            "merged": false,
+            "mergeable": false,
+            "mergeable_state": "dirty",
+            "head": {"label": "octocat:refactor", "ref": "refactor", "sha": "abc123"},
+            "base": {"label": "owner:main", "ref": "main", "sha": "def456"}
+        }));
+        assert_eq!(
+            info,
+            PRInfo {
+                title: Some("Refactor parser".to_string()),
+                url: Some("https://github.com/owner/repo/pull/7".to_string()),
+                node_id: Some("PR_kwDOfull".to_string()),
+                head_sha: Some("abc123".to_string()),
+                base_ref: Some("main".to_string()),
+                head_ref: Some("refactor".to_string()),
+                state: Some("open".to_string()),
+                draft: true,
+                mergeable: Some(false),
+                author: Some("octocat".to_string()),
+            }
+        );
+
+        // GitHub sends null while mergeability is being computed
+        let info = parse_pr_info(&json!({"state": "closed", "mergeable": null}));
+        assert_eq!(info.mergeable, None);
+        assert!(!info.draft);
+    }
+
+    #[test]
+    fn test_parse_pr_info_missing_fields() {
+        assert_eq!(parse_pr_info(&json!({})), PRInfo::default());
+    }
+
+    fn create_files_fixture() -> Vec<Value> {
+        vec![
+            json!({"filename": "file2.rs", "status": "modified", "additions": 120, "deletions": 30, "changes": 150}),
+            json!({"filename": "file1.rs", "status": "added", "additions": 10, "deletions": 0, "changes": 10}),
+        ]
+    }
+
+    #[test]
+    fn test_parse_pr_files() {
+        let files = parse_pr_files(&create_files_fixture());
+        assert_eq!(files.len(), 2);
+        assert_eq!(files[0].filename, "file2.rs");
+        assert_eq!(files[0].status, "modified");
+        assert_eq!(files[0].additions, 120);
+        assert_eq!(files[0].deletions, 30);
+        assert_eq!(files[0].changes, 150);
+        assert_eq!(files[1].filename, "file1.rs");
+    }
+
+    #[test]
+    fn test_review_coverage() {
+        let files = parse_pr_files(&create_files_fixture());
+        let mut comments = create_test_comments();
+        // A range comment on file2.rs lines 1-5, overlapping the line-5 comment
+        let mut range = create_thread_comment(4, "user1", None);
+        range.file_path = "file2.rs".to_string();
+        range.start_line = Some(1);
+        range.line_number = Some(5);
+        comments.push(range);
+
+        let coverage = review_coverage(&comments, &files);
+        assert_eq!(
+            coverage,
+            vec![
+                FileCoverage {
+                    filename: "file2.rs".to_string(),
+                    commented_lines: 5,
+                    changed_lines: 120
+                },
+                FileCoverage {
+                    filename: "file1.rs".to_string(),
+                    commented_lines: 2,
+                    changed_lines: 10
+                },
+            ]
+        );
+        assert_eq!(coverage[1].ratio(), Some(0.2));
+    }
+
+    #[test]
+    fn test_review_coverage_caps_and_empty_files() {
+        let files = vec![PRFile {
+            filename: "file1.rs".to_string(),
+            status: "removed".to_string(),
+            additions: 0,
+            deletions: 40,
+            changes: 40,
+        }];
+        let mut review = create_thread_comment(9, "user1", None);
+        review.line_number = None;
+        let coverage = review_coverage(&[create_thread_comment(1, "user1", None), review], &files);
+        assert_eq!(coverage[0].commented_lines, 0);
+        assert_eq!(coverage[0].ratio(), None);
+    }
+
+    #[test]
+    fn test_parse_pr_file_missing_filename() {
+        assert!(parse_pr_file(&json!({"status": "added"})).is_none());
+    }
+
+    #[test]
+    fn test_parse_pr_file_missing_counts() {
+        let file = parse_pr_file(&json!({"filename": "a.rs"})).unwrap();
+        assert_eq!(file.status, "");
+        assert_eq!(file.additions, 0);
+        assert_eq!(file.deletions, 0);
+        assert_eq!(file.changes, 0);
+    }
+
+    #[test]
+    fn test_filter_orphaned() {
+        let files = parse_pr_files(&create_files_fixture());
+        let mut comments = create_test_comments();
+        comments.push(create_thread_comment(4, "user3", None));
+        comments[3].file_path = "deleted.rs".to_string();
+        let mut review = create_thread_comment(5, "user3", None);
+        review.file_path = String::new();
+        comments.push(review);
+
+        let orphaned = filter_orphaned(comments.clone(), &files);
+        let ids: Vec<i64> = orphaned.iter().map(|c| c.id).collect();
+        assert_eq!(ids, vec![4]);
+
+        // The inverse keeps everything but the comment on deleted.rs
+        let changed = filter_changed_files(comments, &files);
+        let ids: Vec<i64> = changed.iter().map(|c| c.id).collect();
+        assert_eq!(ids, vec![1, 2, 3, 5]);
+    }
+
+    fn create_hotspot_fixture() -> Vec<PRComment> {
+        let mut comments = Vec::new();
+        for (id, file, line) in [
+            (1, "a.rs", Some(10)),
+            (2, "a.rs", Some(14)),
+            (3, "a.rs", Some(12)),
+            (4, "a.rs", Some(40)),
+            (5, "b.rs", Some(13)),
+            (6, "", None),
+        ] {
+            let mut comment = create_thread_comment(id, "user1", None);
+            comment.file_path = file.to_string();
+            comment.line_number = line;
+            comments.push(comment);
+        }
+        comments
+    }
+
+    #[test]
+    fn test_is_test_file() {
+        let matcher = test_file_matcher(&[]);
+        for path in [
+            "pkg/server/handler_test.go",
+            "test_models.py",
+            "app/tests/helpers.rs",
+            "tests/cli.rs",
+            "web/src/button.spec.ts",
+        ] {
+            assert!(is_test_file(path, &matcher), "{path} should be a test file");
+        }
+        for path in [
+            "pkg/server/handler.go",
+            "src/testing.rs",
+            "contest/main.py",
+            "",
+        ] {
+            assert!(!is_test_file(path, &matcher), "{path} is not a test file");
+        }
+
+        let matcher = test_file_matcher(&[Glob::new("fixtures/**").unwrap()]);
+        assert!(is_test_file("fixtures/data.json", &matcher));
+    }
+
+    #[test]
+    fn test_filter_test_files() {
+        let mut comments = create_test_comments();
+        comments[0].file_path = "server/handler_test.go".to_string();
+        comments[1].file_path = "server/handler.go".to_string();
+        comments[2].file_path = String::new();
+        let kept = filter_test_files(comments, &test_file_matcher(&[]));
+        let ids: Vec<i64> = kept.iter().map(|c| c.id).collect();
+        assert_eq!(ids, vec![2, 3]);
+    }
+
+    #[test]
+    fn test_cluster_by_proximity() {
+        let comments = create_hotspot_fixture();
+        let clusters: Vec<Vec<i64>> = cluster_by_proximity(&comments, 5)
+            .iter()
+            .map(|cluster| cluster.iter().map(|c| c.id).collect())
+            .collect();
+        assert_eq!(clusters, vec![vec![1, 3, 2], vec![4], vec![5]]);
+
+        // The 10-12-14 chain holds at radius 2 and splits at radius 1
+        assert_eq!(cluster_by_proximity(&comments, 2)[0].len(), 3);
+        assert_eq!(cluster_by_proximity(&comments, 1)[0].len(), 1);
+    }
+
+    #[test]
+    fn test_filter_hotspots() {
+        let hot = filter_hotspots(create_hotspot_fixture(), 3, 5);
+        let ids: Vec<i64> = hot.iter().map(|c| c.id).collect();
+        assert_eq!(ids, vec![1, 2, 3]);
+
+        assert!(filter_hotspots(create_hotspot_fixture(), 4, 5).is_empty());
+        assert_eq!(filter_hotspots(create_hotspot_fixture(), 1, 5).len(), 5);
+    }
+
+    #[test]
+    fn test_sort_by_diff_order() {
+        let order: Vec<String> = parse_pr_files(&create_files_fixture())
+            .into_iter()
+            .map(|f| f.filename)
+            .collect();
+        let mut comments = create_test_comments();
+        // Review-level comment without a file goes last
+        comments.push(create_thread_comment(4, "user3", None));
+        comments[3].file_path = String::new();
+
+        let sorted = sort_by_diff_order(comments, &order);
+        let ids: Vec<i64> = sorted.iter().map(|c| c.id).collect();
+        // file2.rs first (diff order), then file1.rs by line, then the review comment
+        assert_eq!(ids, vec![3, 1, 2, 4]);
+    }
+
+    #[test]
+    fn test_sort_by_diff_order_empty_order() {
+        let sorted = sort_by_diff_order(create_test_comments(), &[]);
+        let files: Vec<&str> = sorted.iter().map(|c| c.file_path.as_str()).collect();
+        assert_eq!(files, vec!["file1.rs", "file1.rs", "file2.rs"]);
+    }
+
+    #[test]
+    fn test_latest_review_submitted_by() {
+        let reviews = vec![
+            json!({"id": 1, "user": {"login": "me"}, "submitted_at": "2024-01-15T10:00:00Z"}),
+            json!({"id": 2, "user": {"login": "me"}, "submitted_at": "2024-01-16T10:00:00Z"}),
+            json!({"id": 3, "user": {"login": "other"}, "submitted_at": "2024-01-17T10:00:00Z"}),
+            json!({"id": 4, "user": {"login": "me"}, "state": "PENDING", "submitted_at": null}),
+        ];
+
+        let latest = latest_review_submitted_by(&reviews, "me").unwrap();
+        assert_eq!(latest, parse_datetime("2024-01-16T10:00:00Z").unwrap());
+        assert!(latest_review_submitted_by(&reviews, "nobody").is_none());
+    }
+
+    #[test]
+    fn test_filter_updated_since_exclusive() {
+        // Fixture comments are updated at 10:00, 11:00 and 12:00; the boundary
+        // itself is excluded
+        let since = parse_datetime("2024-01-15T11:00:00Z").unwrap();
+        let filtered = filter_updated_since(create_test_comments(), since, false);
+        let ids: Vec<i64> = filtered.iter().map(|c| c.id).collect();
+        assert_eq!(ids, vec![3]);
+
+        // A comment created before the cutoff but edited after it is new
+        let mut comments = create_test_comments();
+        comments[0].updated_at = parse_datetime("2024-01-15T13:00:00Z").unwrap();
+        let filtered = filter_updated_since(comments, since, false);
+        let ids: Vec<i64> = filtered.iter().map(|c| c.id).collect();
+        assert_eq!(ids, vec![1, 3]);
+    }
+
+    #[test]
+    fn test_filter_by_line() {
+        let mut comments = create_test_comments();
+        comments[0].line_number = Some(12);
+        comments[1].line_number = Some(55);
+        comments[2].line_number = Some(55);
+        comments[2].file_path = "file3.rs".to_string();
+
+        let filtered = filter_by_line(comments.clone(), 12);
+        assert_eq!(filtered.len(), 1);
+        assert_eq!(filtered[0].id, 1);
+
+        // Matches regardless of file
+        let filtered = filter_by_line(comments, 55);
+        let ids: Vec<i64> = filtered.iter().map(|c| c.id).collect();
+        assert_eq!(ids, vec![2, 3]);
+    }
+
+    #[test]
+    fn test_filter_by_line_within_range() {
+        let mut comments = create_test_comments();
+        comments[0].start_line = Some(8);
+        comments[0].line_number = Some(12);
+
+        let ids: Vec<i64> = filter_by_line(comments.clone(), 10)
+            .iter()
+            .map(|c| c.id)
+            .collect();
+        assert_eq!(ids, vec![1]);
+        assert!(filter_by_line(comments, 13).is_empty());
+    }
+
+    #[test]
+    fn test_filter_by_line_start_only_and_no_line() {
+        let mut comments = create_test_comments();
+        comments[0].line_number = None;
+        comments[0].start_line = Some(7);
+        comments[1].line_number = None;
+
+        let ids: Vec<i64> = filter_by_line(comments, 7).iter().map(|c| c.id).collect();
+        assert_eq!(ids, vec![1]);
+    }
+
+    #[test]
+    fn test_filter_by_line_range() {
+        // Lines 10, 20 and 5
+        let ids = |min, max| -> Vec<i64> {
+            filter_by_line_range(create_test_comments(), min, max)
+                .iter()
+                .map(|c| c.id)
+                .collect()
+        };
+        assert_eq!(ids(Some(5), Some(10)), vec![1, 3]);
+        assert_eq!(ids(Some(10), Some(20)), vec![1, 2]);
+        assert_eq!(ids(Some(11), Some(19)), Vec::<i64>::new());
+        assert_eq!(ids(Some(20), None), vec![2]);
+        assert_eq!(ids(None, Some(5)), vec![3]);
+        assert_eq!(ids(None, None), vec![1, 2, 3]);
+
+        let mut comments = create_test_comments();
+        comments[0].line_number = None;
+        let filtered = filter_by_line_range(comments, None, None);
+        assert_eq!(filtered.len(), 2);
+    }
+
+    #[test]
+    fn test_filter_by_file_glob() {
+        let mut comments = create_test_comments();
+        comments[0].file_path = "main.go".to_string();
+        comments[1].file_path = "src/api/handler.py".to_string();
+        comments[2].file_path = "src/models.py".to_string();
+        let mut general = comments[0].clone();
+        general.id = 4;
+        general.file_path = String::new();
+        comments.push(general);
+
+        let ids = |glob: &str| -> Vec<i64> {
+            filter_by_file_glob(comments.clone(), &Glob::new(glob).unwrap())
+                .iter()
+                .map(|c| c.id)
+                .collect()
+        };
+        assert_eq!(ids("*.go"), vec![1]);
+        assert_eq!(ids("src/**/*.py"), vec![2, 3]);
+        assert_eq!(ids("src/api/**"), vec![2]);
+        assert!(ids("*.rs").is_empty());
+    }
+
+    #[test]
+    fn test_find_line_references() {
+        assert_eq!(
+            find_line_references("See also line 88 for the caller"),
+            vec![88]
+        );
+        assert_eq!(
+            find_line_references("Same bug on Line 12 and L40."),
+            vec![12, 40]
+        );
+        assert_eq!(find_line_references("lines 5 and line 5 again"), vec![5]);
+        assert_eq!(
+            find_line_references("https://github.com/o/r/blob/main/a.rs#L7-L9"),
+            vec![7, 9]
+        );
+    }
+
+    #[test]
+    fn test_find_line_references_ignores_lookalikes() {
+        assert!(find_line_references("no references here").is_empty());
+        assert!(find_line_references("inline 5 deadline 6").is_empty());
+        assert!(find_line_references("LRU cache, line2, line x").is_empty());
+        assert!(find_line_references("URL99 HTML5 line 99999999999").is_empty());
+        assert!(find_line_references("line").is_empty());
+    }
+
+    #[test]
+    fn test_extract_tasks_mixed_checked_and_unchecked() {
+        let body = "Before merging:\n- [ ] Add tests\n- [x] Update docs\n  * [ ] Bump version\n+ [X] Changelog\n- [ ]   Rename `foo`  \nDone.";
+        assert_eq!(
+            extract_tasks(body),
+            vec!["Add tests", "Bump version", "Rename `foo`"]
+        );
+    }
+
+    #[test]
+    fn test_extract_links() {
+        let body = "See https://docs.rs/regex/latest/regex/. Related: [PR](https://github.com/o/r/pull/12), <http://example.com/a?b=1> and https://docs.rs/regex/latest/regex/ again!";
+        assert_eq!(
+            extract_links(body),
+            vec![
+                "https://docs.rs/regex/latest/regex/",
+                "https://github.com/o/r/pull/12",
+                "http://example.com/a?b=1"
+            ]
+        );
+        assert!(has_links(body));
+        assert!(!has_links(
+            "no links, just ftp://old.example and www.example.com"
+        ));
+    }
+
+    #[test]
+    fn test_extract_issue_refs() {
+        let body = "Fixes #12 and other/lib#7, see #12 again. Not: abc#3, a/b/c#4, &#39; or https://x.io/p#5";
+        let refs = extract_issue_refs(body, "owner", "repo");
+        let issue = |owner: &str, repo: &str, number| IssueRef {
+            owner: owner.to_string(),
+            repo: repo.to_string(),
+            number,
+        };
+        assert_eq!(
+            refs,
+            vec![issue("owner", "repo", 12), issue("other", "lib", 7)]
+        );
+        assert_eq!(extract_issue_refs("#1,#2", "o", "r").len(), 2);
+        assert!(extract_issue_refs("no refs #abc", "o", "r").is_empty());
+    }
+
+    #[test]
+    fn test_inline_issue_titles() {
+        let body = "Fixes #12 (also other/lib#7)";
+        let inlined = inline_issue_titles(body, "owner", "repo", |issue| {
+            (issue.number == 12).then(|| "Crash on start".to_string())
+        });
+        assert_eq!(inlined, "Fixes (#12: Crash on start) (also other/lib#7)");
+    }
+
+    #[test]
+    fn test_filter_with_links() {
+        let mut comments = create_test_comments();
+        comments[1].body = "Per https://github.com/o/r/issues/7 this is expected".to_string();
+        let filtered = filter_with_links(comments);
+        assert_eq!(filtered.len(), 1);
+        assert_eq!(filtered[0].id, 2);
+    }
+
+    #[test]
+    fn test_extract_tasks_ignores_lookalikes() {
+        assert!(extract_tasks("no tasks here").is_empty());
+        assert!(extract_tasks("- [ ]").is_empty());
+        assert!(extract_tasks("- [ ]x not a task").is_empty());
+        assert!(extract_tasks("-[ ] missing space").is_empty());
+        assert!(extract_tasks("text - [ ] inline").is_empty());
+    }
+
+    /// A comment whose hunk comes from a real-world Django bug report.
+    fn create_complex_comment() -> PRComment {
+        let mut comment = create_thread_comment(7, "devin-ai-integration[bot]", None);
+        comment.file_path = "attributes/services.py".to_string();
+        comment.start_line = Some(141);
+        comment.line_number = Some(144);
+        comment.diff_hunk = "@@ -138,7 +138,9 @@ def link_caches(attr_id):\n     unlinked_caches = AttributeCache.objects.filter(attribute_id=None)\n     for cache_entry in unlinked_caches:\n         cache_entry.attribute_id = attr_id\n-    AttributeCache.objects.update(attribute_id=attr_id)\n+    AttributeCache.objects.bulk_update(unlinked_caches, [\"attribute_id\"])".to_string();
+        comment
+    }
+
+    #[test]
+    fn test_filter_by_snippet_regex() {
+        let comments = vec![
+            create_complex_comment(),
+            create_thread_comment(8, "user1", None),
+        ];
+
+        let filtered =
+            filter_by_snippet_regex(comments.clone(), &Regex::new("bulk_update").unwrap());
+        assert_eq!(filtered.len(), 1);
+        assert_eq!(filtered[0].id, 7);
+
+        // Anchored patterns can target the raw hunk's +/- markers
+        let removed = Regex::new(r"(?m)^-.*\.update\(").unwrap();
+        assert_eq!(filter_by_snippet_regex(comments.clone(), &removed).len(), 1);
+
+        let no_match = Regex::new(r"\bbulk_create\b").unwrap();
+        assert!(filter_by_snippet_regex(comments, &no_match).is_empty());
+    }
+
+    #[test]
+    fn test_filter_by_author_matches_real_bot_login() {
+        let mut comments = create_test_comments();
+        comments[0].author = "devin-ai-integration[bot]".to_string();
+
+        // Normalized display names don't affect filtering, which uses the login
+        let filtered = filter_by_author(comments.clone(), Some("devin-ai-integration[bot]"));
+        assert_eq!(filtered.len(), 1);
+        assert!(filter_by_author(comments, Some("devin-ai-integration")).is_empty());
+    }
+
+    #[test]
+    fn test_parse_comments_file() {
+        let raw = parse_comments_file(r#"[{"id": 1, "path": "a.rs"}, {"id": 2}]"#).unwrap();
+        assert_eq!(raw.len(), 2);
+        assert_eq!(raw[0]["path"], "a.rs");
+
+        assert!(parse_comments_file("[]").unwrap().is_empty());
+        let err = parse_comments_file(r#"{"id": 1}"#).unwrap_err();
+        assert!(err
+            .to_string()
+            .contains("Expected a JSON array of comments"));
+    }
+
+    #[test]
+    fn test_filter_by_author_regex_bots() {
+        let mut comments = create_test_comments();
+        comments[1].author = "dependabot[bot]".to_string();
+
+        let bots = filter_by_author_regex(comments, &Regex::new(r".*\[bot\]$").unwrap());
+        assert_eq!(bots.len(), 1);
+        assert_eq!(bots[0].id, 2);
+    }
+
+    #[test]
+    fn test_filter_by_author_regex_anchored() {
+        let mut comments = create_test_comments();
+        comments[1].author = "user10".to_string();
+
+        let anchored = filter_by_author_regex(comments.clone(), &Regex::new("^user1$").unwrap());
+        let ids: Vec<i64> = anchored.iter().map(|c| c.id).collect();
+        assert_eq!(ids, vec![1, 3]);
+
+        let unanchored = filter_by_author_regex(comments, &Regex::new("user1").unwrap());
+        assert_eq!(unanchored.len(), 3);
+    }
+
+    #[test]
+    fn test_filter_by_authors_any_of() {
+        let mut comments = create_test_comments();
+        comments[2].author = "user3".to_string();
+
+        let logins = vec!["user1".to_string(), "user3".to_string()];
+        let filtered = filter_by_authors(comments, &logins);
+        let ids: Vec<i64> = filtered.iter().map(|c| c.id).collect();
+        assert_eq!(ids, vec![1, 3]);
+    }
+
+    #[test]
+    fn test_filter_by_authors_single_matches_filter_by_author() {
+        let logins = vec!["user1".to_string()];
+        assert_eq!(
+            filter_by_authors(create_test_comments(), &logins),
+            filter_by_author(create_test_comments(), Some("user1"))
+        );
+    }
+
+    #[test]
+    fn test_filter_by_authors_empty() {
+        assert_eq!(filter_by_authors(create_test_comments(), &[]).len(), 3);
+        let blank = vec![String::new()];
+        assert_eq!(filter_by_authors(create_test_comments(), &blank).len(), 3);
+    }
+
+    #[test]
+    fn test_group_by_severity() {
+        let mut comments = create_test_comments();
+        comments[0].body = "nit: rename".to_string();
+        comments[2].body = "Security: token leak".to_string();
+        comments.push(comments[2].clone());
+        comments[3].id = 4;
+        comments[3].file_path = "a.rs".to_string();
+
+        let grouped = group_by_severity(&comments);
+        let ids = |s: Severity| grouped[&s].iter().map(|c| c.id).collect::<Vec<_>>();
+        assert_eq!(ids(Severity::Critical), vec![4, 3]);
+        assert_eq!(ids(Severity::Nit), vec![1]);
+        assert_eq!(ids(Severity::None), vec![2]);
+        assert!(!grouped.contains_key(&Severity::Warning));
+    }
+
+    #[test]
+    fn test_resolved_state_merge_and_filter() {
+        let response = json!({"data": {"repository": {"pullRequest": {"reviewThreads": {"nodes": [
+            {"isResolved": true, "comments": {"nodes": [{"databaseId": 1}, {"databaseId": 2}]}},
+            {"isResolved": false, "comments": {"nodes": [{"databaseId": 3}, {"id": "x"}]}},
+            {"comments": {"nodes": []}},
+            {"isResolved": true}
+        ]}}}}});
+        let state = parse_resolved_state(&response).unwrap();
+        assert_eq!(state, HashMap::from([(1, true), (2, true), (3, false)]));
+
+        let mut comments = create_test_comments();
+        comments.push(create_thread_comment(4, "reviewer", None));
+        let comments = apply_resolved_state(comments, &state);
+        let resolved: Vec<bool> = comments.iter().map(|c| c.resolved).collect();
+        assert_eq!(resolved, vec![true, true, false, false]);
+
+        let ids = |c: Vec<PRComment>| c.iter().map(|c| c.id).collect::<Vec<_>>();
+        assert_eq!(
+            ids(filter_resolved(comments.clone(), &state, true)),
+            vec![1, 2]
+        );
+        // Comment 4 is in no thread, so neither filter keeps it
+        assert_eq!(ids(filter_resolved(comments, &state, false)), vec![3]);
+    }
+
+    #[test]
+    fn test_parse_resolved_state_missing_threads() {
+        let err = parse_resolved_state(&json!({"data": {}})).unwrap_err();
+        assert!(err.to_string().contains("reviewThreads"));
+    }
+
+    #[test]
+    fn test_filter_test_requests() {
+        let mut comments = create_test_comments();
+        comments[1].body = "Please add a test for this".to_string();
+        let filtered = filter_test_requests(comments);
+        assert_eq!(filtered.len(), 1);
+        assert_eq!(filtered[0].id, 2);
+    }
+
+    #[test]
+    fn test_blocking_from_changes_requested_review() {
+        let comment = |id: i64, review_id: i64| {
+            json!({
+                "id": id,
+                "path": "src/lib.rs",
+                "line": 10,
+                "user": {"login": "reviewer"},
+                "body": format!("Comment {id}"),
+                "created_at": "2024-01-15T10:00:00Z",
+                "updated_at": "2024-01-15T10:00:00Z",
+                "pull_request_review_id": review_id
+            })
+        };
+        let reviews = vec![
+            json!({
+                "id": 900,
+                "user": {"login": "reviewer"},
+                "body": "Needs work before merging.",
+                "state": "CHANGES_REQUESTED",
+                "submitted_at": "2024-01-15T10:05:00Z"
+            }),
+            json!({
+                "id": 901,
+                "user": {"login": "other"},
+                "body": "",
+                "state": "COMMENTED",
+                "submitted_at": "2024-01-15T11:00:00Z"
+            }),
+        ];
+        let comments = parse_all_comments(&[comment(1, 900), comment(2, 901)], &reviews);
+        assert_eq!(comments[0].review_id, Some(900));
+        assert!(comments[0].blocking);
+        assert!(!comments[1].blocking);
+        // The changes-requested review body itself blocks too
+        assert_eq!(comments[2].id, 900);
+        assert!(comments[2].blocking);
+
+        let ids: Vec<i64> = filter_blocking(comments).iter().map(|c| c.id).collect();
+        assert_eq!(ids, vec![1, 900]);
+    }
+
+    #[test]
+    fn test_filter_suggestions() {
+        let mut comments = create_test_comments();
+        comments[2].body = "```suggestion\nfn run() {}\n```".to_string();
+        let filtered = filter_suggestions(comments);
+        assert_eq!(filtered.len(), 1);
+        assert_eq!(filtered[0].id, 3);
+    }
+
+    #[test]
+    fn test_filter_bots() {
+        let mut comments = create_test_comments();
+        comments[0].author = "devin-ai-integration[bot]".to_string();
+        comments[1].author = "Dependabot[BOT]".to_string();
+        // A human whose login merely contains "bot"
+        comments[2].author = "robotics-fan".to_string();
+
+        let humans = filter_bots(comments.clone());
+        assert_eq!(humans.len(), 1);
+        assert_eq!(humans[0].author, "robotics-fan");
+
+        let bots: Vec<i64> = filter_only_bots(comments).iter().map(|c| c.id).collect();
+        assert_eq!(bots, vec![1, 2]);
+    }
+
+    #[test]
+    fn test_filter_by_min_severity() {
+        let mut comments = create_test_comments();
+        // A bot finding, a plain human comment and a nit
+        comments[0].author = "coderabbitai[bot]".to_string();
+        comments[0].body =
+            "\u{26A0}\u{FE0F} **Potential issue:** the lock is never released".to_string();
+        comments[1].body = "Thanks, this reads well".to_string();
+        comments[2].body = "nit: extra blank line".to_string();
+        assert_eq!(comments[0].infer_severity(), Severity::Warning);
+        assert_eq!(comments[1].infer_severity(), Severity::None);
+
+        let ids = |min| -> Vec<i64> {
+            filter_by_min_severity(comments.clone(), min)
+                .iter()
+                .map(|c| c.id)
+                .collect()
+        };
+        assert_eq!(ids(Severity::None), vec![1, 2, 3]);
+        assert_eq!(ids(Severity::Nit), vec![1, 3]);
+        assert_eq!(ids(Severity::Warning), vec![1]);
+        assert!(ids(Severity::Critical).is_empty());
+    }
+
     #[test]
     fn test_filter_by_author_none() {
         let comments = create_test_comments();
@@ -632,6 +2625,163 @@ mod tests {
         assert_eq!(file1_comment.id, 2); // The more recent one
     }
 
+    #[test]
+    fn test_sorts_break_ties_by_id() {
+        let mut comments = create_test_comments();
+        for comment in &mut comments {
+            comment.file_path = "same.rs".to_string();
+            comment.line_number = Some(1);
+        }
+        comments.reverse();
+
+        let ids = |sorted: Vec<PRComment>| sorted.iter().map(|c| c.id).collect::<Vec<_>>();
+        assert_eq!(ids(sort_by_agent_order(comments.clone())), vec![1, 2, 3]);
+        assert_eq!(ids(sort_by_reaction_score(comments.clone())), vec![1, 2, 3]);
+        assert_eq!(
+            ids(sort_by_diff_order(comments.clone(), &[])),
+            vec![1, 2, 3]
+        );
+
+        // Equal timestamps: the most recent comment is the higher ID, and
+        // files come out in path order regardless of input order
+        let mut tied = create_test_comments();
+        tied[1].updated_at = tied[0].updated_at;
+        tied.swap(0, 1);
+        let recent = get_most_recent_per_file(tied);
+        let files: Vec<&str> = recent.iter().map(|c| c.file_path.as_str()).collect();
+        assert_eq!(files, vec!["file1.rs", "file2.rs"]);
+        assert_eq!(recent[0].id, 2);
+    }
+
+    #[test]
+    fn test_sort_comments_by_each_key() {
+        let mut comments = create_test_comments();
+        comments.reverse();
+
+        let ids = |key| {
+            sort_comments(comments.clone(), key)
+                .iter()
+                .map(|c| c.id)
+                .collect::<Vec<_>>()
+        };
+        assert_eq!(ids(SortKey::Line), vec![3, 1, 2]);
+        assert_eq!(ids(SortKey::Date), vec![3, 2, 1]);
+        assert_eq!(ids(SortKey::Author), vec![1, 3, 2]);
+        assert_eq!(ids(SortKey::File), vec![1, 2, 3]);
+        assert_eq!(ids(SortKey::Reactions), vec![1, 2, 3]);
+    }
+
+    #[test]
+    fn test_sort_comments_author_ignores_case() {
+        let mut comments = create_test_comments();
+        comments[1].author = "Alice".to_string();
+
+        let sorted = sort_comments(comments, SortKey::Author);
+        assert_eq!(sorted[0].id, 2);
+    }
+
+    #[test]
+    fn test_dedupe_comments_keeps_most_recently_updated() {
+        let comments = create_test_comments();
+        let mut older = comments[0].clone();
+        older.id = 10;
+        older.updated_at = Utc.with_ymd_and_hms(2024, 1, 14, 9, 0, 0).unwrap();
+        let mut newer = comments[0].clone();
+        newer.id = 11;
+        newer.updated_at = Utc.with_ymd_and_hms(2024, 1, 16, 9, 0, 0).unwrap();
+
+        let deduped = dedupe_comments(vec![older.clone(), newer.clone()]);
+        assert_eq!(deduped.len(), 1);
+        assert_eq!(deduped[0].id, 11);
+
+        let deduped = dedupe_comments(vec![newer, older]);
+        assert_eq!(deduped.len(), 1);
+        assert_eq!(deduped[0].id, 11);
+    }
+
+    #[test]
+    fn test_dedupe_comments_overlapping_ranges() {
+        let comments = create_test_comments();
+        let mut range = comments[0].clone();
+        range.id = 10;
+        range.start_line = Some(8);
+        range.line_number = Some(12);
+
+        // Line 10 falls inside 8-12
+        let deduped = dedupe_comments(vec![comments[0].clone(), range.clone()]);
+        assert_eq!(deduped.len(), 1);
+
+        range.start_line = Some(11);
+        let deduped = dedupe_comments(vec![comments[0].clone(), range]);
+        assert_eq!(deduped.len(), 2);
+    }
+
+    #[test]
+    fn test_dedupe_comments_keeps_distinct_comments() {
+        let comments = create_test_comments();
+        assert_eq!(dedupe_comments(comments.clone()).len(), 3);
+
+        // Same position and author, different body
+        let mut other_body = comments[0].clone();
+        other_body.id = 10;
+        other_body.body = "Something else".to_string();
+        // Same body, different author
+        let mut other_author = comments[0].clone();
+        other_author.id = 11;
+        other_author.author = "user3".to_string();
+        // Same body, one with no line
+        let mut no_line = comments[0].clone();
+        no_line.id = 12;
+        no_line.line_number = None;
+        let deduped = dedupe_comments(vec![comments[0].clone(), other_body, other_author, no_line]);
+        assert_eq!(deduped.len(), 4);
+
+        assert!(dedupe_comments(vec![]).is_empty());
+    }
+
+    #[test]
+    fn test_limit_comments_keeps_first_after_sorting() {
+        let comments = sort_comments(create_test_comments(), SortKey::Line);
+        let ids: Vec<i64> = limit_comments(comments, 2).iter().map(|c| c.id).collect();
+        assert_eq!(ids, vec![3, 1]);
+
+        let comments = sort_comments(create_test_comments(), SortKey::Author);
+        let ids: Vec<i64> = limit_comments(comments, 2).iter().map(|c| c.id).collect();
+        assert_eq!(ids, vec![1, 3]);
+    }
+
+    #[test]
+    fn test_limit_most_recent_keeps_order() {
+        let mut comments = create_test_comments();
+        comments.reverse();
+
+        let limited = limit_most_recent(comments, 2);
+        let ids: Vec<i64> = limited.iter().map(|c| c.id).collect();
+        assert_eq!(ids, vec![3, 2]);
+    }
+
+    #[test]
+    fn test_limit_most_recent_ties_go_to_higher_id() {
+        let mut comments = create_test_comments();
+        for comment in &mut comments {
+            comment.updated_at = Utc.with_ymd_and_hms(2024, 1, 15, 10, 0, 0).unwrap();
+        }
+
+        let limited = limit_most_recent(comments, 1);
+        assert_eq!(limited.len(), 1);
+        assert_eq!(limited[0].id, 3);
+    }
+
+    #[test]
+    fn test_limit_comments_zero_or_large_is_unbounded() {
+        for limit in [limit_comments, limit_most_recent] {
+            assert_eq!(limit(create_test_comments(), 0).len(), 3);
+            assert_eq!(limit(create_test_comments(), 3).len(), 3);
+            assert_eq!(limit(create_test_comments(), 10).len(), 3);
+            assert!(limit(vec![], 5).is_empty());
+        }
+    }
+
     #[test]
     fn test_get_most_recent_per_file_empty() {
         let most_recent = get_most_recent_per_file(vec![]);
@@ -648,6 +2798,104 @@ mod tests {
         assert_eq!(grouped.get("file2.rs").unwrap().len(), 1);
     }
 
+    #[test]
+    fn test_group_by_author() {
+        let comments = create_test_comments();
+        let grouped = group_by_author(&comments);
+
+        assert_eq!(grouped.len(), 2);
+        assert_eq!(grouped["user1"].len(), 2);
+        assert_eq!(grouped["user2"].len(), 1);
+        assert!(group_by_author(&[]).is_empty());
+    }
+
+    #[test]
+    fn test_count_by_author() {
+        let comments = create_test_comments();
+        let counts = count_by(&comments, |c| c.author.clone());
+        assert_eq!(
+            counts,
+            vec![("user1".to_string(), 2), ("user2".to_string(), 1)]
+        );
+    }
+
+    #[test]
+    fn test_count_by_file() {
+        let comments = create_test_comments();
+        let counts = count_by(&comments, |c| c.file_path.clone());
+        assert_eq!(
+            counts,
+            vec![("file1.rs".to_string(), 2), ("file2.rs".to_string(), 1)]
+        );
+    }
+
+    #[test]
+    fn test_count_by_ties_sorted_by_key() {
+        let comments = create_test_comments();
+        let counts = count_by(&comments, |c| c.id.to_string());
+        let keys: Vec<&str> = counts.iter().map(|(k, _)| k.as_str()).collect();
+        assert_eq!(keys, vec!["1", "2", "3"]);
+    }
+
+    #[test]
+    fn test_count_by_empty() {
+        assert!(count_by(&[], |c| c.author.clone()).is_empty());
+    }
+
+    #[test]
+    fn test_compute_stats_on_sample_comments() {
+        use crate::selftest::{sample_comments, sample_reviews};
+        let comments = parse_all_comments(&sample_comments(), &sample_reviews());
+        let stats = compute_stats(&comments);
+
+        assert_eq!(stats.total_comments, 4);
+        assert_eq!(stats.unique_files, 2);
+        assert_eq!(stats.unique_authors, 2);
+        assert_eq!(
+            stats.comments_per_author,
+            vec![("reviewer".to_string(), 3), ("other".to_string(), 1)]
+        );
+        assert_eq!(stats.busiest_file, Some(("src/lib.rs".to_string(), 2)));
+        assert_eq!(stats.comments_per_file[0], ("src/lib.rs".to_string(), 2));
+        let (earliest, latest) = stats.time_span.unwrap();
+        assert!(earliest <= latest);
+
+        assert_eq!(compute_stats(&[]), ReviewStats::default());
+    }
+
+    #[test]
+    fn test_group_by_expr_on_sample_comments() {
+        use crate::cli::GroupExpr;
+        use crate::selftest::{sample_comments, sample_reviews};
+        let comments = parse_all_comments(&sample_comments(), &sample_reviews());
+        let sizes = |grouped: HashMap<String, Vec<&PRComment>>| {
+            let mut sizes: Vec<(String, usize)> =
+                grouped.into_iter().map(|(k, v)| (k, v.len())).collect();
+            sizes.sort();
+            sizes
+        };
+
+        let by_dir = group_by(&comments, |c| GroupExpr::Dir(1).key_for(c));
+        assert_eq!(
+            sizes(by_dir),
+            vec![
+                ("(review)".to_string(), 1),
+                (".".to_string(), 1),
+                ("src".to_string(), 2)
+            ]
+        );
+
+        let by_ext = group_by(&comments, |c| GroupExpr::Ext.key_for(c));
+        assert_eq!(
+            sizes(by_ext),
+            vec![
+                ("(review)".to_string(), 1),
+                ("md".to_string(), 1),
+                ("rs".to_string(), 2)
+            ]
+        );
+    }
+
     #[test]
     fn test_group_by_file_empty() {
         let grouped = group_by_file(&[]);
@@ -674,6 +2922,33 @@ mod tests {
         assert!(comment.diff_hunk.is_empty());
     }
 
+    #[test]
+    fn test_parse_issue_comments() {
+        let data = vec![
+            json!({
+                "id": 501,
+                "user": {"login": "maintainer"},
+                "body": "Can you rebase on main?",
+                "created_at": "2024-01-15T10:30:00Z",
+                "updated_at": "2024-01-15T10:30:00Z",
+                "html_url": "https://github.com/owner/repo/pull/1#issuecomment-501"
+            }),
+            json!({"id": 502}),
+        ];
+
+        let comments = parse_issue_comments(&data);
+        assert_eq!(comments.len(), 1);
+        assert_eq!(comments[0].file_path, GENERAL_FILE_PATH);
+        assert!(comments[0].is_general());
+        assert_eq!(comments[0].line_number, None);
+        assert_eq!(comments[0].body, "Can you rebase on main?");
+
+        // Conversation comments are never orphaned or test-file comments
+        assert!(filter_orphaned(comments.clone(), &[]).is_empty());
+        let matcher = test_file_matcher(&[]);
+        assert_eq!(filter_test_files(comments, &matcher).len(), 1);
+    }
+
     #[test]
     fn test_parse_review_comment_empty_body() {
         let data = json!({
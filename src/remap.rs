@@ -0,0 +1,317 @@
+//! Line-number remapping through a unified diff.
+//!
+//! Review comments point at lines in the commit that was reviewed. Given a
+//! patch from that commit to the current working tree, comment positions can
+//! be moved to where the code lives now.
+
+use crate::models::PRComment;
+use std::collections::HashMap;
+
+/// One line of a hunk body.
+#[derive(Debug, Clone, Copy, PartialEq)]
+enum HunkLine {
+    Context,
+    Removed,
+    Added,
+}
+
+/// A hunk: where it starts on each side and its body lines.
+#[derive(Debug, Clone, PartialEq)]
+struct Hunk {
+    /// First old-side line covered by the body.
+    old_start: i32,
+    /// First new-side line covered by the body.
+    new_start: i32,
+    lines: Vec<HunkLine>,
+}
+
+/// The changes a patch makes to one file.
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct FilePatch {
+    /// Path after the patch, or None if the file is deleted.
+    pub new_path: Option<String>,
+    hunks: Vec<Hunk>,
+}
+
+impl FilePatch {
+    /// Maps an old-side line to its new-side line, or None if the patch
+    /// deletes it.
+    pub fn remap_line(&self, line: i32) -> Option<i32> {
+        let mut offset = 0;
+        for hunk in &self.hunks {
+            if line < hunk.old_start {
+                break;
+            }
+            let (mut old, mut new) = (hunk.old_start, hunk.new_start);
+            for kind in &hunk.lines {
+                match kind {
+                    HunkLine::Context if old == line => return Some(new),
+                    HunkLine::Removed if old == line => return None,
+                    HunkLine::Context => {
+                        old += 1;
+                        new += 1;
+                    }
+                    HunkLine::Removed => old += 1,
+                    HunkLine::Added => new += 1,
+                }
+            }
+            offset = new - old;
+        }
+        Some(line + offset)
+    }
+}
+
+/// Parses a unified diff (as from `git diff`) into per-file patches, keyed
+/// by the old path.
+///
+/// New files are keyed by their new path. Lines outside `---`/`+++` file
+/// headers and `@@` hunks (e.g. `diff --git`, `index`) are ignored.
+pub fn parse_patch(patch: &str) -> HashMap<String, FilePatch> {
+    let mut files = HashMap::new();
+    let mut current: Option<(String, FilePatch)> = None;
+    let mut lines = patch.lines();
+
+    while let Some(line) = lines.next() {
+        if let Some(old) = line.strip_prefix("--- ") {
+            files.extend(current.take());
+            let new = lines.next().and_then(|l| l.strip_prefix("+++ "));
+            let old_path = diff_path(old, "a/");
+            let new_path = new.and_then(|n| diff_path(n, "b/"));
+            let Some(key) = old_path.or_else(|| new_path.clone()) else {
+                continue;
+            };
+            current = Some((
+                key,
+                FilePatch {
+                    new_path,
+                    hunks: Vec::new(),
+                },
+            ));
+        } else if let Some(header) = line.strip_prefix("@@ ") {
+            let Some((old_start, old_len, new_start, new_len)) = parse_hunk_header(header) else {
+                continue;
+            };
+            let (mut old_left, mut new_left) = (old_len, new_len);
+            let mut body = Vec::new();
+            while old_left > 0 || new_left > 0 {
+                let Some(line) = lines.next() else {
+                    break;
+                };
+                match line.chars().next() {
+                    Some('-') => {
+                        old_left = old_left.saturating_sub(1);
+                        body.push(HunkLine::Removed);
+                    }
+                    Some('+') => {
+                        new_left = new_left.saturating_sub(1);
+                        body.push(HunkLine::Added);
+                    }
+                    // "\ No newline at end of file"
+                    Some('\\') => {}
+                    _ => {
+                        old_left = old_left.saturating_sub(1);
+                        new_left = new_left.saturating_sub(1);
+                        body.push(HunkLine::Context);
+                    }
+                }
+            }
+            if let Some((_, file)) = current.as_mut() {
+                // An empty side's start is the line before the change
+                file.hunks.push(Hunk {
+                    old_start: if old_len == 0 {
+                        old_start + 1
+                    } else {
+                        old_start
+                    },
+                    new_start: if new_len == 0 {
+                        new_start + 1
+                    } else {
+                        new_start
+                    },
+                    lines: body,
+                });
+            }
+        }
+    }
+    files.extend(current);
+    files
+}
+
+/// Returns the path from a `---`/`+++` header, without its `a/`/`b/` prefix
+/// or trailing timestamp, or None for `/dev/null`.
+fn diff_path(header: &str, prefix: &str) -> Option<String> {
+    let path = header.split('\t').next().unwrap_or(header).trim_end();
+    if path == "/dev/null" {
+        return None;
+    }
+    Some(path.strip_prefix(prefix).unwrap_or(path).to_string())
+}
+
+/// Parses "-a,b +c,d @@" into (a, b, c, d); omitted lengths default to 1.
+fn parse_hunk_header(header: &str) -> Option<(i32, usize, i32, usize)> {
+    let mut parts = header.split_whitespace();
+    let (old_start, old_len) = parse_range(parts.next()?.strip_prefix('-')?)?;
+    let (new_start, new_len) = parse_range(parts.next()?.strip_prefix('+')?)?;
+    Some((old_start, old_len, new_start, new_len))
+}
+
+fn parse_range(range: &str) -> Option<(i32, usize)> {
+    match range.split_once(',') {
+        Some((start, len)) => Some((start.parse().ok()?, len.parse().ok()?)),
+        None => Some((range.parse().ok()?, 1)),
+    }
+}
+
+/// Moves comment positions through `patch`, returning the comments and how
+/// many of them point at deleted lines.
+///
+/// Comments on deleted lines keep their original position and are marked
+/// outdated. Comments on renamed files take the new path.
+pub fn remap_lines(comments: Vec<PRComment>, patch: &str) -> (Vec<PRComment>, usize) {
+    let files = parse_patch(patch);
+    let mut deleted = 0;
+    let comments = comments
+        .into_iter()
+        .map(|mut comment| {
+            let Some(file) = files.get(&comment.file_path) else {
+                return comment;
+            };
+            let line = comment.line_number.map(|l| file.remap_line(l));
+            let start = comment.start_line.map(|l| file.remap_line(l));
+            match &file.new_path {
+                Some(new_path) if line != Some(None) && start != Some(None) => {
+                    comment.line_number = line.flatten();
+                    comment.start_line = start.flatten();
+                    comment.file_path = new_path.clone();
+                }
+                _ => {
+                    comment.outdated = true;
+                    deleted += 1;
+                }
+            }
+            comment
+        })
+        .collect();
+    (comments, deleted)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use chrono::{TimeZone, Utc};
+
+    fn create_test_comment(id: i64, file: &str, line: Option<i32>) -> PRComment {
+        PRComment::new(
+            id,
+            None,
+            file.to_string(),
+            line,
+            None,
+            "user1".to_string(),
+            format!("Comment {id}"),
+            Utc.with_ymd_and_hms(2024, 1, 15, 10, 30, 0).unwrap(),
+            Utc.with_ymd_and_hms(2024, 1, 15, 10, 30, 0).unwrap(),
+            String::new(),
+            String::new(),
+        )
+    }
+
+    /// Inserts two lines after line 3 and deletes line 8 of src/main.rs.
+    const PATCH: &str = "diff --git a/src/main.rs b/src/main.rs
+index 1111111..2222222 100644
+--- a/src/main.rs
++++ b/src/main.rs
+@@ -2,3 +2,5 @@ fn main() {
+ line2
+ line3
++inserted1
++inserted2
+ line4
+@@ -7,3 +9,2 @@ fn helper() {
+ line7
+-line8
+ line9
+";
+
+    #[test]
+    fn test_insertion_shifts_subsequent_lines() {
+        let file = &parse_patch(PATCH)["src/main.rs"];
+        assert_eq!(file.remap_line(1), Some(1));
+        assert_eq!(file.remap_line(3), Some(3));
+        assert_eq!(file.remap_line(4), Some(6));
+        assert_eq!(file.remap_line(6), Some(8));
+        assert_eq!(file.remap_line(7), Some(9));
+        assert_eq!(file.remap_line(8), None);
+        assert_eq!(file.remap_line(9), Some(10));
+        assert_eq!(file.remap_line(20), Some(21));
+    }
+
+    #[test]
+    fn test_remap_lines_updates_and_flags_comments() {
+        let mut range = create_test_comment(3, "src/main.rs", Some(5));
+        range.start_line = Some(4);
+        let comments = vec![
+            create_test_comment(1, "src/main.rs", Some(6)),
+            create_test_comment(2, "src/main.rs", Some(8)),
+            range,
+            create_test_comment(4, "other.rs", Some(6)),
+            create_test_comment(5, "", None),
+        ];
+
+        let (remapped, deleted) = remap_lines(comments, PATCH);
+        assert_eq!(deleted, 1);
+        assert_eq!(remapped[0].line_number, Some(8));
+        assert!(!remapped[0].outdated);
+        assert_eq!(remapped[1].line_number, Some(8));
+        assert!(remapped[1].outdated);
+        assert_eq!(remapped[2].start_line, Some(6));
+        assert_eq!(remapped[2].line_number, Some(7));
+        assert_eq!(remapped[3].line_number, Some(6));
+        assert_eq!(remapped[4].line_number, None);
+    }
+
+    #[test]
+    fn test_pure_insertion_at_top() {
+        let patch = "--- a/a.rs\n+++ b/a.rs\n@@ -0,0 +1,2 @@\n+one\n+two\n";
+        let file = &parse_patch(patch)["a.rs"];
+        assert_eq!(file.remap_line(1), Some(3));
+    }
+
+    #[test]
+    fn test_pure_deletion_hunk() {
+        let patch = "--- a/a.rs\n+++ b/a.rs\n@@ -3,2 +2,0 @@\n-three\n-four\n";
+        let file = &parse_patch(patch)["a.rs"];
+        assert_eq!(file.remap_line(2), Some(2));
+        assert_eq!(file.remap_line(3), None);
+        assert_eq!(file.remap_line(5), Some(3));
+    }
+
+    #[test]
+    fn test_rename_and_delete() {
+        let patch = "--- a/old.rs\t2024-01-15 10:00:00\n+++ b/new.rs\n@@ -1 +1 @@\n-a\n\\ No newline at end of file\n+b\n--- a/gone.rs\n+++ /dev/null\n@@ -1,2 +0,0 @@\n-x\n-y\n--- /dev/null\n+++ b/added.rs\n@@ -0,0 +1 @@\n+z\n";
+        let comments = vec![
+            create_test_comment(1, "old.rs", Some(5)),
+            create_test_comment(2, "gone.rs", Some(9)),
+        ];
+        let (remapped, deleted) = remap_lines(comments, patch);
+        assert_eq!(remapped[0].file_path, "new.rs");
+        assert_eq!(remapped[0].line_number, Some(5));
+        assert_eq!(deleted, 1);
+        assert!(remapped[1].outdated);
+        assert!(parse_patch(patch).contains_key("added.rs"));
+    }
+
+    #[test]
+    fn test_parse_patch_tolerates_malformed_input() {
+        // Hunks before any file header, bad headers, and truncated bodies
+        let patch = "@@ -1 +1 @@\n x\n--- a/a.rs\n+++ b/a.rs\n@@ bogus @@\n@@ -1,5 +1,5 @@\n a\n";
+        let files = parse_patch(patch);
+        assert_eq!(files.len(), 1);
+        assert_eq!(files["a.rs"].remap_line(1), Some(1));
+
+        assert!(parse_patch("--- /dev/null\n+++ /dev/null\n").is_empty());
+        assert!(parse_patch("--- a/a.rs").contains_key("a.rs"));
+        assert_eq!(parse_hunk_header("-1,x +1 @@"), None);
+        assert_eq!(parse_hunk_header("-1 +y @@"), None);
+    }
+}
@@ -0,0 +1,147 @@
+//! Offline self-test of the parse → filter → format pipeline.
+//!
+//! Runs against built-in sample API responses, so a binary can be verified
+//! in a locked-down environment without gh or network access.
+
+use crate::cli::OutputFormat;
+use crate::formatter::{format_comments, FormatOptions};
+use crate::parser::{filter_by_authors, get_most_recent_per_file, parse_all_comments};
+use clap::ValueEnum;
+use serde_json::{json, Value};
+
+/// Sample response from `gh api repos/{owner}/{repo}/pulls/{n}/comments`.
+pub fn sample_comments() -> Vec<Value> {
+    vec![
+        json!({
+            "id": 1001,
+            "node_id": "PRRC_sample1",
+            "path": "src/lib.rs",
+            "line": 12,
+            "user": {"login": "reviewer"},
+            "body": "Consider returning a Result here.",
+            "created_at": "2024-01-15T10:00:00Z",
+            "updated_at": "2024-01-15T10:00:00Z",
+            "diff_hunk": "@@ -10,3 +10,3 @@\n fn load() {\n-    parse().unwrap()\n+    parse().expect(\"valid\")",
+            "html_url": "https://github.com/owner/repo/pull/1#discussion_r1001"
+        }),
+        json!({
+            "id": 1002,
+            "node_id": "PRRC_sample2",
+            "path": "src/lib.rs",
+            "line": 30,
+            "user": {"login": "reviewer"},
+            "body": "<!-- bot marker -->Consider a doc comment.",
+            "created_at": "2024-01-15T11:00:00Z",
+            "updated_at": "2024-01-15T11:00:00Z",
+            "diff_hunk": "@@ -28,2 +28,3 @@\n pub fn run() {\n+    load();",
+            "html_url": "https://github.com/owner/repo/pull/1#discussion_r1002"
+        }),
+        json!({
+            "id": 1003,
+            "path": "README.md",
+            "original_line": 4,
+            "user": {"login": "other"},
+            "body": "Typo.",
+            "created_at": "2024-01-15T12:00:00Z",
+            "updated_at": "2024-01-15T12:00:00Z"
+        }),
+    ]
+}
+
+/// Sample response from `gh api repos/{owner}/{repo}/pulls/{n}/reviews`.
+pub fn sample_reviews() -> Vec<Value> {
+    vec![
+        json!({
+            "id": 2001,
+            "user": {"login": "reviewer"},
+            "body": "Looks good overall.",
+            "submitted_at": "2024-01-15T13:00:00Z"
+        }),
+        json!({"id": 2002, "user": {"login": "other"}, "body": ""}),
+    ]
+}
+
+/// Runs the pipeline on the built-in samples and reports OK or the first
+/// failure.
+pub fn run_selftest() -> Result<String, String> {
+    check_pipeline(&sample_comments(), &sample_reviews())
+}
+
+/// Runs parse → filter → format on the given responses, checking each stage.
+fn check_pipeline(raw_comments: &[Value], raw_reviews: &[Value]) -> Result<String, String> {
+    let comments = parse_all_comments(raw_comments, raw_reviews);
+    expect_count("parse", comments.len(), 4)?;
+
+    let comments = filter_by_authors(comments, &["reviewer".to_string()]);
+    expect_count("author filter", comments.len(), 3)?;
+    let comments = get_most_recent_per_file(comments);
+    expect_count("most-recent filter", comments.len(), 2)?;
+
+    let options = FormatOptions::default();
+    // Built-in formats that render comments; custom registered formats,
+    // tallies and mention lists don't echo bodies
+    let formats: Vec<&OutputFormat> = OutputFormat::value_variants()
+        .iter()
+        .filter(|f| !matches!(f, OutputFormat::AgeBuckets | OutputFormat::Mentions))
+        .collect();
+    for name in formats.iter().map(|f| f.name()) {
+        let output = format_comments(name, &comments, &options).unwrap_or_default();
+        if !output.contains("Consider a doc comment") {
+            return Err(format!(
+                "Self-test failed at format {name}: comment body missing from output"
+            ));
+        }
+    }
+
+    Ok(format!(
+        "Self-test OK: parsed, filtered and formatted sample comments in {} format(s)\n",
+        formats.len()
+    ))
+}
+
+/// Fails with the stage name when a pipeline stage yields the wrong count.
+fn expect_count(stage: &str, actual: usize, expected: usize) -> Result<(), String> {
+    if actual == expected {
+        Ok(())
+    } else {
+        Err(format!(
+            "Self-test failed at {stage}: expected {expected} comment(s), got {actual}"
+        ))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_run_selftest_succeeds() {
+        let report = run_selftest().unwrap();
+        assert!(report.starts_with("Self-test OK"));
+    }
+
+    #[test]
+    fn test_selftest_reports_parse_failure() {
+        let err = check_pipeline(&[], &sample_reviews()).unwrap_err();
+        assert_eq!(
+            err,
+            "Self-test failed at parse: expected 4 comment(s), got 1"
+        );
+    }
+
+    #[test]
+    fn test_selftest_reports_filter_failure() {
+        let mut comments = sample_comments();
+        comments[2]["user"]["login"] = json!("reviewer");
+        let err = check_pipeline(&comments, &sample_reviews()).unwrap_err();
+        assert!(err.contains("at author filter"));
+    }
+
+    #[test]
+    fn test_selftest_reports_format_failure() {
+        let mut comments = sample_comments();
+        comments[1]["body"] = json!("Changed body.");
+        let err = check_pipeline(&comments, &sample_reviews()).unwrap_err();
+        assert!(err.contains("at format"));
+    }
+}
@@ -0,0 +1,222 @@
+//! Snapshots of previously seen comment IDs, for showing only new comments.
+//!
+//! Each PR gets a small JSON file under the user's cache directory recording
+//! the IDs of comments already shown, so iterative reviews can skip them.
+
+use crate::formatter::write_atomic;
+use crate::models::PRComment;
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeSet;
+use std::fs;
+use std::io;
+use std::path::{Path, PathBuf};
+
+/// Comment IDs seen in previous runs for one PR.
+#[derive(Debug, Clone, Default, Serialize, Deserialize, PartialEq)]
+pub struct Snapshot {
+    pub comment_ids: BTreeSet<i64>,
+}
+
+impl Snapshot {
+    /// Splits comments into those not in the snapshot and the count of those
+    /// already seen.
+    pub fn diff(&self, comments: Vec<PRComment>) -> (Vec<PRComment>, usize) {
+        let total = comments.len();
+        let new: Vec<PRComment> = comments
+            .into_iter()
+            .filter(|c| !self.comment_ids.contains(&c.id))
+            .collect();
+        let unchanged = total - new.len();
+        (new, unchanged)
+    }
+
+    /// Records the given comments as seen.
+    pub fn record<'a>(&mut self, comments: impl IntoIterator<Item = &'a PRComment>) {
+        self.comment_ids.extend(comments.into_iter().map(|c| c.id));
+    }
+}
+
+/// Returns the comments that made it into the written `output`.
+///
+/// Untruncated output shows every comment. Output cut by `--max-output-bytes`
+/// only counts comments whose GitHub URL survived the cut, so a comment lost
+/// to truncation (or one without a URL) is shown again on the next run.
+pub fn emitted_comments<'a>(
+    comments: &'a [PRComment],
+    output: &str,
+    truncated: bool,
+) -> Vec<&'a PRComment> {
+    comments
+        .iter()
+        .filter(|c| !truncated || contains_url(output, &c.html_url))
+        .collect()
+}
+
+/// Returns true if `url` appears in `text` and is not just the prefix of a
+/// longer ID (".../r12" inside ".../r123").
+fn contains_url(text: &str, url: &str) -> bool {
+    !url.is_empty()
+        && text.match_indices(url).any(|(i, _)| {
+            !text[i + url.len()..]
+                .chars()
+                .next()
+                .is_some_and(|c| c.is_ascii_alphanumeric())
+        })
+}
+
+/// Returns the snapshot file for a PR under `cache_dir`, nested by owner
+/// and repo so names containing `_` can't collide.
+pub fn snapshot_path(cache_dir: &Path, owner: &str, repo: &str, pr_number: i32) -> PathBuf {
+    cache_dir
+        .join("pr-comments")
+        .join(owner)
+        .join(repo)
+        .join(format!("{pr_number}.json"))
+}
+
+/// Returns the user's cache directory: `$XDG_CACHE_HOME`, else `$HOME/.cache`.
+pub fn default_cache_dir() -> Option<PathBuf> {
+    std::env::var_os("XDG_CACHE_HOME")
+        .filter(|d| !d.is_empty())
+        .map(PathBuf::from)
+        .or_else(|| std::env::var_os("HOME").map(|h| PathBuf::from(h).join(".cache")))
+}
+
+/// Loads a snapshot, returning an empty one if the file does not exist yet.
+pub fn load_snapshot(path: &Path) -> io::Result<Snapshot> {
+    match fs::read_to_string(path) {
+        Ok(contents) => serde_json::from_str(&contents)
+            .map_err(|e| io::Error::new(io::ErrorKind::InvalidData, e)),
+        Err(e) if e.kind() == io::ErrorKind::NotFound => Ok(Snapshot::default()),
+        Err(e) => Err(e),
+    }
+}
+
+/// Saves a snapshot atomically, creating parent directories as needed, so
+/// an interrupted run never leaves a truncated file behind.
+pub fn save_snapshot(path: &Path, snapshot: &Snapshot) -> io::Result<()> {
+    if let Some(parent) = path.parent() {
+        fs::create_dir_all(parent)?;
+    }
+    let json = serde_json::to_string(snapshot).map_err(io::Error::other)?;
+    write_atomic(path, &json)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use chrono::{TimeZone, Utc};
+
+    fn create_test_comment(id: i64) -> PRComment {
+        PRComment::new(
+            id,
+            None,
+            "src/main.rs".to_string(),
+            Some(10),
+            None,
+            "user1".to_string(),
+            format!("Comment {id}"),
+            Utc.with_ymd_and_hms(2024, 1, 15, 10, 30, 0).unwrap(),
+            Utc.with_ymd_and_hms(2024, 1, 15, 10, 30, 0).unwrap(),
+            String::new(),
+            String::new(),
+        )
+    }
+
+    #[test]
+    fn test_diff_between_two_snapshots() {
+        let first_run: Vec<PRComment> = [1, 2, 3].into_iter().map(create_test_comment).collect();
+        let mut snapshot = Snapshot::default();
+        let (new, unchanged) = snapshot.diff(first_run.clone());
+        assert_eq!(new.len(), 3);
+        assert_eq!(unchanged, 0);
+        snapshot.record(&first_run);
+
+        let second_run: Vec<PRComment> =
+            [2, 3, 4, 5].into_iter().map(create_test_comment).collect();
+        let (new, unchanged) = snapshot.diff(second_run);
+        let ids: Vec<i64> = new.iter().map(|c| c.id).collect();
+        assert_eq!(ids, vec![4, 5]);
+        assert_eq!(unchanged, 2);
+    }
+
+    #[test]
+    fn test_save_and_load_round_trip() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = snapshot_path(dir.path(), "owner", "repo", 7);
+        assert!(path.ends_with("pr-comments/owner/repo/7.json"));
+        assert_ne!(
+            snapshot_path(dir.path(), "a_b", "c", 1),
+            snapshot_path(dir.path(), "a", "b_c", 1)
+        );
+
+        assert_eq!(load_snapshot(&path).unwrap(), Snapshot::default());
+
+        let mut snapshot = Snapshot::default();
+        snapshot.record(&[create_test_comment(1), create_test_comment(2)]);
+        save_snapshot(&path, &snapshot).unwrap();
+        assert_eq!(load_snapshot(&path).unwrap(), snapshot);
+        // No temp files are left beside the snapshot
+        assert_eq!(fs::read_dir(path.parent().unwrap()).unwrap().count(), 1);
+    }
+
+    #[test]
+    fn test_emitted_comments() {
+        let comments: Vec<PRComment> = [12, 123, 7]
+            .into_iter()
+            .map(|id| {
+                let mut comment = create_test_comment(id);
+                comment.html_url = format!("https://github.com/o/r/pull/1#discussion_r{id}");
+                comment
+            })
+            .collect();
+        let ids = |emitted: Vec<&PRComment>| emitted.iter().map(|c| c.id).collect::<Vec<_>>();
+
+        // Everything counts when nothing was cut
+        assert_eq!(
+            ids(emitted_comments(&comments, "", false)),
+            vec![12, 123, 7]
+        );
+
+        // Only URLs that survived the cut; r12 is not matched by r123
+        let output = "[View](https://github.com/o/r/pull/1#discussion_r123)\n[...truncated";
+        assert_eq!(ids(emitted_comments(&comments, output, true)), vec![123]);
+
+        let mut no_url = create_test_comment(9);
+        no_url.html_url = String::new();
+        assert!(emitted_comments(&[no_url], output, true).is_empty());
+    }
+
+    #[test]
+    fn test_load_snapshot_invalid_json() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("bad.json");
+        fs::write(&path, "not json").unwrap();
+        let err = load_snapshot(&path).unwrap_err();
+        assert_eq!(err.kind(), io::ErrorKind::InvalidData);
+    }
+
+    #[test]
+    fn test_load_snapshot_unreadable() {
+        // A directory can't be read as a file
+        let dir = tempfile::tempdir().unwrap();
+        assert!(load_snapshot(dir.path()).is_err());
+    }
+
+    #[test]
+    fn test_save_snapshot_unwritable() {
+        let dir = tempfile::tempdir().unwrap();
+        let blocker = dir.path().join("file");
+        fs::write(&blocker, "").unwrap();
+        let result = save_snapshot(&blocker.join("snap.json"), &Snapshot::default());
+        assert!(result.is_err());
+    }
+
+    #[test]
+    fn test_default_cache_dir() {
+        // Depends on the environment; when HOME or XDG_CACHE_HOME is set we get a path
+        if std::env::var_os("XDG_CACHE_HOME").is_some() || std::env::var_os("HOME").is_some() {
+            assert!(default_cache_dir().is_some());
+        }
+    }
+}
@@ -367,3 +367,151 @@ mod output_file_tests {
         assert!(parsed.is_ok(), "Output is not valid JSON: {content}");
     }
 }
+
+mod from_file_tests {
+    use super::*;
+    use std::fs;
+    use tempfile::TempDir;
+
+    const COMMENTS_JSON: &str = r#"[
+        {
+            "id": 1,
+            "node_id": "PRRC_1",
+            "path": "src/lib.rs",
+            "line": 12,
+            "user": {"login": "reviewer"},
+            "body": "Consider returning a Result here.",
+            "created_at": "2024-01-15T10:00:00Z",
+            "updated_at": "2024-01-15T10:00:00Z",
+            "diff_hunk": "@@ -10,3 +10,3 @@\n fn load() {\n+    parse().unwrap()",
+            "html_url": "https://github.com/owner/repo/pull/1#discussion_r1"
+        },
+        {
+            "id": 2,
+            "path": "README.md",
+            "line": 4,
+            "user": {"login": "other"},
+            "body": "Typo.",
+            "created_at": "2024-01-15T12:00:00Z",
+            "updated_at": "2024-01-15T12:00:00Z"
+        }
+    ]"#;
+
+    fn write_comments(temp_dir: &TempDir) -> String {
+        let path = temp_dir.path().join("comments.json");
+        fs::write(&path, COMMENTS_JSON).expect("Failed to write comments file");
+        path.to_str().unwrap().to_string()
+    }
+
+    #[test]
+    fn test_from_file_json_format() {
+        let temp_dir = TempDir::new().expect("Failed to create temp dir");
+        let path = write_comments(&temp_dir);
+
+        let output = Command::new(binary_path())
+            .args(["owner/repo#1", "--from-file", &path, "--format", "json"])
+            .output()
+            .expect("Failed to execute command");
+
+        let stderr = String::from_utf8_lossy(&output.stderr);
+        assert!(output.status.success(), "stderr: {stderr}");
+        let parsed: serde_json::Value =
+            serde_json::from_slice(&output.stdout).expect("Output is not valid JSON");
+        let comments = parsed.as_array().unwrap();
+        assert_eq!(comments.len(), 2);
+        assert_eq!(comments[0]["author"], "reviewer");
+        assert_eq!(comments[1]["body"], "Typo.");
+    }
+
+    #[test]
+    fn test_from_file_claude_format_with_title() {
+        let temp_dir = TempDir::new().expect("Failed to create temp dir");
+        let path = write_comments(&temp_dir);
+
+        let output = Command::new(binary_path())
+            .args([
+                "owner/repo#1",
+                "--from-file",
+                &path,
+                "--pr-title",
+                "Offline PR",
+                "--author",
+                "reviewer",
+            ])
+            .output()
+            .expect("Failed to execute command");
+
+        let stderr = String::from_utf8_lossy(&output.stderr);
+        assert!(output.status.success(), "stderr: {stderr}");
+        let stdout = String::from_utf8_lossy(&output.stdout);
+        assert!(stdout.contains("Offline PR"));
+        assert!(stdout.contains("src/lib.rs"));
+        assert!(stdout.contains("Consider returning a Result here."));
+        assert!(!stdout.contains("Typo."));
+    }
+
+    #[test]
+    fn test_from_file_group_by_severity_keeps_format() {
+        let temp_dir = TempDir::new().expect("Failed to create temp dir");
+        let path = write_comments(&temp_dir);
+        let run = |format: &str| {
+            let output = Command::new(binary_path())
+                .args([
+                    "owner/repo#1",
+                    "--from-file",
+                    &path,
+                    "--group-by",
+                    "severity",
+                    "--format",
+                    format,
+                ])
+                .output()
+                .expect("Failed to execute command");
+            let stderr = String::from_utf8_lossy(&output.stderr);
+            assert!(output.status.success(), "stderr: {stderr}");
+            String::from_utf8_lossy(&output.stdout).into_owned()
+        };
+
+        let json: serde_json::Value =
+            serde_json::from_str(&run("json")).expect("Output is not valid JSON");
+        assert_eq!(json.as_array().unwrap().len(), 2);
+
+        assert!(run("claude").contains("## Suggestions"));
+    }
+
+    #[test]
+    fn test_from_file_rejects_api_flags() {
+        let temp_dir = TempDir::new().expect("Failed to create temp dir");
+        let path = write_comments(&temp_dir);
+
+        let output = Command::new(binary_path())
+            .args([
+                "owner/repo#1",
+                "--from-file",
+                &path,
+                "--include-general",
+                "--diff-order",
+            ])
+            .output()
+            .expect("Failed to execute command");
+
+        assert!(!output.status.success());
+        let stderr = String::from_utf8_lossy(&output.stderr);
+        assert!(stderr.contains("can't be combined with --include-general, --diff-order"));
+    }
+
+    #[test]
+    fn test_from_file_missing_file_error() {
+        let temp_dir = TempDir::new().expect("Failed to create temp dir");
+        let path = temp_dir.path().join("missing.json");
+
+        let output = Command::new(binary_path())
+            .args(["owner/repo#1", "--from-file", path.to_str().unwrap()])
+            .output()
+            .expect("Failed to execute command");
+
+        assert!(!output.status.success());
+        let stderr = String::from_utf8_lossy(&output.stderr);
+        assert!(stderr.contains("Failed to read comments file"));
+    }
+}